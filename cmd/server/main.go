@@ -65,9 +65,14 @@ func main() {
 	apiGroup.Use(middleware.RateLimitMiddleware())
 	{
 		apiGroup.GET("/random", publicAPI.RandomImage)
+		apiGroup.GET("/daily", publicAPI.DailyImage)
+		apiGroup.GET("/hourly", publicAPI.HourlyImage)
+		apiGroup.GET("/weekly", publicAPI.WeeklyImage)
 		apiGroup.GET("/proxy/:id", publicAPI.ProxyImage)
+		apiGroup.GET("/variants/:id/:version/:file", publicAPI.VariantImage)
 		apiGroup.GET("/images", publicAPI.ListImages)
 		apiGroup.GET("/categories", publicAPI.ListCategories)
+		apiGroup.GET("/tags", publicAPI.ListTags)
 	}
 
 	// 公开统计API（无需认证）
@@ -87,6 +92,13 @@ func main() {
 		adminGroup.POST("/images/auto-fetch", adminAPI.AutoFetchImageInfo)
 		adminGroup.PUT("/images/batch", adminAPI.BatchUpdateImages)
 		adminGroup.DELETE("/images/batch", adminAPI.BatchDeleteImages)
+		adminGroup.POST("/images/batch/tags", adminAPI.BatchTagImages)
+		adminGroup.POST("/images/search", adminAPI.SearchSimilarImages)
+		adminGroup.POST("/images/upload", adminAPI.UploadImages)
+
+		// 重复图片
+		adminGroup.GET("/duplicates", adminAPI.ListDuplicates)
+		adminGroup.POST("/duplicates/merge", adminAPI.MergeDuplicates)
 
 		// 分类管理
 		adminGroup.GET("/categories", adminAPI.ListCategories)
@@ -94,6 +106,12 @@ func main() {
 		adminGroup.PUT("/categories/:id", adminAPI.UpdateCategory)
 		adminGroup.DELETE("/categories/:id", adminAPI.DeleteCategory)
 
+		// 标签管理
+		adminGroup.GET("/tags", adminAPI.ListTags)
+		adminGroup.POST("/tags", adminAPI.CreateTag)
+		adminGroup.PUT("/tags/:id", adminAPI.UpdateTag)
+		adminGroup.DELETE("/tags/:id", adminAPI.DeleteTag)
+
 		// API Key管理
 		adminGroup.GET("/api-keys", adminAPI.ListAPIKeys)
 		adminGroup.POST("/api-keys", adminAPI.CreateAPIKey)
@@ -103,6 +121,27 @@ func main() {
 		// 统计查询
 		adminGroup.GET("/stats", adminAPI.GetStats)
 		adminGroup.GET("/stats/overview", adminAPI.GetStatsOverview)
+
+		// 代理缓存
+		adminGroup.GET("/proxy-cache", adminAPI.GetProxyCacheStats)
+		adminGroup.DELETE("/proxy-cache", adminAPI.PurgeProxyCache)
+
+		// 上游请求统计
+		adminGroup.GET("/upstream", adminAPI.GetUpstreamStats)
+
+		// 链接检查
+		adminGroup.GET("/link-check", adminAPI.GetLinkCheckStats)
+		adminGroup.POST("/link-check", adminAPI.RunLinkCheck)
+
+		// 镜像
+		adminGroup.GET("/mirror", adminAPI.GetMirrorStats)
+		adminGroup.POST("/mirror", adminAPI.RunMirror)
+
+		// 上游主机配置
+		adminGroup.GET("/host-profiles", adminAPI.ListHostProfiles)
+		adminGroup.POST("/host-profiles", adminAPI.CreateHostProfile)
+		adminGroup.PUT("/host-profiles/:id", adminAPI.UpdateHostProfile)
+		adminGroup.DELETE("/host-profiles/:id", adminAPI.DeleteHostProfile)
 	}
 
 	// 静态文件服务（管理后台）
@@ -131,12 +170,19 @@ func main() {
 		log.Println("Shutting down gracefully...")
 		service.GetStatService().Stop()
 		service.GetImageFetchService().Stop()
+		service.GetRandomIndexService().Stop()
+		service.GetShuffleBagService().Stop()
+		service.GetLinkCheckService().Stop()
+		service.GetMirrorService().Stop()
 		os.Exit(0)
 	}()
 
 	// 启动后台服务
 	log.Println("Starting background services...")
-	service.GetImageFetchService() // 启动fetch服务
+	service.GetImageFetchService()  // 启动fetch服务
+	service.GetRandomIndexService() // 构建随机选图索引
+	service.GetLinkCheckService()   // 定期检查原图链接
+	service.GetMirrorService()      // 镜像原图到自有存储
 
 	// 启动服务器
 	port := os.Getenv("PORT")
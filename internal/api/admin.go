@@ -1,8 +1,10 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"randimg/internal/database"
@@ -13,29 +15,63 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // AdminAPI 管理API处理器
 type AdminAPI struct {
 	statService *service.StatService
+	randomIndex *service.RandomIndexService
+	proxyCache  *service.ProxyCacheService
+	upstream    *service.UpstreamService
+	linkCheck   *service.LinkCheckService
+	mirror      *service.MirrorService
+	imageFetch  *service.ImageFetchService
+}
+
+// maxWeight 图片/分类权重上限
+const maxWeight = 10000
+
+// validateWeight 校验权重取值范围
+func validateWeight(weight int) error {
+	if weight < 0 || weight > maxWeight {
+		return fmt.Errorf("weight must be between 0 and %d", maxWeight)
+	}
+	return nil
+}
+
+// validateRating 校验内容分级取值
+func validateRating(rating string) error {
+	if model.RatingLevel(rating) < 0 {
+		return fmt.Errorf("rating must be one of %s", strings.Join(model.Ratings, ", "))
+	}
+	return nil
 }
 
 // NewAdminAPI 创建管理API处理器
 func NewAdminAPI() *AdminAPI {
 	return &AdminAPI{
 		statService: service.GetStatService(),
+		randomIndex: service.GetRandomIndexService(),
+		proxyCache:  service.GetProxyCacheService(),
+		upstream:    service.GetUpstreamService(),
+		linkCheck:   service.GetLinkCheckService(),
+		mirror:      service.GetMirrorService(),
+		imageFetch:  service.GetImageFetchService(),
 	}
 }
 
 // ========== 图片管理 ==========
 
 // ListImages 获取图片列表
-// GET /api/admin/images?page=1&page_size=20&category=acg&status=active
+// GET /api/admin/images?page=1&page_size=20&category=acg&status=active&rating=safe
 func (api *AdminAPI) ListImages(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 	category := c.Query("category")
 	status := c.Query("status")
+	rating := c.Query("rating")
 
 	if page < 1 {
 		page = 1
@@ -44,7 +80,7 @@ func (api *AdminAPI) ListImages(c *gin.Context) {
 		pageSize = 20
 	}
 
-	query := database.DB.Model(&model.Image{}).Preload("Category")
+	query := database.DB.Model(&model.Image{}).Preload("Category").Preload("Tags")
 
 	if category != "" {
 		var cat model.Category
@@ -57,6 +93,10 @@ func (api *AdminAPI) ListImages(c *gin.Context) {
 		query = query.Where("status = ?", status)
 	}
 
+	if rating != "" {
+		query = query.Where("rating = ?", rating)
+	}
+
 	var total int64
 	query.Count(&total)
 
@@ -66,6 +106,9 @@ func (api *AdminAPI) ListImages(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range images {
+		service.FillVariants(&images[i])
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": images,
@@ -84,10 +127,11 @@ func (api *AdminAPI) GetImage(c *gin.Context) {
 	id := c.Param("id")
 
 	var image model.Image
-	if err := database.DB.Preload("Category").First(&image, id).Error; err != nil {
+	if err := database.DB.Preload("Category").Preload("Tags").First(&image, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
+	service.FillVariants(&image)
 
 	c.JSON(http.StatusOK, image)
 }
@@ -102,8 +146,12 @@ func (api *AdminAPI) BatchCreateImages(c *gin.Context) {
 			Height     *int   `json:"height"`
 			Format     string `json:"format"`
 			Source     string `json:"source"`
+			Weight     *int   `json:"weight"`
+			Rating     string `json:"rating"`
 			CategoryID uint   `json:"category_id" binding:"required"`
+			TagIDs     []uint `json:"tag_ids"`
 			AutoFetch  bool   `json:"auto_fetch"`
+			Mirror     bool   `json:"mirror"`
 		} `json:"images" binding:"required,min=1,max=1000"` // 最多1000条
 	}
 
@@ -112,20 +160,58 @@ func (api *AdminAPI) BatchCreateImages(c *gin.Context) {
 		return
 	}
 
+	// 预先加载所有用到的标签
+	tagIDs := make([]uint, 0)
+	for _, item := range input.Images {
+		tagIDs = append(tagIDs, item.TagIDs...)
+	}
+	tags, err := loadTags(tagIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tagByID := make(map[uint]model.Tag, len(tags))
+	for _, tag := range tags {
+		tagByID[tag.ID] = tag
+	}
+
 	// 构建图片数据（先插入数据库）
 	images := make([]model.Image, len(input.Images))
 	needFetchIDs := make([]uint, 0)
 
 	for i, item := range input.Images {
+		if err := service.ValidateOutboundURL(item.SourceURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("images[%d]: %s", i, err.Error())})
+			return
+		}
+		if item.Weight != nil {
+			if err := validateWeight(*item.Weight); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("images[%d]: %s", i, err.Error())})
+				return
+			}
+		}
+		if item.Rating == "" {
+			item.Rating = model.RatingSafe
+		} else if err := validateRating(item.Rating); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("images[%d]: %s", i, err.Error())})
+			return
+		}
 		images[i] = model.Image{
 			SourceURL:  item.SourceURL,
 			Width:      item.Width,
 			Height:     item.Height,
 			Format:     item.Format,
 			Source:     item.Source,
+			Weight:     item.Weight,
+			Rating:     item.Rating,
 			CategoryID: item.CategoryID,
+			Mirror:     item.Mirror,
 			Status:     "active",
 		}
+		images[i].SetShape()
+		for _, tagID := range item.TagIDs {
+			images[i].Tags = append(images[i].Tags, tagByID[tagID])
+		}
 	}
 
 	// 使��事务批量插入
@@ -137,9 +223,16 @@ func (api *AdminAPI) BatchCreateImages(c *gin.Context) {
 	}
 	tx.Commit()
 
-	// 收集需要auto_fetch的图片ID
+	// 同步随机索引
+	created := make([]*model.Image, len(images))
+	for i := range images {
+		created[i] = &images[i]
+	}
+	api.randomIndex.Upsert(created...)
+
+	// 收集需要auto_fetch的图片ID（宽高已知时也需要分析颜色）
 	for i, item := range input.Images {
-		if item.AutoFetch && (item.Width == nil || item.Height == nil || item.Format == "") {
+		if item.AutoFetch {
 			needFetchIDs = append(needFetchIDs, images[i].ID)
 		}
 	}
@@ -147,9 +240,8 @@ func (api *AdminAPI) BatchCreateImages(c *gin.Context) {
 	// 异步提交fetch任务
 	if len(needFetchIDs) > 0 {
 		go func() {
-			fetchService := service.GetImageFetchService()
 			for _, id := range needFetchIDs {
-				fetchService.AddTask(id)
+				api.imageFetch.AddTask(id)
 			}
 		}()
 	}
@@ -171,14 +263,40 @@ func (api *AdminAPI) CreateImage(c *gin.Context) {
 		Height     *int   `json:"height"`
 		Format     string `json:"format"`
 		Source     string `json:"source"`
+		Weight     *int   `json:"weight"` // 为空时使用分类默认权重
+		Rating     string `json:"rating"` // 为空时为safe
 		CategoryID uint   `json:"category_id" binding:"required"`
+		TagIDs     []uint `json:"tag_ids"`
 		AutoFetch  bool   `json:"auto_fetch"` // 是否自动获取图片信息
+		Mirror     bool   `json:"mirror"`     // 是否将原图镜像到自有存储
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := service.ValidateOutboundURL(input.SourceURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Weight != nil {
+		if err := validateWeight(*input.Weight); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.Rating == "" {
+		input.Rating = model.RatingSafe
+	} else if err := validateRating(input.Rating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := loadTags(input.TagIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// 先插入数据库
 	image := model.Image{
@@ -187,21 +305,24 @@ func (api *AdminAPI) CreateImage(c *gin.Context) {
 		Height:     input.Height,
 		Format:     input.Format,
 		Source:     input.Source,
+		Weight:     input.Weight,
+		Rating:     input.Rating,
 		CategoryID: input.CategoryID,
+		Tags:       tags,
+		Mirror:     input.Mirror,
 		Status:     "active",
 	}
+	image.SetShape()
 
 	if err := database.DB.Create(&image).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	api.randomIndex.Upsert(&image)
 
-	// 如果需要auto_fetch，异步提交任务
-	if input.AutoFetch && (input.Width == nil || input.Height == nil || input.Format == "") {
-		go func() {
-			fetchService := service.GetImageFetchService()
-			fetchService.AddTask(image.ID)
-		}()
+	// 如果需要auto_fetch，异步提交任务（宽高已知时也需要分析颜色）
+	if input.AutoFetch {
+		go api.imageFetch.AddTask(image.ID)
 	}
 
 	c.JSON(http.StatusCreated, image)
@@ -226,6 +347,10 @@ func (api *AdminAPI) UpdateImage(c *gin.Context) {
 		Source     *string `json:"source"`
 		CategoryID *uint   `json:"category_id"`
 		Status     *string `json:"status"`
+		Rating     *string `json:"rating"`
+		Weight     *int    `json:"weight"`  // 负数表示清除，恢复使用分类默认权重
+		TagIDs     *[]uint `json:"tag_ids"` // 提供时整体替换图片的标签
+		Mirror     *bool   `json:"mirror"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -234,8 +359,12 @@ func (api *AdminAPI) UpdateImage(c *gin.Context) {
 	}
 
 	updates := make(map[string]interface{})
-	if input.SourceURL != nil {
-		updates["source_url"] = *input.SourceURL
+	sourceURLChanged := input.SourceURL != nil && *input.SourceURL != image.SourceURL
+	if sourceURLChanged {
+		if err := sourceURLUpdates(&image, *input.SourceURL, updates); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 	if input.Width != nil {
 		updates["width"] = *input.Width
@@ -243,6 +372,17 @@ func (api *AdminAPI) UpdateImage(c *gin.Context) {
 	if input.Height != nil {
 		updates["height"] = *input.Height
 	}
+	if input.Width != nil || input.Height != nil {
+		// 宽高变化时同步更新宽高比和方向
+		width, height := image.Width, image.Height
+		if input.Width != nil {
+			width = input.Width
+		}
+		if input.Height != nil {
+			height = input.Height
+		}
+		updates["aspect_ratio"], updates["orientation"] = model.ImageShape(width, height)
+	}
 	if input.Format != nil {
 		updates["format"] = *input.Format
 	}
@@ -255,15 +395,98 @@ func (api *AdminAPI) UpdateImage(c *gin.Context) {
 	if input.Status != nil {
 		updates["status"] = *input.Status
 	}
+	if input.SourceURL != nil || input.Status != nil {
+		// 地址或状态由管理员修改后重新开始链接检查
+		updates["check_failures"] = 0
+		updates["next_check_at"] = nil
+	}
+	if input.Weight != nil {
+		if *input.Weight < 0 {
+			updates["weight"] = nil
+		} else if err := validateWeight(*input.Weight); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else {
+			updates["weight"] = *input.Weight
+		}
+	}
+	if input.Rating != nil {
+		if err := validateRating(*input.Rating); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["rating"] = *input.Rating
+	}
+	if input.Mirror != nil {
+		updates["mirror"] = *input.Mirror
+	}
 
-	if err := database.DB.Model(&image).Updates(updates).Error; err != nil {
+	var tags []model.Tag
+	if input.TagIDs != nil {
+		var err error
+		if tags, err = loadTags(*input.TagIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// 字段和标签在同一事务中更新，避免只更新了一半
+	oldImage := image
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&image).Updates(updates).Error; err != nil {
+			return err
+		}
+		if input.TagIDs != nil {
+			return tx.Model(&image).Association("Tags").Replace(tags)
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if sourceURLChanged {
+		api.afterSourceURLChange(c.Request.Context(), oldImage)
+	}
+
+	api.randomIndex.Refresh(image.ID)
 
 	c.JSON(http.StatusOK, image)
 }
 
+// sourceURLUpdates 校验新的原图地址，把需要一起更新的字段加入updates
+// 上传的图片原图保存在存储中，不能改为其他地址；镜像副本、缩略图和图片信息按新的原图重新生成
+func sourceURLUpdates(image *model.Image, sourceURL string, updates map[string]interface{}) error {
+	if _, ok := service.StorageKeyFromURL(image.SourceURL); ok {
+		return errors.New("source_url of uploaded images cannot be changed")
+	}
+	if err := service.ValidateOutboundURL(sourceURL); err != nil {
+		return err
+	}
+	updates["source_url"] = sourceURL
+	if image.StorageKey != "" {
+		// 原图地址变化后镜像副本失效，重新镜像
+		updates["storage_key"] = ""
+		updates["content_hash"] = ""
+		updates["file_size"] = nil
+		updates["mirrored_at"] = nil
+		updates["mirror_attempt_at"] = nil
+		updates["mirror_error"] = ""
+	}
+	// 缩略图和图片信息按新的原图重新生成
+	updates["variant_version"] = ""
+	updates["variant_widths"] = ""
+	updates["analyzed_at"] = nil
+	return nil
+}
+
+// afterSourceURLChange 原图地址修改后删除旧的镜像副本和缩略图，重新分析图片
+func (api *AdminAPI) afterSourceURLChange(ctx context.Context, oldImage model.Image) {
+	service.GetStorageService().DeleteUnreferenced(ctx, oldImage.StorageKey)
+	service.GetVariantService().Delete(ctx, oldImage)
+	api.imageFetch.AddTask(oldImage.ID)
+}
+
 // DeleteImage 删除图片（硬删除）
 // DELETE /api/admin/images/:id
 func (api *AdminAPI) DeleteImage(c *gin.Context) {
@@ -280,6 +503,11 @@ func (api *AdminAPI) DeleteImage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	database.DB.Where("image_id = ?", image.ID).Delete(&model.ImageTag{})
+	api.randomIndex.Remove(image.ID)
+	api.proxyCache.PurgeImage(image.ID)
+	service.GetStorageService().DeleteUnreferenced(c.Request.Context(), image.StorageKey)
+	service.GetVariantService().Delete(c.Request.Context(), image)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
 }
@@ -325,7 +553,7 @@ func (api *AdminAPI) AutoFetchImageInfo(c *gin.Context) {
 	errors := make([]string, 0)
 
 	for _, image := range images {
-		info, err := infoService.GetImageInfo(image.SourceURL)
+		info, err := infoService.GetImageInfo(service.ImageOriginURL(&image))
 		if err != nil {
 			failed++
 			errors = append(errors, fmt.Sprintf("Image %d: %s", image.ID, err.Error()))
@@ -334,15 +562,21 @@ func (api *AdminAPI) AutoFetchImageInfo(c *gin.Context) {
 
 		// 更新图片信息
 		updates := make(map[string]interface{})
+		width, height := image.Width, image.Height
 		if image.Width == nil && info.Width > 0 {
 			updates["width"] = info.Width
+			width = &info.Width
 		}
 		if image.Height == nil && info.Height > 0 {
 			updates["height"] = info.Height
+			height = &info.Height
 		}
 		if (image.Format == "" || image.Format == "null") && info.Format != "" {
 			updates["format"] = info.Format
 		}
+		if len(updates) > 0 {
+			updates["aspect_ratio"], updates["orientation"] = model.ImageShape(width, height)
+		}
 
 		if len(updates) > 0 {
 			if err := database.DB.Model(&image).Updates(updates).Error; err != nil {
@@ -350,6 +584,7 @@ func (api *AdminAPI) AutoFetchImageInfo(c *gin.Context) {
 				errors = append(errors, fmt.Sprintf("Image %d: failed to update - %s", image.ID, err.Error()))
 			} else {
 				updated++
+				api.randomIndex.Refresh(image.ID)
 			}
 		}
 	}
@@ -380,12 +615,83 @@ func (api *AdminAPI) BatchUpdateImages(c *gin.Context) {
 		return
 	}
 
+	// 校验权重：null表示恢复使用分类默认权重
+	if weight, ok := input.Updates["weight"]; ok && weight != nil {
+		value, isNumber := weight.(float64)
+		if !isNumber || value != float64(int(value)) || validateWeight(int(value)) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("weight must be null or an integer between 0 and %d", maxWeight)})
+			return
+		}
+	}
+
+	if rating, ok := input.Updates["rating"]; ok {
+		value, isString := rating.(string)
+		if !isString || validateRating(value) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("rating must be one of %s", strings.Join(model.Ratings, ", "))})
+			return
+		}
+	}
+
+	// 宽高比和方向由宽高计算得出，不允许直接修改
+	delete(input.Updates, "aspect_ratio")
+	delete(input.Updates, "orientation")
+	// 原图在存储中的位置、镜像状态和缩略图由上传、镜像和缩略图服务管理，不允许直接修改
+	for _, field := range []string{"storage_key", "content_hash", "file_size", "mirrored_at", "mirror_attempt_at", "mirror_error",
+		"variant_version", "variant_widths"} {
+		delete(input.Updates, field)
+	}
+
+	// 修改图片地址与单张更新相同；地址不能重复，一次只能修改一张图片
+	var oldImage *model.Image
+	if sourceURL, ok := input.Updates["source_url"]; ok {
+		value, isString := sourceURL.(string)
+		if !isString {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source_url must be a string"})
+			return
+		}
+		if len(input.ImageIDs) > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source_url can only be changed for one image at a time"})
+			return
+		}
+		var image model.Image
+		if err := database.DB.First(&image, input.ImageIDs[0]).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		delete(input.Updates, "source_url")
+		if value != image.SourceURL {
+			if err := sourceURLUpdates(&image, value, input.Updates); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			oldImage = &image
+		}
+	}
+
+	if _, ok := input.Updates["status"]; ok || oldImage != nil {
+		// 地址或状态由管理员修改后重新开始链接检查
+		input.Updates["check_failures"] = 0
+		input.Updates["next_check_at"] = nil
+	}
+
 	// 批量更新
 	result := database.DB.Model(&model.Image{}).Where("id IN ?", input.ImageIDs).Updates(input.Updates)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	_, hasWidth := input.Updates["width"]
+	_, hasHeight := input.Updates["height"]
+	if hasWidth || hasHeight {
+		if err := database.RefreshImageShapes(input.ImageIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if oldImage != nil {
+		api.afterSourceURLChange(c.Request.Context(), *oldImage)
+	}
+	api.randomIndex.Refresh(input.ImageIDs...)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Batch update successful",
@@ -410,12 +716,29 @@ func (api *AdminAPI) BatchDeleteImages(c *gin.Context) {
 		return
 	}
 
+	// 删除后清理不再被引用的存储对象和缩略图
+	var stored []model.Image
+	database.DB.Select("id", "storage_key", "variant_version", "variant_widths").Where("id IN ?", input.ImageIDs).Find(&stored)
+	storageKeys := make([]string, 0, len(stored))
+	for _, image := range stored {
+		if image.StorageKey != "" {
+			storageKeys = append(storageKeys, image.StorageKey)
+		}
+	}
+
 	// 批量硬删除
 	result := database.DB.Unscoped().Where("id IN ?", input.ImageIDs).Delete(&model.Image{})
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	database.DB.Where("image_id IN ?", input.ImageIDs).Delete(&model.ImageTag{})
+	api.randomIndex.Remove(input.ImageIDs...)
+	for _, id := range input.ImageIDs {
+		api.proxyCache.PurgeImage(id)
+	}
+	service.GetStorageService().DeleteUnreferenced(c.Request.Context(), storageKeys...)
+	service.GetVariantService().Delete(c.Request.Context(), stored...)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Batch delete successful",
@@ -423,6 +746,87 @@ func (api *AdminAPI) BatchDeleteImages(c *gin.Context) {
 	})
 }
 
+// BatchTagImages 批量添加/移除图片标签
+// POST /api/admin/images/batch/tags
+func (api *AdminAPI) BatchTagImages(c *gin.Context) {
+	var input struct {
+		ImageIDs []uint `json:"image_ids" binding:"required,min=1"`
+		TagIDs   []uint `json:"tag_ids" binding:"required,min=1"`
+		Action   string `json:"action" binding:"required,oneof=add remove"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := loadTags(input.TagIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var affected int64
+	if input.Action == "add" {
+		// 只为存在的图片添加标签
+		var imageIDs []uint
+		if err := database.DB.Model(&model.Image{}).Where("id IN ?", input.ImageIDs).Pluck("id", &imageIDs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		rows := make([]model.ImageTag, 0, len(imageIDs)*len(input.TagIDs))
+		for _, imageID := range imageIDs {
+			for _, tagID := range input.TagIDs {
+				rows = append(rows, model.ImageTag{ImageID: imageID, TagID: tagID})
+			}
+		}
+		if len(rows) > 0 {
+			result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500)
+			if result.Error != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+				return
+			}
+			affected = result.RowsAffected
+		}
+	} else {
+		result := database.DB.Where("image_id IN ? AND tag_id IN ?", input.ImageIDs, input.TagIDs).Delete(&model.ImageTag{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		affected = result.RowsAffected
+	}
+	api.randomIndex.Refresh(input.ImageIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Batch tag update successful",
+		"affected": affected,
+	})
+}
+
+// loadTags 按ID加载标签，有不存在的标签时返回错误
+func loadTags(ids []uint) ([]model.Tag, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var tags []model.Tag
+	if err := database.DB.Where("id IN ?", ids).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint]bool, len(tags))
+	for _, tag := range tags {
+		found[tag.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, fmt.Errorf("tag %d not found", id)
+		}
+	}
+	return tags, nil
+}
+
 // ========== 分类管理 ==========
 
 // ListCategories 获取分类列表
@@ -441,9 +845,11 @@ func (api *AdminAPI) ListCategories(c *gin.Context) {
 // POST /api/admin/categories
 func (api *AdminAPI) CreateCategory(c *gin.Context) {
 	var input struct {
-		Name        string `json:"name" binding:"required"`
-		Slug        string `json:"slug" binding:"required"`
-		Description string `json:"description"`
+		Name          string `json:"name" binding:"required"`
+		Slug          string `json:"slug" binding:"required"`
+		Description   string `json:"description"`
+		DefaultWeight *int   `json:"default_weight"` // 默认为1
+		Mirror        bool   `json:"mirror"`         // 是否镜像分类下所有图片的原图
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -451,16 +857,28 @@ func (api *AdminAPI) CreateCategory(c *gin.Context) {
 		return
 	}
 
+	defaultWeight := 1
+	if input.DefaultWeight != nil {
+		if err := validateWeight(*input.DefaultWeight); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defaultWeight = *input.DefaultWeight
+	}
+
 	category := model.Category{
-		Name:        input.Name,
-		Slug:        input.Slug,
-		Description: input.Description,
+		Name:          input.Name,
+		Slug:          input.Slug,
+		Description:   input.Description,
+		DefaultWeight: defaultWeight,
+		Mirror:        input.Mirror,
 	}
 
 	if err := database.DB.Create(&category).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	api.randomIndex.SetCategoryWeight(category.ID, category.DefaultWeight)
 
 	c.JSON(http.StatusCreated, category)
 }
@@ -477,9 +895,11 @@ func (api *AdminAPI) UpdateCategory(c *gin.Context) {
 	}
 
 	var input struct {
-		Name        *string `json:"name"`
-		Slug        *string `json:"slug"`
-		Description *string `json:"description"`
+		Name          *string `json:"name"`
+		Slug          *string `json:"slug"`
+		Description   *string `json:"description"`
+		DefaultWeight *int    `json:"default_weight"`
+		Mirror        *bool   `json:"mirror"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -497,11 +917,24 @@ func (api *AdminAPI) UpdateCategory(c *gin.Context) {
 	if input.Description != nil {
 		updates["description"] = *input.Description
 	}
+	if input.DefaultWeight != nil {
+		if err := validateWeight(*input.DefaultWeight); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["default_weight"] = *input.DefaultWeight
+	}
+	if input.Mirror != nil {
+		updates["mirror"] = *input.Mirror
+	}
 
 	if err := database.DB.Model(&category).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if input.DefaultWeight != nil {
+		api.randomIndex.SetCategoryWeight(category.ID, *input.DefaultWeight)
+	}
 
 	c.JSON(http.StatusOK, category)
 }
@@ -527,6 +960,120 @@ func (api *AdminAPI) DeleteCategory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
 }
 
+// ========== 标签管理 ==========
+
+// ListTags 获取标签列表（含图片数量）
+// GET /api/admin/tags
+func (api *AdminAPI) ListTags(c *gin.Context) {
+	var tags []struct {
+		model.Tag
+		ImageCount int64 `json:"image_count"`
+	}
+	if err := database.DB.Model(&model.Tag{}).
+		Select("tags.*, (SELECT COUNT(*) FROM image_tags WHERE image_tags.tag_id = tags.id) AS image_count").
+		Order("name").Find(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// CreateTag 创建标签
+// POST /api/admin/tags
+func (api *AdminAPI) CreateTag(c *gin.Context) {
+	var input struct {
+		Name string `json:"name" binding:"required"`
+		Slug string `json:"slug" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag := model.Tag{
+		Name: strings.TrimSpace(input.Name),
+		Slug: strings.TrimSpace(input.Slug),
+	}
+
+	if err := database.DB.Create(&tag).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, tag)
+}
+
+// UpdateTag 更新标签
+// PUT /api/admin/tags/:id
+func (api *AdminAPI) UpdateTag(c *gin.Context) {
+	id := c.Param("id")
+
+	var tag model.Tag
+	if err := database.DB.First(&tag, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+		Slug *string `json:"slug"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if input.Name != nil {
+		updates["name"] = strings.TrimSpace(*input.Name)
+	}
+	if input.Slug != nil {
+		updates["slug"] = strings.TrimSpace(*input.Slug)
+	}
+
+	if err := database.DB.Model(&tag).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTag 删除标签（同时移除所有图片上的该标签）
+// DELETE /api/admin/tags/:id
+func (api *AdminAPI) DeleteTag(c *gin.Context) {
+	id := c.Param("id")
+
+	var tag model.Tag
+	if err := database.DB.First(&tag, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	var imageIDs []uint
+	database.DB.Model(&model.ImageTag{}).Where("tag_id = ?", tag.ID).Pluck("image_id", &imageIDs)
+
+	tx := database.DB.Begin()
+	if err := tx.Where("tag_id = ?", tag.ID).Delete(&model.ImageTag{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Delete(&tag).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	api.randomIndex.Refresh(imageIDs...)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
 // ========== API Key管理 ==========
 
 // ListAPIKeys 获取API Key列表
@@ -547,6 +1094,7 @@ func (api *AdminAPI) CreateAPIKey(c *gin.Context) {
 	var input struct {
 		Key       string `json:"key"`        // 可选，自定义key
 		RateLimit int    `json:"rate_limit" binding:"required,min=1"`
+		MaxRating string `json:"max_rating"` // 可选，默认safe
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -554,6 +1102,13 @@ func (api *AdminAPI) CreateAPIKey(c *gin.Context) {
 		return
 	}
 
+	if input.MaxRating == "" {
+		input.MaxRating = model.RatingSafe
+	} else if err := validateRating(input.MaxRating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_" + err.Error()})
+		return
+	}
+
 	// Trim空格并处理key
 	key := strings.TrimSpace(input.Key)
 	if key == "" {
@@ -570,6 +1125,7 @@ func (api *AdminAPI) CreateAPIKey(c *gin.Context) {
 	apiKey := model.APIKey{
 		Key:       key,
 		RateLimit: input.RateLimit,
+		MaxRating: input.MaxRating,
 		Status:    "active",
 	}
 
@@ -596,6 +1152,7 @@ func (api *AdminAPI) UpdateAPIKey(c *gin.Context) {
 		Key       *string `json:"key"`
 		RateLimit *int    `json:"rate_limit"`
 		Status    *string `json:"status"`
+		MaxRating *string `json:"max_rating"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -624,6 +1181,13 @@ func (api *AdminAPI) UpdateAPIKey(c *gin.Context) {
 	if input.Status != nil {
 		updates["status"] = *input.Status
 	}
+	if input.MaxRating != nil {
+		if err := validateRating(*input.MaxRating); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_" + err.Error()})
+			return
+		}
+		updates["max_rating"] = *input.MaxRating
+	}
 
 	if err := database.DB.Model(&apiKey).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -723,6 +1287,151 @@ func (api *AdminAPI) GetStatsOverview(c *gin.Context) {
 	})
 }
 
+// ========== 代理缓存 ==========
+
+// GetProxyCacheStats 获取代理缓存统计
+// GET /api/admin/proxy-cache
+func (api *AdminAPI) GetProxyCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, api.proxyCache.Stats())
+}
+
+// GetUpstreamStats 获取上游请求的并发、排队和合并统计
+// GET /api/admin/upstream
+func (api *AdminAPI) GetUpstreamStats(c *gin.Context) {
+	c.JSON(http.StatusOK, api.upstream.Stats())
+}
+
+// GetLinkCheckStats 获取链接检查统计
+// GET /api/admin/link-check
+func (api *AdminAPI) GetLinkCheckStats(c *gin.Context) {
+	c.JSON(http.StatusOK, api.linkCheck.Stats())
+}
+
+// maxLinkCheckIDs 手动检查一次最多允许的图片数量
+const maxLinkCheckIDs = 50
+
+// RunLinkCheck 手动检查链接
+// POST /api/admin/link-check
+// 提供 image_ids 时立即检查这些图片并返回结果，否则在后台检查一批已到检查时间的图片
+func (api *AdminAPI) RunLinkCheck(c *gin.Context) {
+	var input struct {
+		ImageIDs []uint `json:"image_ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if len(input.ImageIDs) == 0 {
+		go api.linkCheck.RunOnce()
+		c.JSON(http.StatusAccepted, gin.H{"message": "Link check started"})
+		return
+	}
+	if len(input.ImageIDs) > maxLinkCheckIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("image_ids accepts at most %d ids", maxLinkCheckIDs)})
+		return
+	}
+
+	var images []model.Image
+	if err := database.DB.Where("id IN ?", input.ImageIDs).Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]gin.H, len(images))
+	for i := range images {
+		result := gin.H{"id": images[i].ID, "ok": true}
+		if err := api.linkCheck.CheckImage(&images[i]); err != nil {
+			result["ok"] = false
+			result["error"] = err.Error()
+		}
+		database.DB.Select("status", "check_failures").First(&images[i], images[i].ID)
+		result["status"] = images[i].Status
+		result["check_failures"] = images[i].CheckFailures
+		results[i] = result
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+// ========== 镜像 ==========
+
+// GetMirrorStats 获取镜像统计
+// GET /api/admin/mirror
+func (api *AdminAPI) GetMirrorStats(c *gin.Context) {
+	c.JSON(http.StatusOK, api.mirror.Stats())
+}
+
+// maxMirrorIDs 手动镜像一次最多允许的图片数量
+const maxMirrorIDs = 20
+
+// RunMirror 手动镜像
+// POST /api/admin/mirror
+// 提供 image_ids 时立即镜像这些图片（已有副本时重新下载）并返回结果，否则在后台处理一批待镜像的图片
+func (api *AdminAPI) RunMirror(c *gin.Context) {
+	var input struct {
+		ImageIDs []uint `json:"image_ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if len(input.ImageIDs) == 0 {
+		go api.mirror.RunOnce()
+		c.JSON(http.StatusAccepted, gin.H{"message": "Mirroring started"})
+		return
+	}
+	if len(input.ImageIDs) > maxMirrorIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("image_ids accepts at most %d ids", maxMirrorIDs)})
+		return
+	}
+
+	var images []model.Image
+	if err := database.DB.Where("id IN ?", input.ImageIDs).Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]gin.H, len(images))
+	for i := range images {
+		result := gin.H{"id": images[i].ID, "ok": true}
+		if err := api.mirror.MirrorImage(&images[i]); err != nil {
+			result["ok"] = false
+			result["error"] = err.Error()
+		} else {
+			database.DB.Select("storage_key", "content_hash", "file_size").First(&images[i], images[i].ID)
+			result["storage_key"] = images[i].StorageKey
+			result["content_hash"] = images[i].ContentHash
+			result["file_size"] = images[i].FileSize
+		}
+		results[i] = result
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+// PurgeProxyCache 清除代理缓存，指定image_id时只清除该图片的缓存
+// DELETE /api/admin/proxy-cache?image_id=1
+func (api *AdminAPI) PurgeProxyCache(c *gin.Context) {
+	imageIDStr := c.Query("image_id")
+	if imageIDStr == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Proxy cache purged", "purged": api.proxyCache.PurgeAll()})
+		return
+	}
+
+	imageID, err := strconv.ParseUint(imageIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image_id"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Proxy cache purged", "purged": api.proxyCache.PurgeImage(uint(imageID))})
+}
+
 // generateAPIKey 生成随机API key
 func generateAPIKey() string {
 	b := make([]byte, 32)
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestAdminAPI 创建测试用的管理API，分析任务只加入队列，不启动worker
+func newTestAdminAPI() *AdminAPI {
+	return &AdminAPI{randomIndex: service.NewRandomIndexService(), imageFetch: service.NewImageFetchService(1)}
+}
+
+// updateImage 以JSON请求体调用 UpdateImage，返回状态码
+func updateImage(t *testing.T, id uint, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/admin/images/"+strconv.Itoa(int(id)), strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(id))}}
+
+	newTestAdminAPI().UpdateImage(c)
+	return w.Code
+}
+
+// imageTagIDs 查询图片当前的标签ID
+func imageTagIDs(t *testing.T, id uint) []uint {
+	t.Helper()
+	var ids []uint
+	if err := database.DB.Model(&model.ImageTag{}).Where("image_id = ?", id).Order("tag_id").Pluck("tag_id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestUpdateImageTags(t *testing.T) {
+	setupTestDB(t)
+	createCategories(t, "acg")
+	tags := []model.Tag{{Name: "night", Slug: "night"}, {Name: "city", Slug: "city"}}
+	if err := database.DB.Create(&tags).Error; err != nil {
+		t.Fatal(err)
+	}
+	image := model.Image{SourceURL: "https://img.example.com/1.jpg", Status: "active", Rating: model.RatingSafe, CategoryID: 1}
+	if err := database.DB.Create(&image).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"rating":"questionable","tag_ids":[` + strconv.Itoa(int(tags[0].ID)) + `,` + strconv.Itoa(int(tags[1].ID)) + `]}`
+	if code := updateImage(t, image.ID, body); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if got := imageTagIDs(t, image.ID); len(got) != 2 {
+		t.Fatalf("tags = %v, want both", got)
+	}
+
+	// 标签不存在时整个更新失败，字段和标签都保持不变
+	if code := updateImage(t, image.ID, `{"rating":"explicit","tag_ids":[999]}`); code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", code)
+	}
+	var stored model.Image
+	database.DB.First(&stored, image.ID)
+	if stored.Rating != model.RatingQuestionable {
+		t.Errorf("rating = %q, want questionable", stored.Rating)
+	}
+	if got := imageTagIDs(t, image.ID); len(got) != 2 {
+		t.Errorf("tags = %v, want unchanged", got)
+	}
+
+	// 空列表清除全部标签
+	if code := updateImage(t, image.ID, `{"tag_ids":[]}`); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if got := imageTagIDs(t, image.ID); len(got) != 0 {
+		t.Errorf("tags = %v, want none", got)
+	}
+}
+
+// batchUpdateImages 以JSON请求体调用 BatchUpdateImages，返回状态码
+func batchUpdateImages(t *testing.T, api *AdminAPI, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/admin/images/batch", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	api.BatchUpdateImages(c)
+	return w.Code
+}
+
+func TestBatchUpdateValidatesSourceURL(t *testing.T) {
+	setupTestDB(t)
+	createCategories(t, "acg")
+	image := model.Image{SourceURL: "https://img.example.com/1.jpg", Status: "active", Rating: model.RatingSafe, CategoryID: 1}
+	if err := database.DB.Create(&image).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ids := `"image_ids":[` + strconv.Itoa(int(image.ID)) + `]`
+	for _, value := range []string{`"http://127.0.0.1/a.jpg"`, `"file:///etc/passwd"`, `"storage://uploads/a.jpg"`, `123`} {
+		if code := batchUpdateImages(t, newTestAdminAPI(), `{`+ids+`,"updates":{"source_url":`+value+`}}`); code != http.StatusBadRequest {
+			t.Errorf("source_url %s: status = %d, want 400", value, code)
+		}
+	}
+	var stored model.Image
+	database.DB.First(&stored, image.ID)
+	if stored.SourceURL != image.SourceURL {
+		t.Errorf("source_url changed to %q", stored.SourceURL)
+	}
+
+	if code := batchUpdateImages(t, newTestAdminAPI(), `{`+ids+`,"updates":{"source_url":"https://img.example.com/2.jpg"}}`); code != http.StatusOK {
+		t.Errorf("valid source_url: status = %d, want 200", code)
+	}
+}
+
+func TestBatchUpdateSourceURL(t *testing.T) {
+	setupTestDB(t)
+	createCategories(t, "acg")
+	now := time.Now()
+	mirrored := model.Image{
+		SourceURL: "https://img.example.com/1.jpg", StorageKey: "mirrors/ab/ab.jpg", ContentHash: "ab", MirroredAt: &now,
+		VariantVersion: "abc", AnalyzedAt: &now, CheckFailures: 2,
+	}
+	other := model.Image{SourceURL: "https://img.example.com/2.jpg"}
+	uploaded := model.Image{SourceURL: service.StorageURL("uploads/ab/ab.jpg"), StorageKey: "uploads/ab/ab.jpg"}
+	for _, image := range []*model.Image{&mirrored, &other, &uploaded} {
+		image.Status, image.Rating, image.CategoryID = "active", model.RatingSafe, 1
+		if err := database.DB.Create(image).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	body := func(url string, ids ...uint) string {
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = strconv.Itoa(int(id))
+		}
+		return `{"image_ids":[` + strings.Join(parts, ",") + `],"updates":{"source_url":"` + url + `"}}`
+	}
+
+	api := newTestAdminAPI()
+	// 上传的图片不能修改地址；地址唯一，一次只能修改一张
+	if code := batchUpdateImages(t, api, body("https://img.example.com/3.jpg", uploaded.ID)); code != http.StatusBadRequest {
+		t.Errorf("uploaded image: status = %d, want 400", code)
+	}
+	if code := batchUpdateImages(t, api, body("https://img.example.com/3.jpg", mirrored.ID, other.ID)); code != http.StatusBadRequest {
+		t.Errorf("several images: status = %d, want 400", code)
+	}
+	if api.imageFetch.GetQueueSize() != 0 {
+		t.Fatal("rejected update queued an analysis")
+	}
+
+	// 与单张更新一样重置镜像副本、缩略图、图片信息和链接检查，并重新分析
+	if code := batchUpdateImages(t, api, body("https://img.example.com/3.jpg", mirrored.ID)); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	var stored model.Image
+	database.DB.First(&stored, mirrored.ID)
+	if stored.SourceURL != "https://img.example.com/3.jpg" || stored.StorageKey != "" || stored.ContentHash != "" || stored.MirroredAt != nil {
+		t.Errorf("mirror not reset: url %q, key %q", stored.SourceURL, stored.StorageKey)
+	}
+	if stored.VariantVersion != "" || stored.AnalyzedAt != nil || stored.CheckFailures != 0 {
+		t.Errorf("variant %q, analyzed_at %v, check failures %d", stored.VariantVersion, stored.AnalyzedAt, stored.CheckFailures)
+	}
+	if api.imageFetch.GetQueueSize() != 1 {
+		t.Errorf("queued analyses = %d, want 1", api.imageFetch.GetQueueSize())
+	}
+
+	// 地址未变化时不重置
+	if code := batchUpdateImages(t, api, body(other.SourceURL, other.ID)); code != http.StatusOK {
+		t.Fatalf("unchanged url: status = %d, want 200", code)
+	}
+	if api.imageFetch.GetQueueSize() != 1 {
+		t.Error("unchanged url queued an analysis")
+	}
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultDuplicateDistance 默认的感知哈希汉明距离阈值
+	defaultDuplicateDistance = 6
+	// maxDuplicateDistance 汉明距离阈值上限，过大时几乎所有图片都会被归为一组
+	maxDuplicateDistance = 16
+	// maxSearchUploadBytes 以图搜图上传图片的大小上限
+	maxSearchUploadBytes = 30 << 20
+)
+
+// ListDuplicates 列出疑似重复的图片分组
+// GET /api/admin/duplicates?distance=6&limit=50
+// 每组中建议保留分辨率最高（相同时最早导入）的图片，即 keep_id
+func (api *AdminAPI) ListDuplicates(c *gin.Context) {
+	distance, err := strconv.Atoi(c.DefaultQuery("distance", strconv.Itoa(defaultDuplicateDistance)))
+	if err != nil || distance < 0 || distance > maxDuplicateDistance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("distance must be between 0 and %d", maxDuplicateDistance)})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	clusters, err := service.FindDuplicateClusters(distance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total := len(clusters)
+	if len(clusters) > limit {
+		clusters = clusters[:limit]
+	}
+
+	ids := make([]uint, 0)
+	for _, cluster := range clusters {
+		ids = append(ids, cluster...)
+	}
+	var images []model.Image
+	if len(ids) > 0 {
+		if err := database.DB.Preload("Category").Find(&images, ids).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	byID := make(map[uint]model.Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	result := make([]gin.H, 0, len(clusters))
+	for _, cluster := range clusters {
+		members := make([]model.Image, 0, len(cluster))
+		for _, id := range cluster {
+			if image, ok := byID[id]; ok {
+				members = append(members, image)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+		result = append(result, gin.H{
+			"keep_id": suggestKeepImage(members).ID,
+			"images":  members,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"distance": distance,
+		"total":    total,
+		"data":     result,
+	})
+}
+
+// MergeDuplicates 合并重复图片：重复图片的标签合并到保留的图片上，然后停用或删除重复图片
+// POST /api/admin/duplicates/merge
+// keep_id 为空时自动选择分辨率最高（相同时最早导入）的图片
+func (api *AdminAPI) MergeDuplicates(c *gin.Context) {
+	var input struct {
+		ImageIDs []uint `json:"image_ids" binding:"required,min=2"`
+		KeepID   uint   `json:"keep_id"`
+		Action   string `json:"action" binding:"omitempty,oneof=deactivate delete"` // 默认deactivate
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Action == "" {
+		input.Action = "deactivate"
+	}
+
+	var images []model.Image
+	if err := database.DB.Find(&images, input.ImageIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(images) < 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "At least two existing images are required"})
+		return
+	}
+
+	keep := suggestKeepImage(images)
+	if input.KeepID != 0 {
+		keep = nil
+		for i := range images {
+			if images[i].ID == input.KeepID {
+				keep = &images[i]
+				break
+			}
+		}
+		if keep == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "keep_id must be one of image_ids"})
+			return
+		}
+	}
+
+	duplicateIDs := make([]uint, 0, len(images)-1)
+	for _, image := range images {
+		if image.ID != keep.ID {
+			duplicateIDs = append(duplicateIDs, image.ID)
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 合并标签
+		var tagIDs []uint
+		if err := tx.Model(&model.ImageTag{}).Distinct("tag_id").Where("image_id IN ?", duplicateIDs).Pluck("tag_id", &tagIDs).Error; err != nil {
+			return err
+		}
+		if len(tagIDs) > 0 {
+			rows := make([]model.ImageTag, len(tagIDs))
+			for i, tagID := range tagIDs {
+				rows[i] = model.ImageTag{ImageID: keep.ID, TagID: tagID}
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+
+		if input.Action == "delete" {
+			if err := tx.Where("image_id IN ?", duplicateIDs).Delete(&model.ImageTag{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", duplicateIDs).Delete(&model.Image{}).Error
+		}
+		return tx.Model(&model.Image{}).Where("id IN ?", duplicateIDs).Update("status", "inactive").Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	api.randomIndex.Remove(duplicateIDs...)
+	api.randomIndex.Refresh(keep.ID)
+	if input.Action == "delete" {
+		storageKeys := make([]string, 0)
+		duplicates := make([]model.Image, 0, len(duplicateIDs))
+		for _, image := range images {
+			if image.ID != keep.ID {
+				api.proxyCache.PurgeImage(image.ID)
+				storageKeys = append(storageKeys, image.StorageKey)
+				duplicates = append(duplicates, image)
+			}
+		}
+		service.GetStorageService().DeleteUnreferenced(c.Request.Context(), storageKeys...)
+		service.GetVariantService().Delete(c.Request.Context(), duplicates...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Duplicates merged successfully",
+		"keep_id":       keep.ID,
+		"duplicate_ids": duplicateIDs,
+		"action":        input.Action,
+	})
+}
+
+// SearchSimilarImages 以图搜图：上传图片或提供图片URL，按视觉相似度返回图库中最相似的图片
+// POST /api/admin/images/search?limit=10&min_similarity=0.6
+// multipart/form-data 上传 file 字段，或提供 url（表单字段或JSON）
+func (api *AdminAPI) SearchSimilarImages(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	minSimilarity, err := strconv.ParseFloat(c.DefaultQuery("min_similarity", "0"), 64)
+	if err != nil || minSimilarity < 0 || minSimilarity > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_similarity must be between 0 and 1"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSearchUploadBytes)
+
+	var info *service.ImageInfo
+	if file, header, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read uploaded file: " + err.Error()})
+			return
+		}
+		if info, err = service.AnalyzeImageData(data, header.Header.Get("Content-Type")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		url := c.PostForm("url")
+		if url == "" && c.ContentType() == "application/json" {
+			var input struct {
+				URL string `json:"url"`
+			}
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			url = input.URL
+		}
+		if url == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Please upload a file or provide a url"})
+			return
+		}
+		if err := service.ValidateOutboundURL(url); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if info, err = service.NewImageInfoService().AnalyzeImage(url); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	results, err := service.SearchSimilarImages(info, limit, minSimilarity)
+	if errors.Is(err, service.ErrUnsupportedImage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]uint, len(results))
+	for i, result := range results {
+		ids[i] = result.ImageID
+	}
+	var images []model.Image
+	if len(ids) > 0 {
+		if err := database.DB.Preload("Category").Find(&images, ids).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	byID := make(map[uint]model.Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	data := make([]gin.H, 0, len(results))
+	for _, result := range results {
+		image, ok := byID[result.ImageID]
+		if !ok {
+			continue
+		}
+		data = append(data, gin.H{
+			"similarity":    result.Similarity,
+			"hash_distance": result.HashDistance,
+			"image":         image,
+		})
+	}
+
+	query := gin.H{
+		"width":           info.Width,
+		"height":          info.Height,
+		"format":          info.Format,
+		"perceptual_hash": info.PerceptualHash,
+	}
+	if info.Colors != nil {
+		query["dominant_color"] = info.Colors.DominantColor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query": query,
+		"data":  data,
+	})
+}
+
+// suggestKeepImage 在重复图片中选择建议保留的一张：优先有效图片，其次分辨率最高，最后最早导入
+func suggestKeepImage(images []model.Image) *model.Image {
+	best := &images[0]
+	for i := 1; i < len(images); i++ {
+		if betterKeepCandidate(&images[i], best) {
+			best = &images[i]
+		}
+	}
+	return best
+}
+
+// betterKeepCandidate 判断a是否比b更适合保留
+func betterKeepCandidate(a, b *model.Image) bool {
+	if (a.Status == "active") != (b.Status == "active") {
+		return a.Status == "active"
+	}
+	if pa, pb := imagePixels(a), imagePixels(b); pa != pb {
+		return pa > pb
+	}
+	return a.ID < b.ID
+}
+
+// imagePixels 图片像素数，宽高未知时为0
+func imagePixels(image *model.Image) int {
+	if image.Width == nil || image.Height == nil {
+		return 0
+	}
+	return *image.Width * *image.Height
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSuggestKeepImage(t *testing.T) {
+	small, large := 100, 2000
+	images := []model.Image{
+		{ID: 1, Width: &small, Height: &small, Status: "active"},
+		{ID: 2, Width: &large, Height: &large, Status: "inactive"},
+		{ID: 3, Width: &large, Height: &large, Status: "active"},
+		{ID: 4, Width: &large, Height: &large, Status: "active"},
+	}
+	// 有效图片优先，其次分辨率最高，相同时ID最小
+	if keep := suggestKeepImage(images); keep.ID != 3 {
+		t.Errorf("keep = %d, want 3", keep.ID)
+	}
+}
+
+func TestMergeDuplicatesDeactivate(t *testing.T) {
+	setupTestDB(t)
+	createCategories(t, "acg")
+	small, large := 640, 1920
+	images := []model.Image{
+		{SourceURL: "https://img.example.com/a.jpg", Width: &small, Height: &small, Status: "active", CategoryID: 1},
+		{SourceURL: "https://img.example.com/b.jpg", Width: &large, Height: &large, Status: "active", CategoryID: 1},
+	}
+	tag := model.Tag{Name: "night", Slug: "night"}
+	if err := database.DB.Create(&images).Error; err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Create(&tag)
+	database.DB.Create(&model.ImageTag{ImageID: images[0].ID, TagID: tag.ID})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := fmt.Sprintf(`{"image_ids":[%d,%d]}`, images[0].ID, images[1].ID)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/duplicates/merge", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	(&AdminAPI{randomIndex: service.NewRandomIndexService()}).MergeDuplicates(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var dup, keep model.Image
+	database.DB.First(&dup, images[0].ID)
+	database.DB.First(&keep, images[1].ID)
+	if dup.Status != "inactive" || keep.Status != "active" {
+		t.Errorf("statuses = %s/%s, want inactive/active", dup.Status, keep.Status)
+	}
+	if got := imageTagIDs(t, keep.ID); len(got) != 1 || got[0] != tag.ID {
+		t.Errorf("kept image tags = %v, want the duplicate's tag", got)
+	}
+}
+
+// searchSimilar 上传图片调用以图搜图接口，返回状态码
+func searchSimilar(t *testing.T, data []byte) int {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "query.png")
+	part.Write(data)
+	form.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/images/search", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	(&AdminAPI{}).SearchSimilarImages(c)
+	return w.Code
+}
+
+func TestSearchSimilarImagesStatus(t *testing.T) {
+	db := setupTestDB(t)
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(0, 0, color.White)
+	var data bytes.Buffer
+	png.Encode(&data, img)
+
+	if code := searchSimilar(t, data.Bytes()); code != http.StatusOK {
+		t.Errorf("valid image: status = %d, want 200", code)
+	}
+	if code := searchSimilar(t, []byte("not an image")); code != http.StatusBadRequest {
+		t.Errorf("invalid image: status = %d, want 400", code)
+	}
+
+	// 数据库错误不是请求的问题
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	if code := searchSimilar(t, data.Bytes()); code != http.StatusInternalServerError {
+		t.Errorf("database error: status = %d, want 500", code)
+	}
+}
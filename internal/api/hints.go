@@ -0,0 +1,168 @@
+package api
+
+import (
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceHintHeaders 参与设备识别的 Client Hints 请求头
+var deviceHintHeaders = []string{"Sec-CH-UA-Mobile"}
+
+// viewportHintHeaders 参与视口匹配的 Client Hints 请求头
+var viewportHintHeaders = []string{
+	"Sec-CH-Viewport-Width",
+	"Sec-CH-Viewport-Height",
+	"Sec-CH-DPR",
+}
+
+const (
+	// maxViewportSize 视口宽高（CSS像素）的合理上限，超出视为无效
+	maxViewportSize = 10000
+	// maxHintPixels 按视口换算出的最低分辨率上限，避免高DPR设备筛掉所有图片
+	maxHintPixels = 4096
+	// maxDPR 设备像素比上限
+	maxDPR = 4.0
+	// viewportRatioTolerance 按视口宽高比匹配时允许的相对误差
+	viewportRatioTolerance = 0.25
+)
+
+// viewportHints 客户端通过 Client Hints 上报的视口信息
+type viewportHints struct {
+	width  int // CSS像素
+	height int // CSS像素，未上报时为0
+	dpr    float64
+}
+
+// getViewportHints 读取视口相关的 Client Hints，未上报视口宽度时返回nil
+func getViewportHints(c *gin.Context) *viewportHints {
+	width, err := strconv.Atoi(c.GetHeader("Sec-CH-Viewport-Width"))
+	if err != nil || width < 1 || width > maxViewportSize {
+		return nil
+	}
+
+	hints := &viewportHints{width: width, dpr: 1}
+	if height, err := strconv.Atoi(c.GetHeader("Sec-CH-Viewport-Height")); err == nil && height >= 1 && height <= maxViewportSize {
+		hints.height = height
+	}
+	if dpr, err := strconv.ParseFloat(c.GetHeader("Sec-CH-DPR"), 64); err == nil && dpr > 0 {
+		hints.dpr = min(max(dpr, 1), maxDPR)
+	}
+	return hints
+}
+
+// pixels 视口对应的物理像素尺寸
+func (h *viewportHints) pixels() (int, int) {
+	width := min(int(float64(h.width)*h.dpr), maxHintPixels)
+	height := min(int(float64(h.height)*h.dpr), maxHintPixels)
+	return width, height
+}
+
+// setClientHintHeaders 请求浏览器在后续请求中携带 Client Hints
+// Accept-CH 对整个站点生效，各接口总是声明全部请求头，避免相互覆盖
+// 设备类型由请求头推断时（未指定device参数），结果随这些请求头变化，需要声明 Vary；
+// useViewport 为false时（固定种子、每日一图等）不按视口选图，Vary 中不包含视口相关的请求头
+func setClientHintHeaders(c *gin.Context, inferred, useViewport bool) {
+	c.Header("Accept-CH", strings.Join(append(append([]string(nil), deviceHintHeaders...), viewportHintHeaders...), ", "))
+	if !inferred {
+		return
+	}
+	vary := append([]string{"User-Agent"}, deviceHintHeaders...)
+	if useViewport {
+		vary = append(vary, viewportHintHeaders...)
+	}
+	c.Writer.Header().Add("Vary", strings.Join(vary, ", "))
+}
+
+// viewportFilters 根据视口生成由严格到宽松的筛选条件
+// 依次为：分辨率足够且宽高比接近视口 > 宽高比接近视口 > 原始条件（按设备类型区分横竖屏）
+// 请求中已显式指定的分辨率、宽高比和方向条件不会被覆盖
+func viewportFilters(base *service.ImageFilter, viewport *viewportHints) []*service.ImageFilter {
+	if viewport == nil {
+		return []*service.ImageFilter{base}
+	}
+
+	width, height := viewport.pixels()
+	useRatio := viewport.height > 0 && base.Ratio == 0 && len(base.Orientations) == 0
+
+	filters := make([]*service.ImageFilter, 0, 3)
+
+	// 分辨率足够覆盖视口
+	fit := *base
+	if fit.MinWidth == 0 && (fit.MaxWidth == 0 || width <= fit.MaxWidth) {
+		fit.MinWidth = width
+	}
+	if viewport.height > 0 && fit.MinHeight == 0 && (fit.MaxHeight == 0 || height <= fit.MaxHeight) {
+		fit.MinHeight = height
+	}
+	if useRatio {
+		applyViewportRatio(&fit, viewport)
+	}
+	if fit.Key() != base.Key() {
+		filters = append(filters, &fit)
+	}
+
+	// 只要求宽高比接近视口
+	if useRatio {
+		shape := *base
+		applyViewportRatio(&shape, viewport)
+		filters = append(filters, &shape)
+	}
+
+	return append(filters, base)
+}
+
+// applyViewportRatio 按视口宽高比筛选，横竖屏由视口决定，不再按设备类型区分
+func applyViewportRatio(filter *service.ImageFilter, viewport *viewportHints) {
+	filter.Device = ""
+	filter.Ratio = float64(viewport.width) / float64(viewport.height)
+	filter.RatioTolerance = viewportRatioTolerance
+}
+
+// pickBestFit 按顺序尝试筛选条件，返回第一张选中的图片
+func pickBestFit(filters []*service.ImageFilter, pick func(*service.ImageFilter) (*model.Image, error)) (*model.Image, error) {
+	var err error
+	for _, filter := range filters {
+		var image *model.Image
+		if image, err = pick(filter); err == nil {
+			return image, nil
+		}
+	}
+	return nil, err
+}
+
+// pickBestFitN 按顺序尝试筛选条件选取最多n张不重复的图片，前面的条件数量不足时由后面的条件补齐
+func pickBestFitN(filters []*service.ImageFilter, n int, pick func(*service.ImageFilter, int) ([]model.Image, error)) ([]model.Image, error) {
+	images := make([]model.Image, 0, n)
+	for _, filter := range filters {
+		f := *filter
+		f.ExcludeIDs = append(append([]uint(nil), filter.ExcludeIDs...), imageIDs(images)...)
+
+		picked, err := pick(&f, n-len(images))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, picked...)
+		if len(images) >= n {
+			break
+		}
+	}
+	return images, nil
+}
+
+// isTabletUserAgent 根据User-Agent判断是否为平板
+// iPad、不含Mobile标识的Android设备，以及常见平板标识
+func isTabletUserAgent(ua string) bool {
+	lower := strings.ToLower(ua)
+	switch {
+	case strings.Contains(lower, "ipad"), strings.Contains(lower, "tablet"),
+		strings.Contains(lower, "kindle"), strings.Contains(lower, "silk/"):
+		return true
+	case strings.Contains(lower, "android") && !strings.Contains(lower, "mobile"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetViewportHints(t *testing.T) {
+	c, _ := newTestContext(http.MethodGet, "/api/random")
+	if hints := getViewportHints(c); hints != nil {
+		t.Fatalf("hints without headers = %+v", hints)
+	}
+
+	c, _ = newTestContext(http.MethodGet, "/api/random")
+	c.Request.Header.Set("Sec-CH-Viewport-Width", "390")
+	c.Request.Header.Set("Sec-CH-Viewport-Height", "844")
+	c.Request.Header.Set("Sec-CH-DPR", "9")
+	hints := getViewportHints(c)
+	if hints == nil || hints.width != 390 || hints.height != 844 || hints.dpr != maxDPR {
+		t.Fatalf("hints = %+v", hints)
+	}
+	if width, height := hints.pixels(); width != 1560 || height != 3376 {
+		t.Errorf("pixels = %dx%d, want 1560x3376", width, height)
+	}
+}
+
+func TestViewportFilters(t *testing.T) {
+	base := &service.ImageFilter{Device: "mobile"}
+	if filters := viewportFilters(base, nil); len(filters) != 1 || filters[0] != base {
+		t.Fatalf("without viewport: %d filters", len(filters))
+	}
+
+	filters := viewportFilters(base, &viewportHints{width: 400, height: 800, dpr: 2})
+	if len(filters) != 3 || filters[2] != base {
+		t.Fatalf("got %d filters, want fit > ratio > base", len(filters))
+	}
+	fit, shape := filters[0], filters[1]
+	if fit.MinWidth != 800 || fit.MinHeight != 1600 || fit.Ratio != 0.5 || fit.Device != "" {
+		t.Errorf("fit filter = %+v", fit)
+	}
+	if shape.MinWidth != 0 || shape.Ratio != 0.5 {
+		t.Errorf("ratio filter = %+v", shape)
+	}
+
+	// 显式指定的方向不被视口宽高比覆盖
+	explicit := &service.ImageFilter{Orientations: []string{model.OrientationLandscape}}
+	for _, f := range viewportFilters(explicit, &viewportHints{width: 400, height: 800, dpr: 1}) {
+		if f.Ratio != 0 {
+			t.Errorf("viewport ratio overrode orientation: %+v", f)
+		}
+	}
+}
+
+func TestSetClientHintHeaders(t *testing.T) {
+	c, w := newTestContext(http.MethodGet, "/api/daily")
+	setClientHintHeaders(c, true, false)
+	if accept := w.Header().Get("Accept-CH"); !strings.Contains(accept, "Sec-CH-Viewport-Width") {
+		t.Errorf("Accept-CH = %q, want all hints", accept)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Sec-CH-UA-Mobile") || strings.Contains(vary, "Viewport") {
+		t.Errorf("Vary = %q, want device hints only", vary)
+	}
+
+	c, w = newTestContext(http.MethodGet, "/api/random?device=pc")
+	setClientHintHeaders(c, false, false)
+	if vary := w.Header().Get("Vary"); vary != "" {
+		t.Errorf("Vary = %q for explicit device", vary)
+	}
+}
+
+func TestGetDeviceFromRequest(t *testing.T) {
+	tests := []struct {
+		query, ua, chMobile string
+		want                string
+	}{
+		{"?device=mobile", "", "", "mobile"},
+		{"", "", "", "pc"},
+		{"", "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", "", "tablet"},
+		{"", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", "", "mobile"},
+		{"", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", "?0", "pc"},
+		{"", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "?1", "mobile"},
+	}
+	for _, tt := range tests {
+		c, _ := newTestContext(http.MethodGet, "/api/random"+tt.query)
+		c.Request.Header.Set("User-Agent", tt.ua)
+		if tt.chMobile != "" {
+			c.Request.Header.Set("Sec-CH-UA-Mobile", tt.chMobile)
+		}
+		if got := getDeviceFromRequest(c); got != tt.want {
+			t.Errorf("%q %q %q: device = %q, want %q", tt.query, tt.ua, tt.chMobile, got, tt.want)
+		}
+	}
+}
+
+func TestPickBestFitNFillsFromLaterFilters(t *testing.T) {
+	fit := &service.ImageFilter{MinWidth: 1000}
+	base := &service.ImageFilter{ExcludeIDs: []uint{9}}
+	pools := map[int][]uint{1000: {1, 2}, 0: {1, 2, 3, 4}}
+
+	var calls []*service.ImageFilter
+	images, err := pickBestFitN([]*service.ImageFilter{fit, base}, 3, func(f *service.ImageFilter, n int) ([]model.Image, error) {
+		calls = append(calls, f)
+		var picked []model.Image
+		for _, id := range pools[f.MinWidth] {
+			if len(picked) < n && !slices.Contains(f.ExcludeIDs, id) {
+				picked = append(picked, model.Image{ID: id})
+			}
+		}
+		return picked, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := imageIDs(images); len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Fatalf("picked %v, want [1 2 3]", ids)
+	}
+	// 后面的条件排除已选中的图片，并保留请求本身的排除项
+	if len(calls) != 2 || len(calls[1].ExcludeIDs) != 3 || len(base.ExcludeIDs) != 1 {
+		t.Fatalf("second filter excludes %v, base excludes %v", calls[1].ExcludeIDs, base.ExcludeIDs)
+	}
+	// 临时排除不改变洗牌袋和候选图片缓存的分组
+	if calls[1].PoolKey() != base.PoolKey() {
+		t.Error("temporary exclusions changed the pool key")
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 上游主机配置 ==========
+
+// ListHostProfiles 获取上游主机配置列表
+// GET /api/admin/host-profiles
+func (api *AdminAPI) ListHostProfiles(c *gin.Context) {
+	var profiles []model.HostProfile
+	if err := database.DB.Order("pattern").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+// CreateHostProfile 创建上游主机配置
+// POST /api/admin/host-profiles
+func (api *AdminAPI) CreateHostProfile(c *gin.Context) {
+	var input struct {
+		Pattern   string            `json:"pattern" binding:"required"`
+		Referer   string            `json:"referer"`
+		UserAgent string            `json:"user_agent"`
+		Cookie    string            `json:"cookie"`
+		Headers   map[string]string `json:"headers"`
+		Timeout   int               `json:"timeout"`
+		ProxyURL  string            `json:"proxy_url"`
+		Enabled   *bool             `json:"enabled"` // 默认启用
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := model.HostProfile{
+		Pattern:   input.Pattern,
+		Referer:   input.Referer,
+		UserAgent: input.UserAgent,
+		Cookie:    input.Cookie,
+		Headers:   input.Headers,
+		Timeout:   input.Timeout,
+		ProxyURL:  input.ProxyURL,
+		Enabled:   input.Enabled == nil || *input.Enabled,
+	}
+	if err := service.NormalizeHostProfile(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&model.HostProfile{}).Where("pattern = ?", profile.Pattern).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pattern already exists"})
+		return
+	}
+
+	if err := database.DB.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	api.reloadHostProfiles()
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+// UpdateHostProfile 更新上游主机配置，只修改提供的字段
+// PUT /api/admin/host-profiles/:id
+func (api *AdminAPI) UpdateHostProfile(c *gin.Context) {
+	id := c.Param("id")
+
+	var profile model.HostProfile
+	if err := database.DB.First(&profile, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host profile not found"})
+		return
+	}
+
+	var input struct {
+		Pattern   *string            `json:"pattern"`
+		Referer   *string            `json:"referer"`
+		UserAgent *string            `json:"user_agent"`
+		Cookie    *string            `json:"cookie"`
+		Headers   *map[string]string `json:"headers"` // 提供时整体替换
+		Timeout   *int               `json:"timeout"`
+		ProxyURL  *string            `json:"proxy_url"`
+		Enabled   *bool              `json:"enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Pattern != nil {
+		profile.Pattern = *input.Pattern
+	}
+	if input.Referer != nil {
+		profile.Referer = *input.Referer
+	}
+	if input.UserAgent != nil {
+		profile.UserAgent = *input.UserAgent
+	}
+	if input.Cookie != nil {
+		profile.Cookie = *input.Cookie
+	}
+	if input.Headers != nil {
+		profile.Headers = *input.Headers
+	}
+	if input.Timeout != nil {
+		profile.Timeout = *input.Timeout
+	}
+	if input.ProxyURL != nil {
+		profile.ProxyURL = *input.ProxyURL
+	}
+	if input.Enabled != nil {
+		profile.Enabled = *input.Enabled
+	}
+	if err := service.NormalizeHostProfile(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&model.HostProfile{}).Where("pattern = ? AND id != ?", profile.Pattern, profile.ID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pattern already exists"})
+		return
+	}
+
+	if err := database.DB.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	api.reloadHostProfiles()
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteHostProfile 删除上游主机配置
+// DELETE /api/admin/host-profiles/:id
+func (api *AdminAPI) DeleteHostProfile(c *gin.Context) {
+	id := c.Param("id")
+
+	var profile model.HostProfile
+	if err := database.DB.First(&profile, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host profile not found"})
+		return
+	}
+
+	if err := database.DB.Delete(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	api.reloadHostProfiles()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Host profile deleted successfully"})
+}
+
+// reloadHostProfiles 配置修改后重新加载，使新请求立即生效
+func (api *AdminAPI) reloadHostProfiles() {
+	if err := service.GetHostProfileService().Reload(); err != nil {
+		log.Printf("HostProfile: failed to reload profiles: %v", err)
+	}
+}
@@ -1,12 +1,19 @@
 package api
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"randimg/internal/database"
 	"randimg/internal/model"
 	"randimg/internal/service"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,49 +23,129 @@ import (
 // PublicAPI 公开API处理器
 type PublicAPI struct {
 	proxyService *service.ImageProxyService
+	proxyCache   *service.ProxyCacheService
 	statService  *service.StatService
+	randomIndex  *service.RandomIndexService
+	shuffleBag   *service.ShuffleBagService
+	linkCheck    *service.LinkCheckService
 }
 
+// clientCookieName 不重复随机模式下标识匿名客户端的cookie
+const clientCookieName = "randimg_sid"
+
+// maxBatchCount 一次随机请求最多返回的图片数量
+const maxBatchCount = 50
+
+// maxSeedLength seed参数的最大长度，限制固定种子结果缓存的键大小
+const maxSeedLength = 64
+
+// maxFallbackAttempts fallback=true 时原图不可用最多换图的次数
+const maxFallbackAttempts = 3
+
+// errNoReachableImage 换图次数用完仍没有可访问的图片
+var errNoReachableImage = errors.New("no reachable images found")
+
 // NewPublicAPI 创建公开API处理器
 func NewPublicAPI() *PublicAPI {
 	return &PublicAPI{
-		proxyService: service.NewImageProxyService(),
+		proxyService: service.GetImageProxyService(),
+		proxyCache:   service.GetProxyCacheService(),
 		statService:  service.GetStatService(),
+		randomIndex:  service.GetRandomIndexService(),
+		shuffleBag:   service.GetShuffleBagService(),
+		linkCheck:    service.GetLinkCheckService(),
 	}
 }
 
+// getClientKey 获取客户端标识：有API key时使用API key，否则使用cookie
+// 没有cookie时下发一个并返回空字符串，本次请求按普通随机处理：不保存cookie的客户端（如<img>外链）
+// 每次请求都会是新客户端，不为其创建洗牌袋
+func getClientKey(c *gin.Context) string {
+	if apiKeyInterface, exists := c.Get("api_key"); exists {
+		if apiKey, ok := apiKeyInterface.(*model.APIKey); ok {
+			return fmt.Sprintf("key:%d", apiKey.ID)
+		}
+	}
+
+	if sid, err := c.Cookie(clientCookieName); err == nil && len(sid) == 32 {
+		if _, err := hex.DecodeString(sid); err == nil {
+			return "sid:" + sid
+		}
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate client id: %v", err)
+		return ""
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(clientCookieName, hex.EncodeToString(b), 30*24*3600, "/", "", false, true)
+	return ""
+}
+
+// getAllowedRating 当前请求允许访问的最高内容分级
+// 有API key时使用API key的设置，匿名（同源）访问默认只允许safe，可通过环境变量 ANONYMOUS_MAX_RATING 调整
+func getAllowedRating(c *gin.Context) string {
+	if apiKeyInterface, exists := c.Get("api_key"); exists {
+		if apiKey, ok := apiKeyInterface.(*model.APIKey); ok {
+			if model.RatingLevel(apiKey.MaxRating) < 0 {
+				return model.RatingSafe
+			}
+			return apiKey.MaxRating
+		}
+	}
+
+	if rating := os.Getenv("ANONYMOUS_MAX_RATING"); model.RatingLevel(rating) >= 0 {
+		return rating
+	}
+	return model.RatingSafe
+}
+
 // getDeviceFromRequest 从请求中智能识别设备类型
-// 优先级：URL参数 > User-Agent解析 > 默认值(pc)
+// 优先级：URL参数 > Sec-CH-UA-Mobile > User-Agent解析 > 默认值(pc)
+// 平板单独识别为tablet（不限制横竖屏）
 func getDeviceFromRequest(c *gin.Context) string {
 	// 1. 如果URL明确指定了device参数，直接使用
 	if device := c.Query("device"); device != "" {
 		return device
 	}
 
-	// 2. 从User-Agent解析设备类型
 	uaString := c.GetHeader("User-Agent")
+
+	// 2. Client Hints明确声明为移动设备
+	chMobile := c.GetHeader("Sec-CH-UA-Mobile")
+	if chMobile == "?1" {
+		return "mobile"
+	}
+
+	// 3. 从User-Agent解析设备类型
 	if uaString == "" {
 		return "pc" // 无UA时默认pc
 	}
 
+	// 平板经常旋转，User-Agent 无法反映当前方向，因此不限横竖屏；
+	// 携带视口 Client Hints 时由视口宽高比决定方向
+	if isTabletUserAgent(uaString) {
+		return "tablet"
+	}
+
 	ua := user_agent.New(uaString)
 
-	// 移动设备（包括手机和平板）
-	if ua.Mobile() {
+	// 移动设备（Client Hints声明为非移动设备时以Client Hints为准，如手机浏览器的桌面模式）
+	if ua.Mobile() && chMobile != "?0" {
 		return "mobile"
 	}
 
-	// 3. 默认返回pc
+	// 4. 默认返回pc
 	return "pc"
 }
 
 // ListImages 获取图片列表（公开API）
-// GET /api/images?page=1&page_size=20&category=acg&device=pc
+// GET /api/images?page=1&page_size=20&category=acg,landscape&exclude_category=nsfw&exclude_id=1,2&tags=night,city&tags_any=&exclude_tags=&device=pc&strict=false&min_width=1920&ratio=16:9&orientation=landscape&max_rating=safe
+// 只返回不超过API key允许分级的图片
 func (api *PublicAPI) ListImages(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-	category := c.Query("category")
-	device := c.Query("device")
 
 	if page < 1 {
 		page = 1
@@ -67,20 +154,13 @@ func (api *PublicAPI) ListImages(c *gin.Context) {
 		pageSize = 20
 	}
 
-	query := database.DB.Model(&model.Image{}).Preload("Category").Where("status = ?", "active")
-
-	if category != "" {
-		var cat model.Category
-		if err := database.DB.Where("slug = ?", category).First(&cat).Error; err == nil {
-			query = query.Where("category_id = ?", cat.ID)
-		}
+	filter, status, err := parseImageFilter(c, c.Query("device"))
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
 	}
 
-	if device == "pc" {
-		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND width > height")
-	} else if device == "mobile" {
-		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND height > width")
-	}
+	query := filter.Apply(database.DB.Model(&model.Image{}).Preload("Category").Preload("Tags").Where("status = ?", "active"))
 
 	var total int64
 	query.Count(&total)
@@ -91,6 +171,9 @@ func (api *PublicAPI) ListImages(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range images {
+		service.FillVariants(&images[i])
+	}
 
 	totalPage := int((total + int64(pageSize) - 1) / int64(pageSize))
 
@@ -117,41 +200,109 @@ func (api *PublicAPI) ListCategories(c *gin.Context) {
 	c.JSON(http.StatusOK, categories)
 }
 
+// ListTags 获取标签列表（公开API）
+// GET /api/tags
+func (api *PublicAPI) ListTags(c *gin.Context) {
+	var tags []model.Tag
+	if err := database.DB.Order("name").Find(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
 // RandomImage 随机图片接口
-// GET /api/random?category=acg&device=pc&format=redirect|proxy|json&compress=false
+// 未指定device时根据 Sec-CH-UA-Mobile、Sec-CH-Viewport-Width/Height、Sec-CH-DPR 和 User-Agent 自动识别
+// GET /api/random?category=acg,landscape&exclude_category=nsfw&exclude_id=1,2&tags=night,city&tags_any=&exclude_tags=&strict=false&min_width=1920&ratio=16:9&orientation=landscape&device=pc&format=redirect|proxy|json&compress=false&unique=session&seed=abc&count=12&max_rating=safe&fallback=false
+// 只返回不超过API key允许分级的图片（匿名访问默认只有safe），max_rating 只能进一步收紧
+// count 仅用于JSON格式，一次返回最多 maxBatchCount 张不重复的图片，只计为一次调用
+// fallback=true 时先检查选中图片的原图是否可访问，不可访问时换一张符合条件的图片（不用于count）
 func (api *PublicAPI) RandomImage(c *gin.Context) {
 	// 获取参数
-	category := c.Query("category")
 	device := getDeviceFromRequest(c) // 智能识别设备类型
 	format := c.DefaultQuery("format", "redirect")
 	compressStr := c.DefaultQuery("compress", "false")
 	compress := compressStr == "true" || compressStr == "1"
+	fallbackStr := c.DefaultQuery("fallback", "false")
+	fallback := fallbackStr == "true" || fallbackStr == "1"
+	unique := c.Query("unique")
+	seed := c.Query("seed")
+	inferred := c.Query("device") == ""
+	// 固定种子的结果不随视口变化，否则首次请求（浏览器尚未携带视口 Client Hints）与之后的结果不同
+	useViewport := inferred && seed == ""
+	setClientHintHeaders(c, inferred, useViewport)
+
+	if unique != "" && unique != "session" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unique parameter"})
+		return
+	}
+	if unique != "" && seed != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unique and seed cannot be used together"})
+		return
+	}
+	if len(seed) > maxSeedLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("seed must be at most %d characters", maxSeedLength)})
+		return
+	}
 
-	// 构建查询
-	query := database.DB.Where("status = ?", "active")
-
-	// 如果指定了分类
-	if category != "" {
-		var cat model.Category
-		if err := database.DB.Where("slug = ?", category).First(&cat).Error; err == nil {
-			query = query.Where("category_id = ?", cat.ID)
+	count := 0
+	if countStr := c.Query("count"); countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 || count > maxBatchCount {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("count must be between 1 and %d", maxBatchCount)})
+			return
+		}
+		if format != "json" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "count is only supported with format=json"})
+			return
 		}
 	}
 
-	// 根据device参数筛选图片（基于宽高比）
-	if device == "pc" {
-		// PC端：横屏图片（宽>高）
-		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND width > height")
-	} else if device == "mobile" {
-		// 移动端：竖屏图片（高>宽）
-		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND height > width")
+	// 解析分类、排除等筛选条件
+	filter, status, err := parseImageFilter(c, device)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
 	}
-	// 如果device为其他值，返回所有图片（包括正方形）
 
-	// 随机获取一张图片
-	var image model.Image
-	if err := query.Preload("Category").Order("RANDOM()").First(&image).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No images found"})
+	// 未指定device时，按Client Hints上报的视口优先选择分辨率和宽高比最合适的图片
+	var viewport *viewportHints
+	if useViewport {
+		viewport = getViewportHints(c)
+	}
+
+	// 随机获取一张图片（unique=session时同一客户端一轮内不重复，指定seed时结果固定）
+	var clientKey string
+	if unique == "session" {
+		clientKey = getClientKey(c)
+	}
+
+	if count > 0 {
+		api.randomImages(c, viewportFilters(filter, viewport), count, unique, seed, clientKey)
+		return
+	}
+
+	filters := viewportFilters(filter, viewport)
+	pick := func(f *service.ImageFilter) (*model.Image, error) {
+		if unique == "session" {
+			return api.pickUniqueImage(clientKey, f)
+		} else if seed != "" {
+			return api.pickSeededImage(seed, f)
+		}
+		return api.pickRandomImage(f)
+	}
+	image, err := pickBestFit(filters, pick)
+	if err == nil && fallback {
+		image, err = api.pickReachableImage(filters, image, pick)
+	}
+	if err != nil {
+		if errors.Is(err, errNoReachableImage) {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "No reachable images found"})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No images found"})
+		}
 		api.recordStat(c)
 		return
 	}
@@ -159,40 +310,75 @@ func (api *PublicAPI) RandomImage(c *gin.Context) {
 	// 记录统计
 	api.recordStat(c)
 
-	// 根据format返回不同格式
-	switch format {
-	case "redirect":
-		// 302重定向到原图（不缓存，保证每次随机）
-		c.Redirect(http.StatusFound, image.SourceURL)
-
-	case "proxy":
-		// 代理模式：302重定向到proxy接口（让Cloudflare缓存固定URL）
-		proxyURL := fmt.Sprintf("/api/proxy/%d", image.ID)
-		if compress {
-			proxyURL += "?compress=true"
+	api.respondImage(c, image, format, compress, fallback)
+}
+
+// pickReachableImage 检查选中图片的原图是否可访问，不可访问时排除后按相同条件重新选取
+// 最多检查 maxFallbackAttempts 张，都不可访问时返回 errNoReachableImage
+func (api *PublicAPI) pickReachableImage(filters []*service.ImageFilter, image *model.Image, pick func(*service.ImageFilter) (*model.Image, error)) (*model.Image, error) {
+	var excluded []uint
+	for attempt := 1; ; attempt++ {
+		if api.linkCheck.Probe(image) == nil {
+			return image, nil
+		}
+		if attempt >= maxFallbackAttempts {
+			return nil, errNoReachableImage
 		}
-		c.Redirect(http.StatusFound, proxyURL)
-
-	case "json":
-		// JSON格式（不缓存，保证每次随机）
-		c.JSON(http.StatusOK, gin.H{
-			"id":       image.ID,
-			"url":      image.SourceURL,
-			"proxy":    fmt.Sprintf("/api/proxy/%d", image.ID),
-			"width":    image.Width,
-			"height":   image.Height,
-			"format":   image.Format,
-			"source":   image.Source,
-			"category": image.Category,
-		})
 
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format parameter"})
+		excluded = append(excluded, image.ID)
+		retry := make([]*service.ImageFilter, len(filters))
+		for i, filter := range filters {
+			f := *filter
+			f.ExcludeIDs = append(append([]uint(nil), filter.ExcludeIDs...), excluded...)
+			retry[i] = &f
+		}
+		next, err := pickBestFit(retry, pick)
+		if err != nil {
+			return nil, errNoReachableImage
+		}
+		image = next
 	}
 }
 
+// randomImages 批量随机，一次返回多张不重复的图片（JSON格式）
+func (api *PublicAPI) randomImages(c *gin.Context, filters []*service.ImageFilter, count int, unique, seed, clientKey string) {
+	images, err := pickBestFitN(filters, count, func(f *service.ImageFilter, n int) ([]model.Image, error) {
+		if unique == "session" {
+			return api.pickUniqueImages(clientKey, f, n)
+		} else if seed != "" {
+			return api.pickSeededImages(seed, f, n)
+		}
+		return api.pickRandomImages(f, n)
+	})
+
+	// 无论返回多少张，都只记录一次调用
+	api.recordStat(c)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No images found"})
+		return
+	}
+
+	data := make([]gin.H, len(images))
+	for i := range images {
+		data[i] = imageJSON(&images[i])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  data,
+		"count": len(data),
+	})
+}
+
 // ProxyImage 图片代理接口
-// GET /api/proxy/:id?compress=false&format=webp
+// GET /api/proxy/:id?compress=false&format=jpeg|png|webp|auto&w=400&h=300&fit=cover|contain|fill|inside&q=80&dpr=2
+// w/h 为CSS像素，实际尺寸乘以dpr；只指定一边时等比缩放，同时指定时按fit处理（默认cover）
+// format=auto 时按Accept请求头选择输出格式，响应带 Vary: Accept
+// 支持Range请求；原图大小受 PROXY_MAX_SIZE_MB 限制，文件头不是图片时返回502
+// fallback=true 时原图不可用（或已标记为broken）换一张同分类的图片返回，响应带 X-Fallback-From 且不缓存
 func (api *PublicAPI) ProxyImage(c *gin.Context) {
 	// 获取图片ID
 	idStr := c.Param("id")
@@ -203,9 +389,13 @@ func (api *PublicAPI) ProxyImage(c *gin.Context) {
 	}
 
 	// 获取参数
-	compressStr := c.DefaultQuery("compress", "false")
-	compress := compressStr == "true" || compressStr == "1"
-	targetFormat := c.Query("format")
+	opts, err := parseProxyOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fallbackStr := c.DefaultQuery("fallback", "false")
+	fallback := fallbackStr == "true" || fallbackStr == "1"
 
 	// 查询图片
 	var image model.Image
@@ -215,19 +405,248 @@ func (api *PublicAPI) ProxyImage(c *gin.Context) {
 		return
 	}
 
+	// 超出允许分级的图片按不存在处理
+	if model.RatingLevel(image.Rating) > model.RatingLevel(getAllowedRating(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		api.recordStat(c)
+		return
+	}
+
 	// 记录统计
 	api.recordStat(c)
 
-	// 代理图片
-	data, contentType, err := api.proxyService.ProxyImage(image.SourceURL, compress, targetFormat)
+	// 已标记为broken的图片不再访问原图，直接换图
+	if fallback && image.Status == "broken" && api.serveFallbackImage(c, &image, opts) {
+		return
+	}
+
+	if err = api.serveProxyImage(c, &image, opts, 0); err == nil {
+		return
+	}
+	if isUpstreamFailure(err) {
+		// 安排链接检查尽快确认原图是否失效
+		api.linkCheck.ReportFailure(image.ID)
+		if fallback && image.Status != "broken" && api.serveFallbackImage(c, &image, opts) {
+			return
+		}
+	}
+	respondProxyError(c, err)
+}
+
+// serveProxyImage 返回代理的图片，fallbackFrom 不为0时表示替换该图片返回
+// 响应头发出前失败时返回错误，由调用方决定换图或返回错误
+func (api *PublicAPI) serveProxyImage(c *gin.Context, image *model.Image, opts service.ProxyOptions, fallbackFrom uint) error {
+	// 优先读取磁盘缓存（参数规范化后作为缓存键）
+	cacheKey := service.ProxyCacheKey(image.SourceURL, opts.Key())
+	data, contentType, hit := api.proxyCache.Get(image.ID, cacheKey)
+	if hit {
+		setProxyHeaders(c, image.Rating, opts, "HIT", fallbackFrom)
+		serveProxyData(c, data, contentType)
+		return nil
+	}
+
+	// 代理图片（相同的并发请求只处理一次，由发起处理的请求写入缓存）
+	// 原图完整下载后才开始响应，上游并发槽位和超时不受客户端读取速度影响
+	data, contentType, shared, err := api.proxyService.ProxyImage(service.ImageOriginURL(image), opts)
 	if err != nil {
+		return err
+	}
+	if !shared {
+		api.proxyCache.Put(image.ID, cacheKey, data, contentType)
+	}
+	setProxyHeaders(c, image.Rating, opts, "MISS", fallbackFrom)
+	serveProxyData(c, data, contentType)
+	return nil
+}
+
+// serveFallbackImage 换一张同分类、允许分级内的图片返回，最多尝试 maxFallbackAttempts 张，都不可用时返回false
+func (api *PublicAPI) serveFallbackImage(c *gin.Context, image *model.Image, opts service.ProxyOptions) bool {
+	filter := &service.ImageFilter{
+		CategoryIDs: []uint{image.CategoryID},
+		ExcludeIDs:  []uint{image.ID},
+		MaxRating:   getAllowedRating(c),
+	}
+	// 请求的Range针对的是原来的图片，替换的图片总是完整返回
+	c.Request.Header.Del("Range")
+
+	for attempt := 0; attempt < maxFallbackAttempts; attempt++ {
+		alt, err := api.pickRandomImage(filter)
+		if err != nil {
+			return false
+		}
+		err = api.serveProxyImage(c, alt, opts, image.ID)
+		if err == nil {
+			log.Printf("Proxy: image %d is unavailable, served image %d instead", image.ID, alt.ID)
+			return true
+		}
+		if !isUpstreamFailure(err) {
+			return false
+		}
+		api.linkCheck.ReportFailure(alt.ID)
+		filter.ExcludeIDs = append(filter.ExcludeIDs, alt.ID)
+	}
+	return false
+}
+
+// isUpstreamFailure 是否为原图不可用导致的错误（本地并发已满不算）
+func isUpstreamFailure(err error) bool {
+	return !errors.Is(err, service.ErrUpstreamBusy)
+}
+
+// imageCacheControl 图片响应的 Cache-Control：只有safe分级的图片允许CDN等共享缓存保存，
+// 其他分级的图片只允许客户端自己缓存，避免共享缓存把它返回给无权查看的请求
+func imageCacheControl(rating string, maxAge int) string {
+	if rating == model.RatingSafe {
+		return "public, max-age=" + strconv.Itoa(maxAge)
+	}
+	return "private, max-age=" + strconv.Itoa(maxAge)
+}
+
+// setProxyHeaders 设置代理成功响应的缓存相关响应头
+// 替换返回的图片（fallbackFrom 不为0）不允许缓存，原图恢复后同一地址能返回原来的图片
+func setProxyHeaders(c *gin.Context, rating string, opts service.ProxyOptions, cacheStatus string, fallbackFrom uint) {
+	c.Header("X-Cache", cacheStatus)
+	c.Header("Cache-Control", imageCacheControl(rating, 172800)) // 2天缓存
+	c.Header("X-Content-Type-Options", "nosniff")
+	if opts.Format == service.FormatAuto {
+		c.Header("Vary", "Accept")
+	}
+	if fallbackFrom != 0 {
+		c.Header("Cache-Control", "no-store")
+		c.Header("X-Fallback-From", strconv.FormatUint(uint64(fallbackFrom), 10))
+	}
+}
+
+// serveProxyData 返回已在内存中的图片，由 http.ServeContent 处理Range和Content-Length
+func serveProxyData(c *gin.Context, data []byte, contentType string) {
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(data))
+}
+
+// VariantImage 返回预生成的缩略图
+// GET /api/variants/:id/:version/:width.webp
+// 地址中的版本是原图内容哈希，原图变化后地址随之变化，因此响应可以永久缓存；缩略图请求不计入统计
+func (api *PublicAPI) VariantImage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+	width, err := strconv.Atoi(strings.TrimSuffix(c.Param("file"), ".webp"))
+	if err != nil || !strings.HasSuffix(c.Param("file"), ".webp") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	var image model.Image
+	if err := database.DB.Select("id", "rating", "variant_version", "variant_widths").First(&image, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	// 超出允许分级的图片按不存在处理
+	if model.RatingLevel(image.Rating) > model.RatingLevel(getAllowedRating(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	// 版本不一致（原图已变化）或没有该宽度的缩略图
+	version := c.Param("version")
+	if version != image.VariantVersion || !service.HasVariant(&image, width) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-%d"`, version, width)
+	c.Header("Cache-Control", imageCacheControl(image.Rating, 31536000)+", immutable")
+	c.Header("ETag", etag)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	reader, size, err := service.GetStorageService().Open(c.Request.Context(), service.VariantKey(image.ID, version, width), -1)
+	if errors.Is(err, service.ErrStorageNotFound) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer reader.Close()
+	c.DataFromReader(http.StatusOK, size, service.VariantContentType, reader, nil)
+}
 
-	c.Header("Cache-Control", "public, max-age=172800") // 2天缓存
-	c.Header("Content-Type", contentType)
-	c.Data(http.StatusOK, contentType, data)
+// respondProxyError 按错误类型返回代理失败的状态码
+func respondProxyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUpstreamBusy):
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrImageTooLarge), errors.Is(err, service.ErrNotImage),
+		errors.Is(err, service.ErrBlockedDestination), errors.Is(err, service.ErrStorageNotFound):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// parseProxyOptions 解析代理接口的压缩和缩放参数
+func parseProxyOptions(c *gin.Context) (service.ProxyOptions, error) {
+	compressStr := c.DefaultQuery("compress", "false")
+	opts := service.ProxyOptions{
+		Compress: compressStr == "true" || compressStr == "1",
+		Format:   c.Query("format"),
+		Fit:      c.Query("fit"),
+	}
+	if strings.EqualFold(opts.Format, service.FormatAuto) {
+		opts.Accept = service.ParseAcceptedFormats(c.GetHeader("Accept"))
+	}
+
+	dimensions := []struct {
+		name  string
+		value *int
+	}{
+		{"w", &opts.Width},
+		{"h", &opts.Height},
+	}
+	for _, d := range dimensions {
+		value := c.Query(d.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > service.MaxProxyDimension {
+			return opts, fmt.Errorf("%s must be between 1 and %d", d.name, service.MaxProxyDimension)
+		}
+		*d.value = n
+	}
+
+	if opts.Fit != "" && !service.IsFit(opts.Fit) {
+		return opts, fmt.Errorf("invalid fit: %s (available: cover,contain,fill,inside)", opts.Fit)
+	}
+
+	if value := c.Query("q"); value != "" {
+		q, err := strconv.Atoi(value)
+		if err != nil || q < 1 || q > 100 {
+			return opts, fmt.Errorf("q must be between 1 and 100")
+		}
+		opts.Quality = q
+	}
+
+	if value := c.Query("dpr"); value != "" {
+		dpr, err := strconv.ParseFloat(value, 64)
+		if err != nil || dpr < 1 || dpr > service.MaxProxyDPR {
+			return opts, fmt.Errorf("dpr must be between 1 and %d", service.MaxProxyDPR)
+		}
+		opts.DPR = dpr
+	}
+
+	opts.Normalize()
+	return opts, nil
 }
 
 // GetPublicStats 获取公开统计数据（无需认证）
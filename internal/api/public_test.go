@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestContext 创建测试用的gin上下文
+func newTestContext(method, target string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	return c, w
+}
+
+func TestGetClientKey(t *testing.T) {
+	// 没有cookie：下发cookie，本次按普通随机处理
+	c, w := newTestContext(http.MethodGet, "/api/random")
+	if key := getClientKey(c); key != "" {
+		t.Errorf("client without cookie got key %q", key)
+	}
+	cookie := w.Result().Cookies()
+	if len(cookie) != 1 || cookie[0].Name != clientCookieName || len(cookie[0].Value) != 32 {
+		t.Fatalf("expected a %s cookie, got %v", clientCookieName, cookie)
+	}
+
+	// 带上下发的cookie后使用洗牌袋
+	c, _ = newTestContext(http.MethodGet, "/api/random")
+	c.Request.AddCookie(cookie[0])
+	if key := getClientKey(c); key != "sid:"+cookie[0].Value {
+		t.Errorf("key = %q, want sid:%s", key, cookie[0].Value)
+	}
+
+	// 伪造的cookie值不作为客户端标识
+	c, _ = newTestContext(http.MethodGet, "/api/random")
+	c.Request.AddCookie(&http.Cookie{Name: clientCookieName, Value: strings.Repeat("z", 1000)})
+	if key := getClientKey(c); key != "" {
+		t.Errorf("invalid cookie got key %q", key)
+	}
+
+	// API key优先
+	c, _ = newTestContext(http.MethodGet, "/api/random")
+	c.Set("api_key", &model.APIKey{ID: 7})
+	if key := getClientKey(c); key != "key:7" {
+		t.Errorf("key = %q, want key:7", key)
+	}
+}
+
+func TestRandomImageRejectsLongSeed(t *testing.T) {
+	c, w := newTestContext(http.MethodGet, "/api/random?seed="+strings.Repeat("a", maxSeedLength+1))
+	(&PublicAPI{}).RandomImage(c)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestImageCacheControl(t *testing.T) {
+	tests := []struct {
+		rating string
+		want   string
+	}{
+		{model.RatingSafe, "public, max-age=60"},
+		{model.RatingQuestionable, "private, max-age=60"},
+		{model.RatingExplicit, "private, max-age=60"},
+		{"", "private, max-age=60"},
+	}
+	for _, tt := range tests {
+		if got := imageCacheControl(tt.rating, 60); got != tt.want {
+			t.Errorf("imageCacheControl(%q) = %q, want %q", tt.rating, got, tt.want)
+		}
+	}
+
+	// 替换返回的图片始终不缓存
+	c, w := newTestContext(http.MethodGet, "/api/proxy/1")
+	setProxyHeaders(c, model.RatingSafe, service.ProxyOptions{}, "MISS", 2)
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("fallback Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestCacheControlByRating(t *testing.T) {
+	setupTestDB(t)
+	createCategories(t, "acg")
+	t.Setenv("ANONYMOUS_MAX_RATING", model.RatingExplicit)
+	width, height := 1920, 1080
+	images := []model.Image{
+		{SourceURL: "https://img.example.com/safe.jpg", Rating: model.RatingSafe, Status: "active", CategoryID: 1, Width: &width, Height: &height, Orientation: "landscape", VariantVersion: "v1", VariantWidths: "320"},
+		{SourceURL: "https://img.example.com/explicit.jpg", Rating: model.RatingExplicit, Status: "active", CategoryID: 1, Width: &width, Height: &height, Orientation: "landscape", VariantVersion: "v1", VariantWidths: "320"},
+	}
+	if err := database.DB.Create(&images).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, image := range images {
+		want := imageCacheControl(image.Rating, 31536000) + ", immutable"
+		target := fmt.Sprintf("/api/variants/%d/v1/320.webp", image.ID)
+		c, w := newTestContext(http.MethodGet, target)
+		c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(image.ID)}, {Key: "version", Value: "v1"}, {Key: "file", Value: "320.webp"}}
+		c.Request.Header.Set("If-None-Match", `"v1-320"`)
+		(&PublicAPI{}).VariantImage(c)
+		if c.Writer.Status() != http.StatusNotModified || w.Header().Get("Cache-Control") != want {
+			t.Errorf("variant of %s: status = %d, Cache-Control = %q, want 304 %q",
+				image.Rating, c.Writer.Status(), w.Header().Get("Cache-Control"), want)
+		}
+	}
+
+	for _, rating := range []string{model.RatingSafe, model.RatingExplicit} {
+		c, w := newTestContext(http.MethodGet, "/api/daily?format=json&device=pc&max_rating="+rating)
+		(&PublicAPI{randomIndex: service.NewRandomIndexService()}).DailyImage(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("daily: status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			ID uint `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		picked := images[0]
+		if body.ID == images[1].ID {
+			picked = images[1]
+		}
+		prefix := "public, "
+		if picked.Rating != model.RatingSafe {
+			prefix = "private, "
+		}
+		if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, prefix) {
+			t.Errorf("daily %s image: Cache-Control = %q, want %s...", picked.Rating, got, prefix)
+		}
+	}
+}
+
+func TestParseProxyOptions(t *testing.T) {
+	for _, query := range []string{"w=0", "w=5000", "h=abc", "fit=stretch", "q=0", "q=101", "dpr=0.5", "dpr=5"} {
+		c, _ := newTestContext(http.MethodGet, "/api/proxy/1?"+query)
+		if _, err := parseProxyOptions(c); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+
+	c, _ := newTestContext(http.MethodGet, "/api/proxy/1?w=300&h=200&fit=contain&q=70&dpr=2&format=jpg")
+	opts, err := parseProxyOptions(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := service.ProxyOptions{Format: "jpeg", Width: 300, Height: 200, Fit: service.FitContain, Quality: 70, DPR: 2}
+	if opts != want {
+		t.Errorf("opts = %+v, want %+v", opts, want)
+	}
+}
+
+func TestAutoFormatUsesAccept(t *testing.T) {
+	c, w := newTestContext(http.MethodGet, "/api/proxy/1?format=AUTO")
+	c.Request.Header.Set("Accept", "image/avif,image/webp,*/*")
+	opts, err := parseProxyOptions(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Format != service.FormatAuto || opts.Accept != "avif,webp" {
+		t.Errorf("opts = %+v, want format auto accepting avif,webp", opts)
+	}
+	setProxyHeaders(c, model.RatingSafe, opts, "MISS", 0)
+	if got := w.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q, want Accept", got)
+	}
+
+	// 指定了格式时不看Accept，也不需要Vary
+	c, w = newTestContext(http.MethodGet, "/api/proxy/1?format=webp&compress=true")
+	c.Request.Header.Set("Accept", "image/avif")
+	opts, _ = parseProxyOptions(c)
+	setProxyHeaders(c, model.RatingSafe, opts, "MISS", 0)
+	if opts.Accept != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("explicit format: Accept = %q, Vary = %q", opts.Accept, w.Header().Get("Vary"))
+	}
+}
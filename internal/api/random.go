@@ -0,0 +1,610 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// effectiveWeightSQL 图片实际生效权重的SQL表达式：图片权重 > 分类默认权重 > 1
+const effectiveWeightSQL = "COALESCE(weight, (SELECT default_weight FROM categories WHERE categories.id = images.category_id), 1)"
+
+// maxExcludeIDs exclude_id 最多允许的数量
+const maxExcludeIDs = 100
+
+// 宽高比筛选的默认和最大相对误差
+const (
+	defaultRatioTolerance = 0.02
+	maxRatioTolerance     = 0.5
+)
+
+// parseImageFilter 解析随机/列表接口共用的筛选参数
+// category、exclude_category 为逗号分隔的分类slug，exclude_id 为逗号分隔的图片ID
+// tags（全部包含）、tags_any（包含任一）、exclude_tags（全不包含）为逗号分隔的标签slug
+// 默认忽略不存在的分类slug和 exclude_tags 中不存在的标签（与旧版行为一致），strict=true 时返回404
+// tags 中有不存在的标签、或 tags_any 中的标签全都不存在时不可能有匹配的图片，总是返回404
+// 查询数据库出错时返回500
+// min_width、min_height、max_width、max_height 为像素值；ratio 为 16:9 或 1.78 形式，
+// 按 ratio_tolerance（相对误差，默认0.02）匹配；orientation 为逗号分隔的 square/landscape/portrait/ultrawide
+// color 为逗号分隔的颜色分组（按主色调），theme 为 dark/light（按平均亮度）
+func parseImageFilter(c *gin.Context, device string) (*service.ImageFilter, int, error) {
+	strictStr := c.DefaultQuery("strict", "false")
+	strict := strictStr == "true" || strictStr == "1"
+
+	filter := &service.ImageFilter{Device: device}
+
+	var err error
+	if filter.CategoryIDs, err = resolveCategorySlugs(c.Query("category"), strict); err != nil {
+		return nil, resolveErrorStatus(err), err
+	}
+	if filter.ExcludeCategoryIDs, err = resolveCategorySlugs(c.Query("exclude_category"), strict); err != nil {
+		return nil, resolveErrorStatus(err), err
+	}
+
+	if filter.TagIDs, err = resolveTagSlugs(c.Query("tags"), true); err != nil {
+		return nil, resolveErrorStatus(err), err
+	}
+	anyTags := splitList(c.Query("tags_any"))
+	if filter.AnyTagIDs, err = resolveTagSlugs(c.Query("tags_any"), strict); err != nil {
+		return nil, resolveErrorStatus(err), err
+	}
+	if len(anyTags) > 0 && len(filter.AnyTagIDs) == 0 {
+		err = &slugNotFoundError{kind: "tag", slugs: anyTags}
+		return nil, resolveErrorStatus(err), err
+	}
+	if filter.ExcludeTagIDs, err = resolveTagSlugs(c.Query("exclude_tags"), strict); err != nil {
+		return nil, resolveErrorStatus(err), err
+	}
+
+	for _, part := range splitList(c.Query("exclude_id")) {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid exclude_id: %s", part)
+		}
+		filter.ExcludeIDs = append(filter.ExcludeIDs, uint(id))
+	}
+	if len(filter.ExcludeIDs) > maxExcludeIDs {
+		return nil, http.StatusBadRequest, fmt.Errorf("exclude_id accepts at most %d ids", maxExcludeIDs)
+	}
+
+	if err := parseShapeFilter(c, filter); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	for _, color := range splitList(c.Query("color")) {
+		if !service.IsColorBucket(color) {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid color: %s (available: %s)", color, strings.Join(service.ColorBuckets, ","))
+		}
+		filter.Colors = append(filter.Colors, color)
+	}
+	switch theme := c.Query("theme"); theme {
+	case "", "dark", "light":
+		filter.Theme = theme
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid theme: %s", theme)
+	}
+
+	// 内容分级：不超过API key允许的分级，max_rating 只能进一步收紧
+	filter.MaxRating = getAllowedRating(c)
+	if maxRating := c.Query("max_rating"); maxRating != "" {
+		level := model.RatingLevel(maxRating)
+		if level < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid max_rating: %s (available: %s)", maxRating, strings.Join(model.Ratings, ","))
+		}
+		if level < model.RatingLevel(filter.MaxRating) {
+			filter.MaxRating = maxRating
+		}
+	}
+
+	return filter, 0, nil
+}
+
+// parseShapeFilter 解析分辨率和宽高比筛选参数
+func parseShapeFilter(c *gin.Context, filter *service.ImageFilter) error {
+	dimensions := []struct {
+		name  string
+		value *int
+	}{
+		{"min_width", &filter.MinWidth},
+		{"min_height", &filter.MinHeight},
+		{"max_width", &filter.MaxWidth},
+		{"max_height", &filter.MaxHeight},
+	}
+	for _, d := range dimensions {
+		value := c.Query(d.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid %s: %s", d.name, value)
+		}
+		*d.value = n
+	}
+	if filter.MaxWidth > 0 && filter.MinWidth > filter.MaxWidth {
+		return fmt.Errorf("min_width cannot be greater than max_width")
+	}
+	if filter.MaxHeight > 0 && filter.MinHeight > filter.MaxHeight {
+		return fmt.Errorf("min_height cannot be greater than max_height")
+	}
+
+	if value := c.Query("ratio"); value != "" {
+		ratio, err := parseRatio(value)
+		if err != nil {
+			return err
+		}
+		filter.Ratio = ratio
+		filter.RatioTolerance = defaultRatioTolerance
+	}
+	if value := c.Query("ratio_tolerance"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance < 0 || tolerance > maxRatioTolerance {
+			return fmt.Errorf("ratio_tolerance must be between 0 and %g", maxRatioTolerance)
+		}
+		filter.RatioTolerance = tolerance
+	}
+
+	for _, orientation := range splitList(c.Query("orientation")) {
+		switch orientation {
+		case model.OrientationSquare, model.OrientationLandscape, model.OrientationPortrait, model.OrientationUltrawide:
+			filter.Orientations = append(filter.Orientations, orientation)
+		default:
+			return fmt.Errorf("invalid orientation: %s", orientation)
+		}
+	}
+	return nil
+}
+
+// parseRatio 解析宽高比，支持 16:9、16x9 和 1.78 三种写法
+func parseRatio(value string) (float64, error) {
+	var ratio float64
+	if w, h, ok := strings.Cut(strings.ReplaceAll(value, "x", ":"), ":"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			ratio = width / height
+		}
+	} else if r, err := strconv.ParseFloat(value, 64); err == nil {
+		ratio = r
+	}
+
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return 0, fmt.Errorf("invalid ratio: %s", value)
+	}
+	return ratio, nil
+}
+
+// slugNotFoundError 分类/标签slug不存在
+type slugNotFoundError struct {
+	kind  string
+	slugs []string
+}
+
+func (e *slugNotFoundError) Error() string {
+	return fmt.Sprintf("%s not found: %s", e.kind, strings.Join(e.slugs, ","))
+}
+
+// resolveErrorStatus slug不存在时返回404，其他错误（查询数据库失败）返回500
+func resolveErrorStatus(err error) int {
+	var notFound *slugNotFoundError
+	if errors.As(err, &notFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// resolveCategorySlugs 将逗号分隔的分类slug转换为分类ID
+func resolveCategorySlugs(value string, strict bool) ([]uint, error) {
+	slugs := splitList(value)
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+
+	var categories []model.Category
+	if err := database.DB.Where("slug IN ?", slugs).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	if strict && len(categories) < len(slugs) {
+		found := make(map[string]bool, len(categories))
+		for _, cat := range categories {
+			found[cat.Slug] = true
+		}
+		missing := make([]string, 0)
+		for _, slug := range slugs {
+			if !found[slug] {
+				missing = append(missing, slug)
+			}
+		}
+		return nil, &slugNotFoundError{kind: "category", slugs: missing}
+	}
+
+	ids := make([]uint, len(categories))
+	for i, cat := range categories {
+		ids[i] = cat.ID
+	}
+	return ids, nil
+}
+
+// resolveTagSlugs 将逗号分隔的标签slug转换为标签ID
+func resolveTagSlugs(value string, strict bool) ([]uint, error) {
+	slugs := splitList(value)
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+
+	var tags []model.Tag
+	if err := database.DB.Where("slug IN ?", slugs).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	if strict && len(tags) < len(slugs) {
+		found := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			found[tag.Slug] = true
+		}
+		missing := make([]string, 0)
+		for _, slug := range slugs {
+			if !found[slug] {
+				missing = append(missing, slug)
+			}
+		}
+		return nil, &slugNotFoundError{kind: "tag", slugs: missing}
+	}
+
+	ids := make([]uint, len(tags))
+	for i, tag := range tags {
+		ids[i] = tag.ID
+	}
+	return ids, nil
+}
+
+// splitList 拆分逗号分隔的参数，去除空白和重复项
+func splitList(value string) []string {
+	items := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || seen[part] {
+			continue
+		}
+		seen[part] = true
+		items = append(items, part)
+	}
+	return items
+}
+
+// DailyImage 每日一图
+// GET /api/daily?category=acg&device=pc&format=redirect|proxy|json&compress=false
+func (api *PublicAPI) DailyImage(c *gin.Context) {
+	api.periodImage(c, "daily")
+}
+
+// HourlyImage 每小时一图
+// GET /api/hourly?category=acg&device=pc&format=redirect|proxy|json&compress=false
+func (api *PublicAPI) HourlyImage(c *gin.Context) {
+	api.periodImage(c, "hourly")
+}
+
+// WeeklyImage 每周一图
+// GET /api/weekly?category=acg&device=pc&format=redirect|proxy|json&compress=false
+func (api *PublicAPI) WeeklyImage(c *gin.Context) {
+	api.periodImage(c, "weekly")
+}
+
+// periodImage 按周期返回固定图片，缓存到周期结束
+func (api *PublicAPI) periodImage(c *gin.Context, period string) {
+	format := c.DefaultQuery("format", "redirect")
+	compressStr := c.DefaultQuery("compress", "false")
+	compress := compressStr == "true" || compressStr == "1"
+
+	// 同一周期内结果固定，不按视口选图，只按设备类型区分
+	setClientHintHeaders(c, c.Query("device") == "", false)
+
+	filter, status, err := parseImageFilter(c, getDeviceFromRequest(c))
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, end := periodBounds(period, time.Now())
+	image, err := api.pickSeededImage(period+":"+key, filter)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No images found"})
+		api.recordStat(c)
+		return
+	}
+
+	api.recordStat(c)
+
+	// 缓存到周期边界，周期切换后客户端和CDN都会重新获取；非safe分级的图片不允许共享缓存
+	maxAge := int(math.Ceil(time.Until(end).Seconds()))
+	if maxAge < 1 {
+		maxAge = 1
+	}
+	c.Header("Cache-Control", imageCacheControl(image.Rating, maxAge))
+	c.Header("Expires", end.UTC().Format(http.TimeFormat))
+
+	api.respondImage(c, image, format, compress, false)
+}
+
+// periodBounds 计算当前周期的标识和结束时间（服务器本地时区）
+func periodBounds(period string, now time.Time) (string, time.Time) {
+	switch period {
+	case "hourly":
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+		return start.Format("2006-01-02T15"), start.Add(time.Hour)
+	case "weekly":
+		// 周一为一周的开始（ISO周）
+		offset := (int(now.Weekday()) + 6) % 7
+		start := time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, now.Location())
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), start.AddDate(0, 0, 7)
+	default:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+	}
+}
+
+// respondImage 根据format返回选中的图片，fallback 会传递给proxy接口
+func (api *PublicAPI) respondImage(c *gin.Context, image *model.Image, format string, compress, fallback bool) {
+	switch format {
+	case "redirect":
+		// 302重定向到原图（不缓存，保证每次随机）
+		c.Redirect(http.StatusFound, imageURL(image))
+
+	case "proxy":
+		// 代理模式：302重定向到proxy接口（让Cloudflare缓存固定URL）
+		proxyURL := fmt.Sprintf("/api/proxy/%d", image.ID)
+		query := url.Values{}
+		if compress {
+			query.Set("compress", "true")
+		}
+		if fallback {
+			query.Set("fallback", "true")
+		}
+		if len(query) > 0 {
+			proxyURL += "?" + query.Encode()
+		}
+		c.Redirect(http.StatusFound, proxyURL)
+
+	case "json":
+		// JSON格式（不缓存，保证每次随机）
+		c.JSON(http.StatusOK, imageJSON(image))
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format parameter"})
+	}
+}
+
+// imageJSON 随机接口JSON格式中单张图片的内容
+func imageJSON(image *model.Image) gin.H {
+	service.FillVariants(image)
+	return gin.H{
+		"id":          image.ID,
+		"url":         imageURL(image),
+		"proxy":       fmt.Sprintf("/api/proxy/%d", image.ID),
+		"width":       image.Width,
+		"height":      image.Height,
+		"orientation": image.Orientation,
+		"color":       image.DominantColor,
+		"palette":     splitList(image.Palette),
+		"brightness":  image.Brightness,
+		"format":      image.Format,
+		"source":      image.Source,
+		"category":    image.Category,
+		"tags":        image.Tags,
+		"variants":    image.Variants,
+		"srcset":      image.Srcset,
+	}
+}
+
+// imageURL 图片的原图地址：上传或已镜像的图片在配置了 STORAGE_PUBLIC_URL 时使用存储的公开地址，
+// 否则上传的图片使用proxy接口，其他图片使用原地址
+func imageURL(image *model.Image) string {
+	if image.StorageKey != "" {
+		if publicURL := service.GetStorageService().PublicURL(image.StorageKey); publicURL != "" {
+			return publicURL
+		}
+	}
+	if _, ok := service.StorageKeyFromURL(image.SourceURL); ok {
+		return fmt.Sprintf("/api/proxy/%d", image.ID)
+	}
+	return image.SourceURL
+}
+
+// randomQuery 构建随机选图的数据库查询（索引不可用时的回退路径）
+func randomQuery(filter *service.ImageFilter) *gorm.DB {
+	query := database.DB.Model(&model.Image{}).
+		Where("status = ?", "active").
+		Where(effectiveWeightSQL + " > 0")
+
+	return filter.Apply(query)
+}
+
+// loadActiveImage 按ID加载有效图片，图片已不可用时同步移除索引
+func (api *PublicAPI) loadActiveImage(id uint) (*model.Image, error) {
+	var image model.Image
+	if err := database.DB.Preload("Category").Preload("Tags").Where("status = ?", "active").First(&image, id).Error; err != nil {
+		// 索引中的图片已被删除或停用
+		api.randomIndex.Remove(id)
+		return nil, err
+	}
+	return &image, nil
+}
+
+// pickRandomImage 随机选取一张图片
+// 优先使用内存索引 O(1) 选取，索引未就绪（冷启动）或索引数据已过期时回退到数据库随机查询
+func (api *PublicAPI) pickRandomImage(filter *service.ImageFilter) (*model.Image, error) {
+	if api.randomIndex.Ready() {
+		if id, ok := api.randomIndex.Pick(filter); ok {
+			if image, err := api.loadActiveImage(id); err == nil {
+				return image, nil
+			}
+		}
+	}
+
+	// 回退查询不做加权，只排除权重为0的图片
+	var image model.Image
+	if err := randomQuery(filter).Preload("Category").Preload("Tags").Order("RANDOM()").First(&image).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// loadActiveImages 按ID批量加载有效图片并保持ID的顺序，已不可用的图片同步移除索引
+func (api *PublicAPI) loadActiveImages(ids []uint) ([]model.Image, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var images []model.Image
+	if err := database.DB.Preload("Category").Preload("Tags").Where("status = ?", "active").Find(&images, ids).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]model.Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	result := make([]model.Image, 0, len(ids))
+	for _, id := range ids {
+		if image, ok := byID[id]; ok {
+			result = append(result, image)
+		} else {
+			api.randomIndex.Remove(id)
+		}
+	}
+	return result, nil
+}
+
+// pickRandomImages 按权重随机选取最多n张不重复的图片
+// 索引不可用时回退到数据库随机查询（不加权）；索引数据过期导致数量不足时由数据库补齐
+func (api *PublicAPI) pickRandomImages(filter *service.ImageFilter, n int) ([]model.Image, error) {
+	images := make([]model.Image, 0, n)
+	if api.randomIndex.Ready() {
+		var err error
+		if images, err = api.loadActiveImages(api.randomIndex.PickN(filter, n)); err != nil {
+			return nil, err
+		}
+		if len(images) == n {
+			return images, nil
+		}
+	}
+
+	query := randomQuery(filter)
+	if len(images) > 0 {
+		query = query.Where("id NOT IN ?", imageIDs(images))
+	}
+	var extra []model.Image
+	if err := query.Preload("Category").Preload("Tags").Order("RANDOM()").Limit(n - len(images)).Find(&extra).Error; err != nil {
+		return nil, err
+	}
+	return append(images, extra...), nil
+}
+
+// pickUniqueImages 从客户端的洗牌袋中连续选取最多n张图片，洗牌袋不可用时回退到普通随机
+func (api *PublicAPI) pickUniqueImages(clientKey string, filter *service.ImageFilter, n int) ([]model.Image, error) {
+	if ids := api.shuffleBag.NextN(clientKey, filter, n); len(ids) > 0 {
+		return api.loadActiveImages(ids)
+	}
+	return api.pickRandomImages(filter, n)
+}
+
+// pickSeededImages 按固定种子选取最多n张图片，第一张与 pickSeededImage 相同
+func (api *PublicAPI) pickSeededImages(seed string, filter *service.ImageFilter, n int) ([]model.Image, error) {
+	if api.randomIndex.Ready() {
+		if ids := api.randomIndex.PickSeededN(seed, filter, n); len(ids) > 0 {
+			return api.loadActiveImages(ids)
+		}
+	}
+
+	var rows []struct {
+		ID     uint
+		Weight int
+	}
+	if err := randomQuery(filter).
+		Select("id, " + effectiveWeightSQL + " AS weight").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	weights := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+		weights[i] = row.Weight
+	}
+	return api.loadActiveImages(service.SeededPickN(seed, ids, weights, n))
+}
+
+// imageIDs 提取图片ID
+func imageIDs(images []model.Image) []uint {
+	ids := make([]uint, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	return ids
+}
+
+// pickUniqueImage 从客户端的洗牌袋中选取图片，洗牌袋不可用（如索引未就绪）时回退到普通随机
+func (api *PublicAPI) pickUniqueImage(clientKey string, filter *service.ImageFilter) (*model.Image, error) {
+	if id, ok := api.shuffleBag.Next(clientKey, filter); ok {
+		if image, err := api.loadActiveImage(id); err == nil {
+			return image, nil
+		}
+	}
+	return api.pickRandomImage(filter)
+}
+
+// pickSeededImage 按固定种子选取图片，相同种子和图片池总是返回同一张
+// 索引未就绪时从数据库读取候选图片，使用相同的算法计算，保证结果一致
+func (api *PublicAPI) pickSeededImage(seed string, filter *service.ImageFilter) (*model.Image, error) {
+	if api.randomIndex.Ready() {
+		if id, ok := api.randomIndex.PickSeeded(seed, filter); ok {
+			if image, err := api.loadActiveImage(id); err == nil {
+				return image, nil
+			}
+		}
+	}
+
+	var rows []struct {
+		ID     uint
+		Weight int
+	}
+	if err := randomQuery(filter).
+		Select("id, " + effectiveWeightSQL + " AS weight").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	weights := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+		weights[i] = row.Weight
+	}
+
+	id, ok := service.SeededPick(seed, ids, weights)
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var image model.Image
+	if err := database.DB.Preload("Category").Preload("Tags").First(&image, id).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"net/http"
+	"path/filepath"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB 使用临时目录中的SQLite数据库替换 database.DB
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	database.DB = db
+	if err := database.AutoMigrate(); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// createCategories 按slug创建分类，返回 slug -> ID
+func createCategories(t *testing.T, slugs ...string) map[string]uint {
+	t.Helper()
+	ids := make(map[string]uint, len(slugs))
+	for _, slug := range slugs {
+		category := model.Category{Name: slug, Slug: slug, DefaultWeight: 1}
+		if err := database.DB.Create(&category).Error; err != nil {
+			t.Fatalf("create category: %v", err)
+		}
+		ids[slug] = category.ID
+	}
+	return ids
+}
+
+func TestParseImageFilterCategories(t *testing.T) {
+	setupTestDB(t)
+	ids := createCategories(t, "acg", "landscape", "nsfw")
+
+	c, _ := newTestContext(http.MethodGet, "/api/random?category=acg,landscape,missing&exclude_category=nsfw&exclude_id=3,4")
+	filter, _, err := parseImageFilter(c, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.CategoryIDs) != 2 || len(filter.ExcludeCategoryIDs) != 1 || filter.ExcludeCategoryIDs[0] != ids["nsfw"] {
+		t.Errorf("categories = %v, excluded = %v", filter.CategoryIDs, filter.ExcludeCategoryIDs)
+	}
+	if len(filter.ExcludeIDs) != 2 {
+		t.Errorf("exclude ids = %v", filter.ExcludeIDs)
+	}
+
+	// strict=true 时不存在的分类返回404
+	c, _ = newTestContext(http.MethodGet, "/api/random?category=acg,missing&strict=true")
+	if _, status, err := parseImageFilter(c, ""); err == nil || status != http.StatusNotFound {
+		t.Errorf("strict unknown category: status = %d, err = %v", status, err)
+	}
+}
+
+func TestParseImageFilterDatabaseError(t *testing.T) {
+	db := setupTestDB(t)
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	c, _ := newTestContext(http.MethodGet, "/api/random?category=acg&strict=true")
+	if _, status, err := parseImageFilter(c, ""); err == nil || status != http.StatusInternalServerError {
+		t.Errorf("database error: status = %d, err = %v, want 500", status, err)
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	// 2024-01-03 是周三
+	now := time.Date(2024, 1, 3, 15, 42, 10, 0, loc)
+
+	tests := []struct {
+		period  string
+		wantKey string
+		wantEnd time.Time
+	}{
+		{"hourly", "2024-01-03T15", time.Date(2024, 1, 3, 16, 0, 0, 0, loc)},
+		{"daily", "2024-01-03", time.Date(2024, 1, 4, 0, 0, 0, 0, loc)},
+		{"weekly", "2024-W01", time.Date(2024, 1, 8, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		key, end := periodBounds(tt.period, now)
+		if key != tt.wantKey || !end.Equal(tt.wantEnd) {
+			t.Errorf("periodBounds(%q) = %q, %v; want %q, %v", tt.period, key, end, tt.wantKey, tt.wantEnd)
+		}
+	}
+
+	// 周日仍属于周一开始的那一周
+	sunday := time.Date(2024, 1, 7, 23, 0, 0, 0, loc)
+	if key, _ := periodBounds("weekly", sunday); key != "2024-W01" {
+		t.Errorf("weekly key on Sunday = %q, want 2024-W01", key)
+	}
+}
+
+func TestParseImageFilterUnknownTags(t *testing.T) {
+	setupTestDB(t)
+	for _, slug := range []string{"night", "city"} {
+		if err := database.DB.Create(&model.Tag{Name: slug, Slug: slug}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		// tags 要求全部包含，忽略不存在的标签会扩大结果
+		{"tags=night,missing", http.StatusNotFound},
+		{"tags_any=missing,gone", http.StatusNotFound},
+		{"tags_any=night,missing", 0},
+		{"exclude_tags=missing", 0},
+		{"exclude_tags=missing&strict=true", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		c, _ := newTestContext(http.MethodGet, "/api/random?"+tt.query)
+		if _, status, _ := parseImageFilter(c, ""); status != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.query, status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"randimg/internal/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxUploadFiles 单次上传的文件数上限
+const maxUploadFiles = 20
+
+// uploadedImage 校验通过、等待保存的上传图片
+type uploadedImage struct {
+	filename    string
+	key         string
+	data        []byte
+	contentType string
+	image       model.Image
+}
+
+// UploadImages 上传图片到自有存储并创建图片记录
+// POST /api/admin/images/upload
+// multipart/form-data：file（可重复，最多20个）、category_id（必填）、source、rating、weight、tag_ids（逗号分隔）
+// 宽高、格式、颜色和感知哈希直接从上传的内容计算；任意一个文件无效时不保存任何图片
+func (api *AdminAPI) UploadImages(c *gin.Context) {
+	maxBytes := service.GetImageProxyService().MaxBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes*maxUploadFiles+1<<20)
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
+		return
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please upload at least one file"})
+		return
+	}
+	if len(files) > maxUploadFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d files can be uploaded at once", maxUploadFiles)})
+		return
+	}
+
+	// 所有图片共用的字段
+	categoryID, err := strconv.ParseUint(c.PostForm("category_id"), 10, 64)
+	if err != nil || categoryID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category_id is required"})
+		return
+	}
+	if err := database.DB.First(&model.Category{}, categoryID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+		return
+	}
+	var weight *int
+	if value := c.PostForm("weight"); value != "" {
+		w, err := strconv.Atoi(value)
+		if err == nil {
+			err = validateWeight(w)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("weight must be an integer between 0 and %d", maxWeight)})
+			return
+		}
+		weight = &w
+	}
+	rating := c.DefaultPostForm("rating", model.RatingSafe)
+	if err := validateRating(rating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var tagIDs []uint
+	for _, value := range splitList(c.PostForm("tag_ids")) {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag_ids must be comma separated tag ids"})
+			return
+		}
+		tagIDs = append(tagIDs, uint(id))
+	}
+	tags, err := loadTags(tagIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 先校验和分析所有文件
+	uploads := make([]*uploadedImage, 0, len(files))
+	byURL := make(map[string]*uploadedImage, len(files))
+	for _, file := range files {
+		upload, err := readUploadedImage(file, maxBytes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", file.Filename, err)})
+			return
+		}
+		if other, ok := byURL[upload.image.SourceURL]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: same image as %s", file.Filename, other.filename)})
+			return
+		}
+		upload.image.CategoryID = uint(categoryID)
+		upload.image.Source = c.PostForm("source")
+		upload.image.Rating = rating
+		upload.image.Weight = weight
+		upload.image.Tags = tags
+		uploads = append(uploads, upload)
+		byURL[upload.image.SourceURL] = upload
+	}
+
+	// 相同内容的图片已上传过
+	sourceURLs := make([]string, 0, len(uploads))
+	for _, upload := range uploads {
+		sourceURLs = append(sourceURLs, upload.image.SourceURL)
+	}
+	var existing []model.Image
+	if err := database.DB.Select("id", "source_url").Where("source_url IN ?", sourceURLs).Find(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(existing) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s: image already exists (id %d)", byURL[existing[0].SourceURL].filename, existing[0].ID)})
+		return
+	}
+
+	// 保存到存储，失败时删除已保存的对象
+	storage := service.GetStorageService()
+	ctx := c.Request.Context()
+	stored := make([]string, 0, len(uploads))
+	for _, upload := range uploads {
+		if err := storage.Put(ctx, upload.key, upload.data, upload.contentType); err != nil {
+			storage.DeleteUnreferenced(context.Background(), stored...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: failed to store image: %v", upload.filename, err)})
+			return
+		}
+		stored = append(stored, upload.key)
+	}
+
+	images := make([]model.Image, len(uploads))
+	for i, upload := range uploads {
+		images[i] = upload.image
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&images).Error
+	})
+	if err != nil {
+		storage.DeleteUnreferenced(context.Background(), stored...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range images {
+		api.randomIndex.Upsert(&images[i])
+		// 后台生成缩略图
+		service.GetImageFetchService().AddTask(images[i].ID)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"count":   len(images),
+		"images":  images,
+	})
+}
+
+// readUploadedImage 读取并分析上传的文件，按文件头识别格式（不信任客户端的Content-Type）
+func readUploadedImage(file *multipart.FileHeader, maxBytes int64) (*uploadedImage, error) {
+	if file.Size > maxBytes {
+		return nil, fmt.Errorf("file too large (max %d MB)", maxBytes>>20)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("file too large (max %d MB)", maxBytes>>20)
+	}
+
+	format, contentType := service.SniffImage(data)
+	if format == "" {
+		return nil, errors.New("not a supported image format")
+	}
+	key := service.UploadKey(data, format)
+	size := int64(len(data))
+	image := model.Image{
+		SourceURL:   service.StorageURL(key),
+		StorageKey:  key,
+		ContentHash: service.ContentHash(data),
+		FileSize:    &size,
+		Format:      format,
+		Status:      "active",
+	}
+	// 无法解码的格式（如avif）只保存格式，其他信息为空
+	if info, err := service.AnalyzeImageData(data, contentType); err == nil {
+		now := time.Now()
+		image.AnalyzedAt = &now
+		if info.Width > 0 && info.Height > 0 {
+			image.Width, image.Height = &info.Width, &info.Height
+		}
+		if info.Colors != nil {
+			image.DominantColor = info.Colors.DominantColor
+			image.Palette = strings.Join(info.Colors.Palette, ",")
+			image.Brightness = &info.Colors.Brightness
+			image.ColorBucket = info.Colors.ColorBucket
+		}
+		image.PerceptualHash = info.PerceptualHash
+		image.Fingerprint = info.Fingerprint
+	}
+	image.SetShape()
+
+	return &uploadedImage{
+		filename:    file.Filename,
+		key:         key,
+		data:        data,
+		contentType: contentType,
+		image:       image,
+	}, nil
+}
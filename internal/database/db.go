@@ -27,20 +27,71 @@ func InitDB(dbPath string) error {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// 为旧数据补全宽高比和方向
+	if err := RefreshImageShapes(nil); err != nil {
+		return fmt.Errorf("failed to backfill image shapes: %w", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
 // AutoMigrate 自动迁移数据库表结构
 func AutoMigrate() error {
+	// 使用自定义的关联表结构（tag_id 单独建索引，便于按标签筛选）
+	if err := DB.SetupJoinTable(&model.Image{}, "Tags", &model.ImageTag{}); err != nil {
+		return err
+	}
+
 	return DB.AutoMigrate(
 		&model.Category{},
+		&model.Tag{},
 		&model.Image{},
+		&model.ImageTag{},
 		&model.APIKey{},
 		&model.APIUsageLog{},
+		&model.HostProfile{},
 	)
 }
 
+// RefreshImageShapes 根据宽高重新计算图片的 aspect_ratio 和 orientation
+// ids为nil时只处理已有宽高但缺少宽高比的图片（旧数据补全）
+func RefreshImageShapes(ids []uint) error {
+	var images []model.Image
+	var updated int
+
+	query := DB.Select("id", "width", "height")
+	if ids == nil {
+		query = query.Where("aspect_ratio IS NULL AND width > 0 AND height > 0")
+	} else {
+		query = query.Where("id IN ?", ids)
+	}
+
+	result := query.FindInBatches(&images, 500, func(_ *gorm.DB, _ int) error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			for i := range images {
+				images[i].SetShape()
+				if err := tx.Model(&model.Image{}).Where("id = ?", images[i].ID).Updates(map[string]interface{}{
+					"aspect_ratio": images[i].AspectRatio,
+					"orientation":  images[i].Orientation,
+				}).Error; err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if ids == nil && updated > 0 {
+		log.Printf("Backfilled aspect ratio for %d images", updated)
+	}
+	return nil
+}
+
 // GetDB 获取数据库实例
 func GetDB() *gorm.DB {
 	return DB
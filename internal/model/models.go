@@ -1,30 +1,171 @@
 package model
 
 import (
+	"math"
 	"time"
 )
 
+// 图片方向（orientation字段取值）
+const (
+	OrientationSquare    = "square"
+	OrientationLandscape = "landscape"
+	OrientationPortrait  = "portrait"
+	OrientationUltrawide = "ultrawide"
+)
+
+const (
+	// SquareTolerance 宽高比与1相差不超过该比例时视为正方形
+	SquareTolerance = 0.03
+	// UltrawideRatio 宽高比不小于该值时视为超宽屏
+	UltrawideRatio = 2.0
+)
+
+// 内容分级（rating字段取值），按从低到高排列
+const (
+	RatingSafe         = "safe"
+	RatingQuestionable = "questionable"
+	RatingExplicit     = "explicit"
+)
+
+// Ratings 所有内容分级，按从低到高排列
+var Ratings = []string{RatingSafe, RatingQuestionable, RatingExplicit}
+
 // Category 分类表
 type Category struct {
 	ID          uint   `gorm:"primaryKey;autoIncrement" json:"id"`
 	Name        string `gorm:"type:varchar(50);not null;uniqueIndex" json:"name"`
 	Slug        string `gorm:"type:varchar(50);not null;uniqueIndex" json:"slug"`
 	Description string `gorm:"type:text" json:"description"`
+	// DefaultWeight 分类下图片的默认随机权重（图片未单独设置权重时使用）
+	DefaultWeight int `gorm:"type:integer;not null;default:1" json:"default_weight"`
+	// Mirror 是否将分类下所有图片的原图镜像到自有存储
+	Mirror bool `gorm:"not null;default:false" json:"mirror"`
 }
 
 // Image 图片信息表
 type Image struct {
 	ID         uint      `gorm:"primaryKey;autoIncrement" json:"id"`
 	SourceURL  string    `gorm:"type:text;not null;uniqueIndex" json:"source_url"`
-	Width      *int      `gorm:"type:integer" json:"width"`
-	Height     *int      `gorm:"type:integer" json:"height"`
+	Width      *int      `gorm:"type:integer;index" json:"width"`
+	Height     *int      `gorm:"type:integer;index" json:"height"`
 	Format     string    `gorm:"type:varchar(10)" json:"format"`
 	Source     string    `gorm:"type:varchar(255)" json:"source"`
 	Status     string    `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
+	Weight     *int      `gorm:"type:integer" json:"weight"` // 随机权重，为空时使用分类默认权重，0表示不参与随机
 	CategoryID uint      `gorm:"not null;index" json:"category_id"`
 	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	Tags       []Tag     `gorm:"many2many:image_tags" json:"tags,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// AspectRatio、Orientation 由宽高计算得出（见 ImageShape），用于按比例和方向筛选
+	AspectRatio *float64 `gorm:"index" json:"aspect_ratio"`
+	Orientation string   `gorm:"type:varchar(10);index" json:"orientation"`
+
+	// 主色调（#rrggbb）、调色板（逗号分隔）、平均亮度（0-1）和主色调所属颜色分组，由图片信息获取服务计算
+	DominantColor string   `gorm:"type:varchar(7)" json:"dominant_color"`
+	Palette       string   `gorm:"type:varchar(64)" json:"palette"`
+	Brightness    *float64 `gorm:"index" json:"brightness"`
+	ColorBucket   string   `gorm:"type:varchar(10);index" json:"color_bucket"`
+
+	// PerceptualHash 感知哈希（dHash，16位十六进制），用于查找重复图片
+	PerceptualHash string `gorm:"type:varchar(16);index" json:"perceptual_hash"`
+	// Fingerprint 视觉指纹（8x8网格的平均颜色，base64编码），用于以图搜图
+	Fingerprint string `gorm:"type:text" json:"-"`
+	// AnalyzedAt 最近一次成功读取原图并分析的时间；图片过大或无法解码时上述信息仍为空，但不再重复下载分析
+	AnalyzedAt *time.Time `json:"analyzed_at"`
+
+	// Rating 内容分级（safe/questionable/explicit），超出API Key允许分级的图片不会被返回
+	Rating string `gorm:"type:varchar(20);not null;default:'safe';index" json:"rating"`
+
+	// StorageKey 原图在自有存储中的对象键，不为空时从存储读取原图（上传的图片 source_url 为 storage://对象键，镜像的图片保留原地址）
+	StorageKey string `gorm:"type:varchar(255);index" json:"storage_key"`
+	// ContentHash 原图内容的SHA256，FileSize 原图字节数，上传和镜像时记录
+	ContentHash string `gorm:"type:varchar(64);index" json:"content_hash"`
+	FileSize    *int64 `json:"file_size"`
+
+	// 镜像：Mirror 为true（或所属分类开启镜像）时后台将原图下载到自有存储，记录镜像时间、最后一次尝试的时间和错误
+	Mirror          bool       `gorm:"not null;default:false" json:"mirror"`
+	MirroredAt      *time.Time `json:"mirrored_at"`
+	MirrorAttemptAt *time.Time `gorm:"index" json:"mirror_attempt_at"`
+	MirrorError     string     `gorm:"type:varchar(255)" json:"mirror_error"`
+
+	// 预生成的缩略图：VariantVersion 为生成时原图内容哈希的前12位（为空表示尚未生成，"-"表示原图无法生成），
+	// VariantWidths 为已生成的宽度（逗号分隔，原图不大于所有缩略图宽度时为空）
+	VariantVersion string `gorm:"type:varchar(16)" json:"-"`
+	VariantWidths  string `gorm:"type:varchar(64)" json:"-"`
+	// Variants、Srcset 由缩略图信息生成（见 service.FillVariants），不保存到数据库
+	Variants []ImageVariant `gorm:"-" json:"variants,omitempty"`
+	Srcset   string         `gorm:"-" json:"srcset,omitempty"`
+
+	// 链接检查：连续失败次数、最后一次检查时间和错误、下次检查时间，连续失败达到阈值后状态由active变为broken，恢复后自动变回active
+	CheckFailures  int        `gorm:"not null;default:0" json:"check_failures"`
+	LastCheckedAt  *time.Time `json:"last_checked_at"`
+	LastCheckError string     `gorm:"type:varchar(255)" json:"last_check_error"`
+	NextCheckAt    *time.Time `gorm:"index" json:"next_check_at"`
+}
+
+// ImageVariant 图片的一个缩略图尺寸
+type ImageVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height,omitempty"` // 原图宽高未知时为0
+	URL    string `json:"url"`
+}
+
+// Tag 标签表（与图片多对多）
+type Tag struct {
+	ID   uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name string `gorm:"type:varchar(50);not null;uniqueIndex" json:"name"`
+	Slug string `gorm:"type:varchar(50);not null;uniqueIndex" json:"slug"`
+}
+
+// ImageTag 图片-标签关联表
+type ImageTag struct {
+	ImageID uint `gorm:"primaryKey" json:"image_id"`
+	TagID   uint `gorm:"primaryKey;index" json:"tag_id"`
+}
+
+// ImageShape 根据宽高计算宽高比和方向，宽高未知时返回 nil 和空字符串
+func ImageShape(width, height *int) (*float64, string) {
+	if width == nil || height == nil || *width <= 0 || *height <= 0 {
+		return nil, ""
+	}
+
+	ratio := float64(*width) / float64(*height)
+	switch {
+	case math.Abs(ratio-1) <= SquareTolerance:
+		return &ratio, OrientationSquare
+	case ratio >= UltrawideRatio:
+		return &ratio, OrientationUltrawide
+	case ratio > 1:
+		return &ratio, OrientationLandscape
+	default:
+		return &ratio, OrientationPortrait
+	}
+}
+
+// SetShape 根据当前宽高更新 AspectRatio 和 Orientation
+func (img *Image) SetShape() {
+	img.AspectRatio, img.Orientation = ImageShape(img.Width, img.Height)
+}
+
+// RatingLevel 内容分级的等级（safe=0），无法识别时返回-1
+func RatingLevel(rating string) int {
+	for i, r := range Ratings {
+		if r == rating {
+			return i
+		}
+	}
+	return -1
+}
+
+// RatingsUpTo 不高于maxRating的所有内容分级，maxRating无法识别时只返回safe
+func RatingsUpTo(maxRating string) []string {
+	level := RatingLevel(maxRating)
+	if level < 0 {
+		level = 0
+	}
+	return Ratings[:level+1]
 }
 
 // APIKey API密钥表
@@ -34,6 +175,7 @@ type APIKey struct {
 	UserID     *uint      `gorm:"type:integer" json:"user_id"`
 	RateLimit  int        `gorm:"type:integer;not null;default:60" json:"rate_limit"`
 	Status     string     `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
+	MaxRating  string     `gorm:"type:varchar(20);not null;default:'safe'" json:"max_rating"` // 允许访问的最高内容分级
 	CreatedAt  time.Time  `json:"created_at"`
 	LastUsedAt *time.Time `json:"last_used_at"`
 }
@@ -45,6 +187,23 @@ type APIUsageLog struct {
 	RequestedAt time.Time `gorm:"not null;index" json:"requested_at"`
 }
 
+// HostProfile 上游主机的请求配置，用于访问有防盗链的图源
+// 获取图片信息、代理和链接检查时按主机匹配，设置请求头、超时和出站代理
+type HostProfile struct {
+	ID uint `gorm:"primaryKey;autoIncrement" json:"id"`
+	// Pattern 主机名（img.example.com）或通配符（*.example.com，匹配所有子域名），精确匹配优先，其次后缀最长的通配符
+	Pattern   string            `gorm:"type:varchar(255);not null;uniqueIndex" json:"pattern"`
+	Referer   string            `gorm:"type:text" json:"referer"`
+	UserAgent string            `gorm:"type:text" json:"user_agent"`
+	Cookie    string            `gorm:"type:text" json:"cookie"`
+	Headers   map[string]string `gorm:"type:text;serializer:json" json:"headers"`       // 其他请求头
+	Timeout   int               `gorm:"type:integer;not null;default:0" json:"timeout"` // 请求超时（秒），0表示默认30秒
+	ProxyURL  string            `gorm:"type:varchar(255)" json:"proxy_url"`             // 出站代理（http/https/socks5），为空时直连
+	Enabled   bool              `gorm:"not null" json:"enabled"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
 // TableName 指定表名
 func (Category) TableName() string {
 	return "categories"
@@ -54,6 +213,14 @@ func (Image) TableName() string {
 	return "images"
 }
 
+func (Tag) TableName() string {
+	return "tags"
+}
+
+func (ImageTag) TableName() string {
+	return "image_tags"
+}
+
 func (APIKey) TableName() string {
 	return "api_keys"
 }
@@ -61,3 +228,7 @@ func (APIKey) TableName() string {
 func (APIUsageLog) TableName() string {
 	return "api_usage_logs"
 }
+
+func (HostProfile) TableName() string {
+	return "host_profiles"
+}
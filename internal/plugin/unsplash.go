@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"randimg/internal/database"
 	"randimg/internal/model"
+	"randimg/internal/service"
 )
 
 // UnsplashPlugin Unsplash图源插件
@@ -19,7 +20,7 @@ type UnsplashPlugin struct {
 func NewUnsplashPlugin(apiKey string) *UnsplashPlugin {
 	return &UnsplashPlugin{
 		apiKey: apiKey,
-		client: &http.Client{},
+		client: service.GetOutboundClient(),
 	}
 }
 
@@ -98,12 +99,16 @@ func (p *UnsplashPlugin) ImportPhotos(categoryID uint, count int, query string)
 			CategoryID: categoryID,
 			Status:     "active",
 		}
+		image.SetShape()
 
 		if err := database.DB.Create(&image).Error; err != nil {
 			// 记录错误但继续
 			fmt.Printf("Failed to import photo %s: %v\n", photo.ID, err)
 			continue
 		}
+		service.GetRandomIndexService().Upsert(&image)
+		// 异步分析主色调和亮度
+		service.GetImageFetchService().AddTask(image.ID)
 
 		imported++
 	}
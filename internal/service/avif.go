@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// AVIF 只从HEIF容器头部解析尺寸，不解码像素
+// 注册到image包后 image.DecodeConfig 可以识别AVIF，image.Decode 返回 errDecodeUnsupported
+
+// errDecodeUnsupported 格式可以识别但不支持解码像素
+var errDecodeUnsupported = errors.New("decoding not supported")
+
+// maxAVIFMetaSize meta box 的大小上限，正常文件只有几KB
+const maxAVIFMetaSize = 1 << 20
+
+func init() {
+	image.RegisterFormat("avif", "????ftypavif", decodeAVIF, decodeAVIFConfig)
+	image.RegisterFormat("avif", "????ftypavis", decodeAVIF, decodeAVIFConfig)
+}
+
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	return nil, fmt.Errorf("avif: %w", errDecodeUnsupported)
+}
+
+// decodeAVIFConfig 读取顶层box直到meta，从主图像关联的ispe属性获取宽高（irot旋转90/270度时交换宽高）
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	var header [16]byte
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return image.Config{}, fmt.Errorf("avif: missing meta box: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// 延伸到文件末尾的box只可能是mdat
+			return image.Config{}, errors.New("avif: missing meta box")
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return image.Config{}, fmt.Errorf("avif: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return image.Config{}, errors.New("avif: invalid box size")
+		}
+
+		payload := size - headerSize
+		if boxType == "meta" {
+			if payload > maxAVIFMetaSize {
+				return image.Config{}, errors.New("avif: meta box too large")
+			}
+			data := make([]byte, payload)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return image.Config{}, fmt.Errorf("avif: %w", err)
+			}
+			return parseAVIFMeta(data)
+		}
+		if _, err := io.CopyN(io.Discard, r, payload); err != nil {
+			return image.Config{}, fmt.Errorf("avif: %w", err)
+		}
+	}
+}
+
+// parseAVIFMeta 解析meta box：pitm（主图像ID）、iprp/ipco（属性列表）、iprp/ipma（图像与属性的关联）
+func parseAVIFMeta(data []byte) (image.Config, error) {
+	if len(data) < 4 {
+		return image.Config{}, errors.New("avif: invalid meta box")
+	}
+
+	var (
+		primaryID  uint32
+		hasPrimary bool
+		properties []avifBox
+		// 各图像关联的属性序号（从1开始）
+		associations = make(map[uint32][]int)
+	)
+	err := walkAVIFBoxes(data[4:], func(box avifBox) error {
+		switch box.boxType {
+		case "pitm":
+			if len(box.data) < 6 {
+				return errors.New("avif: invalid pitm box")
+			}
+			if box.data[0] == 0 {
+				primaryID = uint32(binary.BigEndian.Uint16(box.data[4:6]))
+			} else if len(box.data) >= 8 {
+				primaryID = binary.BigEndian.Uint32(box.data[4:8])
+			} else {
+				return errors.New("avif: invalid pitm box")
+			}
+			hasPrimary = true
+		case "iprp":
+			return walkAVIFBoxes(box.data, func(child avifBox) error {
+				switch child.boxType {
+				case "ipco":
+					return walkAVIFBoxes(child.data, func(property avifBox) error {
+						properties = append(properties, property)
+						return nil
+					})
+				case "ipma":
+					return parseAVIFItemProperties(child.data, associations)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	var width, height int
+	rotated := false
+	found := false
+	for _, index := range associations[primaryID] {
+		if index < 1 || index > len(properties) {
+			continue
+		}
+		property := properties[index-1]
+		switch property.boxType {
+		case "ispe":
+			if len(property.data) >= 12 {
+				width = int(binary.BigEndian.Uint32(property.data[4:8]))
+				height = int(binary.BigEndian.Uint32(property.data[8:12]))
+				found = true
+			}
+		case "irot":
+			if len(property.data) >= 1 {
+				rotated = property.data[0]&0x03 == 1 || property.data[0]&0x03 == 3
+			}
+		}
+	}
+	if !found && !hasPrimary {
+		// 没有主图像信息时使用第一个ispe
+		for _, property := range properties {
+			if property.boxType == "ispe" && len(property.data) >= 12 {
+				width = int(binary.BigEndian.Uint32(property.data[4:8]))
+				height = int(binary.BigEndian.Uint32(property.data[8:12]))
+				found = true
+				break
+			}
+		}
+	}
+	if !found || width <= 0 || height <= 0 {
+		return image.Config{}, errors.New("avif: missing image size")
+	}
+	if rotated {
+		width, height = height, width
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// parseAVIFItemProperties 解析ipma box，记录每个图像关联的属性序号
+func parseAVIFItemProperties(data []byte, associations map[uint32][]int) error {
+	if len(data) < 8 {
+		return errors.New("avif: invalid ipma box")
+	}
+	version, flags := data[0], data[3]
+	count := binary.BigEndian.Uint32(data[4:8])
+	pos := 8
+	for i := uint32(0); i < count; i++ {
+		var itemID uint32
+		if version < 1 {
+			if pos+2 > len(data) {
+				return errors.New("avif: invalid ipma box")
+			}
+			itemID = uint32(binary.BigEndian.Uint16(data[pos:]))
+			pos += 2
+		} else {
+			if pos+4 > len(data) {
+				return errors.New("avif: invalid ipma box")
+			}
+			itemID = binary.BigEndian.Uint32(data[pos:])
+			pos += 4
+		}
+		if pos >= len(data) {
+			return errors.New("avif: invalid ipma box")
+		}
+		n := int(data[pos])
+		pos++
+		for j := 0; j < n; j++ {
+			// 最高位为essential标记，其余为属性序号
+			if flags&1 != 0 {
+				if pos+2 > len(data) {
+					return errors.New("avif: invalid ipma box")
+				}
+				associations[itemID] = append(associations[itemID], int(binary.BigEndian.Uint16(data[pos:])&0x7fff))
+				pos += 2
+			} else {
+				if pos+1 > len(data) {
+					return errors.New("avif: invalid ipma box")
+				}
+				associations[itemID] = append(associations[itemID], int(data[pos]&0x7f))
+				pos++
+			}
+		}
+	}
+	return nil
+}
+
+// avifBox ISOBMFF box
+type avifBox struct {
+	boxType string
+	data    []byte
+}
+
+// walkAVIFBoxes 依次遍历data中的box
+func walkAVIFBoxes(data []byte, fn func(avifBox) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("avif: truncated box")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errors.New("avif: truncated box")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return errors.New("avif: invalid box size")
+		}
+		if err := fn(avifBox{boxType: boxType, data: data[headerSize:size]}); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// avifTestBox 生成ISOBMFF box
+func avifTestBox(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	box = append(box, boxType...)
+	return append(box, body...)
+}
+
+// avifTestFile 生成只有头部的AVIF：主图像ID为1，关联属性 ispe(width x height) 和 irot(rotation)
+func avifTestFile(width, height uint32, rotation byte) []byte {
+	ispe := binary.BigEndian.AppendUint32(make([]byte, 4), width)
+	ispe = binary.BigEndian.AppendUint32(ispe, height)
+	ipco := avifTestBox("ipco", avifTestBox("ispe", ispe), avifTestBox("irot", []byte{rotation}))
+	// 版本0、无标记：1个图像，ID为1，关联属性1和2（属性2带essential标记）
+	ipma := avifTestBox("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 2, 0x01, 0x82})
+	meta := avifTestBox("meta",
+		[]byte{0, 0, 0, 0},
+		avifTestBox("hdlr", make([]byte, 24)),
+		avifTestBox("pitm", []byte{0, 0, 0, 0, 0, 1}),
+		avifTestBox("iprp", ipco, ipma),
+	)
+	ftyp := avifTestBox("ftyp", []byte("avif"), make([]byte, 4), []byte("mif1avif"))
+	return append(append(ftyp, meta...), avifTestBox("mdat", make([]byte, 16))...)
+}
+
+func TestDecodeAVIFConfig(t *testing.T) {
+	tests := []struct {
+		rotation      byte
+		width, height int
+	}{
+		{0, 1920, 1080},
+		{1, 1080, 1920}, // 旋转90度
+		{2, 1920, 1080},
+		{3, 1080, 1920},
+	}
+	for _, tt := range tests {
+		config, format, err := image.DecodeConfig(bytes.NewReader(avifTestFile(1920, 1080, tt.rotation)))
+		if err != nil {
+			t.Fatalf("irot %d: %v", tt.rotation, err)
+		}
+		if format != "avif" || config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("irot %d: %s %dx%d, want avif %dx%d", tt.rotation, format, config.Width, config.Height, tt.width, tt.height)
+		}
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(avifTestFile(10, 10, 0))); err == nil {
+		t.Error("AVIF pixel decoding should be unsupported")
+	}
+}
+
+func TestDecodeAVIFConfigTruncated(t *testing.T) {
+	file := avifTestFile(640, 480, 0)
+	metaEnd := bytes.Index(file, []byte("mdat")) - 4
+	// 在meta结束前的任何位置截断都应返回错误而不是panic
+	for n := 0; n < metaEnd; n++ {
+		if config, err := decodeAVIFConfig(bytes.NewReader(file[:n])); err == nil {
+			t.Fatalf("truncated at %d: got %+v, want error", n, config)
+		}
+	}
+	if _, err := decodeAVIFConfig(bytes.NewReader(file[:metaEnd])); err != nil {
+		t.Errorf("complete meta box: %v", err)
+	}
+}
+
+func TestParseAVIFMetaMalformed(t *testing.T) {
+	ispe := func(w, h uint32) []byte {
+		data := binary.BigEndian.AppendUint32(make([]byte, 4), w)
+		return avifTestBox("ispe", binary.BigEndian.AppendUint32(data, h))
+	}
+	meta := func(boxes ...[]byte) []byte {
+		return append([]byte{0, 0, 0, 0}, bytes.Join(boxes, nil)...)
+	}
+	pitm := avifTestBox("pitm", []byte{0, 0, 0, 0, 0, 1})
+	ipma := avifTestBox("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 1, 0x01})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated box header", meta([]byte{0, 0, 0})},
+		{"box size smaller than header", meta([]byte{0, 0, 0, 4, 'p', 'i', 't', 'm'})},
+		{"box size past end", meta([]byte{0, 0, 1, 0, 'p', 'i', 't', 'm', 0})},
+		{"64-bit size truncated", meta([]byte{0, 0, 0, 1, 'p', 'i', 't', 'm', 0, 0})},
+		{"short pitm", meta(avifTestBox("pitm", []byte{0, 0, 0, 0}))},
+		{"short pitm version 1", meta(avifTestBox("pitm", []byte{1, 0, 0, 0, 0, 1}))},
+		{"short ipma", meta(pitm, avifTestBox("iprp", avifTestBox("ipma", []byte{0, 0, 0})))},
+		{"ipma count past end", meta(pitm, avifTestBox("iprp", avifTestBox("ipma", []byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})))},
+		{"ipma associations past end", meta(pitm, avifTestBox("iprp", avifTestBox("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 5, 1})))},
+		{"ipma 16-bit index truncated", meta(pitm, avifTestBox("iprp", avifTestBox("ipma", []byte{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0})))},
+		{"property index out of range", meta(pitm, avifTestBox("iprp", avifTestBox("ipco", ispe(1, 1)),
+			avifTestBox("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 1, 0x09})))},
+		{"zero size", meta(pitm, avifTestBox("iprp", avifTestBox("ipco", ispe(0, 100)), ipma))},
+		{"short ispe", meta(pitm, avifTestBox("iprp", avifTestBox("ipco", avifTestBox("ispe", make([]byte, 8))), ipma))},
+		{"ispe of another item", meta(pitm, avifTestBox("iprp", avifTestBox("ipco", ispe(10, 10)),
+			avifTestBox("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 1, 0x01})))},
+	}
+	for _, tt := range tests {
+		if config, err := parseAVIFMeta(tt.data); err == nil {
+			t.Errorf("%s: got %+v, want error", tt.name, config)
+		}
+	}
+
+	// 没有pitm时使用第一个ispe；ipma版本1（32位图像ID）和16位属性序号
+	valid := []struct {
+		name string
+		data []byte
+	}{
+		{"no pitm", meta(avifTestBox("iprp", avifTestBox("ipco", ispe(30, 20), ispe(300, 200))))},
+		{"ipma version 1 with 16-bit indices", meta(
+			avifTestBox("pitm", []byte{1, 0, 0, 0, 0, 0, 0, 7}),
+			avifTestBox("iprp", avifTestBox("ipco", ispe(30, 20)),
+				avifTestBox("ipma", []byte{1, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 7, 1, 0x80, 0x01})))},
+	}
+	for _, tt := range valid {
+		config, err := parseAVIFMeta(tt.data)
+		if err != nil || config.Width != 30 || config.Height != 20 {
+			t.Errorf("%s: got %+v, %v, want 30x20", tt.name, config, err)
+		}
+	}
+}
+
+func TestDecodeAVIFConfigRejectsHugeMeta(t *testing.T) {
+	var file []byte
+	file = binary.BigEndian.AppendUint32(file, 1)
+	file = append(file, "meta"...)
+	file = binary.BigEndian.AppendUint64(file, maxAVIFMetaSize+17)
+	if _, err := decodeAVIFConfig(bytes.NewReader(file)); err == nil {
+		t.Error("oversized meta box accepted")
+	}
+
+	// size为0的box延伸到文件末尾，不可能在其后找到meta
+	if _, err := decodeAVIFConfig(bytes.NewReader([]byte{0, 0, 0, 0, 'm', 'd', 'a', 't'})); err == nil {
+		t.Error("missing meta box accepted")
+	}
+}
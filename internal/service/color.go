@@ -0,0 +1,206 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// ColorBuckets 颜色分组（按色相划分，低饱和度的颜色归为黑/白/灰）
+var ColorBuckets = []string{
+	"red", "orange", "yellow", "green", "cyan", "blue", "purple", "pink",
+	"brown", "black", "white", "gray",
+}
+
+// ThemeBrightnessThreshold 平均亮度低于该值视为深色图片（theme=dark），否则为浅色（theme=light）
+const ThemeBrightnessThreshold = 0.5
+
+const (
+	// colorSampleSize 提取颜色时每个方向最多采样的像素数
+	colorSampleSize = 64
+	// paletteSize 调色板颜色数量
+	paletteSize = 5
+	// paletteMinDistance 调色板中颜色之间的最小距离（RGB欧氏距离），避免选出相近的颜色
+	paletteMinDistance = 48
+)
+
+// ColorInfo 图片的主色调信息
+type ColorInfo struct {
+	DominantColor string   // 主色调，#rrggbb
+	Palette       []string // 调色板（按占比从高到低，包含主色调）
+	Brightness    float64  // 平均亮度 0-1
+	ColorBucket   string   // 主色调所属的颜色分组
+}
+
+// colorBin 量化后的颜色区间
+type colorBin struct {
+	count            int
+	r, g, b          int
+	avgR, avgG, avgB float64
+}
+
+// ExtractColors 提取图片的主色调、调色板和平均亮度
+// 按网格采样后将颜色量化为 16x16x16 个区间，按像素数量选取调色板
+func ExtractColors(img image.Image) *ColorInfo {
+	bounds := img.Bounds()
+	if bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return nil
+	}
+
+	stepX := max(bounds.Dx()/colorSampleSize, 1)
+	stepY := max(bounds.Dy()/colorSampleSize, 1)
+
+	bins := make(map[int]*colorBin)
+	var luminance float64
+	var samples int
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r32, g32, b32, a32 := img.At(x, y).RGBA()
+			if a32 < 0x8000 {
+				// 跳过半透明像素
+				continue
+			}
+			r, g, b := int(r32>>8), int(g32>>8), int(b32>>8)
+
+			luminance += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 255
+			samples++
+
+			key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+			bin := bins[key]
+			if bin == nil {
+				bin = &colorBin{}
+				bins[key] = bin
+			}
+			bin.count++
+			bin.r += r
+			bin.g += g
+			bin.b += b
+		}
+	}
+
+	if samples == 0 {
+		return nil
+	}
+
+	sorted := make([]*colorBin, 0, len(bins))
+	for _, bin := range bins {
+		bin.avgR = float64(bin.r) / float64(bin.count)
+		bin.avgG = float64(bin.g) / float64(bin.count)
+		bin.avgB = float64(bin.b) / float64(bin.count)
+		sorted = append(sorted, bin)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].count > sorted[j].count })
+
+	// 按占比依次选取，跳过与已选颜色过于接近的区间
+	palette := make([]*colorBin, 0, paletteSize)
+	for _, bin := range sorted {
+		distinct := true
+		for _, chosen := range palette {
+			if colorDistance(bin, chosen) < paletteMinDistance {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			palette = append(palette, bin)
+			if len(palette) == paletteSize {
+				break
+			}
+		}
+	}
+
+	info := &ColorInfo{
+		Brightness: math.Round(luminance/float64(samples)*1000) / 1000,
+	}
+	for _, bin := range palette {
+		info.Palette = append(info.Palette, hexColor(bin.avgR, bin.avgG, bin.avgB))
+	}
+	info.DominantColor = info.Palette[0]
+	info.ColorBucket = colorBucket(palette[0].avgR, palette[0].avgG, palette[0].avgB)
+	return info
+}
+
+// IsColorBucket 判断是否为有效的颜色分组
+func IsColorBucket(name string) bool {
+	return containsString(ColorBuckets, name)
+}
+
+// colorBucket 将颜色归入最接近的颜色分组
+func colorBucket(r, g, b float64) string {
+	h, s, v := rgbToHSV(r/255, g/255, b/255)
+
+	switch {
+	case v < 0.2:
+		return "black"
+	case s < 0.15 && v > 0.85:
+		return "white"
+	case s < 0.15:
+		return "gray"
+	}
+
+	switch {
+	case h < 15 || h >= 345:
+		if v < 0.5 {
+			return "brown"
+		}
+		return "red"
+	case h < 45:
+		if v < 0.6 {
+			return "brown"
+		}
+		return "orange"
+	case h < 70:
+		return "yellow"
+	case h < 165:
+		return "green"
+	case h < 195:
+		return "cyan"
+	case h < 255:
+		return "blue"
+	case h < 290:
+		return "purple"
+	default:
+		return "pink"
+	}
+}
+
+// rgbToHSV RGB(0-1) 转 HSV，色相为 0-360
+func rgbToHSV(r, g, b float64) (float64, float64, float64) {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	delta := maxC - minC
+
+	var h float64
+	switch {
+	case delta == 0:
+		h = 0
+	case maxC == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case maxC == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	var s float64
+	if maxC > 0 {
+		s = delta / maxC
+	}
+	return h, s, maxC
+}
+
+// colorDistance 两个颜色区间平均色的RGB欧氏距离
+func colorDistance(a, b *colorBin) float64 {
+	dr, dg, db := a.avgR-b.avgR, a.avgG-b.avgG, a.avgB-b.avgB
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// hexColor 格式化为 #rrggbb
+func hexColor(r, g, b float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round(r)), int(math.Round(g)), int(math.Round(b)))
+}
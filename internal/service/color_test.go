@@ -0,0 +1,72 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// solidImage 创建纯色图片，左侧 split 列使用 other 颜色
+func solidImage(width, height int, c color.Color, split int, other color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < split {
+				img.Set(x, y, other)
+			} else {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+func TestExtractColors(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	info := ExtractColors(solidImage(100, 50, red, 30, blue))
+	if info.DominantColor != "#ff0000" || info.ColorBucket != "red" {
+		t.Errorf("dominant = %s (%s), want #ff0000 (red)", info.DominantColor, info.ColorBucket)
+	}
+	if len(info.Palette) != 2 || info.Palette[1] != "#0000ff" {
+		t.Errorf("palette = %v, want red then blue", info.Palette)
+	}
+
+	if info := ExtractColors(solidImage(10, 10, color.White, 0, nil)); info.Brightness != 1 || info.ColorBucket != "white" {
+		t.Errorf("white image: %+v", info)
+	}
+	if info := ExtractColors(solidImage(10, 10, color.Black, 0, nil)); info.Brightness != 0 || info.ColorBucket != "black" {
+		t.Errorf("black image: %+v", info)
+	}
+
+	// 全透明图片没有可用的采样
+	if info := ExtractColors(image.NewRGBA(image.Rect(0, 0, 10, 10))); info != nil {
+		t.Errorf("transparent image: %+v", info)
+	}
+}
+
+func TestColorBucket(t *testing.T) {
+	tests := []struct {
+		r, g, b float64
+		want    string
+	}{
+		{250, 20, 20, "red"},
+		{120, 20, 20, "brown"},
+		{250, 140, 20, "orange"},
+		{240, 230, 30, "yellow"},
+		{30, 200, 40, "green"},
+		{30, 200, 210, "cyan"},
+		{30, 60, 220, "blue"},
+		{140, 40, 220, "purple"},
+		{240, 60, 170, "pink"},
+		{128, 128, 128, "gray"},
+		{10, 10, 10, "black"},
+		{245, 245, 245, "white"},
+	}
+	for _, tt := range tests {
+		if got := colorBucket(tt.r, tt.g, tt.b); got != tt.want {
+			t.Errorf("colorBucket(%v, %v, %v) = %s, want %s", tt.r, tt.g, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"log"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"sort"
+)
+
+// FindDuplicateClusters 按感知哈希查找相似图片分组
+// 汉明距离不超过maxDistance的图片归为一组（传递闭包），只返回包含两张及以上图片的分组，按图片数量从多到少排列
+func FindDuplicateClusters(maxDistance int) ([][]uint, error) {
+	var rows []struct {
+		ID             uint
+		PerceptualHash string
+	}
+	if err := database.DB.Model(&model.Image{}).
+		Select("id", "perceptual_hash").
+		Where("status = ? AND perceptual_hash <> ''", "active").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// 哈希相同的图片合并到同一节点，BK树中只保存不同的哈希
+	tree := &bkTree{}
+	for _, row := range rows {
+		hash, err := ParseHash(row.PerceptualHash)
+		if err != nil {
+			log.Printf("Duplicate: invalid perceptual hash for image %d: %v", row.ID, err)
+			continue
+		}
+		tree.insert(hash, row.ID)
+	}
+
+	// 并查集，按节点的第一张图片ID合并
+	parent := make(map[uint]uint)
+	var find func(id uint) uint
+	find = func(id uint) uint {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	union := func(a, b uint) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[ra] = rb
+		}
+	}
+
+	nodes := make([]*bkNode, 0)
+	collectNodes(tree.root, &nodes)
+	for _, node := range nodes {
+		for _, id := range node.ids[1:] {
+			union(id, node.ids[0])
+		}
+		if maxDistance > 0 {
+			tree.search(node.hash, maxDistance, func(other *bkNode, _ int) {
+				union(other.ids[0], node.ids[0])
+			})
+		}
+	}
+
+	groups := make(map[uint][]uint)
+	for _, node := range nodes {
+		for _, id := range node.ids {
+			root := find(id)
+			groups[root] = append(groups[root], id)
+		}
+	}
+
+	clusters := make([][]uint, 0)
+	for _, ids := range groups {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		clusters = append(clusters, ids)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		return clusters[i][0] < clusters[j][0]
+	})
+	return clusters, nil
+}
+
+// collectNodes 收集BK树的所有节点
+func collectNodes(node *bkNode, nodes *[]*bkNode) {
+	if node == nil {
+		return
+	}
+	*nodes = append(*nodes, node)
+	for _, child := range node.children {
+		collectNodes(child, nodes)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"testing"
+)
+
+func TestFindDuplicateClusters(t *testing.T) {
+	setupTestDB(t)
+	hashes := []struct {
+		hash   string
+		status string
+	}{
+		{"00000000000000ff", "active"}, // 1
+		{"00000000000000ff", "active"}, // 2 与1相同
+		{"00000000000001ff", "active"}, // 3 与1相差1位
+		{"00000000000003ff", "active"}, // 4 与3相差1位，与1相差2位
+		{"ffffffff00000000", "active"}, // 5 单独
+		{"00000000000000ff", "inactive"},
+		{"", "active"},
+	}
+	for i, h := range hashes {
+		image := model.Image{SourceURL: fmt.Sprintf("https://img.example.com/%d.jpg", i), PerceptualHash: h.hash, Status: h.status, CategoryID: 1}
+		if err := database.DB.Create(&image).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		distance int
+		want     string
+	}{
+		{0, "[[1 2]]"},
+		{1, "[[1 2 3 4]]"}, // 4 经由 3 传递归入同一组
+		{40, "[[1 2 3 4 5]]"},
+	}
+	for _, tt := range tests {
+		clusters, err := FindDuplicateClusters(tt.distance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(clusters); got != tt.want {
+			t.Errorf("distance %d: clusters = %s, want %s", tt.distance, got, tt.want)
+		}
+	}
+}
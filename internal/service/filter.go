@@ -0,0 +1,285 @@
+package service
+
+import (
+	"fmt"
+	"randimg/internal/model"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ImageFilter 图片筛选条件，随机索引和数据库查询共用同一套条件
+type ImageFilter struct {
+	CategoryIDs        []uint // 为空表示不限分类
+	ExcludeCategoryIDs []uint
+	ExcludeIDs         []uint
+	TagIDs             []uint // 必须包含全部标签
+	AnyTagIDs          []uint // 至少包含其中一个标签
+	ExcludeTagIDs      []uint // 不能包含其中任何标签
+	Device             string // pc=横屏（宽>高），mobile=竖屏（高>宽），其他值不限；接近正方形的图片由 Orientations 单独筛选
+
+	// 分辨率和宽高比条件，设置任一条件时排除宽高未知的图片
+	MinWidth       int
+	MinHeight      int
+	MaxWidth       int
+	MaxHeight      int
+	Ratio          float64  // 目标宽高比，0表示不限
+	RatioTolerance float64  // 宽高比允许的相对误差
+	Orientations   []string // square/landscape/portrait/ultrawide，满足其一即可
+
+	// 颜色条件，设置时排除尚未分析颜色的图片
+	Colors []string // 主色调所属颜色分组，满足其一即可
+	Theme  string   // dark=深色，light=浅色
+
+	// MaxRating 允许的最高内容分级，为空表示不限
+	MaxRating string
+}
+
+// Key 筛选条件的规范化标识，用于缓存选取结果
+func (f *ImageFilter) Key() string {
+	return f.PoolKey() + ";xi=" + joinIDs(f.ExcludeIDs)
+}
+
+// PoolKey 不含 ExcludeIDs 的筛选条件标识，用于洗牌袋分组和候选图片缓存
+// exclude_id、换图重试和批量选取时临时排除的图片在选取时逐张跳过，不会产生新的洗牌袋
+func (f *ImageFilter) PoolKey() string {
+	parts := []string{
+		"c=" + joinIDs(f.CategoryIDs),
+		"xc=" + joinIDs(f.ExcludeCategoryIDs),
+		"t=" + joinIDs(f.TagIDs),
+		"ta=" + joinIDs(f.AnyTagIDs),
+		"xt=" + joinIDs(f.ExcludeTagIDs),
+		"d=" + f.Device,
+		fmt.Sprintf("w=%d-%d", f.MinWidth, f.MaxWidth),
+		fmt.Sprintf("h=%d-%d", f.MinHeight, f.MaxHeight),
+		fmt.Sprintf("r=%g~%g", f.Ratio, f.RatioTolerance),
+		"o=" + joinStrings(f.Orientations),
+		"col=" + joinStrings(f.Colors),
+		"th=" + f.Theme,
+		"mr=" + f.MaxRating,
+	}
+	return strings.Join(parts, ";")
+}
+
+// bucketKey 分桶条件（分类、内容分级、设备类型和方向）的规范化标识，用于缓存符合条件的桶
+func (f *ImageFilter) bucketKey() string {
+	return strings.Join([]string{
+		"c=" + joinIDs(f.CategoryIDs),
+		"xc=" + joinIDs(f.ExcludeCategoryIDs),
+		"d=" + f.Device,
+		"o=" + joinStrings(f.Orientations),
+		"mr=" + f.MaxRating,
+	}, ";")
+}
+
+// Apply 将筛选条件应用到images表的查询上
+func (f *ImageFilter) Apply(query *gorm.DB) *gorm.DB {
+	if len(f.CategoryIDs) > 0 {
+		query = query.Where("category_id IN ?", f.CategoryIDs)
+	}
+	if len(f.ExcludeCategoryIDs) > 0 {
+		query = query.Where("category_id NOT IN ?", f.ExcludeCategoryIDs)
+	}
+	if len(f.ExcludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", f.ExcludeIDs)
+	}
+	if len(f.TagIDs) > 0 {
+		query = query.Where("id IN (SELECT image_id FROM image_tags WHERE tag_id IN ? GROUP BY image_id HAVING COUNT(*) = ?)",
+			f.TagIDs, len(f.TagIDs))
+	}
+	if len(f.AnyTagIDs) > 0 {
+		query = query.Where("id IN (SELECT image_id FROM image_tags WHERE tag_id IN ?)", f.AnyTagIDs)
+	}
+	if len(f.ExcludeTagIDs) > 0 {
+		query = query.Where("id NOT IN (SELECT image_id FROM image_tags WHERE tag_id IN ?)", f.ExcludeTagIDs)
+	}
+
+	// 根据device参数筛选图片（基于宽高比）
+	if f.Device == "pc" {
+		// PC端：横屏图片（宽>高）
+		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND width > height")
+	} else if f.Device == "mobile" {
+		// 移动端：竖屏图片（高>宽）
+		query = query.Where("width IS NOT NULL AND height IS NOT NULL AND height > width")
+	}
+	// 如果device为其他值，返回所有图片（包括正方形）
+
+	if f.MinWidth > 0 {
+		query = query.Where("width >= ?", f.MinWidth)
+	}
+	if f.MinHeight > 0 {
+		query = query.Where("height >= ?", f.MinHeight)
+	}
+	if f.MaxWidth > 0 {
+		query = query.Where("width <= ?", f.MaxWidth)
+	}
+	if f.MaxHeight > 0 {
+		query = query.Where("height <= ?", f.MaxHeight)
+	}
+	if f.Ratio > 0 {
+		low, high := f.ratioRange()
+		query = query.Where("aspect_ratio BETWEEN ? AND ?", low, high)
+	}
+	if len(f.Orientations) > 0 {
+		query = query.Where("orientation IN ?", f.Orientations)
+	}
+	if len(f.Colors) > 0 {
+		query = query.Where("color_bucket IN ?", f.Colors)
+	}
+	switch f.Theme {
+	case "dark":
+		query = query.Where("brightness < ?", ThemeBrightnessThreshold)
+	case "light":
+		query = query.Where("brightness >= ?", ThemeBrightnessThreshold)
+	}
+	if f.MaxRating != "" && f.MaxRating != model.RatingExplicit {
+		query = query.Where("rating IN ?", model.RatingsUpTo(f.MaxRating))
+	}
+
+	return query
+}
+
+// matchBucket 判断分桶是否符合分类、内容分级、设备类型和方向条件
+func (f *ImageFilter) matchBucket(key bucketKey) bool {
+	if len(f.CategoryIDs) > 0 && !containsID(f.CategoryIDs, key.categoryID) {
+		return false
+	}
+	if containsID(f.ExcludeCategoryIDs, key.categoryID) {
+		return false
+	}
+	if f.MaxRating != "" && !containsString(model.RatingsUpTo(f.MaxRating), key.rating) {
+		return false
+	}
+	if (f.Device == "pc" || f.Device == "mobile") && key.device != f.Device {
+		return false
+	}
+	return len(f.Orientations) == 0 || containsString(f.Orientations, key.shape)
+}
+
+// deviceOf 图片适合的设备类型：宽>高为pc，高>宽为mobile，宽高相等或未知时为空
+// 只比较宽高，接近正方形（见 model.SquareTolerance）但不相等的图片也归入pc或mobile
+func deviceOf(width, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case width > height:
+		return "pc"
+	case height > width:
+		return "mobile"
+	default:
+		return ""
+	}
+}
+
+// hasEntryConditions 是否存在分桶之外、需要逐张图片判断的条件（方向已由分桶判断）
+func (f *ImageFilter) hasEntryConditions() bool {
+	return len(f.ExcludeIDs) > 0 || len(f.TagIDs) > 0 || len(f.AnyTagIDs) > 0 || len(f.ExcludeTagIDs) > 0 ||
+		f.hasShapeConditions() || len(f.Colors) > 0 || f.Theme != ""
+}
+
+// hasShapeConditions 是否设置了分辨率或宽高比条件
+func (f *ImageFilter) hasShapeConditions() bool {
+	return f.MinWidth > 0 || f.MinHeight > 0 || f.MaxWidth > 0 || f.MaxHeight > 0 || f.Ratio > 0
+}
+
+// ratioRange 目标宽高比按相对误差换算后的取值范围
+func (f *ImageFilter) ratioRange() (float64, float64) {
+	return f.Ratio * (1 - f.RatioTolerance), f.Ratio * (1 + f.RatioTolerance)
+}
+
+// matchEntry 判断单张图片是否符合分桶之外的条件
+func (f *ImageFilter) matchEntry(id uint, entry *indexEntry) bool {
+	if containsID(f.ExcludeIDs, id) {
+		return false
+	}
+	for _, tagID := range f.TagIDs {
+		if !containsID(entry.tags, tagID) {
+			return false
+		}
+	}
+	if len(f.AnyTagIDs) > 0 && !containsAnyID(entry.tags, f.AnyTagIDs) {
+		return false
+	}
+	if containsAnyID(entry.tags, f.ExcludeTagIDs) {
+		return false
+	}
+	if f.hasShapeConditions() && !f.matchShape(entry) {
+		return false
+	}
+	if len(f.Colors) > 0 && !containsString(f.Colors, entry.colorBucket) {
+		return false
+	}
+	switch f.Theme {
+	case "dark":
+		return entry.brightness >= 0 && entry.brightness < ThemeBrightnessThreshold
+	case "light":
+		return entry.brightness >= ThemeBrightnessThreshold
+	}
+	return true
+}
+
+// matchShape 判断图片是否符合分辨率和宽高比条件，宽高未知的图片不符合
+func (f *ImageFilter) matchShape(entry *indexEntry) bool {
+	if entry.width <= 0 || entry.height <= 0 {
+		return false
+	}
+	if f.MinWidth > 0 && entry.width < f.MinWidth {
+		return false
+	}
+	if f.MinHeight > 0 && entry.height < f.MinHeight {
+		return false
+	}
+	if f.MaxWidth > 0 && entry.width > f.MaxWidth {
+		return false
+	}
+	if f.MaxHeight > 0 && entry.height > f.MaxHeight {
+		return false
+	}
+	if f.Ratio > 0 {
+		low, high := f.ratioRange()
+		if entry.ratio < low || entry.ratio > high {
+			return false
+		}
+	}
+	return true
+}
+
+// joinIDs 将ID排序后拼接为字符串
+func joinIDs(ids []uint) string {
+	sorted := append([]uint(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	parts := make([]string, len(sorted))
+	for i, id := range sorted {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// joinStrings 将字符串排序后拼接
+func joinStrings(items []string) string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// containsAnyID 判断两个切片是否有交集
+func containsAnyID(ids []uint, candidates []uint) bool {
+	for _, id := range candidates {
+		if containsID(ids, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsID 判断切片中是否包含指定ID
+func containsID(ids []uint, id uint) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
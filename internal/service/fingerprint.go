@@ -0,0 +1,176 @@
+package service
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"randimg/internal/database"
+	"randimg/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// fingerprintGrid 视觉指纹的网格尺寸，每个格子保存平均颜色的 Y/Cb/Cr 三个分量
+const fingerprintGrid = 8
+
+// fingerprintSize 视觉指纹的字节数
+const fingerprintSize = fingerprintGrid * fingerprintGrid * 3
+
+// ErrUnsupportedImage 以图搜图的图片无法解码，不能计算感知哈希和视觉指纹
+var ErrUnsupportedImage = errors.New("unsupported image: failed to decode for fingerprint")
+
+// SimilarImage 以图搜图的结果
+type SimilarImage struct {
+	ImageID      uint    `json:"image_id"`
+	Similarity   float64 `json:"similarity"`    // 综合相似度 0-1
+	HashDistance int     `json:"hash_distance"` // 感知哈希的汉明距离
+}
+
+// VisualFingerprint 计算图片的视觉指纹：缩小为 8x8 网格后每格的平均颜色（YCbCr）
+// 与只关注明暗结构的感知哈希互补，能区分结构相似但颜色不同的图片
+func VisualFingerprint(img image.Image) []byte {
+	bounds := img.Bounds()
+	fingerprint := make([]byte, 0, fingerprintSize)
+
+	for ty := 0; ty < fingerprintGrid; ty++ {
+		y0 := bounds.Min.Y + ty*bounds.Dy()/fingerprintGrid
+		y1 := max(bounds.Min.Y+(ty+1)*bounds.Dy()/fingerprintGrid, y0+1)
+		stepY := max((y1-y0)/8, 1)
+
+		for tx := 0; tx < fingerprintGrid; tx++ {
+			x0 := bounds.Min.X + tx*bounds.Dx()/fingerprintGrid
+			x1 := max(bounds.Min.X+(tx+1)*bounds.Dx()/fingerprintGrid, x0+1)
+			stepX := max((x1-x0)/8, 1)
+
+			var sumR, sumG, sumB float64
+			var n int
+			for y := y0; y < y1; y += stepY {
+				for x := x0; x < x1; x += stepX {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sumR += float64(r >> 8)
+					sumG += float64(g >> 8)
+					sumB += float64(b >> 8)
+					n++
+				}
+			}
+
+			cy, cb, cr := color.RGBToYCbCr(
+				uint8(math.Round(sumR/float64(n))),
+				uint8(math.Round(sumG/float64(n))),
+				uint8(math.Round(sumB/float64(n))),
+			)
+			fingerprint = append(fingerprint, cy, cb, cr)
+		}
+	}
+	return fingerprint
+}
+
+// EncodeFingerprint 将视觉指纹编码为字符串（用于存储）
+func EncodeFingerprint(fingerprint []byte) string {
+	return base64.StdEncoding.EncodeToString(fingerprint)
+}
+
+// DecodeFingerprint 解码视觉指纹，格式不正确时返回nil
+func DecodeFingerprint(s string) []byte {
+	fingerprint, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(fingerprint) != fingerprintSize {
+		return nil
+	}
+	return fingerprint
+}
+
+// ImageSimilarity 根据感知哈希和视觉指纹计算两张图片的相似度（0-1）
+// 明暗结构（感知哈希）和颜色分布（视觉指纹）各占一半；亮度分量的权重是色度分量的两倍
+func ImageSimilarity(hashA, hashB uint64, fingerprintA, fingerprintB []byte) float64 {
+	// 汉明距离超过32（一半的位）视为结构完全不同
+	hashSimilarity := 1 - math.Min(float64(HammingDistance(hashA, hashB))/32, 1)
+
+	var sum, weights float64
+	for i := range fingerprintA {
+		w := 1.0
+		if i%3 == 0 {
+			w = 2
+		}
+		d := float64(fingerprintA[i]) - float64(fingerprintB[i])
+		sum += w * d * d
+		weights += w
+	}
+	// 均方根差超过128视为颜色完全不同
+	colorSimilarity := 1 - math.Min(math.Sqrt(sum/weights)/128, 1)
+
+	return math.Round((hashSimilarity+colorSimilarity)/2*10000) / 10000
+}
+
+// SearchSimilarImages 在图库的有效图片中查找与给定图片最相似的图片，按相似度从高到低返回最多limit个结果
+// 给定图片无法计算指纹时返回 ErrUnsupportedImage
+func SearchSimilarImages(info *ImageInfo, limit int, minSimilarity float64) ([]SimilarImage, error) {
+	hash, err := ParseHash(info.PerceptualHash)
+	fingerprint := DecodeFingerprint(info.Fingerprint)
+	if err != nil || fingerprint == nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	var rows []struct {
+		ID             uint
+		PerceptualHash string
+		Fingerprint    string
+	}
+	results := &similarHeap{}
+
+	err = database.DB.Model(&model.Image{}).
+		Select("id", "perceptual_hash", "fingerprint").
+		Where("status = ? AND perceptual_hash <> '' AND fingerprint <> ''", "active").
+		FindInBatches(&rows, 1000, func(_ *gorm.DB, _ int) error {
+			for _, row := range rows {
+				otherHash, err := ParseHash(row.PerceptualHash)
+				if err != nil {
+					continue
+				}
+				otherFingerprint := DecodeFingerprint(row.Fingerprint)
+				if otherFingerprint == nil {
+					continue
+				}
+
+				similarity := ImageSimilarity(hash, otherHash, fingerprint, otherFingerprint)
+				if similarity < minSimilarity {
+					continue
+				}
+
+				// 小顶堆只保留相似度最高的limit个
+				item := SimilarImage{ImageID: row.ID, Similarity: similarity, HashDistance: HammingDistance(hash, otherHash)}
+				if results.Len() < limit {
+					heap.Push(results, item)
+				} else if similarity > (*results)[0].Similarity {
+					(*results)[0] = item
+					heap.Fix(results, 0)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]SimilarImage, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(SimilarImage)
+	}
+	return sorted, nil
+}
+
+// similarHeap 按相似度排序的小顶堆
+type similarHeap []SimilarImage
+
+func (h similarHeap) Len() int           { return len(h) }
+func (h similarHeap) Less(i, j int) bool { return h[i].Similarity < h[j].Similarity }
+func (h similarHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *similarHeap) Push(x any)        { *h = append(*h, x.(SimilarImage)) }
+func (h *similarHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
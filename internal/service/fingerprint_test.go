@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"testing"
+)
+
+// fingerprintOf 计算测试图片的感知哈希和视觉指纹
+func fingerprintOf(img image.Image) (uint64, []byte) {
+	return DHash(img), VisualFingerprint(img)
+}
+
+func TestEncodeDecodeFingerprint(t *testing.T) {
+	fingerprint := VisualFingerprint(gradientImage(64, 48, 2))
+	if len(fingerprint) != fingerprintSize {
+		t.Fatalf("len = %d, want %d", len(fingerprint), fingerprintSize)
+	}
+	decoded := DecodeFingerprint(EncodeFingerprint(fingerprint))
+	if string(decoded) != string(fingerprint) {
+		t.Error("round trip changed the fingerprint")
+	}
+
+	for _, s := range []string{"", "not base64!", EncodeFingerprint(fingerprint[:10])} {
+		if got := DecodeFingerprint(s); got != nil {
+			t.Errorf("DecodeFingerprint(%q) = %v, want nil", s, got)
+		}
+	}
+}
+
+func TestVisualFingerprintTinyImage(t *testing.T) {
+	// 小于网格的图片每格至少采样一个像素
+	fingerprint := VisualFingerprint(solidImage(3, 2, color.RGBA{R: 255, A: 255}, 0, nil))
+	if len(fingerprint) != fingerprintSize {
+		t.Fatalf("len = %d, want %d", len(fingerprint), fingerprintSize)
+	}
+}
+
+func TestImageSimilarity(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	hashA, fpA := fingerprintOf(gradientImage(120, 90, 3))
+	hashB, fpB := fingerprintOf(gradientImage(600, 450, 3))
+	if s := ImageSimilarity(hashA, hashA, fpA, fpA); s != 1 {
+		t.Errorf("identical similarity = %v, want 1", s)
+	}
+	if s := ImageSimilarity(hashA, hashB, fpA, fpB); s < 0.95 {
+		t.Errorf("rescaled similarity = %v, want >= 0.95", s)
+	}
+
+	// 结构相同颜色不同的图片只得到感知哈希那一半的分数
+	hashRed, fpRed := fingerprintOf(solidImage(80, 80, red, 0, nil))
+	hashBlue, fpBlue := fingerprintOf(solidImage(80, 80, blue, 0, nil))
+	if s := ImageSimilarity(hashRed, hashBlue, fpRed, fpBlue); s > 0.75 {
+		t.Errorf("red vs blue similarity = %v, want <= 0.75", s)
+	}
+}
+
+func TestSearchSimilarImages(t *testing.T) {
+	setupTestDB(t)
+	query := gradientImage(120, 90, 3)
+	images := []struct {
+		img    image.Image
+		status string
+	}{
+		{gradientImage(240, 180, 3), "active"},                             // 1 与查询图相同
+		{gradientImage(120, 90, 3, 7), "active"},                           // 2 多一条竖条
+		{solidImage(80, 80, color.RGBA{G: 255, A: 255}, 0, nil), "active"}, // 3 完全不同
+		{gradientImage(120, 90, 3), "inactive"},                            // 4 已停用
+	}
+	for i, item := range images {
+		hash, fingerprint := fingerprintOf(item.img)
+		image := model.Image{
+			SourceURL:      fmt.Sprintf("https://img.example.com/%d.jpg", i),
+			PerceptualHash: FormatHash(hash),
+			Fingerprint:    EncodeFingerprint(fingerprint),
+			Status:         item.status,
+			CategoryID:     1,
+		}
+		if err := database.DB.Create(&image).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, fingerprint := fingerprintOf(query)
+	info := &ImageInfo{PerceptualHash: FormatHash(hash), Fingerprint: EncodeFingerprint(fingerprint)}
+
+	results, err := SearchSimilarImages(info, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3 (inactive image excluded): %+v", len(results), results)
+	}
+	if results[0].ImageID != 1 || results[1].ImageID != 2 || results[2].ImageID != 3 {
+		t.Errorf("order = %+v, want 1, 2, 3", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Similarity > results[i-1].Similarity {
+			t.Errorf("results not sorted by similarity: %+v", results)
+		}
+	}
+
+	limited, err := SearchSimilarImages(info, 1, 0)
+	if err != nil || len(limited) != 1 || limited[0].ImageID != 1 {
+		t.Errorf("limit 1 = %+v, %v, want only image 1", limited, err)
+	}
+
+	filtered, err := SearchSimilarImages(info, 10, results[1].Similarity)
+	if err != nil || len(filtered) != 2 {
+		t.Errorf("minSimilarity = %v gave %+v, %v, want 2 results", results[1].Similarity, filtered, err)
+	}
+
+	if _, err := SearchSimilarImages(&ImageInfo{}, 10, 0); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("err = %v, want ErrUnsupportedImage", err)
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHostProfileTimeout 主机配置允许的最大超时（秒）
+const maxHostProfileTimeout = 300
+
+// HostProfileService 按上游主机匹配请求配置（Referer、User-Agent、Cookie、其他请求头、超时和出站代理）
+// 配置保存在数据库中，启动时和管理后台修改后重新加载到内存
+type HostProfileService struct {
+	mu       sync.RWMutex
+	exact    map[string]*hostProfileEntry
+	wildcard []*hostProfileEntry // 按后缀长度从长到短排列
+}
+
+// hostProfileEntry 已加载的配置和对应的HTTP客户端
+type hostProfileEntry struct {
+	profile model.HostProfile
+	suffix  string // 通配符去掉 "*" 后的后缀，如 ".example.com"
+	client  *http.Client
+}
+
+var (
+	hostProfileInstance *HostProfileService
+	hostProfileOnce     sync.Once
+)
+
+// GetHostProfileService 获取主机配置服务单例
+func GetHostProfileService() *HostProfileService {
+	hostProfileOnce.Do(func() {
+		hostProfileInstance = &HostProfileService{exact: make(map[string]*hostProfileEntry)}
+		if err := hostProfileInstance.Reload(); err != nil {
+			log.Printf("HostProfile: failed to load profiles: %v", err)
+		}
+	})
+	return hostProfileInstance
+}
+
+// Reload 从数据库重新加载启用的配置
+func (s *HostProfileService) Reload() error {
+	var profiles []model.HostProfile
+	if err := database.DB.Where("enabled = ?", true).Find(&profiles).Error; err != nil {
+		return err
+	}
+
+	exact := make(map[string]*hostProfileEntry)
+	var wildcard []*hostProfileEntry
+	for _, profile := range profiles {
+		entry := &hostProfileEntry{profile: profile, client: GetOutboundClient()}
+		if profile.Timeout > 0 || profile.ProxyURL != "" {
+			timeout := defaultOutboundTimeout
+			if profile.Timeout > 0 {
+				timeout = time.Duration(profile.Timeout) * time.Second
+			}
+			var proxy *url.URL
+			if profile.ProxyURL != "" {
+				var err error
+				if proxy, err = url.Parse(profile.ProxyURL); err != nil {
+					log.Printf("HostProfile: invalid proxy for %s: %v", profile.Pattern, err)
+					continue
+				}
+			}
+			entry.client = NewOutboundClient(timeout, proxy)
+		}
+
+		if suffix, ok := strings.CutPrefix(profile.Pattern, "*"); ok {
+			entry.suffix = suffix
+			wildcard = append(wildcard, entry)
+		} else {
+			exact[profile.Pattern] = entry
+		}
+	}
+	// 后缀越长的通配符越具体，优先匹配
+	sort.Slice(wildcard, func(i, j int) bool {
+		return len(wildcard[i].suffix) > len(wildcard[j].suffix)
+	})
+
+	s.mu.Lock()
+	old := s.entries()
+	s.exact, s.wildcard = exact, wildcard
+	s.mu.Unlock()
+
+	// 旧配置的连接池不再使用
+	for _, entry := range old {
+		if entry.client != GetOutboundClient() {
+			entry.client.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// entries 所有已加载的配置（调用方需持有锁）
+func (s *HostProfileService) entries() []*hostProfileEntry {
+	entries := make([]*hostProfileEntry, 0, len(s.exact)+len(s.wildcard))
+	for _, entry := range s.exact {
+		entries = append(entries, entry)
+	}
+	return append(entries, s.wildcard...)
+}
+
+// match 查找主机对应的配置，没有时返回nil
+func (s *HostProfileService) match(host string) *hostProfileEntry {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if entry, ok := s.exact[host]; ok {
+		return entry
+	}
+	for _, entry := range s.wildcard {
+		if strings.HasSuffix(host, entry.suffix) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// NewRequest 创建访问rawURL的GET请求并应用匹配的主机配置，返回应使用的HTTP客户端
+// 没有匹配的配置时使用共享客户端和Go默认请求头
+func (s *HostProfileService) NewRequest(rawURL string) (*http.Request, *http.Client, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := s.match(req.URL.Hostname())
+	if entry == nil {
+		return req, GetOutboundClient(), nil
+	}
+
+	profile := &entry.profile
+	for name, value := range profile.Headers {
+		req.Header.Set(name, value)
+	}
+	if profile.Referer != "" {
+		req.Header.Set("Referer", profile.Referer)
+	}
+	if profile.UserAgent != "" {
+		req.Header.Set("User-Agent", profile.UserAgent)
+	}
+	if profile.Cookie != "" {
+		req.Header.Set("Cookie", profile.Cookie)
+	}
+	return req, entry.client, nil
+}
+
+// NormalizeHostProfile 检查并规范化配置：主机名转小写，请求头名称规范化
+func NormalizeHostProfile(profile *model.HostProfile) error {
+	pattern := strings.ToLower(strings.TrimSpace(profile.Pattern))
+	host := strings.TrimPrefix(pattern, "*.")
+	if host == "" || strings.ContainsAny(host, "*/:@ ") || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") {
+		return fmt.Errorf("invalid pattern %q (expected host name or *.domain)", profile.Pattern)
+	}
+	profile.Pattern = pattern
+
+	if profile.Timeout < 0 || profile.Timeout > maxHostProfileTimeout {
+		return fmt.Errorf("timeout must be between 0 and %d", maxHostProfileTimeout)
+	}
+
+	profile.ProxyURL = strings.TrimSpace(profile.ProxyURL)
+	if profile.ProxyURL != "" {
+		u, err := url.Parse(profile.ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy_url %q", profile.ProxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("proxy_url scheme must be http, https or socks5")
+		}
+	}
+
+	for _, value := range []string{profile.Referer, profile.UserAgent, profile.Cookie} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.New("header values must not contain line breaks")
+		}
+	}
+	headers := make(map[string]string, len(profile.Headers))
+	for name, value := range profile.Headers {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s must not contain line breaks", name)
+		}
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+	}
+	profile.Headers = headers
+	return nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"net/http"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadHostProfiles 保存配置并重新加载
+func loadHostProfiles(t *testing.T, profiles ...model.HostProfile) *HostProfileService {
+	t.Helper()
+	setupTestDB(t)
+	for i := range profiles {
+		if err := database.DB.Create(&profiles[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &HostProfileService{exact: make(map[string]*hostProfileEntry)}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHostProfileMatch(t *testing.T) {
+	s := loadHostProfiles(t,
+		model.HostProfile{Pattern: "img.example.com", Referer: "exact", Enabled: true},
+		model.HostProfile{Pattern: "*.example.com", Referer: "short", Enabled: true},
+		model.HostProfile{Pattern: "*.cdn.example.com", Referer: "long", Enabled: true},
+		model.HostProfile{Pattern: "off.example.net", Referer: "disabled", Enabled: false},
+	)
+
+	tests := []struct {
+		host string
+		want string // 匹配到的配置的 Referer，空表示没有匹配
+	}{
+		{"img.example.com", "exact"},
+		{"IMG.Example.COM.", "exact"},
+		{"a.example.com", "short"},
+		{"a.b.example.com", "short"},
+		{"a.cdn.example.com", "long"},
+		{"cdn.example.com", "short"},
+		// 通配符只匹配子域名
+		{"example.com", ""},
+		{"badexample.com", ""},
+		{"off.example.net", ""},
+		{"other.org", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if entry := s.match(tt.host); entry != nil {
+			got = entry.profile.Referer
+		}
+		if got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostProfileReloadClients(t *testing.T) {
+	s := loadHostProfiles(t,
+		model.HostProfile{Pattern: "plain.example.com", Referer: "r", Enabled: true},
+		model.HostProfile{Pattern: "slow.example.com", Timeout: 90, Enabled: true},
+		model.HostProfile{Pattern: "proxied.example.com", ProxyURL: "http://10.0.0.1:3128", Enabled: true},
+		model.HostProfile{Pattern: "broken.example.com", ProxyURL: "http://[::1", Enabled: true},
+	)
+
+	// 只设置请求头时共用出站客户端
+	if entry := s.match("plain.example.com"); entry == nil || entry.client != GetOutboundClient() {
+		t.Error("header-only profile should use the shared client")
+	}
+	if entry := s.match("slow.example.com"); entry == nil || entry.client.Timeout != 90*time.Second {
+		t.Error("profile timeout not applied")
+	}
+	if entry := s.match("proxied.example.com"); entry == nil || entry.client == GetOutboundClient() {
+		t.Error("proxied profile should have its own client")
+	}
+	// 代理地址无效的配置跳过，不影响其他配置
+	if s.match("broken.example.com") != nil {
+		t.Error("profile with an invalid proxy was loaded")
+	}
+
+	// 重新加载后删除的配置不再匹配
+	database.DB.Where("pattern = ?", "slow.example.com").Delete(&model.HostProfile{})
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s.match("slow.example.com") != nil {
+		t.Error("deleted profile still matches after reload")
+	}
+}
+
+func TestHostProfileNewRequest(t *testing.T) {
+	s := loadHostProfiles(t, model.HostProfile{
+		Pattern:   "*.example.com",
+		Referer:   "https://www.example.com/",
+		UserAgent: "Mozilla/5.0",
+		Cookie:    "session=1",
+		// 单独的字段优先于 Headers 中的同名请求头
+		Headers: map[string]string{"X-Token": "abc", "Referer": "https://ignored/"},
+		Enabled: true,
+	})
+
+	req, client, err := s.NewRequest("https://img.example.com/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Referer":    "https://www.example.com/",
+		"User-Agent": "Mozilla/5.0",
+		"Cookie":     "session=1",
+		"X-Token":    "abc",
+	}
+	for name, value := range want {
+		if got := req.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if client != GetOutboundClient() {
+		t.Error("header-only profile should use the shared client")
+	}
+
+	req, client, err = s.NewRequest("https://other.org/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Header) != 0 || client != GetOutboundClient() {
+		t.Errorf("unmatched host: headers = %v", req.Header)
+	}
+	if _, _, err := s.NewRequest("http://bad host/"); err == nil {
+		t.Error("invalid URL accepted")
+	}
+}
+
+func TestHostProfileAppliedToProxy(t *testing.T) {
+	png := encodeTestImage(t, "png", photoImage(8, 8, nil))
+	proxy, base := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		// 模拟防盗链：Referer 不对时拒绝
+		if r.Header.Get("Referer") != "https://site.example/" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write(png)
+	}, 1<<20)
+
+	if _, _, err := proxy.FetchOriginal(base + "/a.png"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("without profile: err = %v, want 403", err)
+	}
+
+	setupTestDB(t)
+	database.DB.Create(&model.HostProfile{Pattern: "127.0.0.1", Referer: "https://site.example/", Enabled: true})
+	if err := proxy.profiles.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := proxy.FetchOriginal(base + "/a.png")
+	if err != nil {
+		t.Fatalf("with profile: %v", err)
+	}
+	if len(data) != len(png) {
+		t.Errorf("got %d bytes, want %d", len(data), len(png))
+	}
+}
+
+func TestNormalizeHostProfile(t *testing.T) {
+	profile := model.HostProfile{
+		Pattern:  "  *.Example.COM ",
+		ProxyURL: " socks5://127.0.0.1:1080 ",
+		Headers:  map[string]string{" x-api-key ": "k", "accept": "image/*"},
+	}
+	if err := NormalizeHostProfile(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Pattern != "*.example.com" || profile.ProxyURL != "socks5://127.0.0.1:1080" {
+		t.Errorf("normalized to %q, %q", profile.Pattern, profile.ProxyURL)
+	}
+	if profile.Headers["X-Api-Key"] != "k" || profile.Headers["Accept"] != "image/*" || len(profile.Headers) != 2 {
+		t.Errorf("headers = %v", profile.Headers)
+	}
+
+	invalid := []model.HostProfile{
+		{Pattern: ""},
+		{Pattern: "*"},
+		{Pattern: "*example.com"},
+		{Pattern: "a.*.example.com"},
+		{Pattern: "example.com:8080"},
+		{Pattern: "https://example.com"},
+		{Pattern: "user@example.com"},
+		{Pattern: ".example.com"},
+		{Pattern: "example.com."},
+		{Pattern: "example.com", Timeout: -1},
+		{Pattern: "example.com", Timeout: maxHostProfileTimeout + 1},
+		{Pattern: "example.com", ProxyURL: "ftp://proxy:21"},
+		{Pattern: "example.com", ProxyURL: "proxy:3128"},
+		{Pattern: "example.com", Referer: "https://a/\r\nX-Evil: 1"},
+		{Pattern: "example.com", Cookie: "a=1\nb=2"},
+		{Pattern: "example.com", Headers: map[string]string{"Bad Name": "v"}},
+		{Pattern: "example.com", Headers: map[string]string{"X-Ok": "v\r\n"}},
+	}
+	for _, profile := range invalid {
+		if err := NormalizeHostProfile(&profile); err == nil {
+			t.Errorf("accepted %+v", profile)
+		}
+	}
+}
@@ -1,21 +1,38 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
 	"randimg/internal/database"
 	"randimg/internal/model"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // ImageFetchService 图片信息异步获取服务
+// 下载和解码原图占用大量内存，同时进行的数量由 analyzeSlots 单独限制，与worker数量无关
 type ImageFetchService struct {
-	taskQueue   chan uint
-	workers     int
-	stopChan    chan struct{}
-	wg          sync.WaitGroup
-	infoService *ImageInfoService
+	taskQueue    chan uint
+	workers      int
+	analyzeSlots chan struct{}
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
+	infoService  *ImageInfoService
+	proxy        *ImageProxyService
+	variants     *VariantService
 }
 
+const (
+	// defaultAnalyzeConcurrency 同时下载和解码原图的默认数量，可通过 ANALYZE_CONCURRENCY 调整
+	defaultAnalyzeConcurrency = 2
+	// scanPageSize 启动时分页扫描待处理图片的每页数量
+	scanPageSize = 500
+)
+
 var (
 	fetchServiceInstance *ImageFetchService
 	fetchServiceOnce     sync.Once
@@ -32,11 +49,18 @@ func GetImageFetchService() *ImageFetchService {
 
 // NewImageFetchService 创建图片fetch服务
 func NewImageFetchService(workers int) *ImageFetchService {
+	concurrency := defaultAnalyzeConcurrency
+	if value, err := strconv.Atoi(os.Getenv("ANALYZE_CONCURRENCY")); err == nil && value > 0 {
+		concurrency = value
+	}
 	return &ImageFetchService{
-		taskQueue:   make(chan uint, 10000), // 队列容量10000
-		workers:     workers,
-		stopChan:    make(chan struct{}),
-		infoService: NewImageInfoService(),
+		taskQueue:    make(chan uint, 10000), // 队列容量10000
+		workers:      workers,
+		analyzeSlots: make(chan struct{}, concurrency),
+		stopChan:     make(chan struct{}),
+		infoService:  NewImageInfoService(),
+		proxy:        GetImageProxyService(),
+		variants:     GetVariantService(),
 	}
 }
 
@@ -52,23 +76,40 @@ func (s *ImageFetchService) Start() {
 	go s.scanPendingTasks()
 }
 
-// scanPendingTasks 扫描数据库中缺失信息的图片
+// scanPendingTasks 按ID分页扫描数据库中缺失信息或缩略图的图片
+// 已分析过（analyzed_at 不为空）但因图片过大或无法解码而缺少颜色等信息的图片不再加入队列
+// 队列已满时等待，不丢弃任务
 func (s *ImageFetchService) scanPendingTasks() {
 	log.Println("Scanning for pending fetch tasks...")
 
-	var images []model.Image
-	// 查找缺失信息的图片
-	if err := database.DB.Where("(width IS NULL OR height IS NULL OR format = '' OR format IS NULL) AND status = ?", "active").
-		Limit(1000).Find(&images).Error; err != nil {
-		log.Printf("Failed to scan pending tasks: %v", err)
-		return
-	}
+	var lastID uint
+	total := 0
+	for {
+		var ids []uint
+		if err := database.DB.Model(&model.Image{}).
+			Where("status = ? AND id > ?", "active", lastID).
+			Where("((width IS NULL OR height IS NULL OR format = '' OR format IS NULL OR brightness IS NULL OR perceptual_hash IS NULL OR perceptual_hash = '' OR fingerprint IS NULL OR fingerprint = '') AND analyzed_at IS NULL) OR variant_version IS NULL OR variant_version = ''").
+			Order("id").Limit(scanPageSize).Pluck("id", &ids).Error; err != nil {
+			log.Printf("Failed to scan pending tasks: %v", err)
+			return
+		}
 
-	if len(images) > 0 {
-		log.Printf("Found %d images with missing info, adding to fetch queue", len(images))
-		for _, image := range images {
-			s.AddTask(image.ID)
+		for _, id := range ids {
+			select {
+			case s.taskQueue <- id:
+			case <-s.stopChan:
+				return
+			}
+		}
+		total += len(ids)
+		if len(ids) < scanPageSize {
+			break
 		}
+		lastID = ids[len(ids)-1]
+	}
+
+	if total > 0 {
+		log.Printf("Found %d images with missing info, added to fetch queue", total)
 	} else {
 		log.Println("No pending fetch tasks found")
 	}
@@ -114,29 +155,100 @@ func (s *ImageFetchService) processTask(imageID uint) {
 		return
 	}
 
-	// 如果已有完整信息，跳过
-	if image.Width != nil && image.Height != nil && image.Format != "" {
+	// 如果已有完整信息（包括颜色、感知哈希和视觉指纹）或已分析过，并且已有缩略图，跳过
+	needInfo := image.AnalyzedAt == nil && (image.Width == nil || image.Height == nil || image.Format == "" ||
+		image.Brightness == nil || image.PerceptualHash == "" || image.Fingerprint == "")
+	needVariants := image.VariantVersion == ""
+	if !needInfo && !needVariants {
 		return
 	}
 
-	// 获取图片信息
-	info, err := s.infoService.GetImageInfo(image.SourceURL)
-	if err != nil {
-		log.Printf("Worker: failed to fetch info for image %d: %v", imageID, err)
-		return
+	s.analyzeSlots <- struct{}{}
+	defer func() { <-s.analyzeSlots }()
+
+	updates := make(map[string]interface{})
+	var info *ImageInfo
+	analyzed := false
+	if needVariants {
+		// 下载一次原图，同时用于生成缩略图和分析图片信息
+		data, contentType, err := s.proxy.FetchOriginal(ImageOriginURL(&image))
+		switch {
+		case err == nil:
+			if needInfo {
+				if info, _ = AnalyzeImageData(data, contentType); info == nil {
+					info = &ImageInfo{}
+				}
+				analyzed = true
+			}
+			version, widths, err := s.variants.Generate(context.Background(), image.ID, data)
+			if err != nil {
+				log.Printf("Worker: failed to generate variants for image %d: %v", imageID, err)
+				break
+			}
+			updates["variant_version"] = version
+			updates["variant_widths"] = joinInts(widths)
+		case errors.Is(err, ErrNotImage), errors.Is(err, ErrImageTooLarge):
+			// 无法生成缩略图，不再重试；图片信息只读取文件头获取
+			updates["variant_version"] = variantNone
+			if needInfo {
+				info, analyzed = s.headerInfo(&image)
+			}
+		default:
+			log.Printf("Worker: failed to fetch image %d: %v", imageID, err)
+			return
+		}
+	}
+
+	// 获取图片信息并分析颜色
+	if needInfo && info == nil {
+		var err error
+		info, err = s.infoService.AnalyzeImage(ImageOriginURL(&image))
+		switch {
+		case err == nil:
+			analyzed = true
+		case errors.Is(err, ErrNotImage), errors.Is(err, ErrImageTooLarge):
+			info, analyzed = s.headerInfo(&image)
+		default:
+			log.Printf("Worker: failed to fetch info for image %d: %v", imageID, err)
+			info = &ImageInfo{}
+		}
+	}
+	if info == nil {
+		info = &ImageInfo{}
+	}
+	if analyzed {
+		updates["analyzed_at"] = time.Now()
 	}
 
 	// 更新数据库
-	updates := make(map[string]interface{})
+	width, height := image.Width, image.Height
 	if image.Width == nil && info.Width > 0 {
 		updates["width"] = info.Width
+		width = &info.Width
 	}
 	if image.Height == nil && info.Height > 0 {
 		updates["height"] = info.Height
+		height = &info.Height
 	}
 	if image.Format == "" && info.Format != "" {
 		updates["format"] = info.Format
 	}
+	if width != image.Width || height != image.Height {
+		// 同步更新宽高比和方向
+		updates["aspect_ratio"], updates["orientation"] = model.ImageShape(width, height)
+	}
+	if info.Colors != nil {
+		updates["dominant_color"] = info.Colors.DominantColor
+		updates["palette"] = strings.Join(info.Colors.Palette, ",")
+		updates["brightness"] = info.Colors.Brightness
+		updates["color_bucket"] = info.Colors.ColorBucket
+	}
+	if info.PerceptualHash != "" {
+		updates["perceptual_hash"] = info.PerceptualHash
+	}
+	if info.Fingerprint != "" {
+		updates["fingerprint"] = info.Fingerprint
+	}
 
 	if len(updates) > 0 {
 		if err := database.DB.Model(&image).Updates(updates).Error; err != nil {
@@ -144,7 +256,30 @@ func (s *ImageFetchService) processTask(imageID uint) {
 			return
 		}
 		log.Printf("Worker: updated image %d with %v", imageID, updates)
+
+		// 宽高和颜色变化会影响随机索引中的筛选条件
+		GetRandomIndexService().Refresh(imageID)
+	}
+}
+
+// headerInfo 原图过大或无法完整分析时，只读取文件头获取尺寸和格式
+// 此时颜色等信息无法获取，返回的 analyzed 为true，之后不再重复下载分析；读取失败（如网络错误）时为false
+func (s *ImageFetchService) headerInfo(image *model.Image) (*ImageInfo, bool) {
+	info, err := s.infoService.GetImageInfo(ImageOriginURL(image))
+	if err != nil {
+		log.Printf("Worker: failed to fetch info for image %d: %v", image.ID, err)
+		return &ImageInfo{}, false
+	}
+	return info, true
+}
+
+// joinInts 将整数列表用逗号连接
+func joinInts(values []int) string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = strconv.Itoa(value)
 	}
+	return strings.Join(items, ",")
 }
 
 // GetQueueSize 获取队列大小
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"testing"
+	"time"
+)
+
+func TestScanPendingTasks(t *testing.T) {
+	setupTestDB(t)
+	width, height, brightness := 100, 100, 0.5
+	now := time.Now()
+	complete := func(url string) model.Image {
+		return model.Image{
+			SourceURL: url, Width: &width, Height: &height, Format: "jpeg", Brightness: &brightness,
+			PerceptualHash: "0123456789abcdef", Fingerprint: "x", VariantVersion: "v1", Status: "active", CategoryID: 1,
+		}
+	}
+
+	images := []model.Image{
+		complete("https://img.example.com/done.jpg"),
+		{SourceURL: "https://img.example.com/new.jpg", Status: "active", CategoryID: 1},
+		// 分析过但图片过大，缺少颜色信息，不再重复下载
+		{SourceURL: "https://img.example.com/huge.jpg", Width: &width, Height: &height, Format: "jpeg",
+			VariantVersion: variantNone, AnalyzedAt: &now, Status: "active", CategoryID: 1},
+		{SourceURL: "https://img.example.com/broken.jpg", Status: "broken", CategoryID: 1},
+	}
+	noVariants := complete("https://img.example.com/novariants.jpg")
+	noVariants.VariantVersion = ""
+	images = append(images, noVariants)
+	// 超过一页的待处理图片
+	for i := 0; i < scanPageSize+10; i++ {
+		images = append(images, model.Image{SourceURL: fmt.Sprintf("https://img.example.com/bulk/%d.jpg", i), Status: "active", CategoryID: 1})
+	}
+	if err := database.DB.CreateInBatches(&images, 200).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ImageFetchService{taskQueue: make(chan uint, len(images)), stopChan: make(chan struct{})}
+	s.scanPendingTasks()
+	close(s.taskQueue)
+
+	queued := make(map[uint]bool)
+	for id := range s.taskQueue {
+		if queued[id] {
+			t.Fatalf("image %d queued twice", id)
+		}
+		queued[id] = true
+	}
+	if len(queued) != scanPageSize+12 {
+		t.Errorf("queued %d images, want %d", len(queued), scanPageSize+12)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if queued[images[i].ID] {
+			t.Errorf("image %s should not be queued", images[i].SourceURL)
+		}
+	}
+	if !queued[images[1].ID] || !queued[images[4].ID] {
+		t.Error("pending images were not queued")
+	}
+}
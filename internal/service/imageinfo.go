@@ -1,26 +1,41 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"net/http"
 	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxAnalyzeBytes 分析颜色时下载图片的大小上限
+	maxAnalyzeBytes = 30 << 20
+	// maxAnalyzePixels 分析颜色时允许完整解码的像素数上限（解码后约占 4 字节/像素），超过时只返回尺寸和格式
+	maxAnalyzePixels = 16_000_000
 )
 
 // ImageInfoService 图片信息服务
+// 下载图片受 UpstreamService 的并发限制，按 HostProfileService 的主机配置设置请求头；storage:// 地址从存储读取
 type ImageInfoService struct {
-	client *http.Client
+	profiles *HostProfileService
+	upstream *UpstreamService
+	storage  *StorageService
 }
 
 // NewImageInfoService 创建图片信息服务
 func NewImageInfoService() *ImageInfoService {
 	return &ImageInfoService{
-		client: &http.Client{
-			Timeout: 30 * 1e9, // 30秒超时
-		},
+		profiles: GetHostProfileService(),
+		upstream: GetUpstreamService(),
+		storage:  GetStorageService(),
 	}
 }
 
@@ -29,12 +44,39 @@ type ImageInfo struct {
 	Width  int
 	Height int
 	Format string
+	Colors *ColorInfo // 仅 AnalyzeImage 提供，无法解码时为nil
+	// PerceptualHash 感知哈希，Fingerprint 视觉指纹，仅 AnalyzeImage 提供，无法解码时为空
+	PerceptualHash string
+	Fingerprint    string
 }
 
 // GetImageInfo 获取图片信息
 func (s *ImageInfoService) GetImageInfo(url string) (*ImageInfo, error) {
+	if key, ok := StorageKeyFromURL(url); ok {
+		body, _, err := s.storage.Open(context.Background(), key, -1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		defer body.Close()
+		config, format, err := image.DecodeConfig(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image config: %w", err)
+		}
+		return &ImageInfo{Width: config.Width, Height: config.Height, Format: format}, nil
+	}
+
 	// 获取图片
-	resp, err := s.client.Get(url)
+	release, err := s.upstream.Acquire(url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, client, err := s.profiles.NewRequest(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image: %w", err)
 	}
@@ -48,16 +90,7 @@ func (s *ImageInfoService) GetImageInfo(url string) (*ImageInfo, error) {
 	img, format, err := image.DecodeConfig(resp.Body)
 	if err != nil {
 		// 如果解码失败，尝试从Content-Type获取格式
-		contentType := resp.Header.Get("Content-Type")
-		if strings.Contains(contentType, "jpeg") || strings.Contains(contentType, "jpg") {
-			format = "jpeg"
-		} else if strings.Contains(contentType, "png") {
-			format = "png"
-		} else if strings.Contains(contentType, "gif") {
-			format = "gif"
-		} else if strings.Contains(contentType, "webp") {
-			format = "webp"
-		}
+		format = formatFromContentType(resp.Header.Get("Content-Type"))
 
 		// 如果无法获取格式，返回错误
 		if format == "" {
@@ -78,3 +111,95 @@ func (s *ImageInfoService) GetImageInfo(url string) (*ImageInfo, error) {
 		Format: format,
 	}, nil
 }
+
+// AnalyzeImage 下载完整图片，获取尺寸、格式以及主色调、亮度、感知哈希和视觉指纹
+// 像素数过大或格式无法解码时只返回尺寸和格式；文件超过 maxAnalyzeBytes 时返回 ErrImageTooLarge，
+// 无法识别为图片时返回 ErrNotImage
+func (s *ImageInfoService) AnalyzeImage(url string) (*ImageInfo, error) {
+	if key, ok := StorageKeyFromURL(url); ok {
+		body, size, err := s.storage.Open(context.Background(), key, -1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		defer body.Close()
+		data, contentType, err := readImageData(body, size, maxAnalyzeBytes)
+		if err != nil {
+			return nil, err
+		}
+		return AnalyzeImageData(data, contentType)
+	}
+
+	release, err := s.upstream.Acquire(url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, client, err := s.profiles.NewRequest(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAnalyzeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) > maxAnalyzeBytes {
+		return nil, fmt.Errorf("%w to analyze", ErrImageTooLarge)
+	}
+
+	return AnalyzeImageData(data, resp.Header.Get("Content-Type"))
+}
+
+// AnalyzeImageData 分析已读取的图片数据（如管理员上传的图片），与 AnalyzeImage 使用相同的解码和计算方式
+func AnalyzeImageData(data []byte, contentType string) (*ImageInfo, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// 与 GetImageInfo 一致：无法解码时从Content-Type获取格式，只返回部分信息
+		if format = formatFromContentType(contentType); format == "" {
+			return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+		}
+		return &ImageInfo{Format: format}, nil
+	}
+
+	info := &ImageInfo{
+		Width:  config.Width,
+		Height: config.Height,
+		Format: format,
+	}
+
+	if config.Width*config.Height <= maxAnalyzePixels {
+		if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+			info.Colors = ExtractColors(img)
+			info.PerceptualHash = FormatHash(DHash(img))
+			info.Fingerprint = EncodeFingerprint(VisualFingerprint(img))
+		}
+	}
+	return info, nil
+}
+
+// formatFromContentType 从Content-Type推断图片格式，无法识别时返回空字符串
+func formatFromContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "jpeg") || strings.Contains(contentType, "jpg"):
+		return "jpeg"
+	case strings.Contains(contentType, "png"):
+		return "png"
+	case strings.Contains(contentType, "gif"):
+		return "gif"
+	case strings.Contains(contentType, "webp"):
+		return "webp"
+	case strings.Contains(contentType, "avif"):
+		return "avif"
+	}
+	return ""
+}
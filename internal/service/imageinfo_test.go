@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestAnalyzeImageData(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, solidImage(40, 20, color.RGBA{G: 200, A: 255}, 0, nil))
+
+	info, err := AnalyzeImageData(buf.Bytes(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 40 || info.Height != 20 || info.Format != "png" {
+		t.Errorf("info = %+v", info)
+	}
+	if info.Colors == nil || info.Colors.ColorBucket != "green" || info.PerceptualHash == "" || info.Fingerprint == "" {
+		t.Errorf("missing analysis: %+v", info)
+	}
+}
+
+func TestAnalyzeImageDataSkipsHugeImages(t *testing.T) {
+	// 只有文件头的PNG：尺寸超过上限时不应尝试完整解码
+	data := pngHeader(5000, 5000)
+	info, err := AnalyzeImageData(data, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 5000 || info.Colors != nil || info.PerceptualHash != "" {
+		t.Errorf("info = %+v, want size only", info)
+	}
+}
+
+func TestAnalyzeImageDataNotImage(t *testing.T) {
+	if _, err := AnalyzeImageData([]byte("<html></html>"), "text/html"); !errors.Is(err, ErrNotImage) {
+		t.Fatalf("err = %v, want ErrNotImage", err)
+	}
+	// 无法解码但Content-Type可识别时只返回格式
+	info, err := AnalyzeImageData([]byte("not really"), "image/avif")
+	if err != nil || info.Format != "avif" {
+		t.Fatalf("info = %+v, err = %v", info, err)
+	}
+}
+
+// pngHeader 构造只包含签名和IHDR块的PNG
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8位RGBA
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
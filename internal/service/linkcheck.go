@@ -0,0 +1,468 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"os"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultLinkCheckInterval 扫描到期图片的默认间隔
+	defaultLinkCheckInterval = time.Minute
+	// defaultLinkCheckPeriod 正常图片的默认检查周期，也是失败重试间隔的上限
+	defaultLinkCheckPeriod = 24 * time.Hour
+	// defaultLinkCheckRetry 第一次失败后的默认重试间隔，之后每次失败翻倍
+	defaultLinkCheckRetry = 15 * time.Minute
+	// defaultLinkCheckThreshold 默认连续失败多少次后标记为broken
+	defaultLinkCheckThreshold = 3
+	// defaultLinkCheckBatch 每次扫描默认最多检查的图片数
+	defaultLinkCheckBatch = 20
+	// linkCheckWorkers 每次扫描并发检查的数量（同时受上游并发限制）
+	linkCheckWorkers = 4
+	// linkCheckTimeout 后台检查单张图片的超时时间
+	linkCheckTimeout = 15 * time.Second
+	// linkProbeTimeout 请求时检查单张图片的超时时间
+	linkProbeTimeout = 5 * time.Second
+	// 请求时检查结果的缓存时间：成功的结果缓存较久，失败的结果只短暂缓存
+	linkProbeOKTTL   = 10 * time.Minute
+	linkProbeFailTTL = time.Minute
+	// maxLinkProbeCacheSize 检查结果缓存上限，超过后整体清空
+	maxLinkProbeCacheSize = 10000
+	// maxCheckErrorLen 保存的检查错误信息最大长度
+	maxCheckErrorLen = 255
+)
+
+// LinkCheckService 原图链接检查服务
+// 后台定期用HEAD（不支持时改用带Range的GET）检查active和broken状态的图片，记录连续失败次数，
+// 失败后按指数退避重试，连续失败达到阈值的图片标记为broken（不再参与随机），恢复后自动变回active
+type LinkCheckService struct {
+	interval  time.Duration
+	period    time.Duration
+	retry     time.Duration
+	threshold int
+	batch     int
+
+	upstream    *UpstreamService
+	profiles    *HostProfileService
+	storage     *StorageService
+	randomIndex *RandomIndexService
+
+	// 请求时检查（Probe）的结果缓存
+	probeMu    sync.Mutex
+	probeCache map[uint]linkProbeResult
+
+	running   atomic.Bool
+	lastRunAt atomic.Int64 // Unix秒，0表示尚未运行
+	stopCh    chan struct{}
+
+	checked       atomic.Int64
+	failed        atomic.Int64
+	markedBroken  atomic.Int64
+	recovered     atomic.Int64
+	probes        atomic.Int64
+	probeFailures atomic.Int64
+}
+
+// linkProbeResult 请求时检查的结果
+type linkProbeResult struct {
+	err error
+	at  time.Time
+}
+
+// LinkCheckStats 链接检查统计
+type LinkCheckStats struct {
+	Enabled       bool       `json:"enabled"`
+	Interval      float64    `json:"interval"` // 秒
+	Period        float64    `json:"period"`   // 秒
+	Retry         float64    `json:"retry"`    // 秒
+	Threshold     int        `json:"threshold"`
+	Batch         int        `json:"batch"`
+	Running       bool       `json:"running"`
+	LastRunAt     *time.Time `json:"last_run_at"`
+	Checked       int64      `json:"checked"`
+	Failed        int64      `json:"failed"`
+	MarkedBroken  int64      `json:"marked_broken"`
+	Recovered     int64      `json:"recovered"`
+	Probes        int64      `json:"probes"`
+	ProbeFailures int64      `json:"probe_failures"`
+	Broken        int64      `json:"broken"`  // 当前为broken状态的图片数
+	Failing       int64      `json:"failing"` // 仍为active但最近检查失败的图片数
+	Due           int64      `json:"due"`     // 已到检查时间的图片数
+}
+
+var (
+	linkCheckInstance *LinkCheckService
+	linkCheckOnce     sync.Once
+)
+
+// GetLinkCheckService 获取链接检查服务单例
+// 由环境变量 LINK_CHECK_INTERVAL（扫描间隔，0表示关闭后台检查）、LINK_CHECK_PERIOD（正常图片的检查周期）、
+// LINK_CHECK_RETRY（第一次失败后的重试间隔）、LINK_CHECK_THRESHOLD 和 LINK_CHECK_BATCH 配置
+func GetLinkCheckService() *LinkCheckService {
+	linkCheckOnce.Do(func() {
+		linkCheckInstance = &LinkCheckService{
+			interval:    envDuration("LINK_CHECK_INTERVAL", defaultLinkCheckInterval),
+			period:      envDuration("LINK_CHECK_PERIOD", defaultLinkCheckPeriod),
+			retry:       envDuration("LINK_CHECK_RETRY", defaultLinkCheckRetry),
+			threshold:   envInt("LINK_CHECK_THRESHOLD", defaultLinkCheckThreshold),
+			batch:       envInt("LINK_CHECK_BATCH", defaultLinkCheckBatch),
+			upstream:    GetUpstreamService(),
+			profiles:    GetHostProfileService(),
+			storage:     GetStorageService(),
+			randomIndex: GetRandomIndexService(),
+			probeCache:  make(map[uint]linkProbeResult),
+			stopCh:      make(chan struct{}),
+		}
+		if linkCheckInstance.period <= 0 {
+			linkCheckInstance.period = defaultLinkCheckPeriod
+		}
+		if linkCheckInstance.retry <= 0 {
+			linkCheckInstance.retry = defaultLinkCheckRetry
+		}
+		if linkCheckInstance.threshold < 1 {
+			linkCheckInstance.threshold = 1
+		}
+		if linkCheckInstance.batch < 1 {
+			linkCheckInstance.batch = defaultLinkCheckBatch
+		}
+		linkCheckInstance.Start()
+	})
+	return linkCheckInstance
+}
+
+// envDuration 读取时长环境变量（如 30s、10m），未设置或无效时返回默认值
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// Start 启动后台定期扫描，扫描间隔为0时不启动
+func (s *LinkCheckService) Start() {
+	if s.interval <= 0 {
+		log.Println("LinkCheck: background checking disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RunOnce()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+	log.Printf("LinkCheck: started (interval %s, period %s, threshold %d)", s.interval, s.period, s.threshold)
+}
+
+// Stop 停止后台扫描
+func (s *LinkCheckService) Stop() {
+	close(s.stopCh)
+}
+
+// RunOnce 检查一批已到检查时间的图片（从未检查过的图片优先），已在扫描时直接返回
+func (s *LinkCheckService) RunOnce() {
+	if !s.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.running.Store(false)
+	s.lastRunAt.Store(time.Now().Unix())
+
+	var images []model.Image
+	if err := database.DB.Select("id", "source_url", "storage_key", "status", "check_failures", "last_checked_at").
+		Where("status IN ?", []string{"active", "broken"}).
+		Where("next_check_at IS NULL OR next_check_at <= ?", time.Now()).
+		Order("next_check_at").Limit(s.batch).Find(&images).Error; err != nil {
+		log.Printf("LinkCheck: failed to load images: %v", err)
+		return
+	}
+
+	tasks := make(chan *model.Image)
+	var wg sync.WaitGroup
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range tasks {
+				s.CheckImage(image)
+			}
+		}()
+	}
+	for i := range images {
+		tasks <- &images[i]
+	}
+	close(tasks)
+	wg.Wait()
+}
+
+// CheckImage 立即检查一张图片并记录结果，返回检查错误（nil表示链接正常）
+func (s *LinkCheckService) CheckImage(image *model.Image) error {
+	ctx, cancel := context.WithTimeout(context.Background(), linkCheckTimeout)
+	defer cancel()
+
+	err := s.check(ctx, ImageOriginURL(image))
+	s.record(image, err)
+	s.cacheProbe(image.ID, err)
+	return err
+}
+
+// Probe 请求时检查图片是否可访问，用于随机接口的自动替换
+// 结果会短暂缓存；本地上游并发已满时视为可访问；失败距上次检查超过重试间隔时计入连续失败次数
+func (s *LinkCheckService) Probe(image *model.Image) error {
+	if image.Status == "broken" {
+		return fmt.Errorf("image %d is marked as broken", image.ID)
+	}
+
+	s.probeMu.Lock()
+	cached, ok := s.probeCache[image.ID]
+	s.probeMu.Unlock()
+	if ok {
+		ttl := linkProbeOKTTL
+		if cached.err != nil {
+			ttl = linkProbeFailTTL
+		}
+		if time.Since(cached.at) < ttl {
+			return cached.err
+		}
+	}
+
+	s.probes.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), linkProbeTimeout)
+	defer cancel()
+	err := s.check(ctx, ImageOriginURL(image))
+	if errors.Is(err, ErrUpstreamBusy) {
+		return nil
+	}
+
+	s.cacheProbe(image.ID, err)
+	if err != nil {
+		s.probeFailures.Add(1)
+		if image.LastCheckedAt == nil || time.Since(*image.LastCheckedAt) >= s.retry {
+			s.record(image, err)
+		}
+	} else if image.CheckFailures > 0 {
+		s.record(image, nil)
+	}
+	return err
+}
+
+// ReportFailure 记录代理等途径发现的原图访问失败：清除缓存的检查结果并安排尽快检查
+func (s *LinkCheckService) ReportFailure(imageID uint) {
+	s.probeMu.Lock()
+	delete(s.probeCache, imageID)
+	s.probeMu.Unlock()
+
+	now := time.Now()
+	if err := database.DB.Model(&model.Image{}).
+		Where("id = ? AND (next_check_at IS NULL OR next_check_at > ?)", imageID, now).
+		Update("next_check_at", now).Error; err != nil {
+		log.Printf("LinkCheck: failed to schedule image %d: %v", imageID, err)
+	}
+}
+
+// cacheProbe 缓存检查结果，本地并发已满的结果不缓存
+func (s *LinkCheckService) cacheProbe(imageID uint, err error) {
+	if errors.Is(err, ErrUpstreamBusy) {
+		return
+	}
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+	if len(s.probeCache) >= maxLinkProbeCacheSize {
+		s.probeCache = make(map[uint]linkProbeResult)
+	}
+	s.probeCache[imageID] = linkProbeResult{err: err, at: time.Now()}
+}
+
+// check 检查原图是否可访问
+// 先发送HEAD，返回2xx且Content-Type为图片（或未提供）时视为正常；
+// 否则（部分图床不支持HEAD或对HEAD返回错误）改用带Range的GET，读取文件头确认是图片；存储中的原图只检查对象是否存在
+func (s *LinkCheckService) check(ctx context.Context, sourceURL string) error {
+	if key, ok := StorageKeyFromURL(sourceURL); ok {
+		_, err := s.storage.Stat(ctx, key)
+		return err
+	}
+
+	release, err := s.upstream.Acquire(sourceURL)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	req, client, err := s.profiles.NewRequest(sourceURL)
+	if err != nil {
+		return err
+	}
+	req.Method = http.MethodHead
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && isImageContentType(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+
+	req, client, err = s.profiles.NewRequest(sourceURL)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffLen-1))
+	resp, err = client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, sniffLen))
+	if err != nil && len(head) == 0 {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if sniffImageFormat(head) == "" {
+		return ErrNotImage
+	}
+	return nil
+}
+
+// isImageContentType Content-Type为空、image/* 或 application/octet-stream
+func isImageContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/octet-stream" || strings.HasPrefix(mediaType, "image/")
+}
+
+// record 保存检查结果
+// 成功时清零失败次数，broken图片恢复为active；失败时累加失败次数并按指数退避安排下次检查，
+// active图片连续失败达到阈值后标记为broken；本地并发已满时不计入，稍后重试
+func (s *LinkCheckService) record(image *model.Image, checkErr error) {
+	now := time.Now()
+	query := database.DB.Model(&model.Image{}).Where("id = ?", image.ID)
+
+	if errors.Is(checkErr, ErrUpstreamBusy) {
+		if err := query.Update("next_check_at", now.Add(s.retry)).Error; err != nil {
+			log.Printf("LinkCheck: failed to reschedule image %d: %v", image.ID, err)
+		}
+		return
+	}
+	s.checked.Add(1)
+
+	if checkErr == nil {
+		updates := map[string]interface{}{
+			"check_failures":   0,
+			"last_checked_at":  now,
+			"last_check_error": "",
+			"next_check_at":    now.Add(jitter(s.period)),
+		}
+		if err := query.Updates(updates).Error; err != nil {
+			log.Printf("LinkCheck: failed to save result for image %d: %v", image.ID, err)
+			return
+		}
+		// 只恢复由链接检查标记的图片，检查期间管理员修改的状态不受影响
+		result := database.DB.Model(&model.Image{}).Where("id = ? AND status = ?", image.ID, "broken").Update("status", "active")
+		if result.Error == nil && result.RowsAffected > 0 {
+			s.recovered.Add(1)
+			s.randomIndex.Refresh(image.ID)
+			log.Printf("LinkCheck: image %d is reachable again, restored to active", image.ID)
+		}
+		return
+	}
+
+	s.failed.Add(1)
+	message := checkErr.Error()
+	if len(message) > maxCheckErrorLen {
+		message = message[:maxCheckErrorLen]
+	}
+	failures := image.CheckFailures + 1
+	updates := map[string]interface{}{
+		"check_failures":   gorm.Expr("check_failures + 1"),
+		"last_checked_at":  now,
+		"last_check_error": message,
+		"next_check_at":    now.Add(s.backoff(failures)),
+	}
+	if err := query.Updates(updates).Error; err != nil {
+		log.Printf("LinkCheck: failed to save result for image %d: %v", image.ID, err)
+		return
+	}
+	result := database.DB.Model(&model.Image{}).
+		Where("id = ? AND status = ? AND check_failures >= ?", image.ID, "active", s.threshold).
+		Update("status", "broken")
+	if result.Error == nil && result.RowsAffected > 0 {
+		s.markedBroken.Add(1)
+		s.randomIndex.Refresh(image.ID)
+		log.Printf("LinkCheck: image %d marked as broken after %d failures: %s", image.ID, failures, message)
+	}
+}
+
+// backoff 第failures次连续失败后的重试间隔：从重试间隔开始翻倍，不超过检查周期
+func (s *LinkCheckService) backoff(failures int) time.Duration {
+	d := s.retry
+	for i := 1; i < failures && d < s.period; i++ {
+		d *= 2
+	}
+	if d > s.period {
+		d = s.period
+	}
+	return d
+}
+
+// jitter 在 ±10% 范围内随机调整间隔，避免同时添加的图片总在同一时间检查
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.9 + 0.2*rand.Float64()))
+}
+
+// Stats 获取链接检查统计
+func (s *LinkCheckService) Stats() LinkCheckStats {
+	stats := LinkCheckStats{
+		Enabled:       s.interval > 0,
+		Interval:      s.interval.Seconds(),
+		Period:        s.period.Seconds(),
+		Retry:         s.retry.Seconds(),
+		Threshold:     s.threshold,
+		Batch:         s.batch,
+		Running:       s.running.Load(),
+		Checked:       s.checked.Load(),
+		Failed:        s.failed.Load(),
+		MarkedBroken:  s.markedBroken.Load(),
+		Recovered:     s.recovered.Load(),
+		Probes:        s.probes.Load(),
+		ProbeFailures: s.probeFailures.Load(),
+	}
+	if unix := s.lastRunAt.Load(); unix > 0 {
+		lastRunAt := time.Unix(unix, 0)
+		stats.LastRunAt = &lastRunAt
+	}
+
+	database.DB.Model(&model.Image{}).Where("status = ?", "broken").Count(&stats.Broken)
+	database.DB.Model(&model.Image{}).Where("status = ? AND check_failures > 0", "active").Count(&stats.Failing)
+	database.DB.Model(&model.Image{}).Where("status IN ?", []string{"active", "broken"}).
+		Where("next_check_at IS NULL OR next_check_at <= ?", time.Now()).Count(&stats.Due)
+	return stats
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestLinkCheck 创建不启动后台扫描的链接检查服务，允许访问本机的测试服务器
+func newTestLinkCheck(t *testing.T, threshold int) *LinkCheckService {
+	t.Helper()
+	setupTestDB(t)
+	allowOutbound(t, "127.0.0.1")
+	return &LinkCheckService{
+		period:      time.Hour,
+		retry:       time.Minute,
+		threshold:   threshold,
+		batch:       defaultLinkCheckBatch,
+		upstream:    newTestUpstream(0, 4, 0, 10*time.Millisecond),
+		profiles:    &HostProfileService{exact: make(map[string]*hostProfileEntry)},
+		storage:     &StorageService{Storage: NewLocalStorage(t.TempDir()), backend: "local"},
+		randomIndex: NewRandomIndexService(),
+		probeCache:  make(map[uint]linkProbeResult),
+	}
+}
+
+// newLinkCheckServer 模拟各种图源，记录收到的请求（方法 路径 Range）
+func newLinkCheckServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	png := encodeTestImage(t, "png", photoImage(8, 8, nil))
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+r.Header.Get("Range")))
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/nohead.png":
+			// 不支持HEAD的图床
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/page.png":
+			// 图片已删除，返回200的提示页面
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<!DOCTYPE html><html>removed</html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestLinkCheckCheck(t *testing.T) {
+	s := newTestLinkCheck(t, 3)
+	server, requests := newLinkCheckServer(t)
+	s.storage.Put(context.Background(), "uploads/a.png", []byte("data"), "image/png")
+
+	tests := []struct {
+		url      string
+		wantErr  error // nil表示正常
+		anyError bool
+		requests string
+	}{
+		{server.URL + "/ok.png", nil, false, "HEAD /ok.png"},
+		{server.URL + "/nohead.png", nil, false, "HEAD /nohead.png,GET /nohead.png bytes=0-" + strconv.Itoa(sniffLen-1)},
+		{server.URL + "/page.png", ErrNotImage, true, "HEAD /page.png,GET /page.png bytes=0-" + strconv.Itoa(sniffLen-1)},
+		{server.URL + "/gone.png", nil, true, "HEAD /gone.png,GET /gone.png bytes=0-" + strconv.Itoa(sniffLen-1)},
+		{StorageURL("uploads/a.png"), nil, false, ""},
+		{StorageURL("uploads/missing.png"), ErrStorageNotFound, true, ""},
+	}
+	for _, tt := range tests {
+		*requests = nil
+		err := s.check(context.Background(), tt.url)
+		if (err != nil) != tt.anyError || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("check(%s) = %v", tt.url, err)
+		}
+		if got := strings.Join(*requests, ","); got != tt.requests {
+			t.Errorf("check(%s) sent %q, want %q", tt.url, got, tt.requests)
+		}
+	}
+	if err := s.check(context.Background(), server.URL+"/gone.png"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("404: err = %v", err)
+	}
+}
+
+func TestLinkCheckRecord(t *testing.T) {
+	s := newTestLinkCheck(t, 2)
+	image := model.Image{SourceURL: "https://img.example.com/a.jpg", Status: "active", Rating: model.RatingSafe}
+	database.DB.Create(&image)
+	reload := func() {
+		t.Helper()
+		image = model.Image{}
+		database.DB.First(&image)
+	}
+
+	// 失败未达到阈值时仍为active，按重试间隔安排下次检查
+	before := time.Now()
+	s.record(&image, errors.New("status 404"))
+	reload()
+	if image.Status != "active" || image.CheckFailures != 1 || image.LastCheckError != "status 404" {
+		t.Fatalf("after one failure: status %s, failures %d, error %q", image.Status, image.CheckFailures, image.LastCheckError)
+	}
+	if next := image.NextCheckAt.Sub(before); next < time.Minute || next > time.Minute+time.Second {
+		t.Errorf("next check in %s, want 1m", next)
+	}
+
+	// 本地并发已满不计入失败次数
+	s.record(&image, ErrUpstreamBusy)
+	reload()
+	if image.CheckFailures != 1 {
+		t.Errorf("busy counted as a failure: %d", image.CheckFailures)
+	}
+
+	// 达到阈值后标记为broken，错误信息截断保存
+	s.record(&image, errors.New(strings.Repeat("x", 300)))
+	reload()
+	if image.Status != "broken" || image.CheckFailures != 2 || len(image.LastCheckError) != maxCheckErrorLen {
+		t.Fatalf("after threshold: status %s, failures %d, error length %d", image.Status, image.CheckFailures, len(image.LastCheckError))
+	}
+
+	// 恢复后变回active，下次检查在检查周期左右
+	before = time.Now()
+	s.record(&image, nil)
+	reload()
+	if image.Status != "active" || image.CheckFailures != 0 || image.LastCheckError != "" {
+		t.Fatalf("after recovery: status %s, failures %d, error %q", image.Status, image.CheckFailures, image.LastCheckError)
+	}
+	if next := image.NextCheckAt.Sub(before); next < 54*time.Minute || next > 67*time.Minute {
+		t.Errorf("next check in %s, want about 1h", next)
+	}
+	if stats := s.Stats(); stats.MarkedBroken != 1 || stats.Recovered != 1 || stats.Checked != 3 || stats.Failed != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// 管理员禁用的图片检查成功也不会恢复
+	database.DB.Model(&image).Update("status", "inactive")
+	s.record(&image, nil)
+	reload()
+	if image.Status != "inactive" {
+		t.Errorf("inactive image changed to %s", image.Status)
+	}
+}
+
+func TestLinkCheckBackoff(t *testing.T) {
+	s := &LinkCheckService{retry: 15 * time.Minute, period: 2 * time.Hour}
+	want := []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 2 * time.Hour}
+	for i, d := range want {
+		if got := s.backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, d)
+		}
+	}
+}
+
+func TestLinkCheckProbe(t *testing.T) {
+	s := newTestLinkCheck(t, 3)
+	var hits atomic.Int32
+	status := atomic.Int32{}
+	status.Store(http.StatusOK)
+	png := encodeTestImage(t, "png", photoImage(8, 8, nil))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(int(status.Load()))
+		w.Write(png)
+	}))
+	t.Cleanup(server.Close)
+
+	image := model.Image{SourceURL: server.URL + "/a.png", Status: "active", Rating: model.RatingSafe}
+	database.DB.Create(&image)
+
+	// 成功的结果缓存，不重复请求
+	if err := s.Probe(&image); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Probe(&image); err != nil || hits.Load() != 1 {
+		t.Errorf("cached probe: err = %v, requests = %d", err, hits.Load())
+	}
+
+	// 代理发现失败后清除缓存并安排尽快检查
+	status.Store(http.StatusNotFound)
+	s.ReportFailure(image.ID)
+	if err := s.Probe(&image); err == nil {
+		t.Fatal("probe after failure report returned the cached result")
+	}
+	var stored model.Image
+	database.DB.First(&stored, image.ID)
+	if stored.CheckFailures != 1 || stored.NextCheckAt == nil {
+		t.Errorf("failed probe not recorded: failures %d", stored.CheckFailures)
+	}
+
+	// broken 的图片直接视为不可访问
+	hits.Store(0)
+	if err := s.Probe(&model.Image{ID: image.ID, SourceURL: image.SourceURL, Status: "broken"}); err == nil || hits.Load() != 0 {
+		t.Errorf("broken image: err = %v, requests = %d", err, hits.Load())
+	}
+
+	// 本地并发已满时视为可访问，结果不缓存
+	other := model.Image{SourceURL: server.URL + "/b.png", Status: "active", Rating: model.RatingSafe}
+	database.DB.Create(&other)
+	s.upstream = newTestUpstream(0, 1, 0, 10*time.Millisecond)
+	hold, err := s.upstream.Acquire(other.SourceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Probe(&other); err != nil || hits.Load() != 0 {
+		t.Errorf("busy upstream: err = %v, requests = %d", err, hits.Load())
+	}
+	hold()
+	if err := s.Probe(&other); err == nil || hits.Load() == 0 {
+		t.Errorf("probe after busy was cached: err = %v, requests = %d", err, hits.Load())
+	}
+}
+
+func TestLinkCheckRunOnce(t *testing.T) {
+	s := newTestLinkCheck(t, 1)
+	server, _ := newLinkCheckServer(t)
+	future := time.Now().Add(time.Hour)
+	images := []model.Image{
+		{SourceURL: server.URL + "/ok.png", Status: "active"},
+		{SourceURL: server.URL + "/gone.png", Status: "active"},
+		{SourceURL: server.URL + "/ok.png?2", Status: "broken"},
+		{SourceURL: server.URL + "/gone.png?3", Status: "inactive"},
+		{SourceURL: server.URL + "/gone.png?4", Status: "active", NextCheckAt: &future},
+	}
+	for i := range images {
+		images[i].Rating = model.RatingSafe
+		if err := database.DB.Create(&images[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.RunOnce()
+	want := []string{"active", "broken", "active", "inactive", "active"}
+	for i, image := range images {
+		var stored model.Image
+		database.DB.First(&stored, image.ID)
+		if stored.Status != want[i] {
+			t.Errorf("image %d (%s): status %s, want %s", i, image.SourceURL, stored.Status, want[i])
+		}
+		if checked := stored.LastCheckedAt != nil; checked != (i < 3) {
+			t.Errorf("image %d: checked = %v", i, checked)
+		}
+	}
+	if stats := s.Stats(); stats.Checked != 3 || stats.Broken != 1 || stats.Due != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
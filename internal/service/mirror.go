@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultMirrorInterval 扫描待镜像图片的默认间隔
+	defaultMirrorInterval = time.Minute
+	// defaultMirrorBatch 每次扫描默认最多镜像的图片数
+	defaultMirrorBatch = 10
+	// defaultMirrorDelay 两次下载之间的默认间隔，避免集中访问图源
+	defaultMirrorDelay = 2 * time.Second
+	// defaultMirrorRetry 镜像失败后的默认重试间隔
+	defaultMirrorRetry = 6 * time.Hour
+	// mirrorTimeout 保存单个副本的超时时间
+	mirrorTimeout = time.Minute
+)
+
+// ErrMirrorDisabled 图片和所属分类都没有开启镜像
+var ErrMirrorDisabled = errors.New("mirroring is not enabled for this image or its category")
+
+// MirrorService 原图镜像服务
+// 后台逐张将开启了镜像的图片（图片或所属分类的 mirror 为true）下载到自有存储，之后代理从存储读取原图，
+// source_url 保留为原始地址；关闭镜像的图片会删除副本，恢复从原地址读取
+type MirrorService struct {
+	interval time.Duration
+	batch    int
+	delay    time.Duration
+	retry    time.Duration
+
+	proxy   *ImageProxyService
+	storage *StorageService
+
+	running   atomic.Bool
+	lastRunAt atomic.Int64 // Unix秒，0表示尚未运行
+	stopCh    chan struct{}
+
+	mirrored atomic.Int64
+	failed   atomic.Int64
+	released atomic.Int64
+	bytes    atomic.Int64
+}
+
+// MirrorStats 镜像统计
+type MirrorStats struct {
+	Enabled        bool       `json:"enabled"`
+	Interval       float64    `json:"interval"` // 秒
+	Batch          int        `json:"batch"`
+	Delay          float64    `json:"delay"` // 秒
+	Retry          float64    `json:"retry"` // 秒
+	Running        bool       `json:"running"`
+	LastRunAt      *time.Time `json:"last_run_at"`
+	Mirrored       int64      `json:"mirrored"`
+	Failed         int64      `json:"failed"`
+	Released       int64      `json:"released"`
+	Bytes          int64      `json:"bytes"`           // 本次运行以来下载的字节数
+	MirroredImages int64      `json:"mirrored_images"` // 当前已镜像的图片数
+	StoredBytes    int64      `json:"stored_bytes"`    // 已镜像图片的原图总大小
+	Pending        int64      `json:"pending"`         // 开启了镜像但还没有副本的图片数
+	Failing        int64      `json:"failing"`         // 其中最近一次镜像失败的图片数
+}
+
+var (
+	mirrorInstance *MirrorService
+	mirrorOnce     sync.Once
+)
+
+// GetMirrorService 获取镜像服务单例
+// 由环境变量 MIRROR_INTERVAL（扫描间隔，0表示关闭后台镜像）、MIRROR_BATCH、MIRROR_DELAY（两次下载的间隔）
+// 和 MIRROR_RETRY（失败后的重试间隔）配置
+func GetMirrorService() *MirrorService {
+	mirrorOnce.Do(func() {
+		mirrorInstance = &MirrorService{
+			interval: envDuration("MIRROR_INTERVAL", defaultMirrorInterval),
+			batch:    envInt("MIRROR_BATCH", defaultMirrorBatch),
+			delay:    envDuration("MIRROR_DELAY", defaultMirrorDelay),
+			retry:    envDuration("MIRROR_RETRY", defaultMirrorRetry),
+			proxy:    GetImageProxyService(),
+			storage:  GetStorageService(),
+			stopCh:   make(chan struct{}),
+		}
+		if mirrorInstance.batch < 1 {
+			mirrorInstance.batch = defaultMirrorBatch
+		}
+		if mirrorInstance.retry <= 0 {
+			mirrorInstance.retry = defaultMirrorRetry
+		}
+		mirrorInstance.Start()
+	})
+	return mirrorInstance
+}
+
+// Start 启动后台定期扫描，扫描间隔为0时不启动
+func (s *MirrorService) Start() {
+	if s.interval <= 0 {
+		log.Println("Mirror: background mirroring disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RunOnce()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+	log.Printf("Mirror: started (interval %s, batch %d, delay %s)", s.interval, s.batch, s.delay)
+}
+
+// Stop 停止后台扫描
+func (s *MirrorService) Stop() {
+	close(s.stopCh)
+}
+
+// notMirrored 没有副本的图片（storage_key 列添加前导入的图片该列为NULL）
+const notMirrored = "(storage_key IS NULL OR storage_key = '')"
+
+// mirrorEnabled 图片或所属分类开启了镜像，且不是上传的图片
+func mirrorEnabled(db *gorm.DB) *gorm.DB {
+	return db.Where("source_url NOT LIKE ?", storageURLPrefix+"%").
+		Where("mirror = ? OR category_id IN (?)", true, database.DB.Model(&model.Category{}).Select("id").Where("mirror = ?", true))
+}
+
+// mirrorDisabled 图片和所属分类都没有开启镜像，且不是上传的图片
+func mirrorDisabled(db *gorm.DB) *gorm.DB {
+	return db.Where("source_url NOT LIKE ?", storageURLPrefix+"%").
+		Where("mirror = ? AND category_id NOT IN (?)", false, database.DB.Model(&model.Category{}).Select("id").Where("mirror = ?", true))
+}
+
+// RunOnce 删除一批已关闭镜像的副本，再逐张镜像一批待镜像的active图片（从未尝试过的优先），已在扫描时直接返回
+func (s *MirrorService) RunOnce() {
+	if !s.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.running.Store(false)
+	s.lastRunAt.Store(time.Now().Unix())
+
+	var released []model.Image
+	if err := database.DB.Select("id", "storage_key").Scopes(mirrorDisabled).
+		Where("storage_key <> ''").Limit(s.batch).Find(&released).Error; err != nil {
+		log.Printf("Mirror: failed to load images: %v", err)
+		return
+	}
+	for i := range released {
+		s.release(&released[i])
+	}
+
+	var images []model.Image
+	if err := database.DB.Select("id", "source_url", "storage_key", "mirror", "category_id").Scopes(mirrorEnabled).
+		Where(notMirrored).Where("status = ?", "active").
+		Where("mirror_attempt_at IS NULL OR mirror_attempt_at <= ?", time.Now().Add(-s.retry)).
+		Order("mirror_attempt_at").Order("id").Limit(s.batch).Find(&images).Error; err != nil {
+		log.Printf("Mirror: failed to load images: %v", err)
+		return
+	}
+	for i := range images {
+		if i > 0 && s.delay > 0 {
+			select {
+			case <-time.After(s.delay):
+			case <-s.stopCh:
+				return
+			}
+		}
+		if err := s.MirrorImage(&images[i]); err != nil {
+			log.Printf("Mirror: failed to mirror image %d: %v", images[i].ID, err)
+		}
+	}
+}
+
+// MirrorImage 立即下载图片的原图保存到存储（已有副本时重新下载），记录内容哈希和大小
+// 图片和所属分类都没有开启镜像时返回 ErrMirrorDisabled；本地上游并发已满时不记录失败，下次扫描时重试
+func (s *MirrorService) MirrorImage(image *model.Image) error {
+	if _, ok := StorageKeyFromURL(image.SourceURL); ok {
+		return errors.New("uploaded images are already in storage")
+	}
+	if !image.Mirror {
+		var category model.Category
+		if err := database.DB.Select("mirror").First(&category, image.CategoryID).Error; err != nil || !category.Mirror {
+			return ErrMirrorDisabled
+		}
+	}
+
+	err := s.mirror(image)
+	if err == nil || errors.Is(err, ErrUpstreamBusy) {
+		return err
+	}
+
+	s.failed.Add(1)
+	message := err.Error()
+	if len(message) > maxCheckErrorLen {
+		message = message[:maxCheckErrorLen]
+	}
+	if dbErr := database.DB.Model(&model.Image{}).Where("id = ?", image.ID).Updates(map[string]interface{}{
+		"mirror_attempt_at": time.Now(),
+		"mirror_error":      message,
+	}).Error; dbErr != nil {
+		log.Printf("Mirror: failed to save result for image %d: %v", image.ID, dbErr)
+	}
+	return err
+}
+
+// mirror 下载原图并保存，图片地址在下载期间被修改时放弃
+func (s *MirrorService) mirror(image *model.Image) error {
+	data, contentType, err := s.proxy.FetchOriginal(image.SourceURL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	key := MirrorKey(data, sniffImageFormat(data))
+	if err := s.storage.Put(ctx, key, data, contentType); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	size := int64(len(data))
+	result := database.DB.Model(&model.Image{}).Where("id = ? AND source_url = ?", image.ID, image.SourceURL).
+		Updates(map[string]interface{}{
+			"storage_key":       key,
+			"content_hash":      ContentHash(data),
+			"file_size":         size,
+			"mirrored_at":       now,
+			"mirror_attempt_at": now,
+			"mirror_error":      "",
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		s.storage.DeleteUnreferenced(ctx, key)
+		if result.Error != nil {
+			return result.Error
+		}
+		return errors.New("image was changed or deleted during mirroring")
+	}
+	if image.StorageKey != "" && image.StorageKey != key {
+		// 重新镜像后内容变化，删除旧副本
+		s.storage.DeleteUnreferenced(ctx, image.StorageKey)
+	}
+
+	s.mirrored.Add(1)
+	s.bytes.Add(size)
+	return nil
+}
+
+// release 删除已关闭镜像的图片的副本，之后恢复从原地址读取
+func (s *MirrorService) release(image *model.Image) {
+	result := database.DB.Model(&model.Image{}).Where("id = ? AND storage_key = ?", image.ID, image.StorageKey).
+		Updates(map[string]interface{}{
+			"storage_key":       "",
+			"mirrored_at":       nil,
+			"mirror_attempt_at": nil,
+			"mirror_error":      "",
+		})
+	if result.Error != nil {
+		log.Printf("Mirror: failed to release image %d: %v", image.ID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		s.released.Add(1)
+		s.storage.DeleteUnreferenced(context.Background(), image.StorageKey)
+	}
+}
+
+// Stats 获取镜像统计
+func (s *MirrorService) Stats() MirrorStats {
+	stats := MirrorStats{
+		Enabled:  s.interval > 0,
+		Interval: s.interval.Seconds(),
+		Batch:    s.batch,
+		Delay:    s.delay.Seconds(),
+		Retry:    s.retry.Seconds(),
+		Running:  s.running.Load(),
+		Mirrored: s.mirrored.Load(),
+		Failed:   s.failed.Load(),
+		Released: s.released.Load(),
+		Bytes:    s.bytes.Load(),
+	}
+	if unix := s.lastRunAt.Load(); unix > 0 {
+		lastRunAt := time.Unix(unix, 0)
+		stats.LastRunAt = &lastRunAt
+	}
+
+	database.DB.Model(&model.Image{}).Where("storage_key <> '' AND source_url NOT LIKE ?", storageURLPrefix+"%").
+		Count(&stats.MirroredImages)
+	database.DB.Model(&model.Image{}).Where("storage_key <> '' AND source_url NOT LIKE ?", storageURLPrefix+"%").
+		Select("COALESCE(SUM(file_size), 0)").Scan(&stats.StoredBytes)
+	database.DB.Model(&model.Image{}).Scopes(mirrorEnabled).Where(notMirrored).Count(&stats.Pending)
+	database.DB.Model(&model.Image{}).Scopes(mirrorEnabled).Where(notMirrored).Where("mirror_error <> ''").Count(&stats.Failing)
+	return stats
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"net/http"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"testing"
+)
+
+// newTestMirror 创建不启动后台扫描的镜像服务，图源为返回 images 中图片的测试服务器
+func newTestMirror(t *testing.T, images map[string][]byte) (*MirrorService, string) {
+	t.Helper()
+	proxy, base := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		data, ok := images[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}, 1<<20)
+	setupTestDB(t)
+	proxy.storage = &StorageService{Storage: NewLocalStorage(t.TempDir()), backend: "local"}
+	return &MirrorService{batch: defaultMirrorBatch, retry: defaultMirrorRetry, proxy: proxy, storage: proxy.storage}, base
+}
+
+// createMirrorImages 创建分类和图片
+func createMirrorImages(t *testing.T, categoryMirror bool, images ...*model.Image) {
+	t.Helper()
+	category := model.Category{Name: "c", Slug: "c", Mirror: categoryMirror}
+	if err := database.DB.Create(&category).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range images {
+		image.CategoryID = category.ID
+		image.Rating = model.RatingSafe
+		if image.Status == "" {
+			image.Status = "active"
+		}
+		if err := database.DB.Create(image).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedImage 重新读取图片
+func storedImage(t *testing.T, id uint) model.Image {
+	t.Helper()
+	var image model.Image
+	if err := database.DB.First(&image, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+// storageHas 存储中是否有该对象
+func storageHas(s *StorageService, key string) bool {
+	_, err := s.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestMirrorImage(t *testing.T) {
+	red := encodeTestImage(t, "png", solidImage(8, 8, color.RGBA{R: 255, A: 255}, 0, nil))
+	s, base := newTestMirror(t, map[string][]byte{"/a.png": red, "/b.png": red})
+
+	enabled := &model.Image{SourceURL: base + "/a.png", Mirror: true}
+	same := &model.Image{SourceURL: base + "/b.png", Mirror: true}
+	disabled := &model.Image{SourceURL: base + "/c.png"}
+	missing := &model.Image{SourceURL: base + "/missing.png", Mirror: true}
+	uploaded := &model.Image{SourceURL: StorageURL("uploads/x.png"), StorageKey: "uploads/x.png", Mirror: true}
+	createMirrorImages(t, false, enabled, same, disabled, missing, uploaded)
+
+	if err := s.MirrorImage(enabled); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedImage(t, enabled.ID)
+	if stored.StorageKey != MirrorKey(red, "png") || stored.ContentHash != ContentHash(red) ||
+		stored.FileSize == nil || *stored.FileSize != int64(len(red)) || stored.MirroredAt == nil {
+		t.Errorf("mirrored image: key %q, hash %q, size %v", stored.StorageKey, stored.ContentHash, stored.FileSize)
+	}
+	if !storageHas(s.storage, stored.StorageKey) {
+		t.Fatal("mirror copy not in storage")
+	}
+	// 镜像后 source_url 不变，从存储读取原图
+	if stored.SourceURL != enabled.SourceURL || ImageOriginURL(&stored) != StorageURL(stored.StorageKey) {
+		t.Errorf("origin = %s", ImageOriginURL(&stored))
+	}
+
+	// 内容相同的图片共用一个对象
+	if err := s.MirrorImage(same); err != nil {
+		t.Fatal(err)
+	}
+	if storedImage(t, same.ID).StorageKey != stored.StorageKey {
+		t.Error("identical content stored twice")
+	}
+
+	if err := s.MirrorImage(disabled); !errors.Is(err, ErrMirrorDisabled) {
+		t.Errorf("disabled: err = %v, want ErrMirrorDisabled", err)
+	}
+	if err := s.MirrorImage(uploaded); err == nil {
+		t.Error("uploaded image mirrored")
+	}
+
+	// 失败时记录错误和尝试时间
+	if err := s.MirrorImage(missing); err == nil {
+		t.Fatal("missing image mirrored")
+	}
+	failed := storedImage(t, missing.ID)
+	if failed.StorageKey != "" || failed.MirrorError == "" || failed.MirrorAttemptAt == nil {
+		t.Errorf("failure not recorded: key %q, error %q", failed.StorageKey, failed.MirrorError)
+	}
+
+	if stats := s.Stats(); stats.Mirrored != 2 || stats.Failed != 1 || stats.MirroredImages != 2 ||
+		stats.StoredBytes != 2*int64(len(red)) || stats.Pending != 1 || stats.Failing != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestMirrorImageContentChanged(t *testing.T) {
+	red := encodeTestImage(t, "png", solidImage(8, 8, color.RGBA{R: 255, A: 255}, 0, nil))
+	blue := encodeTestImage(t, "png", solidImage(8, 8, color.RGBA{B: 255, A: 255}, 0, nil))
+	images := map[string][]byte{"/a.png": red}
+	s, base := newTestMirror(t, images)
+	image := &model.Image{SourceURL: base + "/a.png", Mirror: true}
+	createMirrorImages(t, false, image)
+
+	if err := s.MirrorImage(image); err != nil {
+		t.Fatal(err)
+	}
+	*image = storedImage(t, image.ID)
+	oldKey := image.StorageKey
+
+	// 图源内容变化后重新镜像，删除不再使用的旧副本
+	images["/a.png"] = blue
+	if err := s.MirrorImage(image); err != nil {
+		t.Fatal(err)
+	}
+	newKey := storedImage(t, image.ID).StorageKey
+	if newKey == oldKey || !storageHas(s.storage, newKey) || storageHas(s.storage, oldKey) {
+		t.Errorf("old copy %v, new copy %v", storageHas(s.storage, oldKey), storageHas(s.storage, newKey))
+	}
+
+	// 下载期间图片地址被修改时放弃，不留下副本
+	images["/b.png"] = encodeTestImage(t, "png", photoImage(8, 8, nil))
+	moved := model.Image{ID: image.ID, SourceURL: base + "/b.png", Mirror: true, CategoryID: image.CategoryID}
+	if err := s.MirrorImage(&moved); err == nil {
+		t.Error("mirrored an image whose source_url changed")
+	}
+	if storageHas(s.storage, MirrorKey(images["/b.png"], "png")) {
+		t.Error("abandoned copy left in storage")
+	}
+}
+
+func TestMirrorRunOnce(t *testing.T) {
+	red := encodeTestImage(t, "png", solidImage(8, 8, color.RGBA{R: 255, A: 255}, 0, nil))
+	blue := encodeTestImage(t, "png", solidImage(8, 8, color.RGBA{B: 255, A: 255}, 0, nil))
+	s, base := newTestMirror(t, map[string][]byte{"/a.png": red, "/b.png": blue, "/inactive.png": red})
+
+	a := &model.Image{SourceURL: base + "/a.png"}
+	b := &model.Image{SourceURL: base + "/b.png"}
+	inactive := &model.Image{SourceURL: base + "/inactive.png", Status: "inactive"}
+	missing := &model.Image{SourceURL: base + "/missing.png"}
+	createMirrorImages(t, true, a, b, inactive, missing)
+
+	s.RunOnce()
+	for _, image := range []*model.Image{a, b} {
+		if storedImage(t, image.ID).StorageKey == "" {
+			t.Errorf("%s not mirrored", image.SourceURL)
+		}
+	}
+	if storedImage(t, inactive.ID).StorageKey != "" {
+		t.Error("inactive image mirrored")
+	}
+	if storedImage(t, missing.ID).MirrorError == "" {
+		t.Error("failure not recorded")
+	}
+
+	// 失败的图片在重试间隔内不再尝试
+	failedAt := storedImage(t, missing.ID).MirrorAttemptAt
+	s.RunOnce()
+	if !storedImage(t, missing.ID).MirrorAttemptAt.Equal(*failedAt) {
+		t.Error("failed image retried before the retry interval")
+	}
+
+	// 分类关闭镜像后删除副本，恢复从原地址读取
+	keyA, keyB := storedImage(t, a.ID).StorageKey, storedImage(t, b.ID).StorageKey
+	database.DB.Model(&model.Category{}).Where("id = ?", a.CategoryID).Update("mirror", false)
+	database.DB.Model(&model.Image{}).Where("id = ?", b.ID).Update("mirror", true)
+	s.RunOnce()
+	if stored := storedImage(t, a.ID); stored.StorageKey != "" || stored.MirroredAt != nil || ImageOriginURL(&stored) != a.SourceURL {
+		t.Errorf("copy not released: key %q", stored.StorageKey)
+	}
+	if storageHas(s.storage, keyA) {
+		t.Error("released copy still in storage")
+	}
+	// 图片自身开启镜像的不受分类影响
+	if storedImage(t, b.ID).StorageKey != keyB || !storageHas(s.storage, keyB) {
+		t.Error("image with its own mirror flag was released")
+	}
+	if stats := s.Stats(); stats.Released != 1 || stats.MirroredImages != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultOutboundMaxRedirects 外部请求默认最多跟随的重定向次数
+	defaultOutboundMaxRedirects = 5
+	// defaultOutboundTimeout 外部请求默认超时时间
+	defaultOutboundTimeout = 30 * time.Second
+)
+
+// ErrBlockedDestination 请求地址指向内网、本机等不允许访问的地址
+var ErrBlockedDestination = errors.New("destination not allowed")
+
+// blockedNetworks 除 net.IP 自带判断（回环、私有、链路本地、组播、未指定地址）外需要拦截的网段
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // 本网络
+	"100.64.0.0/10", // 运营商级NAT
+	"192.0.0.0/24",  // IETF协议分配
+	"198.18.0.0/15", // 基准测试
+	"240.0.0.0/4",   // 保留地址和广播
+	"64:ff9b::/96",  // NAT64，可映射到任意IPv4地址
+)
+
+// hostAllowlist 允许访问的内网地址，由环境变量 OUTBOUND_ALLOWLIST 配置（逗号分隔的网段、IP或主机名）
+type hostAllowlist struct {
+	networks []*net.IPNet
+	hosts    map[string]bool
+}
+
+var (
+	outboundClient        *http.Client
+	outboundOnce          sync.Once
+	outboundAllowlist     *hostAllowlist
+	outboundAllowlistOnce sync.Once
+)
+
+// getOutboundAllowlist 获取允许列表
+func getOutboundAllowlist() *hostAllowlist {
+	outboundAllowlistOnce.Do(func() {
+		outboundAllowlist = parseOutboundAllowlist(os.Getenv("OUTBOUND_ALLOWLIST"))
+	})
+	return outboundAllowlist
+}
+
+// GetOutboundClient 获取访问图源等外部地址的共享HTTP客户端
+// 只允许http/https，连接前解析域名并拒绝内网、本机、链路本地等地址（允许列表中的除外），
+// 每次重定向都重新检查，重定向次数由环境变量 OUTBOUND_MAX_REDIRECTS 配置
+func GetOutboundClient() *http.Client {
+	outboundOnce.Do(func() {
+		outboundClient = NewOutboundClient(defaultOutboundTimeout, nil)
+	})
+	return outboundClient
+}
+
+// NewOutboundClient 创建与共享客户端相同检查规则的HTTP客户端，可以指定超时和出站代理
+// 使用代理时只检查代理地址（内网代理需要加入允许列表），目标地址由代理访问
+func NewOutboundClient(timeout time.Duration, proxy *url.URL) *http.Client {
+	maxRedirects := envInt("OUTBOUND_MAX_REDIRECTS", defaultOutboundMaxRedirects)
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 不使用环境变量中的代理，否则只会检查代理地址
+	transport.Proxy = nil
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialOutbound(ctx, dialer, network, addr)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			// 地址由连接时检查，这里只检查协议
+			return checkOutboundScheme(req.URL)
+		},
+	}
+}
+
+// ValidateOutboundURL 检查地址是否允许访问：协议为http/https，主机为IP时不能是内网地址
+// 域名在实际请求时才解析检查，用于保存图片地址前提前拒绝明显无效的地址
+func ValidateOutboundURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if err := checkOutboundScheme(u); err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("invalid url: missing host")
+	}
+	allowlist := getOutboundAllowlist()
+	if ip := net.ParseIP(host); ip != nil && !allowlist.allowsIP(ip) && isBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedDestination, ip)
+	}
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") && !allowlist.allowsHost(host) {
+		return fmt.Errorf("%w: %s", ErrBlockedDestination, host)
+	}
+	return nil
+}
+
+// checkOutboundScheme 只允许http和https
+func checkOutboundScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrBlockedDestination, u.Scheme)
+	}
+	return nil
+}
+
+// dialOutbound 解析域名后逐个尝试允许访问的地址，直接连接检查过的IP，避免DNS重绑定
+func dialOutbound(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	allowlist := getOutboundAllowlist()
+	if allowlist.allowsHost(host) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		if isBlockedIP(ip.IP) && !allowlist.allowsIP(ip.IP) {
+			lastErr = fmt.Errorf("%w: %s resolves to %s", ErrBlockedDestination, host, ip.IP)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found for %s", host)
+	}
+	return nil, lastErr
+}
+
+// isBlockedIP 是否为内网、本机、链路本地、组播等不允许访问的地址
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOutboundAllowlist 解析允许列表，无效的网段按主机名处理
+func parseOutboundAllowlist(value string) *hostAllowlist {
+	allowlist := &hostAllowlist{hosts: make(map[string]bool)}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(item); err == nil {
+			allowlist.networks = append(allowlist.networks, network)
+		} else if ip := net.ParseIP(item); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			allowlist.networks = append(allowlist.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		} else {
+			allowlist.hosts[item] = true
+		}
+	}
+	return allowlist
+}
+
+// allowsHost 主机名是否在允许列表中（允许列表中的主机名不检查解析结果）
+func (a *hostAllowlist) allowsHost(host string) bool {
+	return a.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// allowsIP IP是否在允许的网段中
+func (a *hostAllowlist) allowsIP(ip net.IP) bool {
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mustParseCIDRs 解析固定的网段列表
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"10.0.0.1", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // 云服务元数据
+		{"0.0.0.0", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true}, // IPv4映射地址
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::7f00:1", true}, // NAT64
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"100.128.0.1", false},
+		{"2606:4700:4700::1111", false},
+		{"::ffff:8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := isBlockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("isBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
+
+func TestParseOutboundAllowlist(t *testing.T) {
+	allowlist := parseOutboundAllowlist(" 10.1.0.0/16, 192.168.5.5 ,Images.Internal,, fd00::1 ")
+	for _, ip := range []string{"10.1.2.3", "192.168.5.5", "::ffff:192.168.5.5", "fd00::1"} {
+		if !allowlist.allowsIP(net.ParseIP(ip)) {
+			t.Errorf("%s should be allowed", ip)
+		}
+	}
+	for _, ip := range []string{"10.2.0.1", "192.168.5.6", "fd00::2"} {
+		if allowlist.allowsIP(net.ParseIP(ip)) {
+			t.Errorf("%s should not be allowed", ip)
+		}
+	}
+	if !allowlist.allowsHost("images.internal") || !allowlist.allowsHost("IMAGES.internal.") {
+		t.Error("host should be allowed case-insensitively and with a trailing dot")
+	}
+	if allowlist.allowsHost("evil.images.internal") {
+		t.Error("allowlisted host must not match subdomains")
+	}
+}
+
+func TestValidateOutboundURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		blocked bool // 返回 ErrBlockedDestination
+		valid   bool
+	}{
+		{"https://img.example.com/a.jpg", false, true},
+		{"http://8.8.8.8/a.jpg", false, true},
+		{"http://127.0.0.1/a.jpg", true, false},
+		{"http://[::1]:8080/a.jpg", true, false},
+		{"http://169.254.169.254/latest/meta-data/", true, false},
+		{"http://localhost/a.jpg", true, false},
+		{"http://LOCALHOST./a.jpg", true, false},
+		{"file:///etc/passwd", true, false},
+		{"gopher://example.com/", true, false},
+		{"ftp://example.com/a.jpg", true, false},
+		{"http:///a.jpg", false, false},
+		{"://bad", false, false},
+		// 域名在连接时才检查
+		{"http://internal.example.com/a.jpg", false, true},
+	}
+	for _, tt := range tests {
+		err := ValidateOutboundURL(tt.url)
+		if (err == nil) != tt.valid || errors.Is(err, ErrBlockedDestination) != tt.blocked {
+			t.Errorf("ValidateOutboundURL(%q) = %v", tt.url, err)
+		}
+	}
+
+	allowOutbound(t, "127.0.0.1,localhost")
+	for _, u := range []string{"http://127.0.0.1/a.jpg", "http://localhost/a.jpg"} {
+		if err := ValidateOutboundURL(u); err != nil {
+			t.Errorf("allowlisted %q: %v", u, err)
+		}
+	}
+}
+
+// newOutboundTestServer 返回固定内容的测试服务器，记录收到的请求数
+func newOutboundTestServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestOutboundClientBlocksPrivateDestinations(t *testing.T) {
+	server, hits := newOutboundTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	// 直接访问回环地址，或通过解析到回环地址的域名访问，都在连接时拒绝
+	client := NewOutboundClient(time.Second, nil)
+	for _, u := range []string{server.URL, fmt.Sprintf("http://localhost:%d/", port)} {
+		if _, err := client.Get(u); !errors.Is(err, ErrBlockedDestination) {
+			t.Errorf("GET %s: err = %v, want ErrBlockedDestination", u, err)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("blocked requests reached the server %d times", hits.Load())
+	}
+
+	// 环境变量中的代理不参与，否则只会检查代理地址
+	t.Setenv("HTTP_PROXY", server.URL)
+	t.Setenv("http_proxy", server.URL)
+	proxied := NewOutboundClient(time.Second, nil)
+	if _, err := proxied.Get("http://10.255.255.1/a.jpg"); !errors.Is(err, ErrBlockedDestination) {
+		t.Errorf("request through HTTP_PROXY: err = %v, want ErrBlockedDestination", err)
+	}
+	if hits.Load() != 0 {
+		t.Error("request was sent to the environment proxy")
+	}
+
+	// 允许列表中的地址可以访问
+	allowOutbound(t, "127.0.0.0/8")
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("allowlisted: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestOutboundClientChecksRedirects(t *testing.T) {
+	target, targetHits := newOutboundTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	})
+	var redirector *httptest.Server
+	redirector, _ = newOutboundTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/to-target":
+			// 重定向到未在允许列表中的IP
+			http.Redirect(w, r, target.URL+"/", http.StatusFound)
+		case "/to-file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	})
+	// 只允许通过主机名 localhost 访问，127.0.0.1 仍然被拒绝
+	allowOutbound(t, "localhost")
+	t.Setenv("OUTBOUND_MAX_REDIRECTS", "3")
+	client := NewOutboundClient(time.Second, nil)
+	base := "http://localhost:" + strings.TrimPrefix(redirector.URL, "http://127.0.0.1:")
+
+	resp, err := client.Get(base + "/")
+	if err != nil {
+		t.Fatalf("allowlisted host: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := client.Get(base + "/to-target"); !errors.Is(err, ErrBlockedDestination) {
+		t.Errorf("redirect to a blocked address: err = %v, want ErrBlockedDestination", err)
+	}
+	if targetHits.Load() != 0 {
+		t.Error("redirect reached the blocked server")
+	}
+	if _, err := client.Get(base + "/to-file"); !errors.Is(err, ErrBlockedDestination) {
+		t.Errorf("redirect to file://: err = %v, want ErrBlockedDestination", err)
+	}
+	if _, err := client.Get(base + "/loop"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("redirect loop: err = %v", err)
+	}
+}
+
+func TestOutboundClientOnlyChecksProxyAddress(t *testing.T) {
+	// 使用出站代理时连接的是代理，目标地址由代理访问
+	var requested atomic.Value
+	proxy, _ := newOutboundTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.String())
+		fmt.Fprint(w, "via proxy")
+	})
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := NewOutboundClient(time.Second, proxyURL)
+	if _, err := client.Get("http://img.example.com/a.jpg"); !errors.Is(err, ErrBlockedDestination) {
+		t.Errorf("proxy on a blocked address: err = %v, want ErrBlockedDestination", err)
+	}
+
+	allowOutbound(t, "127.0.0.1")
+	resp, err := client.Get("http://img.example.com/a.jpg")
+	if err != nil {
+		t.Fatalf("allowlisted proxy: %v", err)
+	}
+	resp.Body.Close()
+	if got, _ := requested.Load().(string); got != "http://img.example.com/a.jpg" {
+		t.Errorf("proxy received %q", got)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// dHashWidth、dHashHeight 差异哈希的采样尺寸，每行相邻像素比较得到 8x8=64 位
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// DHash 计算图片的差异哈希（dHash）
+// 缩小为 9x8 灰度图后逐行比较相邻像素亮度，对缩放、重新压缩和轻微调色不敏感
+func DHash(img image.Image) uint64 {
+	gray := grayThumbnail(img, dHashWidth, dHashHeight)
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if gray[y*dHashWidth+x] < gray[y*dHashWidth+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// FormatHash 将哈希格式化为16位十六进制字符串（用于存储）
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash 解析16位十六进制哈希
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// HammingDistance 两个哈希不同的位数
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayThumbnail 将图片按区域平均缩小为 width x height 的灰度值（0-255）
+// 每个区域最多采样 8x8 个像素，避免大图逐像素计算
+func grayThumbnail(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	result := make([]float64, width*height)
+
+	for ty := 0; ty < height; ty++ {
+		y0 := bounds.Min.Y + ty*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(ty+1)*bounds.Dy()/height, y0+1)
+		stepY := max((y1-y0)/8, 1)
+
+		for tx := 0; tx < width; tx++ {
+			x0 := bounds.Min.X + tx*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(tx+1)*bounds.Dx()/width, x0+1)
+			stepX := max((x1-x0)/8, 1)
+
+			var sum float64
+			var n int
+			for y := y0; y < y1; y += stepY {
+				for x := x0; x < x1; x += stepX {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+					n++
+				}
+			}
+			result[ty*width+tx] = sum / float64(n)
+		}
+	}
+	return result
+}
+
+// bkTree 按汉明距离组织的BK树，用于快速查找相近的哈希
+type bkTree struct {
+	root *bkNode
+}
+
+// bkNode BK树节点，children 按与当前节点的距离索引
+type bkNode struct {
+	hash     uint64
+	ids      []uint // 哈希完全相同的图片
+	children map[int]*bkNode
+}
+
+// insert 插入一个哈希
+func (t *bkTree) insert(hash uint64, id uint) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []uint{id}}
+		return
+	}
+
+	node := t.root
+	for {
+		d := HammingDistance(node.hash, hash)
+		if d == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: hash, ids: []uint{id}}
+			return
+		}
+		node = child
+	}
+}
+
+// search 查找与hash距离不超过maxDistance的所有节点
+func (t *bkTree) search(hash uint64, maxDistance int, fn func(node *bkNode, distance int)) {
+	if t.root == nil {
+		return
+	}
+
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := HammingDistance(node.hash, hash)
+		if d <= maxDistance {
+			fn(node, d)
+		}
+		// 三角不等式：只有距离在 [d-max, d+max] 范围内的子树可能命中
+		for childDistance, child := range node.children {
+			if childDistance >= d-maxDistance && childDistance <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+)
+
+// gradientImage 创建水平渐变加若干竖条的测试图片
+func gradientImage(width, height int, stripes ...int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			for _, s := range stripes {
+				if x*10/width == s {
+					v = 255 - v
+				}
+			}
+			img.Set(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestDHashIgnoresScaling(t *testing.T) {
+	small := DHash(gradientImage(90, 80, 3))
+	large := DHash(gradientImage(900, 800, 3))
+	if d := HammingDistance(small, large); d > 2 {
+		t.Errorf("scaled copy distance = %d, want <= 2", d)
+	}
+
+	other := DHash(gradientImage(900, 800, 1, 6, 8))
+	if d := HammingDistance(large, other); d < 10 {
+		t.Errorf("different image distance = %d, want >= 10", d)
+	}
+}
+
+func TestFormatParseHash(t *testing.T) {
+	for _, hash := range []uint64{0, 1, 0xdeadbeefcafebabe, ^uint64(0)} {
+		s := FormatHash(hash)
+		if len(s) != 16 {
+			t.Fatalf("FormatHash(%x) = %q, want 16 characters", hash, s)
+		}
+		if got, err := ParseHash(s); err != nil || got != hash {
+			t.Fatalf("ParseHash(%q) = %x, %v", s, got, err)
+		}
+	}
+	if _, err := ParseHash("not-a-hash"); err == nil {
+		t.Error("expected an error for an invalid hash")
+	}
+}
+
+func TestBKTreeSearchMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	hashes := make([]uint64, 2000)
+	tree := &bkTree{}
+	for i := range hashes {
+		// 一部分哈希只在少数位上不同，保证存在相近的哈希
+		if i > 0 && i%4 == 0 {
+			hashes[i] = hashes[i-1] ^ (1 << r.IntN(64)) ^ (1 << r.IntN(64))
+		} else {
+			hashes[i] = r.Uint64()
+		}
+		tree.insert(hashes[i], uint(i))
+	}
+
+	for _, maxDistance := range []int{0, 3, 8} {
+		for q := 0; q < 50; q++ {
+			query := hashes[r.IntN(len(hashes))] ^ (1 << r.IntN(64))
+			want := make(map[uint]bool)
+			for i, hash := range hashes {
+				if HammingDistance(hash, query) <= maxDistance {
+					want[uint(i)] = true
+				}
+			}
+
+			got := make(map[uint]bool)
+			tree.search(query, maxDistance, func(node *bkNode, distance int) {
+				if distance != HammingDistance(node.hash, query) {
+					t.Fatalf("reported distance %d, actual %d", distance, HammingDistance(node.hash, query))
+				}
+				for _, id := range node.ids {
+					got[id] = true
+				}
+			})
+			if len(got) != len(want) {
+				t.Fatalf("distance %d: tree found %d hashes, brute force %d", maxDistance, len(got), len(want))
+			}
+			for id := range want {
+				if !got[id] {
+					t.Fatalf("distance %d: tree missed image %d", maxDistance, id)
+				}
+			}
+		}
+	}
+}
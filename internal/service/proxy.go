@@ -2,81 +2,199 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
-	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // ImageProxyService 图片代理服务
+// 相同原图的并发下载、相同原图和处理参数的并发处理会合并为一次，上游请求受 UpstreamService 的并发限制
+// 请求头、超时和出站代理按 HostProfileService 的主机配置设置；storage:// 地址的原图从 StorageService 读取
 type ImageProxyService struct {
-	client *http.Client
+	profiles   *HostProfileService
+	upstream   *UpstreamService
+	storage    *StorageService
+	maxBytes   int64       // 原图大小上限
+	fetches    flightGroup // 按原图地址合并下载
+	transforms flightGroup // 按原图地址和处理参数合并处理
 }
 
-// NewImageProxyService 创建图片代理服务
-func NewImageProxyService() *ImageProxyService {
-	return &ImageProxyService{
-		client: &http.Client{
-			Timeout: 30 * 1e9, // 30秒超时
-		},
-	}
+var (
+	imageProxyInstance *ImageProxyService
+	imageProxyOnce     sync.Once
+)
+
+// GetImageProxyService 获取图片代理服务单例（合并请求需要共享进行中的调用）
+// 原图大小上限由环境变量 PROXY_MAX_SIZE_MB 配置
+func GetImageProxyService() *ImageProxyService {
+	imageProxyOnce.Do(func() {
+		maxSizeMB := envInt("PROXY_MAX_SIZE_MB", defaultProxyMaxSizeMB)
+		if maxSizeMB == 0 {
+			maxSizeMB = defaultProxyMaxSizeMB
+		}
+		imageProxyInstance = &ImageProxyService{
+			profiles: GetHostProfileService(),
+			upstream: GetUpstreamService(),
+			storage:  GetStorageService(),
+			maxBytes: int64(maxSizeMB) << 20,
+		}
+	})
+	return imageProxyInstance
 }
 
-// ProxyImage 代理图片
-func (s *ImageProxyService) ProxyImage(sourceURL string, compress bool, format string) ([]byte, string, error) {
-	// 获取原图
-	resp, err := s.client.Get(sourceURL)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to fetch image: %w", err)
+// MaxBytes 原图大小上限
+func (s *ImageProxyService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// ProxyImage 代理图片，需要压缩或缩放时解码后重新编码
+// shared 为true表示结果来自其他请求进行中的相同处理，返回的数据不能修改
+func (s *ImageProxyService) ProxyImage(sourceURL string, opts ProxyOptions) (data []byte, contentType string, shared bool, err error) {
+	opts.Normalize()
+	data, contentType, shared, err = s.transforms.Do(sourceURL+"\n"+opts.Key(), func() ([]byte, string, error) {
+		// 获取原图
+		data, contentType, err := s.fetch(sourceURL)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// 如果不需要压缩或缩放，直接返回
+		if !opts.NeedsProcessing() {
+			return data, contentType, nil
+		}
+		return s.processImage(data, contentType, &opts)
+	})
+	if shared {
+		s.upstream.coalescedTransforms.Add(1)
 	}
-	defer resp.Body.Close()
+	return data, contentType, shared, err
+}
+
+// FetchOriginal 下载原图（与代理共用合并请求、并发限制和大小上限），返回的数据不能修改
+func (s *ImageProxyService) FetchOriginal(sourceURL string) ([]byte, string, error) {
+	return s.fetch(sourceURL)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+// fetch 下载原图，返回数据和按文件头确定的Content-Type
+func (s *ImageProxyService) fetch(sourceURL string) ([]byte, string, error) {
+	data, contentType, shared, err := s.fetches.Do(sourceURL, func() ([]byte, string, error) {
+		if key, ok := StorageKeyFromURL(sourceURL); ok {
+			body, size, err := s.storage.Open(context.Background(), key, -1)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to read image: %w", err)
+			}
+			defer body.Close()
+			return readImageData(body, size, s.maxBytes)
+		}
+
+		release, err := s.upstream.Acquire(sourceURL)
+		if err != nil {
+			return nil, "", err
+		}
+		defer release()
+
+		req, client, err := s.profiles.NewRequest(sourceURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to fetch image: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to fetch image: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+		}
+
+		// 读取图片数据（限制大小并校验文件头）
+		return readImageBody(resp, s.maxBytes)
+	})
+	if shared {
+		s.upstream.coalescedFetches.Add(1)
 	}
+	return data, contentType, err
+}
 
-	// 读取图片数据
-	data, err := io.ReadAll(resp.Body)
+// processImage 解码图片，按参数缩放后转换为目标格式
+// 能识别但无法解码的格式（如AVIF）原样返回
+func (s *ImageProxyService) processImage(data []byte, contentType string, opts *ProxyOptions) ([]byte, string, error) {
+	// 先读取尺寸，拒绝解码超大图片
+	config, sourceFormat, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read image: %w", err)
+		if opts.Format == FormatAuto && !opts.Compress && !opts.Resize() {
+			// 无法解析的格式（如BMP、TIFF）只协商格式时原样返回
+			return data, contentType, nil
+		}
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	// 获取原始Content-Type
-	contentType := resp.Header.Get("Content-Type")
-
-	// 如果不需要压缩，直接返回
-	if !compress {
-		return data, contentType, nil
+	// format=auto：原图格式客户端可以直接使用且不需要压缩缩放时返回原图
+	targetFormat := strings.ToLower(opts.Format)
+	if targetFormat == FormatAuto {
+		targetFormat = opts.negotiateFormat(sourceFormat)
+		if targetFormat == sourceFormat && !opts.Compress && !opts.Resize() {
+			if contentType == "" {
+				contentType = "image/" + sourceFormat
+			}
+			return data, contentType, nil
+		}
 	}
 
-	// 需要压缩，进行格式转换
-	return s.compressImage(data, format)
-}
+	if config.Width*config.Height > maxProxyPixels {
+		return nil, "", fmt.Errorf("image too large to process: %dx%d", config.Width, config.Height)
+	}
 
-// compressImage 压缩图片
-func (s *ImageProxyService) compressImage(data []byte, targetFormat string) ([]byte, string, error) {
 	// 解码图片
 	img, format, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, errDecodeUnsupported) {
+		if contentType == "" {
+			contentType = "image/" + format
+		}
+		return data, contentType, nil
+	}
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	// 如果目标格式为空，使用原格式
-	if targetFormat == "" {
+	// format=auto 转换为JPEG时，含透明像素的图片改用PNG
+	if opts.Format == FormatAuto && targetFormat == "jpeg" && format != "jpeg" && !isOpaque(img) {
+		targetFormat = "png"
+	}
+
+	// 如果目标格式为空，使用原格式（缩放时不支持的目标格式同样按原格式处理）
+	if targetFormat == "" || (opts.Resize() && !isEncodableFormat(targetFormat)) {
 		targetFormat = format
+		if opts.Resize() && !isEncodableFormat(format) {
+			// 缩放后的GIF等格式统一输出为PNG
+			targetFormat = "png"
+		}
+	}
+
+	if opts.Resize() {
+		// JPEG不支持透明，contain模式的留白填充白色
+		var background color.Color = color.Transparent
+		if targetFormat == "jpeg" || targetFormat == "jpg" {
+			background = color.White
+		}
+		img = resizeImage(img, opts, background)
 	}
 
 	// 转换为目标格式
 	var buf bytes.Buffer
-	var contentType string
 
-	switch strings.ToLower(targetFormat) {
+	switch targetFormat {
 	case "jpeg", "jpg":
 		// 转换为JPEG
-		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.Quality}); err != nil {
 			return nil, "", fmt.Errorf("failed to encode jpeg: %w", err)
 		}
 		contentType = "image/jpeg"
@@ -88,10 +206,65 @@ func (s *ImageProxyService) compressImage(data []byte, targetFormat string) ([]b
 		}
 		contentType = "image/png"
 
+	case "webp":
+		// 转换为有损WebP，保留透明通道
+		if err := encodeWebP(&buf, img, opts.Quality); err != nil {
+			return nil, "", fmt.Errorf("failed to encode webp: %w", err)
+		}
+		contentType = "image/webp"
+
 	default:
-		// 不支持的格式，返回原图
-		return data, "application/octet-stream", nil
+		// 不支持输出的格式（如GIF），返回原图
+		if contentType == "" {
+			contentType = "image/" + format
+		}
+		return data, contentType, nil
 	}
 
 	return buf.Bytes(), contentType, nil
 }
+
+// isOpaque 图片是否不含透明像素
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// ParseAcceptedFormats 从Accept请求头中提取客户端明确接受的新图片格式，返回如 "avif,webp"
+// 只认可显式列出的类型（image/*、*/* 不算），q=0 表示不接受
+func ParseAcceptedFormats(accept string) string {
+	var formats []string
+	for _, format := range []string{"avif", "webp"} {
+		for _, part := range strings.Split(accept, ",") {
+			params := strings.Split(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "image/"+format) {
+				continue
+			}
+			rejected := false
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(name, "q") {
+					if q, err := strconv.ParseFloat(value, 64); err == nil && q <= 0 {
+						rejected = true
+					}
+				}
+			}
+			if !rejected {
+				formats = append(formats, format)
+			}
+			break
+		}
+	}
+	return strings.Join(formats, ",")
+}
+
+// isEncodableFormat 是否为支持输出的格式
+func isEncodableFormat(format string) bool {
+	switch format {
+	case "jpeg", "jpg", "png", "webp":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestParseAcceptedFormats(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		// Chrome / Firefox 的图片请求
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", "avif,webp"},
+		{"image/avif,image/webp,*/*", "avif,webp"},
+		// 旧版Safari
+		{"image/png,image/svg+xml,image/*;q=0.8,video/*;q=0.8,*/*;q=0.5", ""},
+		{"image/webp", "webp"},
+		{" IMAGE/WEBP ; Q=0.5", "webp"},
+		{"image/webp;q=0", ""},
+		{"image/webp;q=0.0, image/avif;q=0.1", "avif"},
+		{"image/webp;q=bogus", "webp"},
+		{"image/webpx,image/av", ""},
+		{"image/*", ""},
+		{"*/*", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ParseAcceptedFormats(tt.accept); got != tt.want {
+			t.Errorf("ParseAcceptedFormats(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		source string
+		accept string
+		resize bool
+		want   string
+	}{
+		{"jpeg", "avif,webp", false, "webp"},
+		{"jpeg", "", false, "jpeg"},
+		{"png", "", false, "png"},
+		{"gif", "webp", false, "gif"}, // 不缩放时保留动画
+		{"gif", "webp", true, "webp"},
+		{"avif", "avif,webp", false, "avif"},
+		{"avif", "webp", false, "webp"},
+		{"avif", "", false, "jpeg"},
+		{"webp", "", false, "jpeg"},
+		{"bmp", "", false, "jpeg"},
+	}
+	for _, tt := range tests {
+		opts := ProxyOptions{Format: FormatAuto, Accept: tt.accept}
+		if tt.resize {
+			opts.Width = 100
+		}
+		if got := opts.negotiateFormat(tt.source); got != tt.want {
+			t.Errorf("%s with Accept %q (resize %v) = %s, want %s", tt.source, tt.accept, tt.resize, got, tt.want)
+		}
+	}
+}
+
+func TestAutoFormatCacheKey(t *testing.T) {
+	a := ProxyOptions{Format: FormatAuto, Accept: "webp"}
+	b := ProxyOptions{Format: FormatAuto}
+	c := ProxyOptions{Format: "png", Compress: true, Accept: "webp"}
+	d := ProxyOptions{Format: "png", Compress: true}
+	for _, opts := range []*ProxyOptions{&a, &b, &c, &d} {
+		opts.Normalize()
+	}
+	if a.Key() == b.Key() {
+		t.Error("format=auto ignores the negotiated formats in the cache key")
+	}
+	if c.Key() != d.Key() {
+		t.Error("explicit format depends on Accept")
+	}
+}
+
+// encodeTestImage 按格式编码测试图片
+func encodeTestImage(t *testing.T, format string, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "webp":
+		err = encodeWebP(&buf, img, 80)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageAutoFormat(t *testing.T) {
+	opaque := photoImage(40, 30, nil)
+	transparent := photoImage(40, 30, func(x, y int) uint8 { return uint8(x * 6) })
+
+	tests := []struct {
+		name       string
+		source     string
+		img        image.Image
+		accept     string
+		resize     bool
+		wantType   string
+		wantSource bool // 原样返回原图
+	}{
+		{"jpeg to webp", "jpeg", opaque, "avif,webp", false, "image/webp", false},
+		{"jpeg kept", "jpeg", opaque, "", false, "image/jpeg", true},
+		{"png kept", "png", transparent, "", false, "image/png", true},
+		{"gif kept for animation", "gif", opaque, "webp", false, "image/gif", true},
+		{"opaque webp to jpeg", "webp", opaque, "", false, "image/jpeg", false},
+		{"transparent webp to png", "webp", transparent, "", false, "image/png", false},
+		{"resized jpeg stays jpeg", "jpeg", opaque, "", true, "image/jpeg", false},
+		{"resized gif to png", "gif", opaque, "", true, "image/png", false},
+	}
+	service := &ImageProxyService{}
+	for _, tt := range tests {
+		data := encodeTestImage(t, tt.source, tt.img)
+		opts := ProxyOptions{Format: FormatAuto, Accept: tt.accept}
+		if tt.resize {
+			opts.Width = 20
+		}
+		opts.Normalize()
+
+		out, contentType, err := service.processImage(data, "image/"+tt.source, &opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if contentType != tt.wantType {
+			t.Errorf("%s: Content-Type = %s, want %s", tt.name, contentType, tt.wantType)
+		}
+		if same := bytes.Equal(out, data); same != tt.wantSource {
+			t.Errorf("%s: returned original = %v, want %v", tt.name, same, tt.wantSource)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(out)); err != nil || "image/"+format != tt.wantType {
+			t.Errorf("%s: output decodes as %q (%v), want %s", tt.name, format, err, tt.wantType)
+		}
+	}
+
+	// 无法识别的格式只协商格式时原样返回，需要处理时报错
+	unknown := []byte("BM not really a bitmap")
+	opts := ProxyOptions{Format: FormatAuto, Accept: "webp"}
+	opts.Normalize()
+	if out, contentType, err := service.processImage(unknown, "image/bmp", &opts); err != nil || !bytes.Equal(out, unknown) || contentType != "image/bmp" {
+		t.Errorf("unknown format: %q, %s, %v, want original", out, contentType, err)
+	}
+	opts.Width = 10
+	opts.Normalize()
+	if _, _, err := service.processImage(unknown, "image/bmp", &opts); err == nil {
+		t.Error("resizing an unknown format should fail")
+	}
+}
+
+func TestIsOpaque(t *testing.T) {
+	if !isOpaque(solidImage(4, 4, color.RGBA{R: 255, A: 255}, 0, nil)) {
+		t.Error("solid image reported as transparent")
+	}
+	if isOpaque(photoImage(4, 4, func(x, y int) uint8 { return 254 })) {
+		t.Error("translucent image reported as opaque")
+	}
+}
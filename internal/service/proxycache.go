@@ -0,0 +1,382 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultProxyCacheDir 代理缓存默认目录
+	defaultProxyCacheDir = "data/proxy-cache"
+	// defaultProxyCacheSizeMB 代理缓存默认容量（MB）
+	defaultProxyCacheSizeMB = 1024
+)
+
+// ProxyCacheService 代理图片的磁盘缓存
+// 按 图片ID+原图地址+处理参数 缓存处理后的图片，总大小超过上限时按最近最少使用（LRU）淘汰
+// 缓存文件保存在 <dir>/<图片ID>/<key>，首行为Content-Type，其后为图片数据
+type ProxyCacheService struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List               // 最近使用的在前
+	entries map[string]*list.Element // 键为 entryName(图片ID, key)
+	size    int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// proxyCacheEntry 缓存项
+type proxyCacheEntry struct {
+	imageID uint
+	key     string
+	size    int64
+}
+
+// ProxyCacheStats 代理缓存统计
+type ProxyCacheStats struct {
+	Enabled   bool   `json:"enabled"`
+	Dir       string `json:"dir"`
+	Entries   int    `json:"entries"`
+	Size      int64  `json:"size"`
+	MaxSize   int64  `json:"max_size"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+}
+
+var (
+	proxyCacheInstance *ProxyCacheService
+	proxyCacheOnce     sync.Once
+)
+
+// GetProxyCacheService 获取代理缓存单例
+// 目录和容量由环境变量 PROXY_CACHE_DIR、PROXY_CACHE_SIZE_MB 配置，容量为0时关闭缓存
+func GetProxyCacheService() *ProxyCacheService {
+	proxyCacheOnce.Do(func() {
+		dir := os.Getenv("PROXY_CACHE_DIR")
+		if dir == "" {
+			dir = defaultProxyCacheDir
+		}
+		sizeMB := int64(defaultProxyCacheSizeMB)
+		if value := os.Getenv("PROXY_CACHE_SIZE_MB"); value != "" {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				sizeMB = n
+			} else {
+				log.Printf("ProxyCache: invalid PROXY_CACHE_SIZE_MB %q, using default %d", value, defaultProxyCacheSizeMB)
+			}
+		}
+
+		proxyCacheInstance = NewProxyCacheService(dir, sizeMB<<20)
+		if err := proxyCacheInstance.Load(); err != nil {
+			log.Printf("ProxyCache: failed to load cache, disabled: %v", err)
+			proxyCacheInstance.maxSize = 0
+		}
+	})
+	return proxyCacheInstance
+}
+
+// NewProxyCacheService 创建代理缓存，maxSize为0时不缓存
+func NewProxyCacheService(dir string, maxSize int64) *ProxyCacheService {
+	return &ProxyCacheService{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// ProxyCacheKey 根据原图地址和处理参数生成缓存键，原图地址变化后旧缓存自然失效
+func ProxyCacheKey(sourceURL string, params string) string {
+	sum := sha256.Sum256([]byte(sourceURL + "\x00" + params))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Enabled 是否启用缓存
+func (s *ProxyCacheService) Enabled() bool {
+	return s.maxSize > 0
+}
+
+// MaxEntrySize 单个缓存项的大小上限（总容量的1/8），未启用时为0
+func (s *ProxyCacheService) MaxEntrySize() int64 {
+	return s.maxSize / 8
+}
+
+// Load 扫描缓存目录重建索引，按文件修改时间恢复LRU顺序
+func (s *ProxyCacheService) Load() error {
+	if !s.Enabled() {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	var entries []*proxyCacheEntry
+	modTimes := make(map[*proxyCacheEntry]time.Time)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// 未完成的临时文件直接删除
+		if strings.HasPrefix(d.Name(), ".") {
+			os.Remove(path)
+			return nil
+		}
+		// 只接受 <图片ID>/<key> 结构的文件
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return nil
+		}
+		idDir, _, found := strings.Cut(rel, string(filepath.Separator))
+		imageID, err := strconv.ParseUint(idDir, 10, 32)
+		if !found || err != nil || filepath.Dir(rel) != idDir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entry := &proxyCacheEntry{imageID: uint(imageID), key: d.Name(), size: info.Size()}
+		entries = append(entries, entry)
+		modTimes[entry] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return modTimes[entries[i]].After(modTimes[entries[j]]) })
+
+	s.mu.Lock()
+	for _, entry := range entries {
+		s.entries[entryName(entry.imageID, entry.key)] = s.lru.PushBack(entry)
+		s.size += entry.size
+	}
+	evicted := s.evictLocked()
+	s.mu.Unlock()
+	s.removeFiles(evicted)
+
+	log.Printf("ProxyCache: loaded %d entries (%d bytes) from %s", len(entries)-len(evicted), s.size, s.dir)
+	return nil
+}
+
+// Get 读取缓存，未命中时返回false
+func (s *ProxyCacheService) Get(imageID uint, key string) ([]byte, string, bool) {
+	if !s.Enabled() {
+		return nil, "", false
+	}
+
+	name := entryName(imageID, key)
+	s.mu.Lock()
+	elem, ok := s.entries[name]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.misses.Add(1)
+		return nil, "", false
+	}
+
+	raw, err := os.ReadFile(s.path(imageID, key))
+	var contentType string
+	var data []byte
+	if err == nil {
+		var found bool
+		var header []byte
+		header, data, found = bytes.Cut(raw, []byte("\n"))
+		contentType = string(header)
+		if !found {
+			err = fmt.Errorf("missing content type")
+		}
+	}
+	if err != nil {
+		// 文件被外部删除或损坏，移除索引
+		log.Printf("ProxyCache: failed to read entry %d/%s: %v", imageID, key, err)
+		s.mu.Lock()
+		if elem, ok := s.entries[name]; ok {
+			s.removeLocked(elem)
+		}
+		s.mu.Unlock()
+		s.misses.Add(1)
+		return nil, "", false
+	}
+
+	s.hits.Add(1)
+	return data, contentType, true
+}
+
+// Put 写入缓存，超过容量时淘汰最久未使用的缓存；单个文件超过总容量的1/8时不缓存
+func (s *ProxyCacheService) Put(imageID uint, key string, data []byte, contentType string) {
+	size := int64(len(contentType) + 1 + len(data))
+	if !s.Enabled() || size > s.MaxEntrySize() {
+		return
+	}
+
+	dir := filepath.Join(s.dir, strconv.FormatUint(uint64(imageID), 10))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("ProxyCache: failed to create dir: %v", err)
+		return
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		log.Printf("ProxyCache: failed to create temp file: %v", err)
+		return
+	}
+	w := bufio.NewWriter(tmp)
+	w.WriteString(contentType)
+	w.WriteByte('\n')
+	w.Write(data)
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		log.Printf("ProxyCache: failed to write entry: %v", err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path(imageID, key)); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("ProxyCache: failed to write entry: %v", err)
+		return
+	}
+
+	name := entryName(imageID, key)
+	s.mu.Lock()
+	if elem, ok := s.entries[name]; ok {
+		// 并发写入同一个键，文件已被覆盖，只更新大小
+		entry := elem.Value.(*proxyCacheEntry)
+		s.size += size - entry.size
+		entry.size = size
+		s.lru.MoveToFront(elem)
+	} else {
+		s.entries[name] = s.lru.PushFront(&proxyCacheEntry{imageID: imageID, key: key, size: size})
+		s.size += size
+	}
+	evicted := s.evictLocked()
+	s.mu.Unlock()
+	s.removeFiles(evicted)
+}
+
+// PurgeImage 删除指定图片的所有缓存，返回删除的缓存数量
+func (s *ProxyCacheService) PurgeImage(imageID uint) int {
+	if !s.Enabled() {
+		return 0
+	}
+
+	s.mu.Lock()
+	count := 0
+	for elem := s.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*proxyCacheEntry).imageID == imageID {
+			s.removeLocked(elem)
+			count++
+		}
+		elem = next
+	}
+	s.mu.Unlock()
+
+	os.RemoveAll(filepath.Join(s.dir, strconv.FormatUint(uint64(imageID), 10)))
+	return count
+}
+
+// PurgeAll 清空缓存，返回删除的缓存数量
+func (s *ProxyCacheService) PurgeAll() int {
+	if !s.Enabled() {
+		return 0
+	}
+
+	s.mu.Lock()
+	count := s.lru.Len()
+	s.lru.Init()
+	s.entries = make(map[string]*list.Element)
+	s.size = 0
+	s.mu.Unlock()
+
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("ProxyCache: failed to purge: %v", err)
+		return count
+	}
+	for _, item := range items {
+		os.RemoveAll(filepath.Join(s.dir, item.Name()))
+	}
+	return count
+}
+
+// Stats 缓存统计
+func (s *ProxyCacheService) Stats() ProxyCacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ProxyCacheStats{
+		Enabled:   s.Enabled(),
+		Dir:       s.dir,
+		Entries:   s.lru.Len(),
+		Size:      s.size,
+		MaxSize:   s.maxSize,
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+	}
+}
+
+// evictLocked 淘汰最久未使用的缓存直到总大小不超过上限，返回需要删除文件的缓存项（调用方需持有锁）
+func (s *ProxyCacheService) evictLocked() []*proxyCacheEntry {
+	var evicted []*proxyCacheEntry
+	for s.size > s.maxSize {
+		elem := s.lru.Back()
+		if elem == nil {
+			break
+		}
+		evicted = append(evicted, elem.Value.(*proxyCacheEntry))
+		s.removeLocked(elem)
+		s.evictions.Add(1)
+	}
+	return evicted
+}
+
+// removeLocked 从索引中移除缓存项（调用方需持有锁）
+func (s *ProxyCacheService) removeLocked(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*proxyCacheEntry)
+	delete(s.entries, entryName(entry.imageID, entry.key))
+	s.size -= entry.size
+}
+
+// removeFiles 删除缓存文件（在锁外执行）
+func (s *ProxyCacheService) removeFiles(entries []*proxyCacheEntry) {
+	for _, entry := range entries {
+		if err := os.Remove(s.path(entry.imageID, entry.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("ProxyCache: failed to remove %d/%s: %v", entry.imageID, entry.key, err)
+		}
+	}
+}
+
+// path 缓存文件路径
+func (s *ProxyCacheService) path(imageID uint, key string) string {
+	return filepath.Join(s.dir, entryName(imageID, key))
+}
+
+// entryName 缓存项在索引中的名称，同时也是相对于缓存目录的文件路径
+// 不同图片可能使用同一个原图地址（重复图片），因此不能只用key区分
+func entryName(imageID uint, key string) string {
+	return filepath.Join(strconv.FormatUint(uint64(imageID), 10), key)
+}
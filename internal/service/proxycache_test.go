@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cacheData 生成加上Content-Type首行后正好占用size字节的缓存数据
+func cacheData(size int) []byte {
+	return bytes.Repeat([]byte{'x'}, size-len("image/png\n"))
+}
+
+func TestProxyCachePutGet(t *testing.T) {
+	cache := NewProxyCacheService(t.TempDir(), 8000)
+	key := ProxyCacheKey("https://img.example.com/a.jpg", "w=100")
+
+	if _, _, ok := cache.Get(1, key); ok {
+		t.Fatal("empty cache hit")
+	}
+	cache.Put(1, key, []byte("data\nwith newline"), "image/webp")
+	data, contentType, ok := cache.Get(1, key)
+	if !ok || string(data) != "data\nwith newline" || contentType != "image/webp" {
+		t.Fatalf("Get = %q, %q, %v", data, contentType, ok)
+	}
+
+	// 同一个原图地址属于不同的图片时分别缓存
+	if _, _, ok := cache.Get(2, key); ok {
+		t.Error("entry of image 1 returned for image 2")
+	}
+	cache.Put(2, key, []byte("other"), "image/png")
+	if data, _, _ := cache.Get(1, key); string(data) != "data\nwith newline" {
+		t.Errorf("image 1 entry = %q after putting image 2", data)
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 2 entries, 2 hits, 2 misses", stats)
+	}
+
+	// 超过总容量1/8的不缓存
+	cache.Put(3, key, cacheData(1001), "image/png")
+	if _, _, ok := cache.Get(3, key); ok {
+		t.Error("oversized entry was cached")
+	}
+}
+
+func TestProxyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewProxyCacheService(dir, 800)
+	for id := uint(1); id <= 8; id++ {
+		cache.Put(id, "k", cacheData(100), "image/png")
+	}
+	// 访问1后，最久未使用的是2
+	cache.Get(1, "k")
+	cache.Put(9, "k", cacheData(100), "image/png")
+
+	if _, _, ok := cache.Get(2, "k"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2", "k")); !os.IsNotExist(err) {
+		t.Errorf("evicted file still exists: %v", err)
+	}
+	for _, id := range []uint{1, 3, 9} {
+		if _, _, ok := cache.Get(id, "k"); !ok {
+			t.Errorf("entry %d was evicted", id)
+		}
+	}
+	if stats := cache.Stats(); stats.Size != 800 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want size 800 and 1 eviction", stats)
+	}
+
+	// 覆盖写入同一个缓存项只更新大小
+	cache.Put(9, "k", cacheData(50), "image/png")
+	if stats := cache.Stats(); stats.Size != 750 || stats.Entries != 8 {
+		t.Errorf("after overwrite stats = %+v, want size 750 and 8 entries", stats)
+	}
+}
+
+func TestProxyCacheLoad(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewProxyCacheService(dir, 800)
+	for id := uint(1); id <= 3; id++ {
+		cache.Put(id, "k", cacheData(100), "image/png")
+	}
+	// 修改时间决定重启后的LRU顺序：1最新，2最旧
+	now := time.Now()
+	os.Chtimes(filepath.Join(dir, "1", "k"), now, now)
+	os.Chtimes(filepath.Join(dir, "2", "k"), now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	os.Chtimes(filepath.Join(dir, "3", "k"), now.Add(-time.Hour), now.Add(-time.Hour))
+	os.WriteFile(filepath.Join(dir, "1", ".tmp-123"), []byte("partial"), 0o644)
+	os.WriteFile(filepath.Join(dir, "not-an-id"), []byte("ignored"), 0o644)
+
+	// 容量变小后重启只保留最近使用的两个
+	reloaded := NewProxyCacheService(dir, 200)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := reloaded.Stats(); stats.Entries != 2 || stats.Size != 200 {
+		t.Errorf("stats = %+v, want 2 entries of 200 bytes", stats)
+	}
+	if _, _, ok := reloaded.Get(2, "k"); ok {
+		t.Error("oldest entry survived the reload")
+	}
+	if data, contentType, ok := reloaded.Get(1, "k"); !ok || contentType != "image/png" || len(data) != 90 {
+		t.Errorf("reloaded entry = %d bytes, %q, %v", len(data), contentType, ok)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1", ".tmp-123")); !os.IsNotExist(err) {
+		t.Error("temp file was not removed on load")
+	}
+}
+
+func TestProxyCacheMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewProxyCacheService(dir, 8000)
+	cache.Put(1, "k", []byte("data"), "image/png")
+	os.Remove(filepath.Join(dir, "1", "k"))
+
+	if _, _, ok := cache.Get(1, "k"); ok {
+		t.Fatal("deleted file reported as hit")
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Size != 0 {
+		t.Errorf("stats = %+v, want the entry dropped from the index", stats)
+	}
+}
+
+func TestProxyCachePurge(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewProxyCacheService(dir, 8000)
+	cache.Put(1, "a", []byte("1a"), "image/png")
+	cache.Put(1, "b", []byte("1b"), "image/png")
+	cache.Put(2, "a", []byte("2a"), "image/png")
+
+	if n := cache.PurgeImage(1); n != 2 {
+		t.Errorf("PurgeImage = %d, want 2", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1")); !os.IsNotExist(err) {
+		t.Error("image directory still exists")
+	}
+	if _, _, ok := cache.Get(2, "a"); !ok {
+		t.Error("other image was purged")
+	}
+
+	if n := cache.PurgeAll(); n != 1 {
+		t.Errorf("PurgeAll = %d, want 1", n)
+	}
+	if items, _ := os.ReadDir(dir); len(items) != 0 {
+		t.Errorf("cache dir not empty: %v", items)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Size != 0 {
+		t.Errorf("stats = %+v after PurgeAll", stats)
+	}
+}
+
+func TestProxyCacheDisabled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache := NewProxyCacheService(dir, 0)
+	if err := cache.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Put(1, "k", []byte("data"), "image/png")
+	if _, _, ok := cache.Get(1, "k"); ok {
+		t.Error("disabled cache returned a hit")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("disabled cache created its directory")
+	}
+}
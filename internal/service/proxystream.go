@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultProxyMaxSizeMB 代理允许下载的原图大小默认上限（MB）
+const defaultProxyMaxSizeMB = 30
+
+// sniffLen 识别图片格式需要读取的字节数
+const sniffLen = 512
+
+var (
+	// ErrImageTooLarge 原图超过 PROXY_MAX_SIZE_MB
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrNotImage 原图的文件头不是支持的图片格式
+	ErrNotImage = errors.New("upstream content is not an image")
+)
+
+// parseContentRange 解析 "bytes first-last/total"，不接受未知总大小（total为*）
+func parseContentRange(header string) (first, last, total int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rangePart, totalPart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	firstPart, lastPart, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	var err1, err2 error
+	first, err1 = strconv.ParseInt(firstPart, 10, 64)
+	last, err2 = strconv.ParseInt(lastPart, 10, 64)
+	if err1 != nil || err2 != nil || last < first {
+		return 0, 0, 0, false
+	}
+	total, err := strconv.ParseInt(totalPart, 10, 64)
+	if err != nil || total <= last {
+		return 0, 0, 0, false
+	}
+	return first, last, total, true
+}
+
+// readImageBody 读取完整的原图，超过上限或文件头不是图片时返回错误，返回数据和按文件头确定的Content-Type
+func readImageBody(resp *http.Response, maxBytes int64) ([]byte, string, error) {
+	return readImageData(resp.Body, resp.ContentLength, maxBytes)
+}
+
+// readImageData 读取完整的图片，size为已知的大小（-1表示未知），规则与 readImageBody 相同
+func readImageData(r io.Reader, size int64, maxBytes int64) ([]byte, string, error) {
+	if size > maxBytes {
+		return nil, "", fmt.Errorf("%w: %d bytes (max %d)", ErrImageTooLarge, size, maxBytes)
+	}
+	data, err := io.ReadAll(&maxBytesReader{r: r, remaining: maxBytes})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %w", err)
+	}
+	format := sniffImageFormat(data)
+	if format == "" {
+		return nil, "", ErrNotImage
+	}
+	return data, imageContentType(format), nil
+}
+
+// maxBytesReader 读取超过 remaining 字节时返回 ErrImageTooLarge
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, ErrImageTooLarge
+	}
+	// 多读一个字节以判断是否超出
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n + int(m.remaining), ErrImageTooLarge
+	}
+	return n, err
+}
+
+// sniffImageFormat 根据文件头识别图片格式，不是支持的图片格式时返回空字符串
+// 不信任上游的Content-Type，SVG等文本格式不视为图片
+func sniffImageFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "gif"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return "webp"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		// ftyp box 的主品牌和兼容品牌中包含avif/avis
+		size := int(binary.BigEndian.Uint32(head[:4]))
+		if size < 16 || size > len(head) {
+			size = len(head)
+		}
+		for i := 8; i+4 <= size; i += 4 {
+			if i == 12 {
+				// 跳过minor_version
+				continue
+			}
+			if brand := string(head[i : i+4]); brand == "avif" || brand == "avis" {
+				return "avif"
+			}
+		}
+	case bytes.HasPrefix(head, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "tiff"
+	case bytes.HasPrefix(head, []byte("\x00\x00\x01\x00")):
+		return "ico"
+	}
+	return ""
+}
+
+// SniffImage 根据文件头识别图片格式，返回格式和对应的Content-Type，不是支持的图片格式时都返回空字符串
+func SniffImage(data []byte) (format, contentType string) {
+	if format = sniffImageFormat(data); format == "" {
+		return "", ""
+	}
+	return format, imageContentType(format)
+}
+
+// imageContentType 图片格式对应的Content-Type
+func imageContentType(format string) string {
+	if format == "ico" {
+		return "image/x-icon"
+	}
+	return "image/" + format
+}
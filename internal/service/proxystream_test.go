@@ -0,0 +1,199 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header             string
+		first, last, total int64
+		ok                 bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, true},
+		{"bytes 0-999/1000", 0, 999, 1000, true},
+		{"bytes 0-99/*", 0, 0, 0, false},
+		{"bytes 0-1000/1000", 0, 0, 0, false},
+		{"bytes 10-5/100", 0, 0, 0, false},
+		{"bytes */1000", 0, 0, 0, false},
+		{"0-99/1000", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, last, total, ok := parseContentRange(tt.header)
+		if first != tt.first || last != tt.last || total != tt.total || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, %v", tt.header, first, last, total, ok)
+		}
+	}
+}
+
+func TestSniffImageFormat(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{"\xff\xd8\xff\xe0", "jpeg"},
+		{"\x89PNG\r\n\x1a\n", "png"},
+		{"GIF89a", "gif"},
+		{"GIF87a", "gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"RIFF\x00\x00\x00\x00WAVEfmt ", ""},
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00mif1", "avif"},
+		{"\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00avis", "avif"},
+		// minor_version 恰好是 "avif" 不算
+		{"\x00\x00\x00\x10ftypheicavif", ""},
+		{"\x00\x00\x00\x18ftypisom\x00\x00\x00\x00mp41", ""},
+		{"BM\x00\x00", "bmp"},
+		{"II*\x00", "tiff"},
+		{"MM\x00*", "tiff"},
+		{"\x00\x00\x01\x00", "ico"},
+		{"<svg xmlns=\"http://www.w3.org/2000/svg\">", ""},
+		{"<!DOCTYPE html>", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sniffImageFormat([]byte(tt.head)); got != tt.want {
+			t.Errorf("sniffImageFormat(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+	if format, contentType := SniffImage([]byte("\x00\x00\x01\x00")); format != "ico" || contentType != "image/x-icon" {
+		t.Errorf("SniffImage(ico) = %s, %s", format, contentType)
+	}
+}
+
+func TestReadImageData(t *testing.T) {
+	png := encodeTestImage(t, "png", photoImage(8, 8, nil))
+	limit := int64(len(png))
+
+	data, contentType, err := readImageData(bytes.NewReader(png), -1, limit)
+	if err != nil || !bytes.Equal(data, png) || contentType != "image/png" {
+		t.Errorf("exactly at the limit: %d bytes, %q, %v", len(data), contentType, err)
+	}
+	// 已知大小超过上限时不读取
+	if _, _, err := readImageData(bytes.NewReader(png), limit+1, limit); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("known size over limit: err = %v", err)
+	}
+	// 未知大小时读取超过上限中断，逐字节读取时也一样
+	if _, _, err := readImageData(bytes.NewReader(png), -1, limit-1); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("unknown size over limit: err = %v", err)
+	}
+	if _, _, err := readImageData(&oneByteReader{bytes.NewReader(png)}, -1, limit-1); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("byte-by-byte over limit: err = %v", err)
+	}
+	if _, _, err := readImageData(strings.NewReader("<html></html>"), -1, limit); !errors.Is(err, ErrNotImage) {
+		t.Errorf("html: err = %v, want ErrNotImage", err)
+	}
+}
+
+// oneByteReader 每次只返回一个字节
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+// newStreamTestProxy 创建访问 httptest 服务器的代理服务
+func newStreamTestProxy(t *testing.T, handler http.HandlerFunc, maxBytes int64) (*ImageProxyService, string) {
+	t.Helper()
+	allowOutbound(t, "127.0.0.1")
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &ImageProxyService{
+		profiles: &HostProfileService{exact: make(map[string]*hostProfileEntry)},
+		upstream: newTestUpstream(0, 4, 0, time.Second),
+		maxBytes: maxBytes,
+	}, server.URL
+}
+
+func TestFetchOriginalRejects(t *testing.T) {
+	png := encodeTestImage(t, "png", photoImage(64, 64, nil))
+	proxy, base := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "image/png") // 不信任上游的Content-Type
+			io.WriteString(w, "<!DOCTYPE html><html></html>")
+		case "/chunked.png":
+			// 不带Content-Length
+			w.(http.Flusher).Flush()
+			w.Write(png)
+		case "/missing.png":
+			http.NotFound(w, r)
+		default:
+			w.Write(png)
+		}
+	}, int64(len(png))-1)
+
+	if _, _, err := proxy.FetchOriginal(base + "/page.html"); !errors.Is(err, ErrNotImage) {
+		t.Errorf("html: err = %v, want ErrNotImage", err)
+	}
+	if _, _, err := proxy.FetchOriginal(base + "/big.png"); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("known size over limit: err = %v, want ErrImageTooLarge", err)
+	}
+	// 大小未知时读取超过上限中断
+	if _, _, err := proxy.FetchOriginal(base + "/chunked.png"); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("chunked over limit: err = %v, want ErrImageTooLarge", err)
+	}
+	if _, _, err := proxy.FetchOriginal(base + "/missing.png"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("404: err = %v", err)
+	}
+}
+
+func TestProxyOriginalReleasesUpstream(t *testing.T) {
+	png := encodeTestImage(t, "png", photoImage(64, 64, nil))
+	proxy, base := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	}, 1<<20)
+	proxy.upstream = newTestUpstream(0, 1, 0, 10*time.Millisecond)
+
+	// 返回时原图已完整下载，客户端还没读完也不再占用上游并发槽位
+	data, contentType, _, err := proxy.ProxyImage(base+"/a.png", ProxyOptions{})
+	if err != nil || !bytes.Equal(data, png) || contentType != "image/png" {
+		t.Fatalf("ProxyImage = %d bytes, %q, %v", len(data), contentType, err)
+	}
+	if _, _, err := proxy.FetchOriginal(base + "/b.png"); err != nil {
+		t.Errorf("second image while the first is still being sent: %v", err)
+	}
+	if stats := proxy.upstream.Stats(); stats.Timeouts != 0 || stats.Rejected != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestProxyOriginalCoalesced(t *testing.T) {
+	png := encodeTestImage(t, "png", photoImage(64, 64, nil))
+	var requests atomic.Int32
+	proxy, base := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write(png)
+	}, 1<<20)
+
+	// 同一张原图的并发请求只下载一次
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _, _, err := proxy.ProxyImage(base+"/a.png", ProxyOptions{})
+			if err != nil || !bytes.Equal(data, png) {
+				t.Errorf("ProxyImage = %d bytes, %v", len(data), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 1 {
+		t.Errorf("upstream requests = %d, want 1", requests.Load())
+	}
+}
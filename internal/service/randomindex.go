@@ -0,0 +1,954 @@
+package service
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RandomIndexService 随机选图内存索引
+// 按 分类+内容分级+形状 分桶保存所有有效图片ID，随机选取不再需要 ORDER BY RANDOM() 全表扫描
+// 选取时按图片权重加权：先按累计权重二分查找选桶，再在桶内做拒绝采样
+type RandomIndexService struct {
+	mu              sync.RWMutex
+	entries         map[uint]indexEntry
+	buckets         map[bucketKey]*indexBucket
+	categoryWeights map[uint]int // 分类默认权重
+
+	// 索引有任何变更时递增，以下缓存按version失效
+	version uint64
+
+	// 各分桶条件对应的桶及累计权重
+	selectionMu sync.Mutex
+	selections  map[string]*bucketSelection
+
+	// 各筛选条件对应的候选图片
+	poolMu       sync.Mutex
+	pools        map[string]*candidatePool
+	poolCacheIDs int // 缓存中的图片ID总数
+
+	// 固定种子选取结果缓存
+	seededMu    sync.Mutex
+	seededCache map[string]seededResult
+
+	// 全量重建期间的增量变更，换入新数据后按顺序重放，避免丢失
+	loadMu  sync.Mutex
+	loading bool
+	pending []func()
+
+	ready           atomic.Bool
+	refreshInterval time.Duration
+	stopCh          chan struct{}
+}
+
+// indexEntry 索引中的单张图片
+type indexEntry struct {
+	categoryID  uint
+	rating      string
+	imageWeight *int // 图片自身权重，为空时使用分类默认权重
+	weight      int  // 实际生效的权重
+	tags        []uint
+
+	// 宽高（未知时为0）、宽高比及形状（model.ImageShape 的方向，宽高未知时为空），用于设备、分辨率和宽高比筛选
+	width  int
+	height int
+	ratio  float64
+	shape  string
+
+	// 平均亮度（未分析时为-1）和主色调所属颜色分组
+	brightness  float64
+	colorBucket string
+}
+
+// bucketKey 分桶键
+type bucketKey struct {
+	categoryID uint
+	rating     string
+	shape      string
+	device     string // 见 deviceOf
+}
+
+// indexBucket 分桶，ids + 位置表，支持 O(1) 增删和随机选取
+// maxWeight 只增不减（删除时不重新计算），过高只会降低拒绝采样的命中率，不影响正确性，全量重建时会修正
+type indexBucket struct {
+	ids         []uint
+	weights     []int
+	pos         map[uint]int
+	totalWeight int64
+	maxWeight   int
+}
+
+// bucketSelection 符合分桶条件、总权重大于0的桶及其累计权重，索引变更后重新计算
+type bucketSelection struct {
+	version    uint64
+	buckets    []*indexBucket
+	cumulative []int64 // cumulative[i] 为前 i+1 个桶的总权重
+}
+
+// total 所有桶的总权重
+func (sel *bucketSelection) total() int64 {
+	if len(sel.cumulative) == 0 {
+		return 0
+	}
+	return sel.cumulative[len(sel.cumulative)-1]
+}
+
+// pick 按总权重随机选取一个桶，O(log 桶数)
+func (sel *bucketSelection) pick() *indexBucket {
+	n := rand.Int64N(sel.total())
+	i := sort.Search(len(sel.cumulative), func(i int) bool { return sel.cumulative[i] > n })
+	return sel.buckets[i]
+}
+
+// candidatePool 符合筛选条件、权重大于0的图片ID（按ID排序）及其累计权重，索引变更后重新计算
+type candidatePool struct {
+	version    uint64
+	ids        []uint
+	weights    []int
+	cumulative []int64 // cumulative[i] 为前 i+1 张图片的总权重
+}
+
+// pick 按权重随机选取一张不在exclude中的图片，O(log 图片数)
+// 多次选中排除的图片时（图片池几乎全被排除），对剩余图片做一次线性扫描抽样
+func (p *candidatePool) pick(exclude []uint) (uint, bool) {
+	if len(p.cumulative) == 0 || p.cumulative[len(p.cumulative)-1] == 0 {
+		return 0, false
+	}
+	for attempt := 0; attempt < maxRejectionAttempts; attempt++ {
+		n := rand.Int64N(p.cumulative[len(p.cumulative)-1])
+		i := sort.Search(len(p.cumulative), func(i int) bool { return p.cumulative[i] > n })
+		if !containsID(exclude, p.ids[i]) {
+			return p.ids[i], true
+		}
+	}
+
+	ids, weights := p.without(exclude)
+	if picked := weightedSample(ids, weights, 1); len(picked) > 0 {
+		return picked[0], true
+	}
+	return 0, false
+}
+
+// without 去掉exclude中的图片，exclude为空时直接返回缓存的切片
+func (p *candidatePool) without(exclude []uint) ([]uint, []int) {
+	if len(exclude) == 0 {
+		return p.ids, p.weights
+	}
+	ids := make([]uint, 0, len(p.ids))
+	weights := make([]int, 0, len(p.ids))
+	for i, id := range p.ids {
+		if !containsID(exclude, id) {
+			ids = append(ids, id)
+			weights = append(weights, p.weights[i])
+		}
+	}
+	return ids, weights
+}
+
+// indexSnapshot 全量重建时从数据库读取的索引数据
+type indexSnapshot struct {
+	entries         map[uint]indexEntry
+	buckets         map[bucketKey]*indexBucket
+	categoryWeights map[uint]int
+}
+
+// seededResult 固定种子选取结果
+type seededResult struct {
+	id      uint
+	ok      bool
+	version uint64
+}
+
+// maxRejectionAttempts 桶内拒绝采样的最大尝试次数，超过后改为从缓存的候选图片中选取
+const maxRejectionAttempts = 32
+
+// maxSeededCacheSize 固定种子结果缓存上限，超过后整体清空
+const maxSeededCacheSize = 10000
+
+// maxSelectionCacheSize 分桶条件缓存上限，超过后整体清空
+const maxSelectionCacheSize = 1000
+
+// maxPoolCacheIDs 候选图片缓存中的图片ID总数上限，超过后整体清空
+const maxPoolCacheIDs = 1 << 20
+
+var (
+	randomIndexInstance *RandomIndexService
+	randomIndexOnce     sync.Once
+)
+
+// GetRandomIndexService 获取随机索引服务单例
+func GetRandomIndexService() *RandomIndexService {
+	randomIndexOnce.Do(func() {
+		randomIndexInstance = NewRandomIndexService()
+		randomIndexInstance.Start()
+	})
+	return randomIndexInstance
+}
+
+// NewRandomIndexService 创建随机索引服务
+func NewRandomIndexService() *RandomIndexService {
+	return &RandomIndexService{
+		entries:         make(map[uint]indexEntry),
+		buckets:         make(map[bucketKey]*indexBucket),
+		categoryWeights: make(map[uint]int),
+		selections:      make(map[string]*bucketSelection),
+		pools:           make(map[string]*candidatePool),
+		seededCache:     make(map[string]seededResult),
+		refreshInterval: 10 * time.Minute,
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// Start 异步构建索引，并定期全量重建以修正可能的偏差
+// 索引构建完成前 Ready() 返回false，调用方应回退到数据库查询
+func (s *RandomIndexService) Start() {
+	go func() {
+		if err := s.Load(); err != nil {
+			log.Printf("RandomIndex: initial load failed: %v", err)
+		}
+
+		ticker := time.NewTicker(s.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Load(); err != nil {
+					log.Printf("RandomIndex: reload failed: %v", err)
+				}
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台重建任务
+func (s *RandomIndexService) Stop() {
+	close(s.stopCh)
+}
+
+// Ready 索引是否已可用
+func (s *RandomIndexService) Ready() bool {
+	return s.ready.Load()
+}
+
+// Load 从数据库全量构建索引
+// 读取数据库期间的 Upsert/Remove/Refresh/SetCategoryWeight 会被记录，换入新数据后按顺序重放
+func (s *RandomIndexService) Load() error {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	start := time.Now()
+	s.beginLoad()
+	snapshot, err := loadIndexSnapshot()
+	if err != nil {
+		s.finishLoad(nil)
+		return err
+	}
+	s.finishLoad(snapshot)
+	s.ready.Store(true)
+
+	log.Printf("RandomIndex: loaded %d images in %v", len(snapshot.entries), time.Since(start))
+	return nil
+}
+
+// beginLoad 开始记录增量变更
+func (s *RandomIndexService) beginLoad() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = true
+	s.pending = nil
+}
+
+// finishLoad 换入新数据并重放重建期间的增量变更，snapshot为nil时（重建失败）只停止记录
+func (s *RandomIndexService) finishLoad(snapshot *indexSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := s.pending
+	s.loading = false
+	s.pending = nil
+	if snapshot == nil {
+		return
+	}
+
+	s.entries = snapshot.entries
+	s.buckets = snapshot.buckets
+	s.categoryWeights = snapshot.categoryWeights
+	s.version++
+	for _, apply := range pending {
+		apply()
+	}
+}
+
+// recordLocked 全量重建期间记录增量变更（调用方需持有写锁）
+func (s *RandomIndexService) recordLocked(apply func()) {
+	if s.loading {
+		s.pending = append(s.pending, apply)
+	}
+}
+
+// loadIndexSnapshot 从数据库读取所有active图片，构建分桶
+func loadIndexSnapshot() (*indexSnapshot, error) {
+	snapshot := &indexSnapshot{
+		entries:         make(map[uint]indexEntry),
+		buckets:         make(map[bucketKey]*indexBucket),
+		categoryWeights: make(map[uint]int),
+	}
+
+	var categories []model.Category
+	if err := database.DB.Select("id", "default_weight").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	for _, cat := range categories {
+		snapshot.categoryWeights[cat.ID] = cat.DefaultWeight
+	}
+
+	imageTags, err := loadImageTags(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch []model.Image
+	err = database.DB.Select("id", "category_id", "width", "height", "weight", "brightness", "color_bucket", "rating").
+		Where("status = ?", "active").
+		FindInBatches(&batch, 5000, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				entry := newIndexEntry(&batch[i], snapshot.categoryWeights, imageTags[batch[i].ID])
+				snapshot.entries[batch[i].ID] = entry
+				addToBucket(snapshot.buckets, batch[i].ID, entry)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Upsert 新增或更新索引中的图片，非active状态的图片会被移除
+func (s *RandomIndexService) Upsert(images ...*model.Image) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, image := range images {
+		tags := make([]uint, len(image.Tags))
+		for i, tag := range image.Tags {
+			tags[i] = tag.ID
+		}
+		s.upsertLocked(image, tags)
+
+		img := *image
+		s.recordLocked(func() { s.upsertLocked(&img, tags) })
+	}
+}
+
+// Remove 从索引中移除图片
+func (s *RandomIndexService) Remove(ids ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.removeLocked(id)
+	}
+	removed := append([]uint(nil), ids...)
+	s.recordLocked(func() {
+		for _, id := range removed {
+			s.removeLocked(id)
+		}
+	})
+}
+
+// Refresh 从数据库重新读取指定图片并同步到索引（用于批量更新等无法得知最终状态的场景）
+func (s *RandomIndexService) Refresh(ids ...uint) {
+	const chunkSize = 500
+
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := ids[start:end]
+
+		var images []model.Image
+		if err := database.DB.Select("id", "category_id", "width", "height", "weight", "status", "brightness", "color_bucket", "rating").
+			Where("id IN ?", chunk).Find(&images).Error; err != nil {
+			log.Printf("RandomIndex: failed to refresh images: %v", err)
+			continue
+		}
+		imageTags, err := loadImageTags(chunk)
+		if err != nil {
+			log.Printf("RandomIndex: failed to refresh image tags: %v", err)
+			continue
+		}
+
+		apply := func() {
+			for _, id := range chunk {
+				s.removeLocked(id)
+			}
+			for i := range images {
+				s.upsertLocked(&images[i], imageTags[images[i].ID])
+			}
+		}
+		s.mu.Lock()
+		apply()
+		s.recordLocked(apply)
+		s.mu.Unlock()
+	}
+}
+
+// SetCategoryWeight 更新分类默认权重，并重新计算该分类下未单独设置权重的图片
+func (s *RandomIndexService) SetCategoryWeight(categoryID uint, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setCategoryWeightLocked(categoryID, weight)
+	s.recordLocked(func() { s.setCategoryWeightLocked(categoryID, weight) })
+}
+
+// setCategoryWeightLocked 更新分类默认权重（调用方需持有写锁）
+func (s *RandomIndexService) setCategoryWeightLocked(categoryID uint, weight int) {
+	s.categoryWeights[categoryID] = weight
+
+	ids := make([]uint, 0)
+	for key, bucket := range s.buckets {
+		if key.categoryID != categoryID {
+			continue
+		}
+		for _, id := range bucket.ids {
+			if s.entries[id].imageWeight == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		entry := s.entries[id]
+		s.removeLocked(id)
+		entry.weight = weight
+		s.entries[id] = entry
+		addToBucket(s.buckets, id, entry)
+	}
+}
+
+// Pick 按权重随机选取一张符合筛选条件的图片ID
+// 按累计权重选桶、桶内按权重选取；存在逐张判断的条件时做拒绝采样，
+// 多次未命中（符合条件的图片占比很低）时从按筛选条件缓存的候选图片中按累计权重选取
+func (s *RandomIndexService) Pick(filter *ImageFilter) (uint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	selection := s.selectionLocked(filter)
+	if selection.total() == 0 {
+		return 0, false
+	}
+
+	if id, ok := s.samplePickLocked(filter, selection); ok {
+		return id, true
+	}
+	return s.poolLocked(filter).pick(filter.ExcludeIDs)
+}
+
+// PickN 按权重随机选取最多n张不重复的图片ID（加权不放回抽样）
+// 先重复按权重选取并去重；图片池较小或重复过多时，对缓存的候选图片中剩余的部分做一次抽样
+func (s *RandomIndexService) PickN(filter *ImageFilter, n int) []uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	selection := s.selectionLocked(filter)
+	if selection.total() == 0 || n <= 0 {
+		return nil
+	}
+
+	picked := make([]uint, 0, n)
+	seen := make(map[uint]bool, n)
+	for attempt := 0; attempt < 4*n && len(picked) < n; attempt++ {
+		id, ok := s.samplePickLocked(filter, selection)
+		if !ok {
+			break
+		}
+		if !seen[id] {
+			seen[id] = true
+			picked = append(picked, id)
+		}
+	}
+	if len(picked) == n {
+		return picked
+	}
+
+	ids, weights := s.poolLocked(filter).without(filter.ExcludeIDs)
+	remainingIDs := make([]uint, 0, len(ids))
+	remainingWeights := make([]int, 0, len(ids))
+	for i, id := range ids {
+		if !seen[id] {
+			remainingIDs = append(remainingIDs, id)
+			remainingWeights = append(remainingWeights, weights[i])
+		}
+	}
+	return append(picked, weightedSample(remainingIDs, remainingWeights, n-len(picked))...)
+}
+
+// samplePickLocked 按累计权重选桶、桶内按权重选取，存在逐张判断的条件时做有限次数的拒绝采样（调用方需持有读锁）
+func (s *RandomIndexService) samplePickLocked(filter *ImageFilter, selection *bucketSelection) (uint, bool) {
+	attempts := 1
+	if filter.hasEntryConditions() {
+		attempts = maxRejectionAttempts
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		id := selection.pick().pick()
+		entry := s.entries[id]
+		if filter.matchEntry(id, &entry) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// PickSeededN 按固定种子选取最多n张不重复的图片ID，按得分从高到低排列
+// 第一张与 PickSeeded 的结果相同
+func (s *RandomIndexService) PickSeededN(seed string, filter *ImageFilter, n int) []uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids, weights := s.poolLocked(filter).without(filter.ExcludeIDs)
+	return SeededPickN(seed, ids, weights, n)
+}
+
+// PickSeeded 按固定种子选取图片ID，相同种子、筛选条件和图片池总是返回同一张图片
+// 使用加权的最高随机权重（rendezvous）哈希，图片池增删只会影响少量种子的结果
+func (s *RandomIndexService) PickSeeded(seed string, filter *ImageFilter) (uint, bool) {
+	cacheKey := seed + "|" + filter.Key()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.seededMu.Lock()
+	cached, hit := s.seededCache[cacheKey]
+	s.seededMu.Unlock()
+	if hit && cached.version == s.version {
+		return cached.id, cached.ok
+	}
+
+	ids, weights := s.poolLocked(filter).without(filter.ExcludeIDs)
+	id, ok := SeededPick(seed, ids, weights)
+	result := seededResult{id: id, ok: ok, version: s.version}
+
+	s.seededMu.Lock()
+	if len(s.seededCache) >= maxSeededCacheSize {
+		s.seededCache = make(map[string]seededResult)
+	}
+	s.seededCache[cacheKey] = result
+	s.seededMu.Unlock()
+
+	return result.id, result.ok
+}
+
+// Candidates 返回所有符合筛选条件、可参与随机的图片ID（权重为0的图片除外），按ID排序
+// 不排除 filter.ExcludeIDs，调用方需自行跳过（如用 Matches 逐张判断）
+// 结果按筛选条件缓存到索引下次变更，多个调用方共用同一个切片，不能修改
+func (s *RandomIndexService) Candidates(filter *ImageFilter) []uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.poolLocked(filter).ids
+}
+
+// poolLocked 返回符合筛选条件（不含 ExcludeIDs）的候选图片（调用方需持有读锁）
+// 结果按 PoolKey 缓存，排除的图片不同的请求共用同一份缓存，索引变更后的第一次调用重新扫描符合分桶条件的桶
+func (s *RandomIndexService) poolLocked(filter *ImageFilter) *candidatePool {
+	key := filter.PoolKey()
+
+	s.poolMu.Lock()
+	pool, ok := s.pools[key]
+	s.poolMu.Unlock()
+	if ok && pool.version == s.version {
+		return pool
+	}
+
+	base := *filter
+	base.ExcludeIDs = nil
+	pool = newCandidatePool(s.matchingEntriesLocked(&base, s.selectionLocked(&base).buckets))
+	pool.version = s.version
+	ids := pool.ids
+
+	s.poolMu.Lock()
+	if old, ok := s.pools[key]; ok {
+		s.poolCacheIDs -= len(old.ids)
+	}
+	if s.poolCacheIDs+len(ids) > maxPoolCacheIDs {
+		s.pools = make(map[string]*candidatePool)
+		s.poolCacheIDs = 0
+	}
+	s.pools[key] = pool
+	s.poolCacheIDs += len(ids)
+	s.poolMu.Unlock()
+	return pool
+}
+
+// Matches 判断图片当前是否仍可参与指定条件的随机
+func (s *RandomIndexService) Matches(id uint, filter *ImageFilter) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[id]
+	if !ok || entry.weight <= 0 {
+		return false
+	}
+	return filter.matchBucket(entry.bucketKey()) && filter.matchEntry(id, &entry)
+}
+
+// Size 索引中的图片数量
+func (s *RandomIndexService) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// selectionLocked 返回符合分桶条件的桶及累计权重（调用方需持有读锁）
+// 结果按分桶条件缓存，索引变更（version变化）后的第一次调用重新计算
+func (s *RandomIndexService) selectionLocked(filter *ImageFilter) *bucketSelection {
+	key := filter.bucketKey()
+
+	s.selectionMu.Lock()
+	selection, ok := s.selections[key]
+	s.selectionMu.Unlock()
+	if ok && selection.version == s.version {
+		return selection
+	}
+
+	selection = &bucketSelection{version: s.version}
+	var total int64
+	for key, bucket := range s.buckets {
+		if bucket.totalWeight <= 0 || !filter.matchBucket(key) {
+			continue
+		}
+		total += bucket.totalWeight
+		selection.buckets = append(selection.buckets, bucket)
+		selection.cumulative = append(selection.cumulative, total)
+	}
+
+	s.selectionMu.Lock()
+	if len(s.selections) >= maxSelectionCacheSize {
+		s.selections = make(map[string]*bucketSelection)
+	}
+	s.selections[key] = selection
+	s.selectionMu.Unlock()
+	return selection
+}
+
+// matchingEntriesLocked 在给定的桶中逐张筛选，返回符合条件且权重大于0的图片及其权重（调用方需持有读锁）
+func (s *RandomIndexService) matchingEntriesLocked(filter *ImageFilter, buckets []*indexBucket) ([]uint, []int) {
+	ids := make([]uint, 0)
+	weights := make([]int, 0)
+	checkEntry := filter.hasEntryConditions()
+
+	for _, bucket := range buckets {
+		for i, id := range bucket.ids {
+			if bucket.weights[i] <= 0 {
+				continue
+			}
+			if checkEntry {
+				entry := s.entries[id]
+				if !filter.matchEntry(id, &entry) {
+					continue
+				}
+			}
+			ids = append(ids, id)
+			weights = append(weights, bucket.weights[i])
+		}
+	}
+	return ids, weights
+}
+
+// upsertLocked 新增或更新索引中的图片（调用方需持有写锁）
+// 索引项没有变化时不做任何修改，避免无谓地使缓存失效
+func (s *RandomIndexService) upsertLocked(image *model.Image, tags []uint) {
+	if image.Status != "active" {
+		s.removeLocked(image.ID)
+		return
+	}
+	entry := newIndexEntry(image, s.categoryWeights, tags)
+	if old, ok := s.entries[image.ID]; ok && old.equal(&entry) {
+		return
+	}
+	s.removeLocked(image.ID)
+	s.version++
+	s.entries[image.ID] = entry
+	addToBucket(s.buckets, image.ID, entry)
+}
+
+// equal 判断两个索引项是否相同（标签不区分顺序）
+func (e *indexEntry) equal(other *indexEntry) bool {
+	if e.categoryID != other.categoryID || e.rating != other.rating ||
+		e.weight != other.weight || e.width != other.width || e.height != other.height || e.ratio != other.ratio ||
+		e.shape != other.shape || e.brightness != other.brightness || e.colorBucket != other.colorBucket ||
+		(e.imageWeight == nil) != (other.imageWeight == nil) || len(e.tags) != len(other.tags) {
+		return false
+	}
+	if e.imageWeight != nil && *e.imageWeight != *other.imageWeight {
+		return false
+	}
+	for _, tag := range e.tags {
+		if !containsID(other.tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// removeLocked 从索引中移除图片（调用方需持有写锁）
+func (s *RandomIndexService) removeLocked(id uint) {
+	entry, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	delete(s.entries, id)
+	s.version++
+
+	key := entry.bucketKey()
+	bucket := s.buckets[key]
+	if bucket == nil {
+		return
+	}
+
+	// 与末尾元素交换后删除，保持 O(1)
+	i := bucket.pos[id]
+	last := len(bucket.ids) - 1
+	bucket.totalWeight -= int64(bucket.weights[i])
+	bucket.ids[i] = bucket.ids[last]
+	bucket.weights[i] = bucket.weights[last]
+	bucket.pos[bucket.ids[i]] = i
+	bucket.ids = bucket.ids[:last]
+	bucket.weights = bucket.weights[:last]
+	delete(bucket.pos, id)
+
+	if len(bucket.ids) == 0 {
+		delete(s.buckets, key)
+	}
+}
+
+// pick 在桶内按权重随机选取
+// 权重相同时一次命中；权重差异较大且多次未命中时退化为按累计权重线性扫描
+func (b *indexBucket) pick() uint {
+	for attempt := 0; attempt < maxRejectionAttempts; attempt++ {
+		i := rand.IntN(len(b.ids))
+		if b.weights[i] >= b.maxWeight || rand.IntN(b.maxWeight) < b.weights[i] {
+			return b.ids[i]
+		}
+	}
+
+	n := rand.Int64N(b.totalWeight)
+	for i, w := range b.weights {
+		if n < int64(w) {
+			return b.ids[i]
+		}
+		n -= int64(w)
+	}
+	return b.ids[len(b.ids)-1]
+}
+
+// SeededPick 在给定的图片ID和权重中按固定种子选取，与 RandomIndexService.PickSeeded 结果一致
+// 用于索引未就绪时基于数据库查询结果的回退
+func SeededPick(seed string, ids []uint, weights []int) (uint, bool) {
+	seedHash := hashSeed(seed)
+	var bestID uint
+	bestScore := -1.0
+	for i, id := range ids {
+		if score := seededScore(seedHash, id, weights[i]); score > bestScore {
+			bestID, bestScore = id, score
+		}
+	}
+	return bestID, bestScore > 0
+}
+
+// SeededPickN 在给定的图片ID和权重中按固定种子选取得分最高的n张
+func SeededPickN(seed string, ids []uint, weights []int, n int) []uint {
+	type scored struct {
+		id    uint
+		score float64
+	}
+
+	seedHash := hashSeed(seed)
+	items := make([]scored, 0, len(ids))
+	for i, id := range ids {
+		if score := seededScore(seedHash, id, weights[i]); score > 0 {
+			items = append(items, scored{id: id, score: score})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].score > items[j].score })
+
+	result := make([]uint, 0, min(n, len(items)))
+	for i := 0; i < len(items) && i < n; i++ {
+		result = append(result, items[i].id)
+	}
+	return result
+}
+
+// weightedSample 加权不放回抽样，选取最多n个ID
+// 每个ID的排序键为 -ln(u)/weight（指数分布），取最小的n个，等价于逐个按权重抽取
+func weightedSample(ids []uint, weights []int, n int) []uint {
+	type keyed struct {
+		id  uint
+		key float64
+	}
+
+	items := make([]keyed, 0, len(ids))
+	for i, id := range ids {
+		if weights[i] <= 0 {
+			continue
+		}
+		u := 1 - rand.Float64() // (0,1]
+		items = append(items, keyed{id: id, key: -math.Log(u) / float64(weights[i])})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+
+	result := make([]uint, 0, min(n, len(items)))
+	for i := 0; i < len(items) && i < n; i++ {
+		result = append(result, items[i].id)
+	}
+	return result
+}
+
+// hashSeed 计算种子的哈希值，每次选取只计算一次
+func hashSeed(seed string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return h.Sum64()
+}
+
+// seededScore 计算图片在指定种子下的得分：weight / -ln(u)，u 为 (0,1) 内的确定性哈希值
+// 只对整数做混合运算，不做字符串格式化，全量扫描时每张图片只需几纳秒
+func seededScore(seedHash uint64, id uint, weight int) float64 {
+	if weight <= 0 {
+		return 0
+	}
+	x := mix64(seedHash ^ mix64(uint64(id)))
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+	return float64(weight) / -math.Log(u)
+}
+
+// newIndexEntry 根据图片信息生成索引项
+func newIndexEntry(image *model.Image, categoryWeights map[uint]int, tags []uint) indexEntry {
+	entry := indexEntry{
+		categoryID:  image.CategoryID,
+		rating:      image.Rating,
+		imageWeight: image.Weight,
+		weight:      effectiveWeight(image.Weight, categoryWeights, image.CategoryID),
+		tags:        tags,
+		brightness:  -1,
+		colorBucket: image.ColorBucket,
+	}
+	if image.Brightness != nil {
+		entry.brightness = *image.Brightness
+	}
+	if entry.rating == "" {
+		// 未设置时与数据库默认值一致
+		entry.rating = model.RatingSafe
+	}
+
+	if ratio, shape := model.ImageShape(image.Width, image.Height); ratio != nil {
+		entry.width, entry.height = *image.Width, *image.Height
+		entry.ratio, entry.shape = *ratio, shape
+	}
+	return entry
+}
+
+// loadImageTags 读取图片的标签ID，ids为nil时读取全部
+func loadImageTags(ids []uint) (map[uint][]uint, error) {
+	imageTags := make(map[uint][]uint)
+
+	query := database.DB.Model(&model.ImageTag{}).Select("image_id", "tag_id")
+	if ids != nil {
+		query = query.Where("image_id IN ?", ids)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageID, tagID uint
+		if err := rows.Scan(&imageID, &tagID); err != nil {
+			return nil, err
+		}
+		imageTags[imageID] = append(imageTags[imageID], tagID)
+	}
+	return imageTags, rows.Err()
+}
+
+// effectiveWeight 计算图片实际生效的权重：图片权重 > 分类默认权重 > 1
+func effectiveWeight(imageWeight *int, categoryWeights map[uint]int, categoryID uint) int {
+	if imageWeight != nil {
+		return max(*imageWeight, 0)
+	}
+	if weight, ok := categoryWeights[categoryID]; ok {
+		return max(weight, 0)
+	}
+	return 1
+}
+
+// bucketKey 图片所在分桶的键
+func (e *indexEntry) bucketKey() bucketKey {
+	return bucketKey{categoryID: e.categoryID, rating: e.rating, shape: e.shape, device: deviceOf(e.width, e.height)}
+}
+
+// newCandidatePool 按ID排序候选图片并计算累计权重
+func newCandidatePool(ids []uint, weights []int) *candidatePool {
+	order := make([]int, len(ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return ids[order[a]] < ids[order[b]] })
+
+	pool := &candidatePool{
+		ids:        make([]uint, len(ids)),
+		weights:    make([]int, len(ids)),
+		cumulative: make([]int64, len(ids)),
+	}
+	var total int64
+	for i, j := range order {
+		total += int64(weights[j])
+		pool.ids[i], pool.weights[i], pool.cumulative[i] = ids[j], weights[j], total
+	}
+	return pool
+}
+
+// addToBucket 将图片加入对应分桶
+func addToBucket(buckets map[bucketKey]*indexBucket, id uint, entry indexEntry) {
+	key := entry.bucketKey()
+	bucket := buckets[key]
+	if bucket == nil {
+		bucket = &indexBucket{pos: make(map[uint]int)}
+		buckets[key] = bucket
+	}
+	bucket.pos[id] = len(bucket.ids)
+	bucket.ids = append(bucket.ids, id)
+	bucket.weights = append(bucket.weights, entry.weight)
+	bucket.totalWeight += int64(entry.weight)
+	if entry.weight > bucket.maxWeight {
+		bucket.maxWeight = entry.weight
+	}
+}
+
+// containsString 判断切片中是否包含指定字符串
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
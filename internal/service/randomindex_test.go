@@ -0,0 +1,503 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"strconv"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB 使用临时目录中的SQLite数据库替换 database.DB
+func setupTestDB(tb testing.TB) {
+	tb.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(tb.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		tb.Fatalf("open database: %v", err)
+	}
+	database.DB = db
+	if err := database.AutoMigrate(); err != nil {
+		tb.Fatalf("migrate database: %v", err)
+	}
+	tb.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// seedImages 创建 categories 个分类和 n 张图片，横竖屏交替
+func seedImages(tb testing.TB, categories, n int) []model.Image {
+	tb.Helper()
+	for i := 1; i <= categories; i++ {
+		category := model.Category{Name: fmt.Sprintf("c%d", i), Slug: fmt.Sprintf("c%d", i), DefaultWeight: 1}
+		if err := database.DB.Create(&category).Error; err != nil {
+			tb.Fatalf("create category: %v", err)
+		}
+	}
+
+	images := make([]model.Image, n)
+	for i := range images {
+		width, height := 1920, 1080
+		if i%2 == 1 {
+			width, height = 1080, 1920
+		}
+		images[i] = model.Image{
+			SourceURL:  fmt.Sprintf("https://img.example.com/%d.jpg", i),
+			Width:      &width,
+			Height:     &height,
+			Status:     "active",
+			Rating:     model.RatingSafe,
+			CategoryID: uint(i%categories + 1),
+		}
+		images[i].SetShape()
+	}
+	if err := database.DB.CreateInBatches(&images, 500).Error; err != nil {
+		tb.Fatalf("create images: %v", err)
+	}
+	return images
+}
+
+// loadTestIndex 从测试数据库构建索引
+func loadTestIndex(tb testing.TB) *RandomIndexService {
+	tb.Helper()
+	index := NewRandomIndexService()
+	if err := index.Load(); err != nil {
+		tb.Fatalf("load index: %v", err)
+	}
+	return index
+}
+
+func TestPickRespectsBucketConditions(t *testing.T) {
+	setupTestDB(t)
+	seedImages(t, 3, 60)
+	index := loadTestIndex(t)
+
+	filter := &ImageFilter{CategoryIDs: []uint{2}, Device: "mobile", MaxRating: model.RatingSafe}
+	for i := 0; i < 200; i++ {
+		id, ok := index.Pick(filter)
+		if !ok {
+			t.Fatal("expected a match")
+		}
+		entry := index.entries[id]
+		if entry.categoryID != 2 || entry.shape != model.OrientationPortrait {
+			t.Fatalf("picked image %d outside the filter: %+v", id, entry)
+		}
+	}
+
+	if _, ok := index.Pick(&ImageFilter{CategoryIDs: []uint{99}}); ok {
+		t.Fatal("expected no match for an unknown category")
+	}
+}
+
+func TestPickSeesChangesAfterSelectionCached(t *testing.T) {
+	setupTestDB(t)
+	images := seedImages(t, 1, 2)
+	index := loadTestIndex(t)
+
+	filter := &ImageFilter{Device: "pc"}
+	if _, ok := index.Pick(filter); !ok {
+		t.Fatal("expected a landscape image")
+	}
+
+	// 唯一的横屏图片被删除后，缓存的累计权重应随之失效
+	index.Remove(images[0].ID)
+	if id, ok := index.Pick(filter); ok {
+		t.Fatalf("picked removed image %d", id)
+	}
+}
+
+func TestLoadReplaysChangesMadeDuringRebuild(t *testing.T) {
+	setupTestDB(t)
+	images := seedImages(t, 1, 4)
+	index := loadTestIndex(t)
+
+	// 模拟全量重建读取数据库期间的增量变更
+	index.beginLoad()
+	snapshot, err := loadIndexSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := 800, 600
+	added := &model.Image{ID: 1000, CategoryID: 1, Status: "active", Width: &width, Height: &height}
+	index.Upsert(added)
+	index.Remove(images[0].ID)
+	index.finishLoad(snapshot)
+
+	if _, ok := index.entries[added.ID]; !ok {
+		t.Error("image added during the rebuild was lost")
+	}
+	if _, ok := index.entries[images[0].ID]; ok {
+		t.Error("image removed during the rebuild came back")
+	}
+	if got := index.Size(); got != 4 {
+		t.Errorf("Size() = %d, want 4", got)
+	}
+}
+
+// BenchmarkPick 内存索引随机选取
+func BenchmarkPick(b *testing.B) {
+	setupTestDB(b)
+	seedImages(b, 10, 10000)
+	index := loadTestIndex(b)
+	filter := &ImageFilter{CategoryIDs: []uint{1, 2, 3}, Device: "pc", MaxRating: model.RatingSafe}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := index.Pick(filter); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+// BenchmarkOrderByRandom 索引之前的 ORDER BY RANDOM() 查询（现为索引未就绪时的回退路径）
+func BenchmarkOrderByRandom(b *testing.B) {
+	setupTestDB(b)
+	seedImages(b, 10, 10000)
+	filter := &ImageFilter{CategoryIDs: []uint{1, 2, 3}, Device: "pc", MaxRating: model.RatingSafe}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var image model.Image
+		query := filter.Apply(database.DB.Model(&model.Image{}).Where("status = ?", "active"))
+		if err := query.Order("RANDOM()").First(&image).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newWeightedIndex 创建只含给定权重图片的索引（ID从1开始）
+func newWeightedIndex(weights ...int) *RandomIndexService {
+	index := NewRandomIndexService()
+	for i, weight := range weights {
+		w := weight
+		index.Upsert(&model.Image{ID: uint(i + 1), CategoryID: 1, Status: "active", Weight: &w})
+	}
+	index.ready.Store(true)
+	return index
+}
+
+func TestPickFollowsWeights(t *testing.T) {
+	index := newWeightedIndex(1, 9, 0)
+
+	const trials = 20000
+	counts := make(map[uint]int)
+	for i := 0; i < trials; i++ {
+		id, ok := index.Pick(&ImageFilter{})
+		if !ok {
+			t.Fatal("expected a match")
+		}
+		counts[id]++
+	}
+
+	if counts[3] != 0 {
+		t.Errorf("image with weight 0 was picked %d times", counts[3])
+	}
+	// 期望比例 90%，允许 ±2%
+	if share := float64(counts[2]) / trials; share < 0.88 || share > 0.92 {
+		t.Errorf("weight 9 image share = %.3f, want about 0.9", share)
+	}
+}
+
+func TestPickNReturnsDistinctWeightedImages(t *testing.T) {
+	index := newWeightedIndex(5, 1, 1, 0, 1)
+
+	ids := index.PickN(&ImageFilter{}, 10)
+	if len(ids) != 4 {
+		t.Fatalf("PickN returned %d images, want the 4 with weight > 0", len(ids))
+	}
+	seen := make(map[uint]bool)
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("PickN returned %d twice", id)
+		}
+		if id == 4 {
+			t.Fatal("PickN returned the image with weight 0")
+		}
+		seen[id] = true
+	}
+}
+
+func TestWeightedSampleSkipsZeroWeights(t *testing.T) {
+	got := weightedSample([]uint{1, 2, 3}, []int{0, 3, 0}, 3)
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatalf("weightedSample = %v, want [2]", got)
+	}
+}
+
+func TestEffectiveWeight(t *testing.T) {
+	categoryWeights := map[uint]int{1: 4, 2: -1}
+	weight := 7
+	negative := -3
+
+	tests := []struct {
+		name        string
+		imageWeight *int
+		categoryID  uint
+		want        int
+	}{
+		{"image weight wins", &weight, 1, 7},
+		{"category default", nil, 1, 4},
+		{"unknown category", nil, 9, 1},
+		{"negative category weight", nil, 2, 0},
+		{"negative image weight", &negative, 1, 0},
+	}
+	for _, tt := range tests {
+		if got := effectiveWeight(tt.imageWeight, categoryWeights, tt.categoryID); got != tt.want {
+			t.Errorf("%s: effectiveWeight = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetCategoryWeightUpdatesInheritingImages(t *testing.T) {
+	index := NewRandomIndexService()
+	own := 2
+	index.Upsert(
+		&model.Image{ID: 1, CategoryID: 1, Status: "active"},
+		&model.Image{ID: 2, CategoryID: 1, Status: "active", Weight: &own},
+	)
+
+	index.SetCategoryWeight(1, 0)
+	if got := index.entries[1].weight; got != 0 {
+		t.Errorf("inheriting image weight = %d, want 0", got)
+	}
+	if got := index.entries[2].weight; got != 2 {
+		t.Errorf("image with its own weight = %d, want 2", got)
+	}
+	for i := 0; i < 100; i++ {
+		if id, _ := index.Pick(&ImageFilter{}); id != 2 {
+			t.Fatalf("picked image %d whose category weight is 0", id)
+		}
+	}
+}
+
+func TestPickSeededIsStable(t *testing.T) {
+	index := newWeightedIndex(1, 1, 1, 1, 1, 1, 1, 1, 1, 1)
+	filter := &ImageFilter{}
+
+	first, ok := index.PickSeeded("post-1", filter)
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	for i := 0; i < 10; i++ {
+		if id, _ := index.PickSeeded("post-1", filter); id != first {
+			t.Fatalf("seed returned %d, then %d", first, id)
+		}
+	}
+
+	// 不同种子应分散到不同图片
+	picked := make(map[uint]bool)
+	for i := 0; i < 100; i++ {
+		id, _ := index.PickSeeded(fmt.Sprintf("post-%d", i), filter)
+		picked[id] = true
+	}
+	if len(picked) < 5 {
+		t.Errorf("100 seeds only picked %d distinct images", len(picked))
+	}
+}
+
+func TestPickSeededMatchesDatabaseFallback(t *testing.T) {
+	index := newWeightedIndex(3, 1, 0, 2, 5)
+	ids := []uint{1, 2, 3, 4, 5}
+	weights := []int{3, 1, 0, 2, 5}
+
+	for i := 0; i < 50; i++ {
+		seed := fmt.Sprintf("s%d", i)
+		want, _ := SeededPick(seed, ids, weights)
+		if got, _ := index.PickSeeded(seed, &ImageFilter{}); got != want {
+			t.Fatalf("seed %q: index picked %d, fallback picked %d", seed, got, want)
+		}
+		top := SeededPickN(seed, ids, weights, 3)
+		if len(top) != 3 || top[0] != want {
+			t.Fatalf("seed %q: SeededPickN = %v, want %d first", seed, top, want)
+		}
+	}
+}
+
+func TestPickSeededKeepsResultWhenOtherImageRemoved(t *testing.T) {
+	index := newWeightedIndex(1, 1, 1, 1, 1, 1, 1, 1)
+	filter := &ImageFilter{}
+
+	before := make(map[string]uint)
+	for i := 0; i < 50; i++ {
+		seed := fmt.Sprintf("s%d", i)
+		before[seed], _ = index.PickSeeded(seed, filter)
+	}
+
+	index.Remove(8)
+	for seed, id := range before {
+		got, _ := index.PickSeeded(seed, filter)
+		if id != 8 && got != id {
+			t.Fatalf("seed %q moved from %d to %d after removing another image", seed, id, got)
+		}
+	}
+}
+
+// BenchmarkPickSeededMiss 固定种子选取未命中缓存时的全量扫描
+func BenchmarkPickSeededMiss(b *testing.B) {
+	setupTestDB(b)
+	seedImages(b, 10, 10000)
+	index := loadTestIndex(b)
+	filter := &ImageFilter{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := index.PickSeeded(strconv.Itoa(i), filter); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+// newShapedIndex 创建含给定宽高图片的索引（ID从1开始）
+func newShapedIndex(sizes ...[2]int) *RandomIndexService {
+	index := NewRandomIndexService()
+	for i, size := range sizes {
+		width, height := size[0], size[1]
+		index.Upsert(&model.Image{ID: uint(i + 1), CategoryID: 1, Status: "active", Width: &width, Height: &height})
+	}
+	index.Upsert(&model.Image{ID: uint(len(sizes) + 1), CategoryID: 1, Status: "active"}) // 宽高未知
+	index.ready.Store(true)
+	return index
+}
+
+func TestDeviceComparesWidthAndHeight(t *testing.T) {
+	// 1000x990、990x1000 与1相差约1%，按 model.ImageShape 视为正方形，device 仍只比较宽高
+	index := newShapedIndex([2]int{1920, 1080}, [2]int{1080, 1920}, [2]int{1000, 990}, [2]int{3440, 1440},
+		[2]int{990, 1000}, [2]int{800, 800})
+
+	tests := []struct {
+		filter *ImageFilter
+		want   []uint
+	}{
+		{&ImageFilter{Device: "pc"}, []uint{1, 3, 4}},
+		{&ImageFilter{Device: "mobile"}, []uint{2, 5}},
+		{&ImageFilter{Device: "tablet"}, []uint{1, 2, 3, 4, 5, 6, 7}},
+		{&ImageFilter{Orientations: []string{model.OrientationSquare}}, []uint{3, 5, 6}},
+		{&ImageFilter{Device: "pc", Orientations: []string{model.OrientationSquare}}, []uint{3}},
+		{&ImageFilter{Device: "pc", Orientations: []string{model.OrientationUltrawide}}, []uint{4}},
+	}
+	for _, tt := range tests {
+		got := index.Candidates(tt.filter)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: candidates = %v, want %v", tt.filter.Key(), got, tt.want)
+		}
+	}
+}
+
+func TestDeviceFilterMatchesDatabase(t *testing.T) {
+	setupTestDB(t)
+	sizes := [][2]int{{1920, 1080}, {1080, 1920}, {1000, 990}, {3440, 1440}, {990, 1000}, {800, 800}}
+	for _, size := range sizes {
+		width, height := size[0], size[1]
+		image := model.Image{SourceURL: fmt.Sprint(size), Width: &width, Height: &height, Status: "active", CategoryID: 1}
+		image.SetShape()
+		if err := database.DB.Create(&image).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := loadTestIndex(t)
+
+	for _, device := range []string{"pc", "mobile", ""} {
+		filter := &ImageFilter{Device: device}
+		var ids []uint
+		filter.Apply(database.DB.Model(&model.Image{})).Order("id").Pluck("id", &ids)
+		if got := index.Candidates(filter); fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("device %q: index %v, database %v", device, got, ids)
+		}
+	}
+}
+
+func TestPickWithRareEntryCondition(t *testing.T) {
+	weights := make([]int, 1000)
+	for i := range weights {
+		weights[i] = 1
+	}
+	index := newWeightedIndex(weights...)
+	tagged := uint(777)
+	index.mu.Lock()
+	index.upsertLocked(&model.Image{ID: tagged, CategoryID: 1, Status: "active"}, []uint{9})
+	index.mu.Unlock()
+
+	// 1/1000 的命中率下拒绝采样几乎总会失败，应从候选图片缓存中选取
+	filter := &ImageFilter{TagIDs: []uint{9}}
+	for i := 0; i < 20; i++ {
+		if id, ok := index.Pick(filter); !ok || id != tagged {
+			t.Fatalf("Pick = %d, %v; want %d", id, ok, tagged)
+		}
+	}
+	if ids := index.PickN(filter, 5); len(ids) != 1 || ids[0] != tagged {
+		t.Fatalf("PickN = %v, want [%d]", ids, tagged)
+	}
+}
+
+func TestCandidatePoolPickFollowsWeights(t *testing.T) {
+	pool := newCandidatePool([]uint{3, 1, 2}, []int{0, 1, 3})
+	if fmt.Sprint(pool.ids) != "[1 2 3]" || fmt.Sprint(pool.cumulative) != "[1 4 4]" {
+		t.Fatalf("pool = %v / %v", pool.ids, pool.cumulative)
+	}
+	counts := make(map[uint]int)
+	for i := 0; i < 4000; i++ {
+		id, _ := pool.pick(nil)
+		counts[id]++
+	}
+	if counts[3] != 0 || counts[2] < 2700 || counts[2] > 3300 {
+		t.Errorf("pick counts = %v, want about 1000/3000/0", counts)
+	}
+}
+
+// BenchmarkPickRareTag 符合标签条件的图片占比很低时的选取
+func BenchmarkPickRareTag(b *testing.B) {
+	setupTestDB(b)
+	images := seedImages(b, 10, 10000)
+	tag := model.Tag{Name: "rare", Slug: "rare"}
+	database.DB.Create(&tag)
+	for i := 0; i < 10; i++ {
+		database.DB.Create(&model.ImageTag{ImageID: images[i*997].ID, TagID: tag.ID})
+	}
+	index := loadTestIndex(b)
+	filter := &ImageFilter{TagIDs: []uint{tag.ID}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := index.Pick(filter); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+func TestPoolIgnoresExcludeIDs(t *testing.T) {
+	index := newWeightedIndex(1, 1, 1, 1)
+	filter := &ImageFilter{ExcludeIDs: []uint{1, 2, 3}}
+
+	if got := index.Candidates(filter); len(got) != 4 {
+		t.Fatalf("Candidates = %v, want all 4 regardless of ExcludeIDs", got)
+	}
+	for i := 0; i < 50; i++ {
+		if id, ok := index.pickFromPool(filter); !ok || id != 4 {
+			t.Fatalf("pool pick = %d, %v; want 4", id, ok)
+		}
+	}
+	if ids := index.PickN(filter, 3); len(ids) != 1 || ids[0] != 4 {
+		t.Fatalf("PickN = %v, want [4]", ids)
+	}
+	if id, _ := index.PickSeeded("s", filter); id != 4 {
+		t.Fatalf("PickSeeded = %d, want 4", id)
+	}
+	if len(index.pools) != 1 {
+		t.Fatalf("got %d cached pools, want 1", len(index.pools))
+	}
+}
+
+// pickFromPool 直接从候选图片缓存中选取（测试用）
+func (s *RandomIndexService) pickFromPool(filter *ImageFilter) (uint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.poolLocked(filter).pick(filter.ExcludeIDs)
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// FormatAuto 按请求的Accept头选择输出格式
+const FormatAuto = "auto"
+
+// 缩放方式（fit参数取值）
+const (
+	FitCover   = "cover"   // 等比缩放覆盖目标尺寸，居中裁剪多余部分
+	FitContain = "contain" // 等比缩放完整放入目标尺寸，空白处填充背景色
+	FitFill    = "fill"    // 拉伸到目标尺寸，不保持比例
+	FitInside  = "inside"  // 等比缩放到不超过目标尺寸，不放大
+)
+
+const (
+	// MaxProxyDimension 代理输出图片的最大宽高（乘以dpr之后）
+	MaxProxyDimension = 4096
+	// MaxProxyDPR dpr参数上限
+	MaxProxyDPR = 4
+	// DefaultProxyQuality 默认编码质量
+	DefaultProxyQuality = 85
+	// maxProxyPixels 代理允许解码的原图像素数上限
+	maxProxyPixels = 50_000_000
+)
+
+// IsFit 判断是否为支持的缩放方式
+func IsFit(fit string) bool {
+	switch fit {
+	case FitCover, FitContain, FitFill, FitInside:
+		return true
+	}
+	return false
+}
+
+// ProxyOptions 代理图片的处理参数
+type ProxyOptions struct {
+	Compress bool    // 重新编码（按Format和Quality）
+	Format   string  // 输出格式 jpeg/png/webp/auto，为空时保持原格式
+	Accept   string  // format=auto 时客户端明确接受的新格式（avif,webp），由 ParseAcceptedFormats 生成
+	Width    int     // 目标宽度（CSS像素），0表示按高度等比计算
+	Height   int     // 目标高度（CSS像素），0表示按宽度等比计算
+	Fit      string  // 同时指定宽高时的缩放方式，默认cover
+	Quality  int     // 编码质量 1-100，0表示默认值
+	DPR      float64 // 设备像素比，目标尺寸会乘以该值
+}
+
+// Normalize 补全默认值并限制取值范围，使等价的参数得到相同的缓存键
+func (o *ProxyOptions) Normalize() {
+	if o.Quality <= 0 || o.Quality > 100 {
+		o.Quality = DefaultProxyQuality
+	}
+	if o.DPR < 1 {
+		o.DPR = 1
+	}
+	if o.DPR > MaxProxyDPR {
+		o.DPR = MaxProxyDPR
+	}
+	o.DPR = math.Round(o.DPR*100) / 100
+	if o.Format = strings.ToLower(o.Format); o.Format == "jpg" {
+		o.Format = "jpeg"
+	}
+	if o.Format != FormatAuto {
+		o.Accept = ""
+	}
+	if o.Width < 0 {
+		o.Width = 0
+	}
+	if o.Height < 0 {
+		o.Height = 0
+	}
+	if !o.Resize() {
+		o.Fit, o.DPR = "", 1
+	} else if o.Width == 0 || o.Height == 0 {
+		// 只指定一边时只能等比缩放，fit无意义
+		o.Fit = ""
+	} else if !IsFit(o.Fit) {
+		o.Fit = FitCover
+	}
+	if !o.NeedsProcessing() {
+		o.Format, o.Quality = "", DefaultProxyQuality
+	}
+}
+
+// Resize 是否需要缩放
+func (o *ProxyOptions) Resize() bool {
+	return o.Width > 0 || o.Height > 0
+}
+
+// NeedsProcessing 是否需要解码后重新编码，否则直接返回原图
+// format=auto 时需要先识别原图格式，原图格式可直接使用时仍返回原图
+func (o *ProxyOptions) NeedsProcessing() bool {
+	return o.Compress || o.Resize() || o.Format == FormatAuto
+}
+
+// Key 处理参数的规范化标识，用于缓存（调用前需先 Normalize）
+// format=auto 时附加协商结果，不同Accept的客户端使用各自的缓存
+func (o *ProxyOptions) Key() string {
+	key := fmt.Sprintf("compress=%t&format=%s&w=%d&h=%d&fit=%s&q=%d&dpr=%g",
+		o.Compress, o.Format, o.Width, o.Height, o.Fit, o.Quality, o.DPR)
+	if o.Format == FormatAuto {
+		key += "&accept=" + o.Accept
+	}
+	return key
+}
+
+// negotiateFormat format=auto 时根据原图格式和客户端接受的格式选择输出格式
+// 客户端接受WebP时优先输出WebP；否则原图为JPEG/PNG/GIF时保持原格式，其余格式转换为JPEG（透明图片在解码后改为PNG）
+func (o *ProxyOptions) negotiateFormat(source string) string {
+	accepts := func(format string) bool {
+		return strings.Contains(o.Accept, format)
+	}
+	switch {
+	case source == "gif" && !o.Resize():
+		// 保留GIF动画
+		return source
+	case source == "avif" && accepts("avif"):
+		return source
+	case accepts("webp"):
+		return "webp"
+	case source == "jpeg" || source == "png" || source == "gif":
+		return source
+	}
+	return "jpeg"
+}
+
+// targetSize 根据原图尺寸计算输出画布尺寸，超过 MaxProxyDimension 时等比缩小
+func (o *ProxyOptions) targetSize(srcW, srcH int) (int, int) {
+	w := float64(o.Width) * o.DPR
+	h := float64(o.Height) * o.DPR
+	ratio := float64(srcW) / float64(srcH)
+
+	switch {
+	case w == 0:
+		w = h * ratio
+	case h == 0:
+		h = w / ratio
+	case o.Fit == FitInside:
+		scale := math.Min(math.Min(w/float64(srcW), h/float64(srcH)), 1)
+		w, h = float64(srcW)*scale, float64(srcH)*scale
+	}
+
+	if limit := float64(MaxProxyDimension); w > limit || h > limit {
+		scale := math.Min(limit/w, limit/h)
+		w, h = w*scale, h*scale
+	}
+	return max(int(math.Round(w)), 1), max(int(math.Round(h)), 1)
+}
+
+// resizeImage 按处理参数缩放/裁剪图片，使用 Catmull-Rom 插值
+// background 为 contain 模式下空白处的填充色
+func resizeImage(src image.Image, opts *ProxyOptions, background color.Color) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := opts.targetSize(srcW, srcH)
+	canvas := image.Rect(0, 0, dstW, dstH)
+	dst := image.NewRGBA(canvas)
+
+	switch opts.Fit {
+	case FitCover:
+		// 按较大的缩放比例覆盖画布，从原图中居中截取与画布比例相同的区域
+		scale := math.Max(float64(dstW)/float64(srcW), float64(dstH)/float64(srcH))
+		cropW := min(int(math.Round(float64(dstW)/scale)), srcW)
+		cropH := min(int(math.Round(float64(dstH)/scale)), srcH)
+		x0 := bounds.Min.X + (srcW-cropW)/2
+		y0 := bounds.Min.Y + (srcH-cropH)/2
+		draw.CatmullRom.Scale(dst, canvas, src, image.Rect(x0, y0, x0+cropW, y0+cropH), draw.Src, nil)
+
+	case FitContain:
+		// 按较小的缩放比例完整放入画布并居中
+		scale := math.Min(float64(dstW)/float64(srcW), float64(dstH)/float64(srcH))
+		w := max(int(math.Round(float64(srcW)*scale)), 1)
+		h := max(int(math.Round(float64(srcH)*scale)), 1)
+		x0, y0 := (dstW-w)/2, (dstH-h)/2
+		draw.Draw(dst, canvas, image.NewUniform(background), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, image.Rect(x0, y0, x0+w, y0+h), src, bounds, draw.Over, nil)
+
+	default:
+		// fill、inside 以及只指定一边时，画布尺寸已经确定，整图缩放即可
+		draw.CatmullRom.Scale(dst, canvas, src, bounds, draw.Src, nil)
+	}
+	return dst
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestProxyOptionsNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ProxyOptions
+		want ProxyOptions
+	}{
+		{"no processing drops format and quality",
+			ProxyOptions{Format: "webp", Quality: 50, DPR: 2},
+			ProxyOptions{Quality: DefaultProxyQuality, DPR: 1}},
+		{"jpg alias and default quality",
+			ProxyOptions{Compress: true, Format: "JPG"},
+			ProxyOptions{Compress: true, Format: "jpeg", Quality: DefaultProxyQuality, DPR: 1}},
+		{"one side drops fit",
+			ProxyOptions{Width: 300, Fit: FitContain, DPR: 1.234},
+			ProxyOptions{Width: 300, Quality: DefaultProxyQuality, DPR: 1.23}},
+		{"both sides default to cover, dpr clamped",
+			ProxyOptions{Width: 300, Height: 200, Fit: "bogus", DPR: 10},
+			ProxyOptions{Width: 300, Height: 200, Fit: FitCover, Quality: DefaultProxyQuality, DPR: MaxProxyDPR}},
+		{"accept only kept for auto",
+			ProxyOptions{Compress: true, Format: "png", Accept: "webp"},
+			ProxyOptions{Compress: true, Format: "png", Quality: DefaultProxyQuality, DPR: 1}},
+	}
+	for _, tt := range tests {
+		tt.opts.Normalize()
+		if tt.opts != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, tt.opts, tt.want)
+		}
+	}
+
+	// 等价的参数得到相同的缓存键
+	a := ProxyOptions{Width: 300, Quality: 200}
+	b := ProxyOptions{Width: 300, Fit: FitFill, DPR: 0.5}
+	a.Normalize()
+	b.Normalize()
+	if a.Key() != b.Key() {
+		t.Errorf("equivalent options have different keys: %s vs %s", a.Key(), b.Key())
+	}
+}
+
+func TestTargetSize(t *testing.T) {
+	tests := []struct {
+		opts       ProxyOptions
+		srcW, srcH int
+		wantW      int
+		wantH      int
+	}{
+		{ProxyOptions{Width: 400}, 1600, 900, 400, 225},
+		{ProxyOptions{Height: 300}, 1600, 900, 533, 300},
+		{ProxyOptions{Width: 400, DPR: 2}, 1600, 900, 800, 450},
+		{ProxyOptions{Width: 300, Height: 300, Fit: FitCover}, 1600, 900, 300, 300},
+		{ProxyOptions{Width: 300, Height: 300, Fit: FitFill}, 1600, 900, 300, 300},
+		{ProxyOptions{Width: 400, Height: 400, Fit: FitInside}, 1600, 900, 400, 225},
+		// inside 不放大
+		{ProxyOptions{Width: 4000, Height: 4000, Fit: FitInside}, 1600, 900, 1600, 900},
+		// 乘以dpr后超过上限时等比缩小
+		{ProxyOptions{Width: 4000, Height: 2000, Fit: FitFill, DPR: 2}, 100, 100, MaxProxyDimension, MaxProxyDimension / 2},
+		// 极端比例至少1像素
+		{ProxyOptions{Width: 10}, 10000, 1, 10, 1},
+	}
+	for _, tt := range tests {
+		if tt.opts.DPR == 0 {
+			tt.opts.DPR = 1
+		}
+		w, h := tt.opts.targetSize(tt.srcW, tt.srcH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("%+v on %dx%d = %dx%d, want %dx%d", tt.opts, tt.srcW, tt.srcH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// bandImage 左中右三等分分别为红、绿、蓝的测试图片
+func bandImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	bands := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, bands[x*3/width])
+		}
+	}
+	return img
+}
+
+func TestResizeImage(t *testing.T) {
+	src := bandImage(300, 100)
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	rgba := func(img image.Image, x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+
+	// cover：正方形画布只保留中间的绿色部分
+	cover := resizeImage(src, &ProxyOptions{Width: 50, Height: 50, Fit: FitCover, DPR: 1}, white)
+	if b := cover.Bounds(); b.Dx() != 50 || b.Dy() != 50 {
+		t.Fatalf("cover size = %v", b)
+	}
+	for _, x := range []int{2, 25, 47} {
+		if c := rgba(cover, x, 25); c.G < 200 || c.R > 50 || c.B > 50 {
+			t.Errorf("cover pixel %d = %v, want green", x, c)
+		}
+	}
+
+	// contain：完整放入，上下留白
+	contain := resizeImage(src, &ProxyOptions{Width: 60, Height: 60, Fit: FitContain, DPR: 1}, white)
+	if c := rgba(contain, 30, 2); c != white {
+		t.Errorf("contain padding = %v, want background", c)
+	}
+	if c := rgba(contain, 3, 30); c.R < 200 || c.G > 50 {
+		t.Errorf("contain left edge = %v, want red", c)
+	}
+	if c := rgba(contain, 57, 30); c.B < 200 || c.G > 50 {
+		t.Errorf("contain right edge = %v, want blue", c)
+	}
+
+	// fill：拉伸后三种颜色都在
+	fill := resizeImage(src, &ProxyOptions{Width: 30, Height: 90, Fit: FitFill, DPR: 1}, white)
+	if b := fill.Bounds(); b.Dx() != 30 || b.Dy() != 90 {
+		t.Fatalf("fill size = %v", b)
+	}
+	if c := rgba(fill, 1, 45); c.R < 200 {
+		t.Errorf("fill left = %v, want red", c)
+	}
+
+	// 原图边界不从0开始时也按原图区域处理
+	sub := bandImage(600, 100).SubImage(image.Rect(300, 0, 600, 100))
+	shifted := resizeImage(sub, &ProxyOptions{Width: 30, DPR: 1}, white)
+	if b := shifted.Bounds(); b.Dx() != 30 || b.Dy() != 10 {
+		t.Fatalf("sub-image size = %v, want 30x10", b)
+	}
+	if c := rgba(shifted, 28, 5); c.B < 200 {
+		t.Errorf("sub-image right = %v, want blue", c)
+	}
+}
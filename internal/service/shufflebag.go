@@ -0,0 +1,223 @@
+package service
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShuffleBagService 不重复随机（洗牌袋）服务
+// 每个客户端 + 筛选条件（不含排除的图片ID）对应一个洗牌袋，取完一轮后才会重新洗牌，保证一轮内不重复
+// 洗牌袋不复制图片ID，只保存本轮的置换种子和游标，按位置从索引缓存的候选图片（按ID排序）中取图，
+// 每个洗牌袋只占用固定的少量内存
+type ShuffleBagService struct {
+	mu      sync.Mutex // 只保护 bags，取图在各洗牌袋自己的锁内进行
+	bags    map[string]*shuffleBag
+	ttl     time.Duration
+	maxBags int
+	index   *RandomIndexService
+	stopCh  chan struct{}
+}
+
+// shuffleBag 单个洗牌袋
+// 本轮按 permute(next+offset, size, seed) 依次访问候选图片的位置；offset 用于避开上一轮的最后一张
+// 候选图片在一轮中途增删时位置会整体偏移，少量图片可能在本轮重复或被跳过，下一轮恢复正常
+type shuffleBag struct {
+	mu        sync.Mutex
+	seed      uint64
+	size      int // 本轮开始时的候选图片数
+	offset    int
+	next      int
+	last      uint
+	expiresAt atomic.Int64 // Unix纳秒
+}
+
+var (
+	shuffleBagInstance *ShuffleBagService
+	shuffleBagOnce     sync.Once
+)
+
+// GetShuffleBagService 获取洗牌袋服务单例
+func GetShuffleBagService() *ShuffleBagService {
+	shuffleBagOnce.Do(func() {
+		shuffleBagInstance = NewShuffleBagService(GetRandomIndexService())
+		shuffleBagInstance.start()
+	})
+	return shuffleBagInstance
+}
+
+// NewShuffleBagService 创建洗牌袋服务
+func NewShuffleBagService(index *RandomIndexService) *ShuffleBagService {
+	return &ShuffleBagService{
+		bags:    make(map[string]*shuffleBag),
+		ttl:     time.Hour,
+		maxBags: 100000,
+		index:   index,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// start 启动过期清理任务
+func (s *ShuffleBagService) start() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanup()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止清理任务
+func (s *ShuffleBagService) Stop() {
+	close(s.stopCh)
+}
+
+// Next 从客户端的洗牌袋中取出下一张图片ID
+// 索引未就绪、没有客户端标识或没有符合条件的图片时返回false
+func (s *ShuffleBagService) Next(clientKey string, filter *ImageFilter) (uint, bool) {
+	if !s.index.Ready() || clientKey == "" {
+		return 0, false
+	}
+
+	bag := s.bag(clientKey + "|" + filter.PoolKey())
+	bag.mu.Lock()
+	defer bag.mu.Unlock()
+	bag.expiresAt.Store(time.Now().Add(s.ttl).UnixNano())
+
+	ids := s.index.Candidates(filter)
+	refilled := false
+	for {
+		if bag.next >= bag.size {
+			// 每次调用最多重新洗牌一次，避免图片池为空时死循环
+			if refilled {
+				return 0, false
+			}
+			bag.refill(ids)
+			refilled = true
+			if bag.size == 0 {
+				return 0, false
+			}
+		}
+
+		pos := bag.position(bag.next)
+		bag.next++
+
+		// 跳过本轮期间被删除、停用或不再符合条件的图片
+		if pos < len(ids) && s.index.Matches(ids[pos], filter) {
+			bag.last = ids[pos]
+			return bag.last, true
+		}
+	}
+}
+
+// NextN 从客户端的洗牌袋中连续取出最多n张不重复的图片ID
+// 图片池不足n张时返回全部可用图片
+func (s *ShuffleBagService) NextN(clientKey string, filter *ImageFilter, n int) []uint {
+	ids := make([]uint, 0, n)
+	seen := make(map[uint]bool, n)
+	for attempt := 0; attempt < 2*n && len(ids) < n; attempt++ {
+		id, ok := s.Next(clientKey, filter)
+		if !ok {
+			break
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// bag 获取或创建洗牌袋，数量达到上限时先清理过期的，仍然已满则随机淘汰一个
+func (s *ShuffleBagService) bag(key string) *shuffleBag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bag, ok := s.bags[key]; ok {
+		return bag
+	}
+	if len(s.bags) >= s.maxBags {
+		s.cleanupLocked(time.Now())
+	}
+	if len(s.bags) >= s.maxBags {
+		for k := range s.bags {
+			delete(s.bags, k)
+			break
+		}
+	}
+	bag := &shuffleBag{}
+	s.bags[key] = bag
+	return bag
+}
+
+// refill 以新的置换种子开始新一轮
+func (b *shuffleBag) refill(ids []uint) {
+	b.seed = rand.Uint64()
+	b.size = len(ids)
+	b.offset = 0
+	b.next = 0
+
+	// 避免上一轮的最后一张恰好成为新一轮的第一张
+	if b.size > 1 && ids[permute(0, b.size, b.seed)] == b.last {
+		b.offset = 1
+	}
+}
+
+// position 本轮第i次取图对应的候选图片位置
+func (b *shuffleBag) position(i int) int {
+	return permute((i+b.offset)%b.size, b.size, b.seed)
+}
+
+// permute 以seed为密钥的 [0,n) 上的伪随机置换
+// 在不小于n的 4^k 范围内做4轮Feistel变换，结果超出n时继续变换（cycle walking），平均不超过4次
+func permute(i, n int, seed uint64) int {
+	bits := 1
+	for 1<<(2*bits) < n {
+		bits++
+	}
+	mask := uint64(1)<<bits - 1
+
+	x := uint64(i)
+	for {
+		left, right := x>>bits, x&mask
+		for round := uint64(0); round < 4; round++ {
+			left, right = right, left^(mix64(right^seed+round)&mask)
+		}
+		x = left<<bits | right
+		if x < uint64(n) {
+			return int(x)
+		}
+	}
+}
+
+// mix64 64位整数混合函数（splitmix64的最终变换）
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// cleanup 清理过期的洗牌袋
+func (s *ShuffleBagService) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanupLocked(time.Now())
+}
+
+// cleanupLocked 清理过期的洗牌袋（调用方需持有锁）
+func (s *ShuffleBagService) cleanupLocked(now time.Time) {
+	for key, bag := range s.bags {
+		if now.UnixNano() > bag.expiresAt.Load() {
+			delete(s.bags, key)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import "testing"
+
+func TestPermuteIsBijection(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 16, 17, 100, 1000} {
+		for _, seed := range []uint64{0, 1, 42, 1 << 63} {
+			seen := make([]bool, n)
+			for i := 0; i < n; i++ {
+				p := permute(i, n, seed)
+				if p < 0 || p >= n || seen[p] {
+					t.Fatalf("permute(%d, %d, %d) = %d is out of range or repeated", i, n, seed, p)
+				}
+				seen[p] = true
+			}
+		}
+	}
+}
+
+// newBagTestIndex 创建包含n张图片的就绪索引
+func newBagTestIndex(n int) *RandomIndexService {
+	weights := make([]int, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return newWeightedIndex(weights...)
+}
+
+func TestNextCoversPoolBeforeRepeating(t *testing.T) {
+	const n = 50
+	bags := NewShuffleBagService(newBagTestIndex(n))
+	filter := &ImageFilter{}
+
+	var last uint
+	for round := 0; round < 5; round++ {
+		seen := make(map[uint]bool, n)
+		for i := 0; i < n; i++ {
+			id, ok := bags.Next("client", filter)
+			if !ok {
+				t.Fatal("expected an image")
+			}
+			if seen[id] {
+				t.Fatalf("round %d: image %d repeated", round, id)
+			}
+			if i == 0 && id == last {
+				t.Fatalf("round %d started with the previous round's last image %d", round, id)
+			}
+			seen[id] = true
+			last = id
+		}
+	}
+}
+
+func TestNextKeepsClientsAndFiltersApart(t *testing.T) {
+	bags := NewShuffleBagService(newBagTestIndex(10))
+
+	a := bags.NextN("a", &ImageFilter{}, 10)
+	b := bags.NextN("b", &ImageFilter{}, 10)
+	if len(a) != 10 || len(b) != 10 {
+		t.Fatalf("each client should get the full pool, got %d and %d", len(a), len(b))
+	}
+	if len(bags.bags) != 2 {
+		t.Fatalf("expected 2 bags, got %d", len(bags.bags))
+	}
+}
+
+func TestNextSkipsImagesRemovedMidRound(t *testing.T) {
+	index := newBagTestIndex(10)
+	bags := NewShuffleBagService(index)
+	filter := &ImageFilter{}
+
+	first, _ := bags.Next("client", filter)
+	removed := first%10 + 1
+	index.Remove(removed)
+
+	for i := 0; i < 20; i++ {
+		id, ok := bags.Next("client", filter)
+		if !ok {
+			t.Fatal("expected an image")
+		}
+		if id == removed {
+			t.Fatalf("returned removed image %d", id)
+		}
+	}
+}
+
+func TestNextRequiresClientKey(t *testing.T) {
+	bags := NewShuffleBagService(newBagTestIndex(3))
+	if _, ok := bags.Next("", &ImageFilter{}); ok {
+		t.Fatal("expected no bag without a client key")
+	}
+	if len(bags.bags) != 0 {
+		t.Fatalf("created %d bags for an anonymous client", len(bags.bags))
+	}
+}
+
+func TestBagLimitEvictsOldBags(t *testing.T) {
+	bags := NewShuffleBagService(newBagTestIndex(3))
+	bags.maxBags = 4
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		bags.Next(key, &ImageFilter{})
+	}
+	if len(bags.bags) > 4 {
+		t.Fatalf("bag count %d exceeds the limit", len(bags.bags))
+	}
+}
+
+func TestExcludedIDsShareTheBag(t *testing.T) {
+	const n = 20
+	bags := NewShuffleBagService(newBagTestIndex(n))
+
+	// 批量选取和换图重试会临时排除已选中的图片，不应换成新的洗牌袋
+	seen := make(map[uint]bool, n)
+	var excluded []uint
+	for i := 0; i < n; i++ {
+		id, ok := bags.Next("client", &ImageFilter{ExcludeIDs: excluded})
+		if !ok {
+			t.Fatal("expected an image")
+		}
+		if seen[id] {
+			t.Fatalf("image %d repeated within the round", id)
+		}
+		seen[id] = true
+		if i%3 == 0 {
+			excluded = append(excluded, id)
+		}
+	}
+	if len(bags.bags) != 1 {
+		t.Fatalf("got %d bags, want 1", len(bags.bags))
+	}
+}
+
+func TestNextSkipsExcludedIDs(t *testing.T) {
+	bags := NewShuffleBagService(newBagTestIndex(5))
+	filter := &ImageFilter{ExcludeIDs: []uint{1, 2, 3, 4}}
+	for i := 0; i < 5; i++ {
+		if id, ok := bags.Next("client", filter); !ok || id != 5 {
+			t.Fatalf("Next = %d, %v; want 5", id, ok)
+		}
+	}
+}
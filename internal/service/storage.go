@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"strings"
+	"sync"
+)
+
+// defaultStorageDir 本地存储的默认目录
+const defaultStorageDir = "data/storage"
+
+// storageURLPrefix 存储中的原图使用的地址前缀，如 storage://uploads/ab/abcd....jpg
+const storageURLPrefix = "storage://"
+
+// ErrStorageNotFound 存储中没有该对象
+var ErrStorageNotFound = errors.New("object not found in storage")
+
+// Storage 原图存储后端
+type Storage interface {
+	// Put 保存对象，已存在时覆盖
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open 读取对象的 [0, end] 区间（end<0 表示整个对象），返回读取器和对象总大小
+	Open(ctx context.Context, key string, end int64) (io.ReadCloser, int64, error)
+	// Stat 获取对象大小
+	Stat(ctx context.Context, key string) (int64, error)
+	// Delete 删除对象，不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+}
+
+// StorageService 上传图片和镜像副本等自有原图的存储
+// 后端由环境变量 STORAGE_BACKEND 选择：local（默认，保存在 STORAGE_DIR）或 s3（S3兼容的对象存储）
+// 配置了 STORAGE_PUBLIC_URL 时随机接口直接重定向到公开地址，否则通过代理接口返回
+type StorageService struct {
+	Storage
+	backend   string
+	publicURL string
+}
+
+var (
+	storageInstance *StorageService
+	storageOnce     sync.Once
+)
+
+// GetStorageService 获取存储服务单例，配置无效时所有操作都返回配置错误
+func GetStorageService() *StorageService {
+	storageOnce.Do(func() {
+		storageInstance = &StorageService{
+			backend:   strings.ToLower(os.Getenv("STORAGE_BACKEND")),
+			publicURL: strings.TrimSuffix(os.Getenv("STORAGE_PUBLIC_URL"), "/"),
+		}
+		switch storageInstance.backend {
+		case "", "local":
+			storageInstance.backend = "local"
+			dir := os.Getenv("STORAGE_DIR")
+			if dir == "" {
+				dir = defaultStorageDir
+			}
+			storageInstance.Storage = NewLocalStorage(dir)
+		case "s3":
+			s3, err := NewS3Storage(S3Config{
+				Endpoint:  os.Getenv("S3_ENDPOINT"),
+				Region:    os.Getenv("S3_REGION"),
+				Bucket:    os.Getenv("S3_BUCKET"),
+				AccessKey: os.Getenv("S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("S3_SECRET_KEY"),
+				PathStyle: os.Getenv("S3_PATH_STYLE") != "false",
+			})
+			if err != nil {
+				log.Printf("Storage: invalid s3 config: %v", err)
+				storageInstance.Storage = unavailableStorage{err}
+			} else {
+				storageInstance.Storage = s3
+			}
+		default:
+			err := fmt.Errorf("unknown STORAGE_BACKEND %q (available: local,s3)", storageInstance.backend)
+			log.Printf("Storage: %v", err)
+			storageInstance.Storage = unavailableStorage{err}
+		}
+	})
+	return storageInstance
+}
+
+// Backend 使用的存储后端名称
+func (s *StorageService) Backend() string {
+	return s.backend
+}
+
+// PublicURL 对象的公开访问地址，未配置 STORAGE_PUBLIC_URL 时返回空字符串
+func (s *StorageService) PublicURL(key string) string {
+	if s.publicURL == "" {
+		return ""
+	}
+	return s.publicURL + "/" + key
+}
+
+// DeleteUnreferenced 删除不再被任何图片引用的对象，删除图片后调用，失败只记录日志
+func (s *StorageService) DeleteUnreferenced(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		var count int64
+		if err := database.DB.Model(&model.Image{}).Where("storage_key = ?", key).Count(&count).Error; err != nil || count > 0 {
+			continue
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			log.Printf("Storage: failed to delete %s: %v", key, err)
+		}
+	}
+}
+
+// StorageURL 存储中对象的地址（保存在图片的 source_url 中）
+func StorageURL(key string) string {
+	return storageURLPrefix + key
+}
+
+// StorageKeyFromURL 从 storage:// 地址中取出对象键，不是存储地址时返回false
+func StorageKeyFromURL(rawURL string) (string, bool) {
+	key, ok := strings.CutPrefix(rawURL, storageURLPrefix)
+	return key, ok && key != ""
+}
+
+// ImageOriginURL 读取图片原图使用的地址：上传或已镜像的图片为 storage:// 地址，否则为图源地址
+func ImageOriginURL(image *model.Image) string {
+	if image.StorageKey != "" {
+		return StorageURL(image.StorageKey)
+	}
+	return image.SourceURL
+}
+
+// ContentHash 图片内容的SHA256（十六进制）
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// UploadKey 按内容生成上传图片的对象键，相同内容总是得到相同的键
+func UploadKey(data []byte, format string) string {
+	return contentKey("uploads", ContentHash(data), format)
+}
+
+// MirrorKey 按内容生成镜像副本的对象键，内容相同的图片共用一个对象
+func MirrorKey(data []byte, format string) string {
+	return contentKey("mirrors", ContentHash(data), format)
+}
+
+// contentKey 对象键：目录/哈希前两位/哈希.扩展名
+func contentKey(dir, hash, format string) string {
+	ext := format
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	return fmt.Sprintf("%s/%s/%s.%s", dir, hash[:2], hash, ext)
+}
+
+// readCloser 组合读取器和关闭函数
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// LocalStorage 本地文件系统存储，对象键对应目录下的相对路径
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage 创建本地存储
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+// path 对象键对应的文件路径，拒绝绝对路径和 ..
+func (s *LocalStorage) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+	if key == "" || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put 先写临时文件再重命名，避免读到写了一半的文件
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Open 打开对象文件
+func (s *LocalStorage) Open(ctx context.Context, key string, end int64) (io.ReadCloser, int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrStorageNotFound
+	} else if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if end >= 0 && end < info.Size()-1 {
+		return readCloser{io.LimitReader(f, end+1), f}, info.Size(), nil
+	}
+	return f, info.Size(), nil
+}
+
+// Stat 获取对象文件大小
+func (s *LocalStorage) Stat(ctx context.Context, key string) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrStorageNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Delete 删除对象文件
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// unavailableStorage 配置无效时使用，所有操作都返回配置错误
+type unavailableStorage struct {
+	err error
+}
+
+func (s unavailableStorage) Put(context.Context, string, []byte, string) error {
+	return s.err
+}
+
+func (s unavailableStorage) Open(context.Context, string, int64) (io.ReadCloser, int64, error) {
+	return nil, 0, s.err
+}
+
+func (s unavailableStorage) Stat(context.Context, string) (int64, error) {
+	return 0, s.err
+}
+
+func (s unavailableStorage) Delete(context.Context, string) error {
+	return s.err
+}
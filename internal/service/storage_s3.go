@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout 访问对象存储的超时时间
+const s3Timeout = 60 * time.Second
+
+// emptyPayloadHash 空请求体的SHA256
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config S3兼容对象存储的配置
+type S3Config struct {
+	Endpoint  string // 如 https://s3.us-east-1.amazonaws.com、http://127.0.0.1:9000
+	Region    string // 为空时为 us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // true 时使用 endpoint/bucket/key，false 时使用 bucket.endpoint/key
+	// HTTPClient 访问对象存储使用的客户端，为空时使用超时为60秒的默认客户端
+	HTTPClient *http.Client
+}
+
+// S3Storage S3兼容的对象存储（AWS S3、MinIO、R2等），使用 Signature Version 4 签名
+// endpoint 由管理员配置，不经过外部请求的内网地址检查
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Storage 创建S3存储
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", config.Endpoint)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: s3Timeout}
+	}
+	return &S3Storage{
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+	}, nil
+}
+
+// Put 上传对象
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, data, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Open 下载对象，end>=0 时只请求 [0, end] 区间
+func (s *S3Storage) Open(ctx context.Context, key string, end int64) (io.ReadCloser, int64, error) {
+	header := http.Header{}
+	if end >= 0 {
+		header.Set("Range", fmt.Sprintf("bytes=0-%d", end))
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, nil
+	case http.StatusPartialContent:
+		_, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("s3: invalid Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		return resp.Body, total, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 空对象不能按区间读取
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), 0, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, 0, ErrStorageNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, 0, s3Error(resp)
+	}
+}
+
+// Stat 获取对象大小
+func (s *S3Storage) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, ErrStorageNotFound
+	default:
+		return 0, fmt.Errorf("s3: HEAD %s: status %d", key, resp.StatusCode)
+	}
+}
+
+// Delete 删除对象
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// do 发送签名后的请求
+func (s *S3Storage) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	if key == "" {
+		return nil, errors.New("s3: empty object key")
+	}
+
+	u := *s.endpoint
+	escapedKey := s3EscapePath(key)
+	if s.config.PathStyle {
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + s3EscapePath(s.config.Bucket) + "/" + escapedKey
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + escapedKey
+	}
+	var err error
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %s %s: %w", method, key, err)
+	}
+	return resp, nil
+}
+
+// sign 按 AWS Signature Version 4 为请求签名
+// 签名 host、x-amz-* 以及 Content-Type、Range 请求头
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath 按SigV4规则编码对象键：除 A-Z a-z 0-9 - _ . ~ 和 / 外都进行百分号编码
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error 根据错误响应生成错误（响应体为XML，只取前200字节）
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	return fmt.Errorf("s3: %s %s: status %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStorage(dir)
+	ctx := context.Background()
+	data := []byte("0123456789")
+	key := UploadKey(data, "jpeg")
+
+	if err := s.Put(ctx, key, data, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := s.Stat(ctx, key); err != nil || size != 10 {
+		t.Errorf("Stat = %d, %v", size, err)
+	}
+
+	tests := []struct {
+		end  int64
+		want string
+	}{
+		{-1, "0123456789"},
+		{3, "0123"},
+		{9, "0123456789"},
+		{100, "0123456789"},
+	}
+	for _, tt := range tests {
+		r, size, err := s.Open(ctx, key, tt.end)
+		if err != nil {
+			t.Fatalf("Open(%d): %v", tt.end, err)
+		}
+		got, _ := io.ReadAll(r)
+		r.Close()
+		if string(got) != tt.want || size != 10 {
+			t.Errorf("Open(%d) = %q (size %d), want %q", tt.end, got, size, tt.want)
+		}
+	}
+
+	// 覆盖写入，不留下临时文件
+	if err := s.Put(ctx, key, []byte("new"), ""); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(filepath.Join(dir, filepath.FromSlash(key))))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the object", len(entries))
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Stat after delete: err = %v", err)
+	}
+	if _, _, err := s.Open(ctx, key, -1); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Open after delete: err = %v", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+}
+
+func TestLocalStorageRejectsUnsafeKeys(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"", "../escape.jpg", "a/../../escape.jpg", "/etc/passwd"} {
+		if err := s.Put(ctx, key, []byte("x"), ""); err == nil {
+			t.Errorf("Put(%q) accepted", key)
+		}
+		if _, err := s.Stat(ctx, key); err == nil || errors.Is(err, ErrStorageNotFound) {
+			t.Errorf("Stat(%q): err = %v, want invalid key", key, err)
+		}
+	}
+}
+
+func TestStorageKeys(t *testing.T) {
+	data := []byte("image data")
+	hash := ContentHash(data)
+	if key := UploadKey(data, "jpeg"); key != "uploads/"+hash[:2]+"/"+hash+".jpg" {
+		t.Errorf("UploadKey = %s", key)
+	}
+	if key := MirrorKey(data, "webp"); key != "mirrors/"+hash[:2]+"/"+hash+".webp" {
+		t.Errorf("MirrorKey = %s", key)
+	}
+	if UploadKey(data, "png") != UploadKey(bytes.Clone(data), "png") {
+		t.Error("same content gave different keys")
+	}
+
+	if key, ok := StorageKeyFromURL(StorageURL("uploads/a.jpg")); !ok || key != "uploads/a.jpg" {
+		t.Errorf("StorageKeyFromURL = %q, %v", key, ok)
+	}
+	for _, u := range []string{"storage://", "https://img.example.com/a.jpg"} {
+		if _, ok := StorageKeyFromURL(u); ok {
+			t.Errorf("StorageKeyFromURL(%q) accepted", u)
+		}
+	}
+}
+
+func TestStorageServicePublicURL(t *testing.T) {
+	s := &StorageService{}
+	if u := s.PublicURL("uploads/a.jpg"); u != "" {
+		t.Errorf("without STORAGE_PUBLIC_URL: %q", u)
+	}
+	s.publicURL = "https://cdn.example.com/images"
+	if u := s.PublicURL("uploads/a.jpg"); u != "https://cdn.example.com/images/uploads/a.jpg" {
+		t.Errorf("PublicURL = %q", u)
+	}
+}
+
+func TestUnavailableStorage(t *testing.T) {
+	cause := errors.New("bad config")
+	s := &StorageService{Storage: unavailableStorage{cause}}
+	ctx := context.Background()
+	if err := s.Put(ctx, "a", nil, ""); err != cause {
+		t.Errorf("Put: %v", err)
+	}
+	if _, _, err := s.Open(ctx, "a", -1); err != cause {
+		t.Errorf("Open: %v", err)
+	}
+	if _, err := s.Stat(ctx, "a"); !strings.Contains(err.Error(), "bad config") {
+		t.Errorf("Stat: %v", err)
+	}
+}
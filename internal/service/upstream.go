@@ -0,0 +1,319 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultUpstreamMaxConcurrency 同时进行的上游请求数默认上限
+	defaultUpstreamMaxConcurrency = 32
+	// defaultUpstreamMaxPerHost 同一上游主机同时进行的请求数默认上限
+	defaultUpstreamMaxPerHost = 4
+	// defaultUpstreamMaxQueue 排队等待的请求数默认上限，超过时直接拒绝
+	defaultUpstreamMaxQueue = 256
+	// defaultUpstreamQueueTimeout 排队等待的默认超时时间
+	defaultUpstreamQueueTimeout = 10 * time.Second
+)
+
+// ErrUpstreamBusy 上游请求排队已满或等待超时
+var ErrUpstreamBusy = errors.New("upstream busy")
+
+// errFlightAborted 合并的调用异常退出
+var errFlightAborted = errors.New("coalesced call aborted")
+
+// UpstreamService 限制向图源发起的并发请求
+// 全局和每个主机各有并发上限，超出时排队等待，等待超时或队列已满时返回 ErrUpstreamBusy
+// 同时记录代理合并的重复请求数，供管理后台查看
+type UpstreamService struct {
+	maxConcurrency int
+	maxPerHost     int
+	maxQueue       int
+	queueTimeout   time.Duration
+
+	slots chan struct{} // 全局并发槽位，为nil表示不限制
+
+	mu     sync.Mutex
+	hosts  map[string]*upstreamHost
+	active int
+	queued int
+
+	acquired            atomic.Int64
+	waited              atomic.Int64
+	timeouts            atomic.Int64
+	rejected            atomic.Int64
+	coalescedFetches    atomic.Int64
+	coalescedTransforms atomic.Int64
+}
+
+// upstreamHost 单个主机的并发槽位，没有进行中和排队的请求时删除
+type upstreamHost struct {
+	slots  chan struct{}
+	refs   int
+	active int
+	queued int
+}
+
+// UpstreamStats 上游请求统计
+type UpstreamStats struct {
+	MaxConcurrency      int                 `json:"max_concurrency"`
+	MaxPerHost          int                 `json:"max_per_host"`
+	MaxQueue            int                 `json:"max_queue"`
+	QueueTimeout        float64             `json:"queue_timeout"` // 秒
+	Active              int                 `json:"active"`
+	Queued              int                 `json:"queued"`
+	Acquired            int64               `json:"acquired"`
+	Waited              int64               `json:"waited"`
+	Timeouts            int64               `json:"timeouts"`
+	Rejected            int64               `json:"rejected"`
+	CoalescedFetches    int64               `json:"coalesced_fetches"`
+	CoalescedTransforms int64               `json:"coalesced_transforms"`
+	Hosts               []UpstreamHostStats `json:"hosts"`
+}
+
+// UpstreamHostStats 单个主机的请求统计
+type UpstreamHostStats struct {
+	Host   string `json:"host"`
+	Active int    `json:"active"`
+	Queued int    `json:"queued"`
+}
+
+var (
+	upstreamInstance *UpstreamService
+	upstreamOnce     sync.Once
+)
+
+// GetUpstreamService 获取上游请求限制单例
+// 由环境变量 UPSTREAM_MAX_CONCURRENCY、UPSTREAM_MAX_PER_HOST、UPSTREAM_MAX_QUEUE（0表示不限制）
+// 和 UPSTREAM_QUEUE_TIMEOUT（如 10s）配置
+func GetUpstreamService() *UpstreamService {
+	upstreamOnce.Do(func() {
+		upstreamInstance = &UpstreamService{
+			maxConcurrency: envInt("UPSTREAM_MAX_CONCURRENCY", defaultUpstreamMaxConcurrency),
+			maxPerHost:     envInt("UPSTREAM_MAX_PER_HOST", defaultUpstreamMaxPerHost),
+			maxQueue:       envInt("UPSTREAM_MAX_QUEUE", defaultUpstreamMaxQueue),
+			queueTimeout:   defaultUpstreamQueueTimeout,
+			hosts:          make(map[string]*upstreamHost),
+		}
+		if value := os.Getenv("UPSTREAM_QUEUE_TIMEOUT"); value != "" {
+			if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				upstreamInstance.queueTimeout = d
+			}
+		}
+		if upstreamInstance.maxConcurrency > 0 {
+			upstreamInstance.slots = make(chan struct{}, upstreamInstance.maxConcurrency)
+		}
+	})
+	return upstreamInstance
+}
+
+// envInt 读取非负整数环境变量，未设置或无效时返回默认值
+func envInt(name string, fallback int) int {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// Acquire 为访问rawURL获取全局和主机的并发槽位，返回的函数用于释放
+// 先获取主机槽位再获取全局槽位，避免排队访问繁忙主机的请求占用全局槽位
+func (s *UpstreamService) Acquire(rawURL string) (func(), error) {
+	key := upstreamHostKey(rawURL)
+
+	s.mu.Lock()
+	host := s.hosts[key]
+	if host == nil {
+		host = &upstreamHost{}
+		if s.maxPerHost > 0 {
+			host.slots = make(chan struct{}, s.maxPerHost)
+		}
+		s.hosts[key] = host
+	}
+	host.refs++
+	s.mu.Unlock()
+
+	// 两次排队共用同一个超时
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	acquire := func(slots chan struct{}) error {
+		if slots == nil {
+			return nil
+		}
+		select {
+		case slots <- struct{}{}:
+			return nil
+		default:
+		}
+
+		s.mu.Lock()
+		if s.maxQueue > 0 && s.queued >= s.maxQueue {
+			s.mu.Unlock()
+			s.rejected.Add(1)
+			return fmt.Errorf("%w: queue full", ErrUpstreamBusy)
+		}
+		s.queued++
+		host.queued++
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			s.queued--
+			host.queued--
+			s.mu.Unlock()
+		}()
+
+		if timer == nil {
+			timer = time.NewTimer(s.queueTimeout)
+			s.waited.Add(1)
+		}
+		select {
+		case slots <- struct{}{}:
+			return nil
+		case <-timer.C:
+			s.timeouts.Add(1)
+			return fmt.Errorf("%w: waited %s for %s", ErrUpstreamBusy, s.queueTimeout, key)
+		}
+	}
+
+	if err := acquire(host.slots); err != nil {
+		s.releaseHost(key, host, false)
+		return nil, err
+	}
+	if err := acquire(s.slots); err != nil {
+		s.releaseHost(key, host, true)
+		return nil, err
+	}
+	s.acquired.Add(1)
+	s.mu.Lock()
+	s.active++
+	host.active++
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.active--
+			host.active--
+			s.mu.Unlock()
+			if s.slots != nil {
+				<-s.slots
+			}
+			s.releaseHost(key, host, true)
+		})
+	}, nil
+}
+
+// releaseHost 释放主机槽位（holding为true时）并减少引用，没有引用时删除主机
+func (s *UpstreamService) releaseHost(key string, host *upstreamHost, holding bool) {
+	if holding && host.slots != nil {
+		<-host.slots
+	}
+	s.mu.Lock()
+	host.refs--
+	if host.refs == 0 {
+		delete(s.hosts, key)
+	}
+	s.mu.Unlock()
+}
+
+// Stats 获取上游请求统计，主机按进行中和排队的请求数从多到少排序
+func (s *UpstreamService) Stats() UpstreamStats {
+	stats := UpstreamStats{
+		MaxConcurrency:      s.maxConcurrency,
+		MaxPerHost:          s.maxPerHost,
+		MaxQueue:            s.maxQueue,
+		QueueTimeout:        s.queueTimeout.Seconds(),
+		Acquired:            s.acquired.Load(),
+		Waited:              s.waited.Load(),
+		Timeouts:            s.timeouts.Load(),
+		Rejected:            s.rejected.Load(),
+		CoalescedFetches:    s.coalescedFetches.Load(),
+		CoalescedTransforms: s.coalescedTransforms.Load(),
+		Hosts:               []UpstreamHostStats{},
+	}
+
+	s.mu.Lock()
+	stats.Active, stats.Queued = s.active, s.queued
+	for key, host := range s.hosts {
+		stats.Hosts = append(stats.Hosts, UpstreamHostStats{
+			Host:   key,
+			Active: host.active,
+			Queued: host.queued,
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		a, b := stats.Hosts[i], stats.Hosts[j]
+		if a.Active+a.Queued != b.Active+b.Queued {
+			return a.Active+a.Queued > b.Active+b.Queued
+		}
+		return a.Host < b.Host
+	})
+	return stats
+}
+
+// upstreamHostKey 提取URL的主机（含端口），解析失败时使用原字符串
+func upstreamHostKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return strings.ToLower(u.Host)
+}
+
+// flightGroup 合并相同键的并发调用：进行中的调用完成前，相同键的调用等待并共享其结果
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall 进行中的调用
+type flightCall struct {
+	done        chan struct{}
+	data        []byte
+	contentType string
+	err         error
+}
+
+// Do 执行fn，相同键已有进行中的调用时等待其结果，shared表示结果来自其他调用
+// 返回的数据在调用方之间共享，不能修改
+func (g *flightGroup) Do(key string, fn func() ([]byte, string, error)) (data []byte, contentType string, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.data, call.contentType, true, call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	// fn panic时等待的调用方得到 errFlightAborted
+	call.err = errFlightAborted
+	call.data, call.contentType, call.err = fn()
+	return call.data, call.contentType, false, call.err
+}
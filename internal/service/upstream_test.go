@@ -0,0 +1,368 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestUpstream 创建上游请求限制，maxConcurrency 为0时不限制全局并发
+func newTestUpstream(maxConcurrency, maxPerHost, maxQueue int, queueTimeout time.Duration) *UpstreamService {
+	s := &UpstreamService{
+		maxConcurrency: maxConcurrency,
+		maxPerHost:     maxPerHost,
+		maxQueue:       maxQueue,
+		queueTimeout:   queueTimeout,
+		hosts:          make(map[string]*upstreamHost),
+	}
+	if maxConcurrency > 0 {
+		s.slots = make(chan struct{}, maxConcurrency)
+	}
+	return s
+}
+
+// allowOutbound 测试期间替换出站请求的允许列表（如允许访问 httptest 的本机地址）
+func allowOutbound(tb testing.TB, value string) {
+	tb.Helper()
+	old := getOutboundAllowlist()
+	outboundAllowlist = parseOutboundAllowlist(value)
+	tb.Cleanup(func() { outboundAllowlist = old })
+}
+
+// waitQueued 等待排队的请求数达到n
+func waitQueued(t *testing.T, s *UpstreamService, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued = %d, want %d", s.Stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpstreamPerHostLimit(t *testing.T) {
+	s := newTestUpstream(0, 2, 0, 30*time.Millisecond)
+	r1, err1 := s.Acquire("https://A.example.com/1.jpg")
+	r2, err2 := s.Acquire("https://a.example.com/2.jpg")
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+
+	// 同一主机（不区分大小写）已满，等待超时
+	if _, err := s.Acquire("https://a.example.com/3.jpg"); !errors.Is(err, ErrUpstreamBusy) {
+		t.Errorf("third request to the same host: err = %v, want ErrUpstreamBusy", err)
+	}
+	// 其他主机不受影响，不同端口算不同主机
+	r3, err := s.Acquire("https://a.example.com:8443/1.jpg")
+	if err != nil {
+		t.Fatalf("other host: %v", err)
+	}
+
+	stats := s.Stats()
+	if stats.Active != 3 || stats.Timeouts != 1 || stats.Waited != 1 || len(stats.Hosts) != 2 || stats.Hosts[0].Host != "a.example.com" {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	r1()
+	r1() // 重复释放无效
+	r2()
+	r3()
+	if stats := s.Stats(); stats.Active != 0 || len(stats.Hosts) != 0 {
+		t.Errorf("after release stats = %+v, want no active requests or hosts", stats)
+	}
+	if r, err := s.Acquire("https://a.example.com/4.jpg"); err != nil {
+		t.Errorf("after release: %v", err)
+	} else {
+		r()
+	}
+}
+
+func TestUpstreamGlobalLimitWaits(t *testing.T) {
+	s := newTestUpstream(1, 0, 0, 2*time.Second)
+	release, err := s.Acquire("https://a.example.com/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		r, err := s.Acquire("https://b.example.com/1.jpg")
+		if err == nil {
+			r()
+		}
+		acquired <- err
+	}()
+	waitQueued(t, s, 1)
+	if stats := s.Stats(); stats.Hosts[0].Queued+stats.Hosts[1].Queued != 1 {
+		t.Errorf("host stats = %+v, want one queued request", stats.Hosts)
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("queued request: %v", err)
+	}
+	if stats := s.Stats(); stats.Acquired != 2 || stats.Waited != 1 || stats.Queued != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestUpstreamQueueFull(t *testing.T) {
+	s := newTestUpstream(0, 1, 1, 2*time.Second)
+	release, _ := s.Acquire("https://a.example.com/1.jpg")
+
+	done := make(chan error)
+	go func() {
+		r, err := s.Acquire("https://a.example.com/2.jpg")
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	waitQueued(t, s, 1)
+
+	// 队列已满时立即拒绝，不等待
+	start := time.Now()
+	if _, err := s.Acquire("https://a.example.com/3.jpg"); !errors.Is(err, ErrUpstreamBusy) {
+		t.Errorf("err = %v, want ErrUpstreamBusy", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("rejected request waited for the queue timeout")
+	}
+	if stats := s.Stats(); stats.Rejected != 1 {
+		t.Errorf("rejected = %d, want 1", stats.Rejected)
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Errorf("queued request: %v", err)
+	}
+}
+
+func TestUpstreamTimeoutReleasesHostSlot(t *testing.T) {
+	// 拿到主机槽位后等待全局槽位超时，需要归还主机槽位
+	s := newTestUpstream(1, 1, 0, 20*time.Millisecond)
+	release, _ := s.Acquire("https://a.example.com/1.jpg")
+	if _, err := s.Acquire("https://b.example.com/1.jpg"); !errors.Is(err, ErrUpstreamBusy) {
+		t.Fatalf("err = %v, want ErrUpstreamBusy", err)
+	}
+	release()
+
+	r, err := s.Acquire("https://b.example.com/2.jpg")
+	if err != nil {
+		t.Fatalf("host slot leaked: %v", err)
+	}
+	r()
+	if stats := s.Stats(); stats.Active != 0 || len(stats.Hosts) != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestUpstreamConcurrentLimits(t *testing.T) {
+	s := newTestUpstream(3, 2, 0, 5*time.Second)
+	hosts := []string{"https://a.example.com/x", "https://b.example.com/x", "https://c.example.com/x"}
+
+	var active, maxActive atomic.Int32
+	perHost := make([]atomic.Int32, len(hosts))
+	var wg sync.WaitGroup
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		go func(h int) {
+			defer wg.Done()
+			release, err := s.Acquire(hosts[h])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := active.Add(1)
+			for {
+				m := maxActive.Load()
+				if n <= m || maxActive.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			if perHost[h].Add(1) > 2 {
+				t.Error("per-host limit exceeded")
+			}
+			time.Sleep(time.Millisecond)
+			perHost[h].Add(-1)
+			active.Add(-1)
+			release()
+		}(i % len(hosts))
+	}
+	wg.Wait()
+
+	if maxActive.Load() > 3 {
+		t.Errorf("max active = %d, want <= 3", maxActive.Load())
+	}
+	if stats := s.Stats(); stats.Acquired != 60 || stats.Active != 0 || stats.Queued != 0 || len(stats.Hosts) != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	var calls atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	fn := func() ([]byte, string, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-unblock
+		return []byte("data"), "image/png", nil
+	}
+
+	var leader sync.WaitGroup
+	leader.Add(1)
+	go func() {
+		defer leader.Done()
+		if _, _, shared, _ := g.Do("k", fn); shared {
+			t.Error("leader result marked as shared")
+		}
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, contentType, shared, err := g.Do("k", fn)
+			if err != nil || string(data) != "data" || contentType != "image/png" {
+				t.Errorf("shared result = %q, %q, %v", data, contentType, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	// 给等待的调用方时间加入进行中的调用
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	leader.Wait()
+	wg.Wait()
+
+	// 每次调用要么执行fn要么共享结果
+	if sharedCount.Load() == 0 || calls.Load()+sharedCount.Load() != 11 {
+		t.Errorf("fn called %d times, %d shared results, want mostly shared and 11 in total", calls.Load(), sharedCount.Load())
+	}
+
+	// 完成后同一个键重新执行
+	before := calls.Load()
+	if _, _, shared, _ := g.Do("k", fn); shared || calls.Load() != before+1 {
+		t.Errorf("call after completion: shared = %v, calls = %d", shared, calls.Load())
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	waiter := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		g.Do("k", func() ([]byte, string, error) {
+			close(started)
+			<-unblock
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, _, _, err := g.Do("k", func() ([]byte, string, error) { return nil, "", nil })
+		waiter <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+
+	// 等待的调用方要么共享到 errFlightAborted，要么在panic清理后自己执行
+	if err := <-waiter; err != nil && !errors.Is(err, errFlightAborted) {
+		t.Errorf("waiter err = %v", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.calls) != 0 {
+		t.Error("panicked call left in the group")
+	}
+}
+
+func TestImageProxyCoalescesUpstreamRequests(t *testing.T) {
+	allowOutbound(t, "127.0.0.1")
+	png := encodeTestImage(t, "png", photoImage(64, 48, nil))
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var active, maxActive atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	upstream := newTestUpstream(0, 1, 0, 5*time.Second)
+	proxy := &ImageProxyService{
+		profiles: &HostProfileService{exact: make(map[string]*hostProfileEntry)},
+		upstream: upstream,
+		maxBytes: 1 << 20,
+	}
+
+	// 同一原图的不同处理参数只下载一次，相同参数只处理一次
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(width int) {
+			defer wg.Done()
+			data, contentType, _, err := proxy.ProxyImage(server.URL+"/same.png", ProxyOptions{Width: width})
+			if err != nil || contentType != "image/png" || len(data) == 0 {
+				t.Errorf("w=%d: %d bytes, %q, %v", width, len(data), contentType, err)
+			}
+		}(10 + i%2*10)
+	}
+	wg.Wait()
+
+	// 不同原图在同一主机上受并发上限限制
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := proxy.FetchOriginal(fmt.Sprintf("%s/%d.png", server.URL, i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if requests["/same.png"] != 1 {
+		t.Errorf("same image fetched %d times, want 1", requests["/same.png"])
+	}
+	if maxActive.Load() != 1 {
+		t.Errorf("max concurrent upstream requests = %d, want 1 (per-host limit)", maxActive.Load())
+	}
+	stats := upstream.Stats()
+	if stats.CoalescedTransforms+stats.CoalescedFetches < 11 || stats.CoalescedTransforms == 0 {
+		t.Errorf("stats = %+v, want 11 requests served from coalesced calls", stats)
+	}
+	if stats.Acquired != 4 {
+		t.Errorf("acquired = %d, want 4 upstream requests", stats.Acquired)
+	}
+}
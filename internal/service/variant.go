@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"randimg/internal/model"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// variantQuality 缩略图的WebP编码质量
+	variantQuality = 80
+	// VariantContentType 缩略图统一输出为WebP
+	VariantContentType = "image/webp"
+	// variantNone 原图无法生成缩略图（不是图片、超过大小上限或数据损坏无法解码）时保存的版本
+	variantNone = "-"
+)
+
+// defaultVariantWidths 默认生成的缩略图宽度
+var defaultVariantWidths = []int{320, 640, 1280}
+
+// VariantService 缩略图服务
+// 获取图片信息时按 VARIANT_WIDTHS 生成比原图窄的WebP缩略图，保存在存储的 variants/ 下，
+// 地址包含原图内容哈希作为版本，原图变化后地址随之变化，可以长期缓存
+type VariantService struct {
+	widths  []int
+	storage *StorageService
+}
+
+var (
+	variantInstance *VariantService
+	variantOnce     sync.Once
+)
+
+// GetVariantService 获取缩略图服务单例，缩略图宽度由环境变量 VARIANT_WIDTHS 配置（逗号分隔，如 320,640,1280）
+func GetVariantService() *VariantService {
+	variantOnce.Do(func() {
+		variantInstance = &VariantService{
+			widths:  parseVariantWidths(os.Getenv("VARIANT_WIDTHS")),
+			storage: GetStorageService(),
+		}
+	})
+	return variantInstance
+}
+
+// parseVariantWidths 解析缩略图宽度列表，忽略无效的值，为空时使用默认值
+func parseVariantWidths(value string) []int {
+	widths := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(value, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || width <= 0 || width > MaxProxyDimension || seen[width] {
+			continue
+		}
+		seen[width] = true
+		widths = append(widths, width)
+	}
+	if len(widths) == 0 {
+		return defaultVariantWidths
+	}
+	sort.Ints(widths)
+	return widths
+}
+
+// VariantKey 缩略图在存储中的对象键
+func VariantKey(imageID uint, version string, width int) string {
+	return fmt.Sprintf("variants/%d/%s/%d.webp", imageID, version, width)
+}
+
+// VariantURL 缩略图的访问地址
+func VariantURL(imageID uint, version string, width int) string {
+	return fmt.Sprintf("/api/variants/%d/%s/%d.webp", imageID, version, width)
+}
+
+// VariantWidths 图片已生成的缩略图宽度
+func VariantWidths(image *model.Image) []int {
+	if image.VariantVersion == "" || image.VariantVersion == variantNone {
+		return nil
+	}
+	widths := make([]int, 0)
+	for _, item := range strings.Split(image.VariantWidths, ",") {
+		if width, err := strconv.Atoi(item); err == nil {
+			widths = append(widths, width)
+		}
+	}
+	return widths
+}
+
+// HasVariant 图片是否已生成该宽度的缩略图
+func HasVariant(image *model.Image, width int) bool {
+	for _, w := range VariantWidths(image) {
+		if w == width {
+			return true
+		}
+	}
+	return false
+}
+
+// FillVariants 根据图片的缩略图信息填充 Variants 和 Srcset
+// srcset 包含所有缩略图，宽度已知时还包含通过代理接口返回的原图
+func FillVariants(image *model.Image) {
+	image.Variants = nil
+	srcset := make([]string, 0)
+	for _, width := range VariantWidths(image) {
+		variant := model.ImageVariant{Width: width, URL: VariantURL(image.ID, image.VariantVersion, width)}
+		if image.Width != nil && image.Height != nil && *image.Width > 0 {
+			variant.Height = int(math.Round(float64(width) * float64(*image.Height) / float64(*image.Width)))
+		}
+		image.Variants = append(image.Variants, variant)
+		srcset = append(srcset, fmt.Sprintf("%s %dw", variant.URL, width))
+	}
+	if len(srcset) > 0 && image.Width != nil && *image.Width > 0 {
+		srcset = append(srcset, fmt.Sprintf("/api/proxy/%d %dw", image.ID, *image.Width))
+	}
+	image.Srcset = strings.Join(srcset, ", ")
+}
+
+// Generate 从原图数据生成缩略图并保存到存储，返回版本和生成的宽度
+// 原图不比任何缩略图宽或格式无法解码（如AVIF）时不生成，只返回版本；
+// 文件头正常但数据损坏无法解码时返回 variantNone，相同的数据重试也不会成功；只有保存失败等错误需要重试
+func (s *VariantService) Generate(ctx context.Context, imageID uint, data []byte) (string, []int, error) {
+	version := ContentHash(data)[:12]
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxProxyPixels {
+		return version, nil, nil
+	}
+
+	widths := make([]int, 0, len(s.widths))
+	for _, width := range s.widths {
+		if width < config.Width {
+			widths = append(widths, width)
+		}
+	}
+	if len(widths) == 0 {
+		return version, nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, errDecodeUnsupported) {
+		return version, nil, nil
+	}
+	if err != nil {
+		log.Printf("Variant: failed to decode image %d: %v", imageID, err)
+		return variantNone, nil, nil
+	}
+
+	for _, width := range widths {
+		opts := ProxyOptions{Width: width, Format: "webp", Quality: variantQuality}
+		opts.Normalize()
+		var buf bytes.Buffer
+		if err := encodeWebP(&buf, resizeImage(img, &opts, color.Transparent), opts.Quality); err != nil {
+			return "", nil, fmt.Errorf("failed to encode webp: %w", err)
+		}
+		if err := s.storage.Put(ctx, VariantKey(imageID, version, width), buf.Bytes(), VariantContentType); err != nil {
+			return "", nil, err
+		}
+	}
+	return version, widths, nil
+}
+
+// Delete 删除图片的所有缩略图，失败只记录日志
+func (s *VariantService) Delete(ctx context.Context, images ...model.Image) {
+	for i := range images {
+		for _, width := range VariantWidths(&images[i]) {
+			key := VariantKey(images[i].ID, images[i].VariantVersion, width)
+			if err := s.storage.Delete(ctx, key); err != nil {
+				log.Printf("Storage: failed to delete %s: %v", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"randimg/internal/database"
+	"randimg/internal/model"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// newTestVariants 创建使用临时本地存储的缩略图服务
+func newTestVariants(t *testing.T, widths ...int) *VariantService {
+	t.Helper()
+	return &VariantService{widths: widths, storage: &StorageService{Storage: NewLocalStorage(t.TempDir()), backend: "local"}}
+}
+
+func TestParseVariantWidths(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"", defaultVariantWidths},
+		{"640, 320,640", []int{320, 640}},
+		{"0,-1,abc", defaultVariantWidths},
+		{"100,99999999", []int{100}},
+	}
+	for _, tt := range tests {
+		if got := parseVariantWidths(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseVariantWidths(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFillVariants(t *testing.T) {
+	width, height := 1000, 750
+	img := model.Image{ID: 7, Width: &width, Height: &height, VariantVersion: "abc", VariantWidths: "320,640"}
+	FillVariants(&img)
+	want := []model.ImageVariant{
+		{Width: 320, Height: 240, URL: "/api/variants/7/abc/320.webp"},
+		{Width: 640, Height: 480, URL: "/api/variants/7/abc/640.webp"},
+	}
+	if !reflect.DeepEqual(img.Variants, want) {
+		t.Errorf("Variants = %+v", img.Variants)
+	}
+	if img.Srcset != "/api/variants/7/abc/320.webp 320w, /api/variants/7/abc/640.webp 640w, /api/proxy/7 1000w" {
+		t.Errorf("Srcset = %q", img.Srcset)
+	}
+	if !HasVariant(&img, 640) || HasVariant(&img, 1280) {
+		t.Error("HasVariant")
+	}
+
+	// 未生成或无法生成时没有缩略图
+	for _, version := range []string{"", variantNone} {
+		img := model.Image{ID: 7, Width: &width, Height: &height, VariantVersion: version, VariantWidths: "320"}
+		FillVariants(&img)
+		if img.Variants != nil || img.Srcset != "" || HasVariant(&img, 320) {
+			t.Errorf("version %q: variants %+v, srcset %q", version, img.Variants, img.Srcset)
+		}
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	s := newTestVariants(t, 32, 64, 128)
+	data := encodeTestImage(t, "png", photoImage(100, 50, nil))
+
+	version, widths, err := s.Generate(context.Background(), 3, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只生成比原图窄的宽度
+	if version != ContentHash(data)[:12] || !reflect.DeepEqual(widths, []int{32, 64}) {
+		t.Fatalf("Generate = %s, %v", version, widths)
+	}
+	for _, width := range widths {
+		r, _, err := s.storage.Open(context.Background(), VariantKey(3, version, width), -1)
+		if err != nil {
+			t.Fatalf("variant %d: %v", width, err)
+		}
+		config, err := webp.DecodeConfig(r)
+		r.Close()
+		if err != nil || config.Width != width || config.Height != width/2 {
+			t.Errorf("variant %d: %+v, %v", width, config, err)
+		}
+	}
+
+	// 删除后存储中不再有缩略图
+	s.Delete(context.Background(), model.Image{ID: 3, VariantVersion: version, VariantWidths: joinInts(widths)})
+	for _, width := range widths {
+		if _, err := s.storage.Stat(context.Background(), VariantKey(3, version, width)); err == nil {
+			t.Errorf("variant %d not deleted", width)
+		}
+	}
+}
+
+func TestGenerateVariantsWithoutOutput(t *testing.T) {
+	s := newTestVariants(t, 32)
+	small := encodeTestImage(t, "png", photoImage(32, 32, nil))
+	large := encodeTestImage(t, "png", photoImage(200, 100, nil))
+	// 文件头完整，像素数据被截断
+	corrupt := large[:len(large)/2]
+	avif := avifTestFile(400, 300, 0)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		version string // 空表示原图内容哈希
+	}{
+		{"not wider than any variant", small, ""},
+		{"not an image", []byte("<html></html>"), ""},
+		{"avif cannot be decoded", avif, ""},
+		{"corrupt data", corrupt, variantNone},
+	}
+	for _, tt := range tests {
+		version, widths, err := s.Generate(context.Background(), 1, tt.data)
+		want := tt.version
+		if want == "" {
+			want = ContentHash(tt.data)[:12]
+		}
+		// 都不需要重试：不返回错误，版本不为空
+		if err != nil || version != want || widths != nil {
+			t.Errorf("%s: Generate = %q, %v, %v, want %q", tt.name, version, widths, err, want)
+		}
+	}
+	if _, _, err := image.Decode(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("corrupt test image decodes")
+	}
+}
+
+func TestProcessTaskCorruptImageNotRetried(t *testing.T) {
+	setupTestDB(t)
+	storage := &StorageService{Storage: NewLocalStorage(t.TempDir()), backend: "local"}
+	data := encodeTestImage(t, "png", photoImage(400, 300, nil))
+	data = data[:len(data)/2]
+	key := UploadKey(data, "png")
+	storage.Put(context.Background(), key, data, "image/png")
+
+	width, height, brightness := 400, 300, 0.5
+	img := model.Image{
+		SourceURL: StorageURL(key), StorageKey: key, Width: &width, Height: &height, Format: "png",
+		Brightness: &brightness, PerceptualHash: "0123456789abcdef", Fingerprint: "x", Status: "active", CategoryID: 1,
+	}
+	if err := database.DB.Create(&img).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ImageFetchService{
+		taskQueue:    make(chan uint, 1),
+		analyzeSlots: make(chan struct{}, 1),
+		stopChan:     make(chan struct{}),
+		proxy:        &ImageProxyService{storage: storage, maxBytes: 1 << 20},
+		variants:     &VariantService{widths: []int{320}, storage: storage},
+	}
+	s.processTask(img.ID)
+
+	var stored model.Image
+	database.DB.First(&stored, img.ID)
+	if stored.VariantVersion != variantNone {
+		t.Fatalf("variant_version = %q, want %q", stored.VariantVersion, variantNone)
+	}
+	// 启动时扫描不再把它加入队列
+	s.scanPendingTasks()
+	if len(s.taskQueue) != 0 {
+		t.Error("corrupt image queued again")
+	}
+}
@@ -0,0 +1,1257 @@
+package service
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// 纯Go实现的VP8关键帧编码器（RFC 6386），供WebP有损输出使用
+// 只使用16x16帧内预测和单一量化参数，不开启环路滤波；
+// 系数概率按统计结果更新，同等画质下体积明显小于JPEG
+
+// 宏块预测模式
+const (
+	vp8PredDC = iota
+	vp8PredTM
+	vp8PredVE
+	vp8PredHE
+	vp8PredModes
+)
+
+// 系数类型（对应 RFC 6386 13.3 节中的 plane）
+const (
+	vp8PlaneYAfterY2 = 0 // 使用Y2时的亮度块，从第1个系数开始
+	vp8PlaneY2       = 1
+	vp8PlaneUV       = 2
+)
+
+const (
+	// vp8MaxDimension VP8帧宽高上限（14位）
+	vp8MaxDimension = 16383
+	// vp8MaxLevel 单个量化系数的最大绝对值（DCT_CAT6可表示的范围）
+	vp8MaxLevel = 2048
+)
+
+var (
+	// 4x4块内各系数位置对应的概率分组（13.3节），第17项用于越界查表
+	vp8Bands = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// 之字形扫描顺序
+	vp8Zigzag = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	// DCT_CAT3~6 附加位的概率（13.2节）
+	vp8CatProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// vp8Quant 各类系数的量化步长，下标0为DC，1为AC
+type vp8Quant struct {
+	y1, y2, uv [2]int32
+}
+
+// newVP8Quant 按量化索引计算量化步长，与解码器的反量化规则一致（14.1节）
+func newVP8Quant(index int) vp8Quant {
+	var q vp8Quant
+	q.y1[0] = int32(vp8DequantDC[index])
+	q.y1[1] = int32(vp8DequantAC[index])
+	q.y2[0] = int32(vp8DequantDC[index]) * 2
+	q.y2[1] = max(int32(vp8DequantAC[index])*155/100, 8)
+	q.uv[0] = int32(vp8DequantDC[min(index, 117)])
+	q.uv[1] = int32(vp8DequantAC[index])
+	return q
+}
+
+// vp8QualityToIndex 把1-100的质量换算为0-127的量化索引（参考libwebp的映射曲线）
+func vp8QualityToIndex(quality int) int {
+	c := float64(quality) / 100
+	linear := c * 2 / 3
+	if c >= 0.75 {
+		linear = 2*c - 1
+	}
+	index := int(127 * (1 - math.Cbrt(linear)))
+	return min(max(index, 0), 127)
+}
+
+// vp8MacroblockInfo 宏块的预测模式和是否跳过系数
+type vp8MacroblockInfo struct {
+	yMode  uint8
+	uvMode uint8
+	skip   bool
+}
+
+// vp8Encoder 单帧编码状态
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	yStride       int
+	uvStride      int
+
+	// 源图像（已补齐到宏块边界）
+	srcY, srcU, srcV []uint8
+	// 重建图像，与解码器输出一致，供后续宏块做帧内预测
+	recY, recU, recV []uint8
+
+	quantIndex int
+	quant      vp8Quant
+
+	mbs []vp8MacroblockInfo
+	// 按解码顺序保存的量化系数：每个块先存有效系数个数，再按之字形顺序存系数
+	levels []int16
+}
+
+// newVP8Encoder 创建编码器，Y/U/V 平面需已补齐到16/8的倍数
+func newVP8Encoder(width, height int, y, u, v []uint8, quality int) *vp8Encoder {
+	mbw, mbh := (width+15)/16, (height+15)/16
+	e := &vp8Encoder{
+		width:    width,
+		height:   height,
+		mbw:      mbw,
+		mbh:      mbh,
+		yStride:  mbw * 16,
+		uvStride: mbw * 8,
+		srcY:     y,
+		srcU:     u,
+		srcV:     v,
+		recY:     make([]uint8, len(y)),
+		recU:     make([]uint8, len(u)),
+		recV:     make([]uint8, len(v)),
+		mbs:      make([]vp8MacroblockInfo, 0, mbw*mbh),
+	}
+	e.quantIndex = vp8QualityToIndex(quality)
+	e.quant = newVP8Quant(e.quantIndex)
+	return e
+}
+
+// encode 编码整帧，返回VP8码流（不含RIFF封装）
+func (e *vp8Encoder) encode() []byte {
+	skipped := 0
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			if e.encodeMacroblock(mbx, mby) {
+				skipped++
+			}
+		}
+	}
+
+	// 第一遍统计各上下文的实际分布，据此决定需要更新的系数概率
+	var counts [4][8][3][11][2]uint32
+	e.writeTokens(&vp8TokenWriter{counts: &counts})
+	probs := vp8DefaultCoeffProb
+	var updates [4][8][3][11]bool
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l := range probs[i][j][k] {
+					c := counts[i][j][k][l]
+					oldProb := probs[i][j][k][l]
+					updateProb := vp8CoeffUpdateProb[i][j][k][l]
+					newProb := vp8ProbFromCounts(c[0], c[1])
+					oldCost := vp8BranchCost(c, oldProb) + vp8BitCost(updateProb, false)
+					newCost := vp8BranchCost(c, newProb) + vp8BitCost(updateProb, true) + 8
+					if newCost < oldCost {
+						probs[i][j][k][l] = newProb
+						updates[i][j][k][l] = true
+					}
+				}
+			}
+		}
+	}
+
+	// 第一分区：帧头和各宏块的预测模式
+	var fp vp8BoolEncoder
+	fp.init()
+	fp.putLiteral(0, 1) // color space
+	fp.putLiteral(0, 1) // clamping type
+	fp.putLiteral(0, 1) // segmentation
+	fp.putLiteral(0, 1) // filter type
+	fp.putLiteral(0, 6) // loop filter level
+	fp.putLiteral(0, 3) // sharpness
+	fp.putLiteral(0, 1) // loop filter deltas
+	fp.putLiteral(0, 2) // 1个DCT分区
+	fp.putLiteral(uint32(e.quantIndex), 7)
+	for i := 0; i < 5; i++ {
+		fp.putLiteral(0, 1) // 不使用量化增量
+	}
+	fp.putLiteral(0, 1) // refresh entropy probs
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l := range probs[i][j][k] {
+					update := updates[i][j][k][l]
+					fp.putBit(vp8CoeffUpdateProb[i][j][k][l], update)
+					if update {
+						fp.putLiteral(uint32(probs[i][j][k][l]), 8)
+					}
+				}
+			}
+		}
+	}
+	useSkip := skipped > 0
+	var skipProb uint8
+	fp.putBit(128, useSkip)
+	if useSkip {
+		skipProb = vp8ProbFromCounts(uint32(len(e.mbs)-skipped), uint32(skipped))
+		fp.putLiteral(uint32(skipProb), 8)
+	}
+	for _, mb := range e.mbs {
+		if useSkip {
+			fp.putBit(skipProb, mb.skip)
+		}
+		fp.putBit(145, true) // 16x16预测
+		switch mb.yMode {
+		case vp8PredDC:
+			fp.putBit(156, false)
+			fp.putBit(163, false)
+		case vp8PredVE:
+			fp.putBit(156, false)
+			fp.putBit(163, true)
+		case vp8PredHE:
+			fp.putBit(156, true)
+			fp.putBit(128, false)
+		case vp8PredTM:
+			fp.putBit(156, true)
+			fp.putBit(128, true)
+		}
+		switch mb.uvMode {
+		case vp8PredDC:
+			fp.putBit(142, false)
+		case vp8PredVE:
+			fp.putBit(142, true)
+			fp.putBit(114, false)
+		case vp8PredHE:
+			fp.putBit(142, true)
+			fp.putBit(114, true)
+			fp.putBit(183, false)
+		case vp8PredTM:
+			fp.putBit(142, true)
+			fp.putBit(114, true)
+			fp.putBit(183, true)
+		}
+	}
+	first := fp.finish()
+
+	// 系数分区
+	var tp vp8BoolEncoder
+	tp.init()
+	e.writeTokens(&vp8TokenWriter{enc: &tp, probs: &probs})
+	tokens := tp.finish()
+
+	// 帧头：关键帧、版本0、显示，后跟第一分区长度、起始码和宽高
+	out := make([]byte, 10, 10+len(first)+len(tokens))
+	tag := uint32(1<<4) | uint32(len(first))<<5
+	out[0], out[1], out[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	out[3], out[4], out[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(out[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(out[8:], uint16(e.height))
+	out = append(out, first...)
+	return append(out, tokens...)
+}
+
+// encodeMacroblock 选择预测模式、变换量化并重建宏块，返回该宏块是否没有任何非零系数
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) bool {
+	mark := len(e.levels)
+	nonZero := false
+
+	// 亮度：选择误差最小的16x16预测模式
+	var pred, bestPred [256]uint8
+	x0, y0 := mbx*16, mby*16
+	yMode, bestSSE := uint8(0), math.MaxInt
+	for mode := uint8(0); mode < vp8PredModes; mode++ {
+		vp8Predict(pred[:], e.recY, e.yStride, x0, y0, 16, mbx, mby, mode)
+		if sse := vp8SSE(pred[:], e.srcY, e.yStride, x0, y0, 16); sse < bestSSE {
+			yMode, bestSSE, bestPred = mode, sse, pred
+		}
+	}
+
+	var (
+		levels [16][16]int32 // 各亮度块的量化系数（光栅顺序）
+		dcs    [16]int32     // 各亮度块的DC，经WHT后单独编码
+		block  [16]int32
+		coeffs [16]int32
+	)
+	for b := 0; b < 16; b++ {
+		bx, by := x0+(b&3)*4, y0+(b>>2)*4
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				block[j*4+i] = int32(e.srcY[(by+j)*e.yStride+bx+i]) - int32(bestPred[((b>>2)*4+j)*16+(b&3)*4+i])
+			}
+		}
+		vp8ForwardDCT(&block, &coeffs)
+		dcs[b] = coeffs[0]
+		for k := 1; k < 16; k++ {
+			levels[b][k] = vp8QuantizeLevel(coeffs[k], e.quant.y1[1], 96)
+		}
+	}
+	var y2 [16]int32
+	vp8ForwardWHT(&dcs, &coeffs)
+	for k := 0; k < 16; k++ {
+		y2[k] = vp8QuantizeLevel(coeffs[k], e.quant.y2[min(k, 1)], 128)
+	}
+
+	// 重建亮度，与解码器的反量化、反变换保持一致
+	var dq [16]int16
+	for k := 0; k < 16; k++ {
+		dq[k] = int16(y2[k] * e.quant.y2[min(k, 1)])
+	}
+	dcOut := vp8InverseWHT(&dq)
+	for j := 0; j < 16; j++ {
+		copy(e.recY[(y0+j)*e.yStride+x0:], bestPred[j*16:j*16+16])
+	}
+	for b := 0; b < 16; b++ {
+		hasAC := false
+		dq[0] = dcOut[b]
+		for k := 1; k < 16; k++ {
+			dq[k] = int16(levels[b][k] * e.quant.y1[1])
+			hasAC = hasAC || levels[b][k] != 0
+		}
+		offset := (y0+(b>>2)*4)*e.yStride + x0 + (b&3)*4
+		if hasAC {
+			vp8InverseDCT(e.recY[offset:], e.yStride, &dq)
+		} else if dq[0] != 0 {
+			vp8InverseDCTDCOnly(e.recY[offset:], e.yStride, dq[0])
+		}
+	}
+
+	nonZero = e.appendLevels(&y2, 0) || nonZero
+	for b := 0; b < 16; b++ {
+		nonZero = e.appendLevels(&levels[b], 1) || nonZero
+	}
+
+	// 色度：U/V共用同一个预测模式
+	var predU, predV, bestU, bestV [64]uint8
+	cx0, cy0 := mbx*8, mby*8
+	uvMode, bestSSE := uint8(0), math.MaxInt
+	for mode := uint8(0); mode < vp8PredModes; mode++ {
+		vp8Predict(predU[:], e.recU, e.uvStride, cx0, cy0, 8, mbx, mby, mode)
+		vp8Predict(predV[:], e.recV, e.uvStride, cx0, cy0, 8, mbx, mby, mode)
+		sse := vp8SSE(predU[:], e.srcU, e.uvStride, cx0, cy0, 8) + vp8SSE(predV[:], e.srcV, e.uvStride, cx0, cy0, 8)
+		if sse < bestSSE {
+			uvMode, bestSSE, bestU, bestV = mode, sse, predU, predV
+		}
+	}
+	for _, plane := range [2]struct {
+		src, rec []uint8
+		pred     *[64]uint8
+	}{{e.srcU, e.recU, &bestU}, {e.srcV, e.recV, &bestV}} {
+		for j := 0; j < 8; j++ {
+			copy(plane.rec[(cy0+j)*e.uvStride+cx0:], plane.pred[j*8:j*8+8])
+		}
+		for b := 0; b < 4; b++ {
+			bx, by := cx0+(b&1)*4, cy0+(b>>1)*4
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					block[j*4+i] = int32(plane.src[(by+j)*e.uvStride+bx+i]) - int32(plane.pred[((b>>1)*4+j)*8+(b&1)*4+i])
+				}
+			}
+			vp8ForwardDCT(&block, &coeffs)
+			var lv [16]int32
+			hasAC := false
+			for k := 0; k < 16; k++ {
+				bias := int32(96)
+				if k == 0 {
+					bias = 128
+				}
+				lv[k] = vp8QuantizeLevel(coeffs[k], e.quant.uv[min(k, 1)], bias)
+				dq[k] = int16(lv[k] * e.quant.uv[min(k, 1)])
+				hasAC = hasAC || (k > 0 && lv[k] != 0)
+			}
+			offset := by*e.uvStride + bx
+			if hasAC {
+				vp8InverseDCT(plane.rec[offset:], e.uvStride, &dq)
+			} else if dq[0] != 0 {
+				vp8InverseDCTDCOnly(plane.rec[offset:], e.uvStride, dq[0])
+			}
+			nonZero = e.appendLevels(&lv, 0) || nonZero
+		}
+	}
+
+	skip := !nonZero
+	if skip {
+		e.levels = e.levels[:mark]
+	}
+	e.mbs = append(e.mbs, vp8MacroblockInfo{yMode: yMode, uvMode: uvMode, skip: skip})
+	return skip
+}
+
+// appendLevels 按之字形顺序保存块中从first开始到最后一个非零系数为止的系数，返回是否有非零系数
+func (e *vp8Encoder) appendLevels(levels *[16]int32, first int) bool {
+	last := -1
+	for n := first; n < 16; n++ {
+		if levels[vp8Zigzag[n]] != 0 {
+			last = n
+		}
+	}
+	count := max(last+1-first, 0)
+	e.levels = append(e.levels, int16(count))
+	for n := first; n < first+count; n++ {
+		e.levels = append(e.levels, int16(levels[vp8Zigzag[n]]))
+	}
+	return count > 0
+}
+
+// writeTokens 按解码顺序输出所有宏块的系数，维护与解码器一致的非零上下文
+func (e *vp8Encoder) writeTokens(w *vp8TokenWriter) {
+	// 上下文下标：0-3 亮度列/行，4-5 U，6-7 V，8 Y2
+	top := make([][9]uint8, e.mbw)
+	pos := 0
+	next := func() []int16 {
+		count := int(e.levels[pos])
+		block := e.levels[pos+1 : pos+1+count]
+		pos += 1 + count
+		return block
+	}
+	for mby := 0; mby < e.mbh; mby++ {
+		var left [9]uint8
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := e.mbs[mby*e.mbw+mbx]
+			up := &top[mbx]
+			if mb.skip {
+				*up = [9]uint8{}
+				left = [9]uint8{}
+				continue
+			}
+			nz := w.writeBlock(vp8PlaneY2, int(left[8]+up[8]), 0, next())
+			left[8], up[8] = nz, nz
+			for y := 0; y < 4; y++ {
+				nz := left[y]
+				for x := 0; x < 4; x++ {
+					nz = w.writeBlock(vp8PlaneYAfterY2, int(nz+up[x]), 1, next())
+					up[x] = nz
+				}
+				left[y] = nz
+			}
+			for c := 4; c < 8; c += 2 {
+				for y := 0; y < 2; y++ {
+					nz := left[c+y]
+					for x := 0; x < 2; x++ {
+						nz = w.writeBlock(vp8PlaneUV, int(nz+up[c+x]), 0, next())
+						up[c+x] = nz
+					}
+					left[c+y] = nz
+				}
+			}
+		}
+	}
+}
+
+// vp8TokenWriter 输出系数token；enc为nil时只统计各分支出现次数
+type vp8TokenWriter struct {
+	enc    *vp8BoolEncoder
+	probs  *[4][8][3][11]uint8
+	counts *[4][8][3][11][2]uint32
+}
+
+// put 输出使用系数概率表的分支
+func (w *vp8TokenWriter) put(plane, band, ctx, node int, bit bool) {
+	if w.enc == nil {
+		w.counts[plane][band][ctx][node][btoi(bit)]++
+		return
+	}
+	w.enc.putBit(w.probs[plane][band][ctx][node], bit)
+}
+
+// putFixed 输出固定概率的位（符号位和附加位）
+func (w *vp8TokenWriter) putFixed(prob uint8, bit bool) {
+	if w.enc != nil {
+		w.enc.putBit(prob, bit)
+	}
+}
+
+// writeBlock 输出一个4x4块的系数（13.2节的token树），返回该块是否有非零系数
+func (w *vp8TokenWriter) writeBlock(plane, ctx, first int, levels []int16) uint8 {
+	band := int(vp8Bands[first])
+	if len(levels) == 0 {
+		w.put(plane, band, ctx, 0, false)
+		return 0
+	}
+	w.put(plane, band, ctx, 0, true)
+	last := first + len(levels) - 1
+	for n := first; n <= last; n++ {
+		v := int(levels[n-first])
+		next := int(vp8Bands[n+1])
+		if v == 0 {
+			w.put(plane, band, ctx, 1, false)
+			band, ctx = next, 0
+			continue
+		}
+		w.put(plane, band, ctx, 1, true)
+		a := v
+		if a < 0 {
+			a = -a
+		}
+		if a == 1 {
+			w.put(plane, band, ctx, 2, false)
+			ctx = 1
+		} else {
+			w.put(plane, band, ctx, 2, true)
+			switch {
+			case a <= 4:
+				w.put(plane, band, ctx, 3, false)
+				if a == 2 {
+					w.put(plane, band, ctx, 4, false)
+				} else {
+					w.put(plane, band, ctx, 4, true)
+					w.put(plane, band, ctx, 5, a == 4)
+				}
+			case a <= 10:
+				w.put(plane, band, ctx, 3, true)
+				w.put(plane, band, ctx, 6, false)
+				if a <= 6 {
+					w.put(plane, band, ctx, 7, false)
+					w.putFixed(159, a == 6)
+				} else {
+					w.put(plane, band, ctx, 7, true)
+					w.putFixed(165, (a-7)&2 != 0)
+					w.putFixed(145, (a-7)&1 != 0)
+				}
+			default:
+				w.put(plane, band, ctx, 3, true)
+				w.put(plane, band, ctx, 6, true)
+				cat := 3
+				for c := 0; c < 3; c++ {
+					if a < 3+(8<<(c+1)) {
+						cat = c
+						break
+					}
+				}
+				w.put(plane, band, ctx, 8, cat >= 2)
+				w.put(plane, band, ctx, 9+cat>>1, cat&1 == 1)
+				extra := a - (3 + 8<<cat)
+				probs := vp8CatProbs[cat]
+				for i, p := range probs {
+					w.putFixed(p, extra>>(len(probs)-1-i)&1 == 1)
+				}
+			}
+			ctx = 2
+		}
+		w.putFixed(128, v < 0)
+		band = next
+		if n < 15 {
+			w.put(plane, band, ctx, 0, n != last)
+		}
+	}
+	return 1
+}
+
+// vp8Predict 计算size x size区域的帧内预测值，边界外的像素按规范取127（上方）和129（左侧）
+func vp8Predict(dst []uint8, rec []uint8, stride, x0, y0, size, mbx, mby int, mode uint8) {
+	var top, left [16]int32
+	topLeft := int32(127)
+	for i := 0; i < size; i++ {
+		top[i], left[i] = 127, 129
+		if mby > 0 {
+			top[i] = int32(rec[(y0-1)*stride+x0+i])
+		}
+		if mbx > 0 {
+			left[i] = int32(rec[(y0+i)*stride+x0-1])
+		}
+	}
+	if mby > 0 {
+		topLeft = 129
+		if mbx > 0 {
+			topLeft = int32(rec[(y0-1)*stride+x0-1])
+		}
+	}
+
+	switch mode {
+	case vp8PredDC:
+		shift := 3
+		if size == 16 {
+			shift = 4
+		}
+		sumTop, sumLeft := int32(0), int32(0)
+		for i := 0; i < size; i++ {
+			sumTop += top[i]
+			sumLeft += left[i]
+		}
+		var dc int32
+		switch {
+		case mbx == 0 && mby == 0:
+			dc = 128
+		case mby == 0:
+			dc = (sumLeft + int32(size/2)) >> shift
+		case mbx == 0:
+			dc = (sumTop + int32(size/2)) >> shift
+		default:
+			dc = (sumTop + sumLeft + int32(size)) >> (shift + 1)
+		}
+		for i := 0; i < size*size; i++ {
+			dst[i] = uint8(dc)
+		}
+	case vp8PredTM:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				dst[j*size+i] = clip8(left[j] + top[i] - topLeft)
+			}
+		}
+	case vp8PredVE:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				dst[j*size+i] = uint8(top[i])
+			}
+		}
+	case vp8PredHE:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				dst[j*size+i] = uint8(left[j])
+			}
+		}
+	}
+}
+
+// vp8SSE 预测值与源图像的误差平方和
+func vp8SSE(pred []uint8, src []uint8, stride, x0, y0, size int) int {
+	sum := 0
+	for j := 0; j < size; j++ {
+		row := src[(y0+j)*stride+x0:]
+		for i := 0; i < size; i++ {
+			d := int(row[i]) - int(pred[j*size+i])
+			sum += d * d
+		}
+	}
+	return sum
+}
+
+// vp8QuantizeLevel 量化一个系数，bias为舍入偏移（1/256步长），较小的偏移使更多小系数归零
+func vp8QuantizeLevel(coeff, q, bias int32) int32 {
+	a := coeff
+	if a < 0 {
+		a = -a
+	}
+	level := (a*256 + q*bias) / (q * 256)
+	// 反量化后需能放入int16
+	level = min(level, vp8MaxLevel, math.MaxInt16/q)
+	if coeff < 0 {
+		return -level
+	}
+	return level
+}
+
+// vp8ForwardDCT 4x4正向DCT（与libvpx的实现相同）
+func vp8ForwardDCT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4:]
+		a1 := (ip[0] + ip[3]) * 8
+		b1 := (ip[1] + ip[2]) * 8
+		c1 := (ip[1] - ip[2]) * 8
+		d1 := (ip[0] - ip[3]) * 8
+		tmp[i*4+0] = a1 + b1
+		tmp[i*4+2] = a1 - b1
+		tmp[i*4+1] = (c1*2217 + d1*5352 + 14500) >> 12
+		tmp[i*4+3] = (d1*2217 - c1*5352 + 7500) >> 12
+	}
+	for i := 0; i < 4; i++ {
+		a1 := tmp[i] + tmp[12+i]
+		b1 := tmp[4+i] + tmp[8+i]
+		c1 := tmp[4+i] - tmp[8+i]
+		d1 := tmp[i] - tmp[12+i]
+		out[i] = (a1 + b1 + 7) >> 4
+		out[8+i] = (a1 - b1 + 7) >> 4
+		out[4+i] = (c1*2217+d1*5352+12000)>>16 + int32(btoi(d1 != 0))
+		out[12+i] = (d1*2217 - c1*5352 + 51000) >> 16
+	}
+}
+
+// vp8ForwardWHT 对16个亮度块的DC做正向Walsh-Hadamard变换
+func vp8ForwardWHT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4:]
+		a1 := (ip[0] + ip[2]) * 4
+		d1 := (ip[1] + ip[3]) * 4
+		c1 := (ip[1] - ip[3]) * 4
+		b1 := (ip[0] - ip[2]) * 4
+		tmp[i*4+0] = a1 + d1 + int32(btoi(a1 != 0))
+		tmp[i*4+1] = b1 + c1
+		tmp[i*4+2] = b1 - c1
+		tmp[i*4+3] = a1 - d1
+	}
+	for i := 0; i < 4; i++ {
+		a1 := tmp[i] + tmp[8+i]
+		d1 := tmp[4+i] + tmp[12+i]
+		c1 := tmp[4+i] - tmp[12+i]
+		b1 := tmp[i] - tmp[8+i]
+		a2, b2, c2, d2 := a1+d1, b1+c1, b1-c1, a1-d1
+		for _, v := range []*int32{&a2, &b2, &c2, &d2} {
+			if *v < 0 {
+				*v++
+			}
+		}
+		out[i] = (a2 + 3) >> 3
+		out[4+i] = (b2 + 3) >> 3
+		out[8+i] = (c2 + 3) >> 3
+		out[12+i] = (d2 + 3) >> 3
+	}
+}
+
+// vp8InverseWHT 反向WHT，返回各亮度块的DC（与解码器实现一致）
+func vp8InverseWHT(in *[16]int16) [16]int16 {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := int32(in[0+i]) + int32(in[12+i])
+		a1 := int32(in[4+i]) + int32(in[8+i])
+		a2 := int32(in[4+i]) - int32(in[8+i])
+		a3 := int32(in[0+i]) - int32(in[12+i])
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	var out [16]int16
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0 := dc + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := dc - m[3+i*4]
+		out[i*4+0] = int16((a0 + a1) >> 3)
+		out[i*4+1] = int16((a3 + a2) >> 3)
+		out[i*4+2] = int16((a0 - a1) >> 3)
+		out[i*4+3] = int16((a3 - a2) >> 3)
+	}
+	return out
+}
+
+// vp8InverseDCT 反向DCT并叠加到预测值上（与解码器实现一致）
+func vp8InverseDCT(dst []uint8, stride int, in *[16]int16) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := int32(in[i]) + int32(in[8+i])
+		b := int32(in[i]) - int32(in[8+i])
+		c := (int32(in[4+i])*c2)>>16 - (int32(in[12+i])*c1)>>16
+		d := (int32(in[4+i])*c1)>>16 + (int32(in[12+i])*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride:]
+		row[0] = clip8(int32(row[0]) + (a+d)>>3)
+		row[1] = clip8(int32(row[1]) + (b+c)>>3)
+		row[2] = clip8(int32(row[2]) + (b-c)>>3)
+		row[3] = clip8(int32(row[3]) + (a-d)>>3)
+	}
+}
+
+// vp8InverseDCTDCOnly 只有DC系数时的反向DCT
+func vp8InverseDCTDCOnly(dst []uint8, stride int, dc int16) {
+	v := (int32(dc) + 4) >> 3
+	for j := 0; j < 4; j++ {
+		row := dst[j*stride:]
+		for i := 0; i < 4; i++ {
+			row[i] = clip8(int32(row[i]) + v)
+		}
+	}
+}
+
+// vp8ProbFromCounts 根据0/1出现次数计算取0的概率
+func vp8ProbFromCounts(zeros, ones uint32) uint8 {
+	total := uint64(zeros) + uint64(ones)
+	if total == 0 {
+		return 128
+	}
+	p := (uint64(zeros)*256 + total/2) / total
+	return uint8(min(max(p, 1), 255))
+}
+
+// vp8BitCost 以给定概率编码一位的代价（比特）
+func vp8BitCost(prob uint8, bit bool) float64 {
+	p := float64(prob) / 256
+	if bit {
+		p = 1 - p
+	}
+	return -math.Log2(p)
+}
+
+// vp8BranchCost 以给定概率编码一组0/1的总代价（比特）
+func vp8BranchCost(counts [2]uint32, prob uint8) float64 {
+	return float64(counts[0])*vp8BitCost(prob, false) + float64(counts[1])*vp8BitCost(prob, true)
+}
+
+// vp8BoolEncoder 布尔熵编码器（RFC 6386 7.3节）
+type vp8BoolEncoder struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func (e *vp8BoolEncoder) init() {
+	e.rng = 255
+	e.bitCount = 24
+}
+
+// putBit 以取0的概率prob/256编码一位
+func (e *vp8BoolEncoder) putBit(prob uint8, bit bool) {
+	split := 1 + ((e.rng-1)*uint32(prob))>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// putLiteral 以均匀概率从高位到低位编码n位无符号数
+func (e *vp8BoolEncoder) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.putBit(128, v>>i&1 == 1)
+	}
+}
+
+// carry 处理进位
+func (e *vp8BoolEncoder) carry() {
+	i := len(e.buf) - 1
+	for ; i >= 0 && e.buf[i] == 255; i-- {
+		e.buf[i] = 0
+	}
+	if i >= 0 {
+		e.buf[i]++
+	}
+}
+
+// finish 输出剩余的位并返回编码结果
+func (e *vp8BoolEncoder) finish() []byte {
+	c := e.bitCount
+	v := e.bottom
+	if v&(1<<(32-c)) != 0 {
+		e.carry()
+	}
+	v <<= c & 7
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for i := 0; i < 4; i++ {
+		e.buf = append(e.buf, byte(v>>24))
+		v <<= 8
+	}
+	return e.buf
+}
+
+func clip8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 以下为规范中的常量表
+
+// 反量化步长（14.1节）
+var vp8DequantDC = [128]uint16{
+	4, 5, 6, 7, 8, 9, 10, 10,
+	11, 12, 13, 14, 15, 16, 17, 17,
+	18, 19, 20, 20, 21, 21, 22, 22,
+	23, 23, 24, 25, 25, 26, 27, 28,
+	29, 30, 31, 32, 33, 34, 35, 36,
+	37, 37, 38, 39, 40, 41, 42, 43,
+	44, 45, 46, 46, 47, 48, 49, 50,
+	51, 52, 53, 54, 55, 56, 57, 58,
+	59, 60, 61, 62, 63, 64, 65, 66,
+	67, 68, 69, 70, 71, 72, 73, 74,
+	75, 76, 76, 77, 78, 79, 80, 81,
+	82, 83, 84, 85, 86, 87, 88, 89,
+	91, 93, 95, 96, 98, 100, 101, 102,
+	104, 106, 108, 110, 112, 114, 116, 118,
+	122, 124, 126, 128, 130, 132, 134, 136,
+	138, 140, 143, 145, 148, 151, 154, 157,
+}
+
+var vp8DequantAC = [128]uint16{
+	4, 5, 6, 7, 8, 9, 10, 11,
+	12, 13, 14, 15, 16, 17, 18, 19,
+	20, 21, 22, 23, 24, 25, 26, 27,
+	28, 29, 30, 31, 32, 33, 34, 35,
+	36, 37, 38, 39, 40, 41, 42, 43,
+	44, 45, 46, 47, 48, 49, 50, 51,
+	52, 53, 54, 55, 56, 57, 58, 60,
+	62, 64, 66, 68, 70, 72, 74, 76,
+	78, 80, 82, 84, 86, 88, 90, 92,
+	94, 96, 98, 100, 102, 104, 106, 108,
+	110, 112, 114, 116, 119, 122, 125, 128,
+	131, 134, 137, 140, 143, 146, 149, 152,
+	155, 158, 161, 164, 167, 170, 173, 177,
+	181, 185, 189, 193, 197, 201, 205, 209,
+	213, 217, 221, 225, 229, 234, 239, 245,
+	249, 254, 259, 264, 269, 274, 279, 284,
+}
+
+// 系数概率的更新概率（13.4节）
+var vp8CoeffUpdateProb = [4][8][3][11]uint8{
+	{
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{176, 246, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 241, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 244, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 246, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{239, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 254, 255, 255, 255, 255, 255, 255},
+			{250, 255, 254, 255, 254, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{217, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{225, 252, 241, 253, 255, 255, 254, 255, 255, 255, 255},
+			{234, 250, 241, 250, 253, 255, 253, 254, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{238, 253, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{247, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{186, 251, 250, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 251, 244, 254, 255, 255, 255, 255, 255, 255, 255},
+			{251, 251, 243, 253, 254, 255, 254, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{236, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 253, 253, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{248, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 254, 252, 254, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 249, 253, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{246, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 254, 251, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{245, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 252, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+}
+
+// 默认系数概率（13.5节）
+var vp8DefaultCoeffProb = [4][8][3][11]uint8{
+	{
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{253, 136, 254, 255, 228, 219, 128, 128, 128, 128, 128},
+			{189, 129, 242, 255, 227, 213, 255, 219, 128, 128, 128},
+			{106, 126, 227, 252, 214, 209, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 98, 248, 255, 236, 226, 255, 255, 128, 128, 128},
+			{181, 133, 238, 254, 221, 234, 255, 154, 128, 128, 128},
+			{78, 134, 202, 247, 198, 180, 255, 219, 128, 128, 128},
+		},
+		{
+			{1, 185, 249, 255, 243, 255, 128, 128, 128, 128, 128},
+			{184, 150, 247, 255, 236, 224, 128, 128, 128, 128, 128},
+			{77, 110, 216, 255, 236, 230, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 101, 251, 255, 241, 255, 128, 128, 128, 128, 128},
+			{170, 139, 241, 252, 236, 209, 255, 255, 128, 128, 128},
+			{37, 116, 196, 243, 228, 255, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 204, 254, 255, 245, 255, 128, 128, 128, 128, 128},
+			{207, 160, 250, 255, 238, 128, 128, 128, 128, 128, 128},
+			{102, 103, 231, 255, 211, 171, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 152, 252, 255, 240, 255, 128, 128, 128, 128, 128},
+			{177, 135, 243, 255, 234, 225, 128, 128, 128, 128, 128},
+			{80, 129, 211, 255, 194, 224, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{246, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{255, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{198, 35, 237, 223, 193, 187, 162, 160, 145, 155, 62},
+			{131, 45, 198, 221, 172, 176, 220, 157, 252, 221, 1},
+			{68, 47, 146, 208, 149, 167, 221, 162, 255, 223, 128},
+		},
+		{
+			{1, 149, 241, 255, 221, 224, 255, 255, 128, 128, 128},
+			{184, 141, 234, 253, 222, 220, 255, 199, 128, 128, 128},
+			{81, 99, 181, 242, 176, 190, 249, 202, 255, 255, 128},
+		},
+		{
+			{1, 129, 232, 253, 214, 197, 242, 196, 255, 255, 128},
+			{99, 121, 210, 250, 201, 198, 255, 202, 128, 128, 128},
+			{23, 91, 163, 242, 170, 187, 247, 210, 255, 255, 128},
+		},
+		{
+			{1, 200, 246, 255, 234, 255, 128, 128, 128, 128, 128},
+			{109, 178, 241, 255, 231, 245, 255, 255, 128, 128, 128},
+			{44, 130, 201, 253, 205, 192, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 132, 239, 251, 219, 209, 255, 165, 128, 128, 128},
+			{94, 136, 225, 251, 218, 190, 255, 255, 128, 128, 128},
+			{22, 100, 174, 245, 186, 161, 255, 199, 128, 128, 128},
+		},
+		{
+			{1, 182, 249, 255, 232, 235, 128, 128, 128, 128, 128},
+			{124, 143, 241, 255, 227, 234, 128, 128, 128, 128, 128},
+			{35, 77, 181, 251, 193, 211, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 157, 247, 255, 236, 231, 255, 255, 128, 128, 128},
+			{121, 141, 235, 255, 225, 227, 255, 255, 128, 128, 128},
+			{45, 99, 188, 251, 195, 217, 255, 224, 128, 128, 128},
+		},
+		{
+			{1, 1, 251, 255, 213, 255, 128, 128, 128, 128, 128},
+			{203, 1, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{137, 1, 177, 255, 224, 255, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{253, 9, 248, 251, 207, 208, 255, 192, 128, 128, 128},
+			{175, 13, 224, 243, 193, 185, 249, 198, 255, 255, 128},
+			{73, 17, 171, 221, 161, 179, 236, 167, 255, 234, 128},
+		},
+		{
+			{1, 95, 247, 253, 212, 183, 255, 255, 128, 128, 128},
+			{239, 90, 244, 250, 211, 209, 255, 255, 128, 128, 128},
+			{155, 77, 195, 248, 188, 195, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 24, 239, 251, 218, 219, 255, 205, 128, 128, 128},
+			{201, 51, 219, 255, 196, 186, 128, 128, 128, 128, 128},
+			{69, 46, 190, 239, 201, 218, 255, 228, 128, 128, 128},
+		},
+		{
+			{1, 191, 251, 255, 255, 128, 128, 128, 128, 128, 128},
+			{223, 165, 249, 255, 213, 255, 128, 128, 128, 128, 128},
+			{141, 124, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 16, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{190, 36, 230, 255, 236, 255, 128, 128, 128, 128, 128},
+			{149, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 226, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{247, 192, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{240, 128, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 134, 252, 255, 255, 128, 128, 128, 128, 128, 128},
+			{213, 62, 250, 255, 255, 128, 128, 128, 128, 128, 128},
+			{55, 93, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{202, 24, 213, 235, 186, 191, 220, 160, 240, 175, 255},
+			{126, 38, 182, 232, 169, 184, 228, 174, 255, 187, 128},
+			{61, 46, 138, 219, 151, 178, 240, 170, 255, 216, 128},
+		},
+		{
+			{1, 112, 230, 250, 199, 191, 247, 159, 255, 255, 128},
+			{166, 109, 228, 252, 211, 215, 255, 174, 128, 128, 128},
+			{39, 77, 162, 232, 172, 180, 245, 178, 255, 255, 128},
+		},
+		{
+			{1, 52, 220, 246, 198, 199, 249, 220, 255, 255, 128},
+			{124, 74, 191, 243, 183, 193, 250, 221, 255, 255, 128},
+			{24, 71, 130, 219, 154, 170, 243, 182, 255, 255, 128},
+		},
+		{
+			{1, 182, 225, 249, 219, 240, 255, 224, 128, 128, 128},
+			{149, 150, 226, 252, 216, 205, 255, 171, 128, 128, 128},
+			{28, 108, 170, 242, 183, 194, 254, 223, 255, 255, 128},
+		},
+		{
+			{1, 81, 230, 252, 204, 203, 255, 192, 128, 128, 128},
+			{123, 102, 209, 247, 188, 196, 255, 233, 128, 128, 128},
+			{20, 95, 153, 243, 164, 173, 255, 203, 128, 128, 128},
+		},
+		{
+			{1, 222, 248, 255, 216, 213, 128, 128, 128, 128, 128},
+			{168, 175, 246, 252, 235, 205, 255, 255, 128, 128, 128},
+			{47, 116, 215, 255, 211, 212, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 121, 236, 253, 212, 214, 255, 255, 128, 128, 128},
+			{141, 84, 213, 252, 201, 202, 255, 219, 128, 128, 128},
+			{42, 80, 160, 240, 162, 185, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{244, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{238, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+}
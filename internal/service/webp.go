@@ -0,0 +1,371 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// encodeWebP 把图片编码为有损WebP（VP8），quality 取值1-100
+// 图片含透明像素时使用扩展格式（VP8X），透明通道单独以无损方式压缩到ALPH块
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return fmt.Errorf("empty image")
+	}
+	if width > vp8MaxDimension || height > vp8MaxDimension {
+		return fmt.Errorf("image too large for webp: %dx%d", width, height)
+	}
+
+	rgba, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+	y, u, v, alpha := rgbaToYUV420(rgba)
+	frame := newVP8Encoder(width, height, y, u, v, quality).encode()
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if alpha != nil {
+		var header [10]byte
+		header[0] = 1 << 4 // 含透明通道
+		putUint24(header[4:], uint32(width-1))
+		putUint24(header[7:], uint32(height-1))
+		writeRIFFChunk(&body, "VP8X", header[:])
+		writeRIFFChunk(&body, "ALPH", encodeWebPAlpha(alpha, width, height))
+	}
+	writeRIFFChunk(&body, "VP8 ", frame)
+
+	var riff [8]byte
+	copy(riff[:4], "RIFF")
+	binary.LittleEndian.PutUint32(riff[4:], uint32(body.Len()))
+	if _, err := w.Write(riff[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// rgbaToYUV420 转换为BT.601（有限范围）YUV 4:2:0，平面尺寸补齐到宏块边界（复制边缘像素）
+// 没有半透明像素时alpha返回nil
+func rgbaToYUV420(img *image.RGBA) (y, u, v, alpha []uint8) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	yStride, uvStride := (width+15)/16*16, (width+15)/16*8
+	yRows, uvRows := (height+15)/16*16, (height+15)/16*8
+	y = make([]uint8, yStride*yRows)
+	u = make([]uint8, uvStride*uvRows)
+	v = make([]uint8, uvStride*uvRows)
+
+	// 先转换为非预乘的RGB，透明度单独保存
+	rgb := make([]int32, yStride*yRows*3)
+	a := make([]uint8, width*height)
+	opaque := true
+	for py := 0; py < yRows; py++ {
+		sy := min(py, height-1)
+		for px := 0; px < yStride; px++ {
+			sx := min(px, width-1)
+			p := img.Pix[sy*img.Stride+sx*4:]
+			r, g, b, pa := int32(p[0]), int32(p[1]), int32(p[2]), int32(p[3])
+			if pa != 255 && pa != 0 {
+				r, g, b = r*255/pa, g*255/pa, b*255/pa
+			}
+			if px < width && py < height {
+				a[py*width+px] = uint8(pa)
+				opaque = opaque && pa == 255
+			}
+			i := (py*yStride + px) * 3
+			rgb[i], rgb[i+1], rgb[i+2] = r, g, b
+			y[py*yStride+px] = uint8((66*r+129*g+25*b+128)>>8 + 16)
+		}
+	}
+
+	// 色度取2x2像素的平均值
+	for cy := 0; cy < uvRows; cy++ {
+		for cx := 0; cx < uvStride; cx++ {
+			var r, g, b int32
+			for _, offset := range [4]int{0, 1, yStride, yStride + 1} {
+				i := ((cy*2)*yStride + cx*2 + offset) * 3
+				r, g, b = r+rgb[i], g+rgb[i+1], b+rgb[i+2]
+			}
+			u[cy*uvStride+cx] = uint8((-38*r-74*g+112*b+512)>>10 + 128)
+			v[cy*uvStride+cx] = uint8((112*r-94*g-18*b+512)>>10 + 128)
+		}
+	}
+
+	if opaque {
+		return y, u, v, nil
+	}
+	return y, u, v, a
+}
+
+// encodeWebPAlpha 生成ALPH块：水平预测滤波后以VP8L无损格式压缩（只使用绿色通道）
+func encodeWebPAlpha(alpha []uint8, width, height int) []byte {
+	// 水平滤波：首行与左侧像素做差，其余行首列与上方像素做差
+	filtered := make([]uint8, len(alpha))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			switch {
+			case x > 0:
+				filtered[i] = alpha[i] - alpha[i-1]
+			case y > 0:
+				filtered[i] = alpha[i] - alpha[i-width]
+			default:
+				filtered[i] = alpha[i]
+			}
+		}
+	}
+
+	// 与前一个像素相同的连续像素用LZ77引用（距离1）表示，其余为字面量
+	// tokens 中小于256的为字面量，其余为 256+重复长度
+	var tokens []int
+	var green [280]uint32
+	for i := 0; i < len(filtered); {
+		run := 0
+		if i > 0 {
+			for i+run < len(filtered) && run < 4096 && filtered[i+run] == filtered[i-1] {
+				run++
+			}
+		}
+		if run >= 3 {
+			tokens = append(tokens, 256+run)
+			symbol, _, _ := vp8lPrefix(run)
+			green[256+symbol]++
+			i += run
+			continue
+		}
+		tokens = append(tokens, int(filtered[i]))
+		green[filtered[i]]++
+		i++
+	}
+
+	greenCode := newVP8LHuffmanCode(vp8lCodeLengths(green[:], 15))
+
+	var bw vp8lBitWriter
+	bw.write(0, 1) // 无变换
+	bw.write(0, 1) // 无颜色缓存
+	bw.write(0, 1) // 无分块霍夫曼编码
+	bw.writeHuffmanCode(greenCode)
+	// 红、蓝、透明通道恒为0，距离只使用符号1（距离码2，即前一个像素）：均为单符号的简单编码
+	for i := 0; i < 3; i++ {
+		bw.write(0b0001, 4)
+	}
+	bw.write(0b1001, 4)
+
+	for _, t := range tokens {
+		if t < 256 {
+			bw.writeSymbol(greenCode, t)
+			continue
+		}
+		symbol, extraBits, extra := vp8lPrefix(t - 256)
+		bw.writeSymbol(greenCode, 256+symbol)
+		bw.write(uint32(extra), uint(extraBits))
+	}
+
+	// 头字节：水平滤波（1<<2），VP8L压缩（1）
+	return append([]byte{1<<2 | 1}, bw.finish()...)
+}
+
+// vp8lPrefix 把LZ77长度/距离值转换为前缀符号和附加位
+func vp8lPrefix(v int) (symbol, extraBits, extra int) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	d := v - 1
+	highest := 0
+	for d>>(highest+1) != 0 {
+		highest++
+	}
+	second := d >> (highest - 1) & 1
+	extraBits = highest - 1
+	return 2*highest + second, extraBits, d & (1<<extraBits - 1)
+}
+
+// vp8lHuffmanCode 规范霍夫曼编码
+type vp8lHuffmanCode struct {
+	lengths []uint8
+	codes   []uint16
+	// single 只有一个符号时解码器不读取任何位
+	single bool
+}
+
+// newVP8LHuffmanCode 根据码长生成规范编码
+func newVP8LHuffmanCode(lengths []uint8) *vp8lHuffmanCode {
+	h := &vp8lHuffmanCode{lengths: lengths, codes: make([]uint16, len(lengths))}
+	var count [16]int
+	used := 0
+	for _, l := range lengths {
+		count[l]++
+		if l > 0 {
+			used++
+		}
+	}
+	h.single = used == 1
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l > 0 {
+			h.codes[s] = uint16(next[l])
+			next[l]++
+		}
+	}
+	return h
+}
+
+// vp8lCodeLengths 根据频率计算不超过maxLength的霍夫曼码长，超长时压缩频率差距后重试
+func vp8lCodeLengths(freq []uint32, maxLength int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	var symbols []int
+	for s, f := range freq {
+		if f > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	switch len(symbols) {
+	case 0:
+		// 没有出现的符号时任选一个，保证编码合法
+		lengths[0] = 1
+		return lengths
+	case 1:
+		lengths[symbols[0]] = 1
+		return lengths
+	}
+
+	weights := make([]uint32, len(freq))
+	copy(weights, freq)
+	for {
+		sort.SliceStable(symbols, func(i, j int) bool { return weights[symbols[i]] < weights[symbols[j]] })
+
+		// 双队列法构建霍夫曼树：叶子按频率排序，内部节点按生成顺序频率递增
+		type node struct {
+			weight uint64
+			parent int
+		}
+		nodes := make([]node, 0, 2*len(symbols)-1)
+		for _, s := range symbols {
+			nodes = append(nodes, node{weight: uint64(weights[s]), parent: -1})
+		}
+		leaf, internal := 0, len(symbols)
+		pop := func() int {
+			if leaf < len(symbols) && (internal >= len(nodes) || nodes[leaf].weight <= nodes[internal].weight) {
+				leaf++
+				return leaf - 1
+			}
+			internal++
+			return internal - 1
+		}
+		for len(nodes) < 2*len(symbols)-1 {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+			nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+		}
+
+		depth := make([]int, len(nodes))
+		maxDepth := 0
+		for i := len(nodes) - 2; i >= 0; i-- {
+			depth[i] = depth[nodes[i].parent] + 1
+			if i < len(symbols) {
+				maxDepth = max(maxDepth, depth[i])
+			}
+		}
+		if maxDepth <= maxLength {
+			for i, s := range symbols {
+				lengths[s] = uint8(depth[i])
+			}
+			return lengths
+		}
+		for _, s := range symbols {
+			weights[s] = max(weights[s]>>1, 1)
+		}
+	}
+}
+
+// vp8lCodeLengthOrder 码长编码中各码长符号的书写顺序
+var vp8lCodeLengthOrder = [19]uint8{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lBitWriter VP8L位流，低位在前
+type vp8lBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *vp8lBitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeSymbol 输出霍夫曼编码的符号，码字从高位开始
+func (w *vp8lBitWriter) writeSymbol(h *vp8lHuffmanCode, symbol int) {
+	if h.single {
+		return
+	}
+	code, length := h.codes[symbol], h.lengths[symbol]
+	for i := int(length) - 1; i >= 0; i-- {
+		w.write(uint32(code>>i)&1, 1)
+	}
+}
+
+// writeHuffmanCode 以常规方式输出霍夫曼码长（先输出码长的码长）
+func (w *vp8lBitWriter) writeHuffmanCode(h *vp8lHuffmanCode) {
+	var freq [19]uint32
+	for _, l := range h.lengths {
+		freq[l]++
+	}
+	lengthCode := newVP8LHuffmanCode(vp8lCodeLengths(freq[:], 7))
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && lengthCode.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+
+	w.write(0, 1) // 非简单编码
+	w.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		w.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	w.write(0, 1) // 输出全部符号的码长
+	for _, l := range h.lengths {
+		w.writeSymbol(lengthCode, int(l))
+	}
+}
+
+func (w *vp8lBitWriter) finish() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// writeRIFFChunk 写入RIFF块，数据长度为奇数时补一个字节
+func writeRIFFChunk(buf *bytes.Buffer, fourcc string, data []byte) {
+	var header [8]byte
+	copy(header[:4], fourcc)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	buf.Write(header[:])
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// photoImage 带渐变和细节的测试图片，alpha 为nil时不透明
+func photoImage(width, height int, alpha func(x, y int) uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a := uint8(255)
+			if alpha != nil {
+				a = alpha(x, y)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x * 255 / max(width-1, 1)),
+				G: uint8(y * 255 / max(height-1, 1)),
+				B: uint8((x*7 + y*13) % 256),
+				A: a,
+			})
+		}
+	}
+	return img
+}
+
+// planePSNR 两个平面左上角 width x height 区域的峰值信噪比
+func planePSNR(a []uint8, aStride int, b []uint8, bStride int, width, height int) float64 {
+	var sum float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			d := float64(a[y*aStride+x]) - float64(b[y*bStride+x])
+			sum += d * d
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(width*height)))
+}
+
+// 解码结果直接与编码前的YUV平面比较：x/image/webp 返回的 image.YCbCr 按JFIF全范围转换为RGB，
+// 与WebP使用的BT.601有限范围不同，比较RGB会把这部分差异算作编码损失
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	sizes := []struct{ w, h int }{{1, 1}, {15, 17}, {16, 16}, {33, 9}, {100, 75}}
+	qualities := []struct {
+		q       int
+		minPSNR float64
+	}{{1, 20}, {DefaultProxyQuality, 38}, {100, 48}}
+
+	for _, size := range sizes {
+		src := photoImage(size.w, size.h, nil)
+		rgba := image.NewRGBA(src.Rect)
+		draw.Draw(rgba, rgba.Rect, src, image.Point{}, draw.Src)
+		y, u, v, _ := rgbaToYUV420(rgba)
+		yStride, uvStride := (size.w+15)/16*16, (size.w+15)/16*8
+		uvW, uvH := (size.w+1)/2, (size.h+1)/2
+
+		for _, quality := range qualities {
+			var buf bytes.Buffer
+			if err := encodeWebP(&buf, src, quality.q); err != nil {
+				t.Fatalf("%dx%d q=%d: %v", size.w, size.h, quality.q, err)
+			}
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%dx%d q=%d: decode: %v", size.w, size.h, quality.q, err)
+			}
+			if b := decoded.Bounds(); b.Dx() != size.w || b.Dy() != size.h {
+				t.Fatalf("%dx%d q=%d: decoded size %v", size.w, size.h, quality.q, b)
+			}
+			ycc, ok := decoded.(*image.YCbCr)
+			if !ok {
+				t.Fatalf("%dx%d q=%d: opaque image decoded as %T, want plain VP8", size.w, size.h, quality.q, decoded)
+			}
+			planes := []struct {
+				name string
+				psnr float64
+			}{
+				{"Y", planePSNR(y, yStride, ycc.Y, ycc.YStride, size.w, size.h)},
+				{"U", planePSNR(u, uvStride, ycc.Cb, ycc.CStride, uvW, uvH)},
+				{"V", planePSNR(v, uvStride, ycc.Cr, ycc.CStride, uvW, uvH)},
+			}
+			for _, plane := range planes {
+				if plane.psnr < quality.minPSNR {
+					t.Errorf("%dx%d q=%d: %s PSNR %.1f dB, want >= %.0f",
+						size.w, size.h, quality.q, plane.name, plane.psnr, quality.minPSNR)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebPQualityAffectsSize(t *testing.T) {
+	src := photoImage(128, 96, nil)
+	var low, high bytes.Buffer
+	encodeWebP(&low, src, 1)
+	encodeWebP(&high, src, 100)
+	if low.Len() >= high.Len() {
+		t.Errorf("q=1 gives %d bytes, q=100 gives %d bytes", low.Len(), high.Len())
+	}
+}
+
+func TestEncodeWebPAlpha(t *testing.T) {
+	// 透明度无损保存：左半透明、右半不透明，中间一列半透明，再加上棋盘格
+	alpha := func(x, y int) uint8 {
+		switch {
+		case x < 10:
+			return 0
+		case x == 10:
+			return 128
+		case (x/3+y/3)%2 == 0:
+			return 200
+		}
+		return 255
+	}
+	for _, size := range []struct{ w, h int }{{1, 1}, {23, 19}, {64, 40}} {
+		src := photoImage(size.w, size.h, alpha)
+		var buf bytes.Buffer
+		if err := encodeWebP(&buf, src, 75); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes()[:64], []byte("VP8X")) {
+			t.Fatalf("%dx%d: transparent image not written as VP8X", size.w, size.h)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%dx%d: decode: %v", size.w, size.h, err)
+		}
+		for y := 0; y < size.h; y++ {
+			for x := 0; x < size.w; x++ {
+				_, _, _, a := decoded.At(x, y).RGBA()
+				if uint8(a>>8) != src.NRGBAAt(x, y).A {
+					t.Fatalf("%dx%d: alpha at (%d,%d) = %d, want %d", size.w, size.h, x, y, a>>8, src.NRGBAAt(x, y).A)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebPSubImage(t *testing.T) {
+	// 原点不为0的图片按其区域编码
+	src := photoImage(64, 64, nil).SubImage(image.Rect(16, 8, 48, 40))
+	var buf bytes.Buffer
+	if err := encodeWebP(&buf, src, 90); err != nil {
+		t.Fatal(err)
+	}
+	config, err := webp.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil || config.Width != 32 || config.Height != 32 {
+		t.Errorf("config = %+v, %v, want 32x32", config, err)
+	}
+}
+
+func TestEncodeWebPRejectsInvalidSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeWebP(&buf, image.NewRGBA(image.Rect(0, 0, 0, 10)), 80); err == nil {
+		t.Error("empty image encoded without error")
+	}
+	if err := encodeWebP(&buf, image.NewRGBA(image.Rect(0, 0, vp8MaxDimension+1, 1)), 80); err == nil {
+		t.Error("oversized image encoded without error")
+	}
+}
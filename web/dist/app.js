@@ -1,6 +1,6 @@
 // 使用 js/api.js 提供的 API 工具
 const { request: apiRequest, getToken: getAdminToken, clearToken: clearAdminToken } = window.API;
-const { showAlert: showAlertUtil, formatDate: formatDateUtil } = window.Utils;
+const { showAlert: showAlertUtil, formatDate: formatDateUtil, thumbSrc } = window.Utils;
 
 // 全局状态
 let currentPage = 1;
@@ -102,7 +102,7 @@ async function loadImages(page = 1) {
             tr.innerHTML = `
                 <td><input type="checkbox" class="image-checkbox" value="${image.id}" onchange="updateSelection()"></td>
                 <td>${image.id}</td>
-                <td><img src="${image.source_url}" style="max-width: 60px; max-height: 60px; object-fit: cover; border-radius: 4px;"></td>
+                <td><img src="${thumbSrc(image)}" style="max-width: 60px; max-height: 60px; object-fit: cover; border-radius: 4px;"></td>
                 <td style="max-width: 200px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;" title="${image.source_url}">${image.source_url}</td>
                 <td>${image.width || '-'} x ${image.height || '-'}</td>
                 <td>${image.category ? image.category.name : '-'}</td>
@@ -216,6 +216,9 @@ async function showImageModal(id = null) {
         document.getElementById('image-height').value = image.height || '';
         document.getElementById('image-format').value = image.format || '';
         document.getElementById('image-source').value = image.source || '';
+        document.getElementById('image-weight').value = image.weight ?? '';
+        document.getElementById('image-rating').value = image.rating || 'safe';
+        document.getElementById('image-mirror').checked = image.mirror;
     } else {
         // 新建模式
         document.getElementById('image-modal-title').textContent = '添加图片';
@@ -231,6 +234,7 @@ document.getElementById('image-form').addEventListener('submit', async (e) => {
 
     const id = document.getElementById('image-id').value;
     const autoFetch = document.getElementById('image-auto-fetch').checked;
+    const weightValue = document.getElementById('image-weight').value;
     const data = {
         source_url: document.getElementById('image-url').value,
         category_id: parseInt(document.getElementById('image-category').value),
@@ -238,6 +242,10 @@ document.getElementById('image-form').addEventListener('submit', async (e) => {
         height: document.getElementById('image-height').value ? parseInt(document.getElementById('image-height').value) : null,
         format: document.getElementById('image-format').value || null,
         source: document.getElementById('image-source').value || null,
+        // 编辑时留空表示恢复使用分类默认权重（-1）
+        weight: weightValue !== '' ? parseInt(weightValue) : (id ? -1 : null),
+        rating: document.getElementById('image-rating').value,
+        mirror: document.getElementById('image-mirror').checked,
         auto_fetch: autoFetch,
     };
 
@@ -316,6 +324,8 @@ function showCategoryModal(id = null) {
         document.getElementById('category-name').value = cat.name;
         document.getElementById('category-slug').value = cat.slug;
         document.getElementById('category-description').value = cat.description || '';
+        document.getElementById('category-default-weight').value = cat.default_weight ?? 1;
+        document.getElementById('category-mirror').checked = cat.mirror;
     } else {
         document.getElementById('category-modal-title').textContent = '添加分类';
         document.getElementById('category-form').reset();
@@ -333,6 +343,8 @@ document.getElementById('category-form').addEventListener('submit', async (e) =>
         name: document.getElementById('category-name').value,
         slug: document.getElementById('category-slug').value,
         description: document.getElementById('category-description').value || null,
+        default_weight: parseInt(document.getElementById('category-default-weight').value || '1'),
+        mirror: document.getElementById('category-mirror').checked,
     };
 
     try {
@@ -392,6 +404,7 @@ async function loadAPIKeys() {
                 </td>
                 <td>${key.rate_limit}</td>
                 <td data-status="${key.status}"><span style="color: ${key.status === 'active' ? 'green' : 'red'}">${key.status}</span></td>
+                <td>${key.max_rating}</td>
                 <td>${formatDate(key.created_at)}</td>
                 <td>${formatDate(key.last_used_at)}</td>
                 <td>
@@ -422,7 +435,8 @@ function showAPIKeyModal(id = null) {
                     id: keyId,
                     key: row.querySelector('td:nth-child(2)').dataset.key,
                     rate_limit: parseInt(row.querySelector('td:nth-child(3)').textContent),
-                    status: row.querySelector('td:nth-child(4)').dataset.status
+                    status: row.querySelector('td:nth-child(4)').dataset.status,
+                    max_rating: row.querySelector('td:nth-child(5)').textContent
                 };
             }
         });
@@ -433,6 +447,7 @@ function showAPIKeyModal(id = null) {
             document.getElementById('apikey-key').value = keyData.key;
             document.getElementById('apikey-ratelimit').value = keyData.rate_limit;
             document.getElementById('apikey-status').value = keyData.status;
+            document.getElementById('apikey-max-rating').value = keyData.max_rating;
             document.getElementById('apikey-status-group').style.display = 'block';
         }
     } else {
@@ -453,7 +468,8 @@ document.getElementById('apikey-form').addEventListener('submit', async (e) => {
     const id = document.getElementById('apikey-id').value;
     const key = document.getElementById('apikey-key').value;
     const data = {
-        rate_limit: parseInt(document.getElementById('apikey-ratelimit').value)
+        rate_limit: parseInt(document.getElementById('apikey-ratelimit').value),
+        max_rating: document.getElementById('apikey-max-rating').value
     };
 
     // 如果提供了自定义key
@@ -839,10 +855,14 @@ document.getElementById('batch-update-form').addEventListener('submit', async (e
     const categoryId = document.getElementById('batch-category').value;
     const status = document.getElementById('batch-status').value;
     const source = document.getElementById('batch-source').value;
+    const weight = document.getElementById('batch-weight').value;
+    const rating = document.getElementById('batch-rating').value;
 
     if (categoryId) updates.category_id = parseInt(categoryId);
     if (status) updates.status = status;
     if (source) updates.source = source;
+    if (weight !== '') updates.weight = parseInt(weight);
+    if (rating) updates.rating = rating;
 
     if (Object.keys(updates).length === 0) {
         showAlert('请至少选择一项要修改的内容', 'error');
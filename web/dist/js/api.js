@@ -67,6 +67,20 @@
         return date.toLocaleString('zh-CN');
     }
 
+    // Image src: uploaded images (storage://) are served through the proxy endpoint
+    function imageSrc(image) {
+        return image.source_url.startsWith('storage://') ? `/api/proxy/${image.id}` : image.source_url;
+    }
+
+    // Thumbnail src: the smallest pre-generated variant, falling back to the original.
+    // Variants follow the public rating limit, so only safe images use them here
+    function thumbSrc(image) {
+        if (image.variants?.length && image.rating === 'safe') {
+            return image.variants[0].url;
+        }
+        return imageSrc(image);
+    }
+
     // Export for use in other modules
     window.API = {
         request: apiRequest,
@@ -77,6 +91,8 @@
 
     window.Utils = {
         showAlert,
-        formatDate
+        formatDate,
+        imageSrc,
+        thumbSrc
     };
 })();
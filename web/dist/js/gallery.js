@@ -7,6 +7,14 @@ let hasMore = true;
 let allImages = [];
 let currentLightboxIndex = 0;
 
+// Uploaded images (storage://) are served through the proxy endpoint
+function imageSrc(image) {
+    return image.source_url.startsWith('storage://') ? `${API_BASE}/proxy/${image.id}` : image.source_url;
+}
+
+// Grid items are 250px to ~500px wide
+const GRID_SIZES = '(max-width: 540px) 100vw, 400px';
+
 // Intersection Observer for lazy loading
 const imageObserver = new IntersectionObserver((entries, observer) => {
     entries.forEach(entry => {
@@ -14,6 +22,12 @@ const imageObserver = new IntersectionObserver((entries, observer) => {
             const img = entry.target;
             const src = img.getAttribute('data-src');
             if (src) {
+                const srcset = img.getAttribute('data-srcset');
+                if (srcset) {
+                    img.sizes = GRID_SIZES;
+                    img.srcset = srcset;
+                    img.removeAttribute('data-srcset');
+                }
                 img.src = src;
                 img.removeAttribute('data-src');
                 observer.unobserve(img);
@@ -126,7 +140,13 @@ function renderImages(images, append = false) {
         item.onclick = () => openLightbox(allImages.indexOf(image));
 
         const img = document.createElement('img');
-        img.setAttribute('data-src', image.source_url);
+        // Pre-generated variants let the browser pick a thumbnail; the lightbox shows the original
+        if (image.srcset) {
+            img.setAttribute('data-srcset', image.srcset);
+            img.setAttribute('data-src', image.variants[0].url);
+        } else {
+            img.setAttribute('data-src', imageSrc(image));
+        }
         img.alt = image.category?.name || 'Image';
         img.loading = 'lazy';
 
@@ -168,7 +188,7 @@ function openLightbox(index) {
     currentLightboxIndex = index;
     const lightbox = document.getElementById('lightbox');
     const img = document.getElementById('lightbox-img');
-    img.src = allImages[index].source_url;
+    img.src = imageSrc(allImages[index]);
     lightbox.classList.add('active');
 
     // Keyboard navigation
@@ -190,7 +210,7 @@ function navigateLightbox(direction) {
     }
 
     const img = document.getElementById('lightbox-img');
-    img.src = allImages[currentLightboxIndex].source_url;
+    img.src = imageSrc(allImages[currentLightboxIndex]);
 }
 
 function handleLightboxKeyboard(e) {
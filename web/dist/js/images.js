@@ -35,7 +35,7 @@ const ImageManager = {
         card.className = 'image-card';
         card.innerHTML = `
             <input type="checkbox" data-id="${image.id}" onchange="ImageManager.toggleSelection(${image.id})">
-            <img src="${image.source_url}" alt="${image.category?.name || ''}" loading="lazy">
+            <img src="${Utils.thumbSrc(image)}" alt="${image.category?.name || ''}" loading="lazy">
             <div class="image-card-info">
                 <p><strong>${image.category?.name || '未分类'}</strong></p>
                 <p>${image.width || '?'} × ${image.height || '?'}</p>